@@ -1,7 +1,11 @@
 package api
 
 import (
-	"encoding/json"
+	"context"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
 
 	"github.com/pkg/errors"
 	"github.com/shopspring/decimal"
@@ -10,6 +14,8 @@ import (
 	"github.com/uscott/go-tools/errs"
 )
 
+const volumeWindow = 30 * 24 * time.Hour
+
 const (
 	apiGetAccountInformation = "/account"
 	apiGetPositions          = "/positions"
@@ -20,26 +26,119 @@ type Account struct {
 	client *Client
 }
 
+// accountCache holds the account information and positions last fetched so
+// that they are served again until ttl has elapsed. Copies are handed out so
+// callers cannot modify the cached values.
+type accountCache struct {
+	mu          sync.Mutex
+	ttl         time.Duration
+	info        *models.AccountInformation
+	infoAt      time.Time
+	positions   []models.Position
+	positionsAt time.Time
+}
+
+func (c *accountCache) getInfo(result *models.AccountInformation) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.info == nil || time.Since(c.infoAt) >= c.ttl {
+		return false
+	}
+	*result = *copyAccountInfo(c.info)
+	return true
+}
+
+func (c *accountCache) setInfo(info *models.AccountInformation) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.ttl <= 0 {
+		return
+	}
+	c.info, c.infoAt = copyAccountInfo(info), time.Now()
+}
+
+// copyAccountInfo returns a copy of info sharing none of its positions or
+// position limits.
+func copyAccountInfo(info *models.AccountInformation) *models.AccountInformation {
+	cached := *info
+	cached.Positions = append([]models.Position(nil), info.Positions...)
+	if info.PositionLimit != nil {
+		limit := *info.PositionLimit
+		cached.PositionLimit = &limit
+	}
+	if info.PositionLimitUsed != nil {
+		used := *info.PositionLimitUsed
+		cached.PositionLimitUsed = &used
+	}
+	return &cached
+}
+
+func (c *accountCache) getPositions() ([]*models.Position, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.positions == nil || time.Since(c.positionsAt) >= c.ttl {
+		return nil, false
+	}
+	result := make([]*models.Position, len(c.positions))
+	for i := range c.positions {
+		p := c.positions[i]
+		result[i] = &p
+	}
+	return result, true
+}
+
+func (c *accountCache) setPositions(positions []*models.Position) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.ttl <= 0 {
+		return
+	}
+	c.positions = make([]models.Position, 0, len(positions))
+	for _, p := range positions {
+		if p != nil {
+			c.positions = append(c.positions, *p)
+		}
+	}
+	c.positionsAt = time.Now()
+}
+
+// InvalidateAccountCache makes the next GetAccountInformation and
+// GetPositions calls fetch from FTX, for instance after an order has filled.
+func (c *Client) InvalidateAccountCache() {
+	c.accountCache.mu.Lock()
+	c.accountCache.info, c.accountCache.positions = nil, nil
+	c.accountCache.mu.Unlock()
+}
+
 func (a *Account) GetAccountInformation(result *models.AccountInformation) (err error) {
 
 	if result == nil {
 		return errs.NilPtr
 	}
+	if a.client.accountCache.getInfo(result) {
+		return nil
+	}
 	url := FormURL(apiGetAccountInformation)
 	response, err := a.client.Get(nil, url, true)
 	if err != nil {
 		return errors.WithStack(err)
 	}
 
-	if err = json.Unmarshal(response, result); err != nil {
+	if err = a.client.unmarshal(response, result); err != nil {
 		return errors.WithStack(err)
 	}
 
+	a.client.accountCache.setInfo(result)
+
 	return nil
 }
 
 func (a *Account) GetPositions() ([]*models.Position, error) {
 
+	if result, ok := a.client.accountCache.getPositions(); ok {
+		return result, nil
+	}
+
 	url := FormURL(apiGetPositions)
 	response, err := a.client.Get(nil, url, true)
 	if err != nil {
@@ -47,9 +146,12 @@ func (a *Account) GetPositions() ([]*models.Position, error) {
 	}
 
 	var result []*models.Position
-	if err = json.Unmarshal(response, &result); err != nil {
+	if err = a.client.unmarshal(response, &result); err != nil {
 		return nil, errors.WithStack(err)
 	}
+
+	a.client.accountCache.setPositions(result)
+
 	return result, nil
 }
 
@@ -66,8 +168,201 @@ func (a *Account) ChangeAccountLeverage(leverage float64) (result string, err er
 		return result, errors.WithStack(err)
 	}
 
-	if err = json.Unmarshal(response, &result); err != nil {
+	// The leverage and margin figures of the cached information are stale
+	a.client.InvalidateAccountCache()
+
+	if err = a.client.unmarshal(response, &result); err != nil {
 		return result, errors.WithStack(err)
 	}
 	return
 }
+
+// GetAccountFees returns the maker and taker fee rates of the account's
+// current fee tier.
+func (a *Account) GetAccountFees(ctx context.Context) (*models.Fees, error) {
+
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	info := models.AccountInformation{}
+	if err := a.GetAccountInformation(&info); err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	return &models.Fees{MakerFee: info.MakerFee, TakerFee: info.TakerFee}, nil
+}
+
+// Get30DayVolume returns the notional traded by the account, or subaccount,
+// over the last 30 days, which FTX bases fee tiers on. FTX has no endpoint
+// for it so it is summed from the fills of the period.
+func (a *Account) Get30DayVolume(ctx context.Context) (decimal.Decimal, error) {
+
+	end := time.Now()
+	fills, err := a.client.Fills.fillsBetween(ctx, nil, end.Add(-volumeWindow), end)
+	if err != nil {
+		return decimal.Zero, errors.WithStack(err)
+	}
+
+	volume := decimal.Zero
+	for _, f := range fills {
+		volume = volume.Add(f.Price.Mul(f.Size).Abs())
+	}
+
+	return volume, nil
+}
+
+// PortfolioSummary values the open positions at the current marks and
+// totals their notional and unrealized PnL along with the account collateral.
+func (a *Account) PortfolioSummary(ctx context.Context) (*models.PortfolioSummary, error) {
+
+	info := models.AccountInformation{}
+	if err := a.GetAccountInformation(&info); err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	positions, err := a.GetPositions()
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	if err = ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	futures, err := a.client.Futures.GetFutures()
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	return summarizePortfolio(&info, positions, futures, time.Now()), nil
+}
+
+// StateSnapshot fetches the account information, positions, open orders and
+// open trigger orders concurrently, bypassing the account cache, so that they
+// are as consistent as FTX allows, Time being when the requests were sent.
+// When some of them fail the others are returned along with a *PartialError.
+func (a *Account) StateSnapshot(ctx context.Context) (*models.AccountState, error) {
+
+	var (
+		info      models.AccountInformation
+		positions []*models.Position
+		orders    []*models.Order
+		triggers  []*models.TriggerOrder
+	)
+
+	parts := []struct {
+		name   string
+		url    string
+		result interface{}
+	}{
+		{"account", FormURL(apiGetAccountInformation), &info},
+		{"positions", FormURL(apiGetPositions), &positions},
+		{"open orders", FormURL(apiGetOpenOrders), &orders},
+		{"trigger orders", FormURL(apiGetTriggerOrders), &triggers},
+	}
+
+	state := &models.AccountState{Time: time.Now()}
+
+	failures := make([]error, len(parts))
+	wg := sync.WaitGroup{}
+	for i := range parts {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			response, err := a.client.GetResponseWithContext(
+				ctx, nil, parts[i].url, http.MethodGet, true)
+			if err == nil {
+				err = a.client.unmarshal(response, parts[i].result)
+			}
+			if err != nil {
+				failures[i] = errors.Wrapf(err, "Get %s", parts[i].name)
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	if failures[0] == nil {
+		state.Account = &info
+	}
+	if failures[1] == nil {
+		state.Positions = positions
+	}
+	if failures[2] == nil {
+		state.OpenOrders = orders
+	}
+	if failures[3] == nil {
+		state.TriggerOrders = triggers
+	}
+
+	partial := &PartialError{Parts: len(parts)}
+	for _, err := range failures {
+		if err != nil {
+			partial.Errors = append(partial.Errors, err)
+		}
+	}
+	if len(partial.Errors) > 0 {
+		return state, errors.WithStack(partial)
+	}
+
+	return state, nil
+}
+
+func summarizePortfolio(
+	info *models.AccountInformation,
+	positions []*models.Position,
+	futures []*models.Future,
+	now time.Time,
+) *models.PortfolioSummary {
+
+	marks := make(map[string]decimal.Decimal, len(futures))
+	for _, f := range futures {
+		if f != nil {
+			marks[f.Name] = f.Mark
+		}
+	}
+
+	summary := &models.PortfolioSummary{
+		Time:              now,
+		TotalAccountValue: info.TotalAccountValue,
+		Collateral:        info.Collateral,
+		FreeCollateral:    info.FreeCollateral,
+		MarginFraction:    info.MarginFraction,
+	}
+
+	for _, p := range positions {
+
+		if p == nil || p.NetSize.IsZero() {
+			continue
+		}
+
+		mark, ok := marks[p.Future]
+		if !ok {
+			mark = p.EntryPrice
+		}
+
+		value := p.NetSize.Mul(mark)
+		position := models.PositionSummary{
+			Future:        p.Future,
+			NetSize:       p.NetSize,
+			EntryPrice:    p.EntryPrice,
+			Mark:          mark,
+			Notional:      value.Abs(),
+			UnrealizedPnl: value.Sub(p.Cost),
+		}
+
+		summary.Notional = summary.Notional.Add(position.Notional)
+		summary.UnrealizedPnl = summary.UnrealizedPnl.Add(position.UnrealizedPnl)
+		summary.Positions = append(summary.Positions, position)
+	}
+
+	sort.Slice(summary.Positions, func(i, j int) bool {
+		return summary.Positions[i].Future < summary.Positions[j].Future
+	})
+
+	return summary
+}
@@ -0,0 +1,244 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/shopspring/decimal"
+	"github.com/uscott/go-ftx/models"
+)
+
+func TestAccount_summarizePortfolio(t *testing.T) {
+
+	d := decimal.RequireFromString
+
+	info := &models.AccountInformation{
+		TotalAccountValue: d("10000"),
+		Collateral:        d("9000"),
+		FreeCollateral:    d("8000"),
+	}
+	positions := []*models.Position{
+		{Future: "ETH-PERP", NetSize: d("-2"), EntryPrice: d("2000"), Cost: d("-4000")},
+		{Future: "BTC-PERP", NetSize: d("0.5"), EntryPrice: d("40000"), Cost: d("20000")},
+		{Future: "SOL-PERP", NetSize: decimal.Zero, Cost: decimal.Zero},
+	}
+	futures := []*models.Future{
+		{Name: "BTC-PERP", Mark: d("42000")},
+		{Name: "ETH-PERP", Mark: d("2100")},
+	}
+
+	now := time.Now()
+	summary := summarizePortfolio(info, positions, futures, now)
+
+	if !summary.Time.Equal(now) || !summary.TotalAccountValue.Equal(d("10000")) {
+		t.Fatalf("Unexpected summary: %+v", summary)
+	}
+	if len(summary.Positions) != 2 {
+		t.Fatalf("Length inequality: %d, %d", len(summary.Positions), 2)
+	}
+
+	expected := []struct {
+		future   string
+		notional string
+		pnl      string
+	}{
+		{"BTC-PERP", "21000", "1000"},
+		{"ETH-PERP", "4200", "-200"},
+	}
+	for i, e := range expected {
+		p := summary.Positions[i]
+		if p.Future != e.future || !p.Notional.Equal(d(e.notional)) || !p.UnrealizedPnl.Equal(d(e.pnl)) {
+			t.Fatalf("Unexpected position: %+v - expected %+v", p, e)
+		}
+	}
+
+	if !summary.Notional.Equal(d("25200")) {
+		t.Fatalf("Should be equal: %v, %v", summary.Notional, "25200")
+	}
+	if !summary.UnrealizedPnl.Equal(d("800")) {
+		t.Fatalf("Should be equal: %v, %v", summary.UnrealizedPnl, "800")
+	}
+}
+
+func TestAccount_Get30DayVolume(t *testing.T) {
+
+	now := time.Now().UTC()
+
+	// The same fill twice checks it is only counted once
+	fills := []*models.Fill{
+		{ID: 2, Price: decimal.NewFromInt(100), Size: decimal.NewFromInt(2), Time: now},
+		{ID: 1, Price: decimal.RequireFromString("50.5"), Size: decimal.NewFromInt(1), Time: now.Add(-time.Hour)},
+		{ID: 1, Price: decimal.RequireFromString("50.5"), Size: decimal.NewFromInt(1), Time: now.Add(-time.Hour)},
+		{ID: 3, Price: decimal.NewFromInt(1), Size: decimal.NewFromInt(1), Time: now.Add(-volumeWindow - time.Hour)},
+	}
+
+	c := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		query := r.URL.Query()
+		start, _ := strconv.ParseInt(query.Get("start_time"), 10, 64)
+		end, _ := strconv.ParseInt(query.Get("end_time"), 10, 64)
+		var page []*models.Fill
+		for _, f := range fills {
+			if ts := f.Time.Unix(); ts >= start && ts <= end {
+				page = append(page, f)
+			}
+		}
+		b, _ := json.Marshal(page)
+		_, _ = w.Write([]byte(`{"success": true, "result": ` + string(b) + `}`))
+	})
+
+	volume, err := c.Account.Get30DayVolume(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected := decimal.RequireFromString("250.5"); !volume.Equal(expected) {
+		t.Fatalf("Should be equal: %v, %v", volume, expected)
+	}
+}
+
+func TestAccount_SetAccountCacheTTL(t *testing.T) {
+
+	var infoRequests, positionRequests int32
+	c := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		var result string
+		switch r.URL.Path {
+		case "/api/account":
+			atomic.AddInt32(&infoRequests, 1)
+			result = `{"username": "user", "collateral": 100, "positionLimit": 1000, ` +
+				`"positions": [{"future": "BTC-PERP", "netSize": 1}]}`
+		case "/api/positions":
+			atomic.AddInt32(&positionRequests, 1)
+			result = `[{"future": "BTC-PERP", "netSize": 1}]`
+		case "/api/account/leverage":
+			result = `null`
+		}
+		_, _ = w.Write([]byte(`{"success": true, "result": ` + result + `}`))
+	}, WithAuth("key", "secret"), SetAccountCacheTTL(100*time.Millisecond))
+
+	expect := func(info, positions int32) {
+		t.Helper()
+		if n := atomic.LoadInt32(&infoRequests); n != info {
+			t.Fatalf("Should be equal: %d, %d", n, info)
+		}
+		if n := atomic.LoadInt32(&positionRequests); n != positions {
+			t.Fatalf("Should be equal: %d, %d", n, positions)
+		}
+	}
+
+	for i := 0; i < 2; i++ {
+		info := models.AccountInformation{}
+		if err := c.GetAccountInformation(&info); err != nil {
+			t.Fatal(err)
+		}
+		if info.Username != "user" || len(info.Positions) != 1 ||
+			info.PositionLimit == nil || !info.PositionLimit.Equal(decimal.NewFromInt(1000)) {
+			t.Fatalf("Unexpected information: %+v", info)
+		}
+		// Modifying the result leaves the cache alone
+		info.Positions[0].NetSize = decimal.Zero
+		*info.PositionLimit = decimal.Zero
+
+		positions, err := c.GetPositions()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(positions) != 1 || !positions[0].NetSize.Equal(decimal.NewFromInt(1)) {
+			t.Fatalf("Unexpected positions: %+v", positions)
+		}
+		positions[0].NetSize = decimal.Zero
+	}
+	expect(1, 1)
+
+	c.InvalidateAccountCache()
+	if _, err := c.GetPositions(); err != nil {
+		t.Fatal(err)
+	}
+	expect(1, 2)
+
+	time.Sleep(100 * time.Millisecond)
+	info := models.AccountInformation{}
+	if err := c.GetAccountInformation(&info); err != nil {
+		t.Fatal(err)
+	}
+	if !info.Positions[0].NetSize.Equal(decimal.NewFromInt(1)) {
+		t.Fatalf("Unexpected positions: %+v", info.Positions)
+	}
+	expect(2, 2)
+
+	// Changing the leverage invalidates the cache
+	if _, err := c.ChangeAccountLeverage(10); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.GetAccountInformation(&info); err != nil {
+		t.Fatal(err)
+	}
+	expect(3, 2)
+}
+
+func TestAccount_StateSnapshot(t *testing.T) {
+
+	var arrived int64
+	allArrived := make(chan struct{})
+
+	c := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+
+		// Answers only once the four requests are in flight
+		if atomic.AddInt64(&arrived, 1) == 4 {
+			close(allArrived)
+		}
+		select {
+		case <-allArrived:
+		case <-time.After(5 * time.Second):
+		}
+
+		switch r.URL.Path {
+		case "/api/account":
+			_, _ = w.Write([]byte(`{"success": true, "result": {"username": "user", "liquidating": false}}`))
+		case "/api/positions":
+			_, _ = w.Write([]byte(`{"success": true, "result": [{"future": "BTC-PERP", "netSize": 1}]}`))
+		case "/api/orders":
+			w.WriteHeader(http.StatusInternalServerError)
+			_, _ = w.Write([]byte(`{"success": false, "error": "Internal error"}`))
+		case "/api/conditional_orders":
+			_, _ = w.Write([]byte(`{"success": true, "result": [{"id": 7, "market": "BTC-PERP"}]}`))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}, WithAuth("key", "secret"))
+
+	before := time.Now()
+	state, err := c.StateSnapshot(context.Background())
+
+	var partial *PartialError
+	if !errors.As(err, &partial) || len(partial.Errors) != 1 || partial.Parts != 4 {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	var apiErr *APIError
+	if !errors.As(err, &apiErr) || apiErr.StatusCode != http.StatusInternalServerError {
+		t.Fatalf("Should unwrap to the API error: %v", err)
+	}
+
+	if state == nil {
+		t.Fatal("The fetched parts should be returned")
+	}
+	if state.Time.Before(before) {
+		t.Fatalf("Unexpected time: %v", state.Time)
+	}
+	if state.Account == nil || state.Account.Username != "user" {
+		t.Fatalf("Unexpected account: %+v", state.Account)
+	}
+	if len(state.Positions) != 1 || state.Positions[0].Future != "BTC-PERP" {
+		t.Fatalf("Unexpected positions: %+v", state.Positions)
+	}
+	if state.OpenOrders != nil {
+		t.Fatalf("Should be nil: %+v", state.OpenOrders)
+	}
+	if len(state.TriggerOrders) != 1 || state.TriggerOrders[0].ID != 7 {
+		t.Fatalf("Unexpected trigger orders: %+v", state.TriggerOrders)
+	}
+}
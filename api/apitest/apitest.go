@@ -0,0 +1,165 @@
+// Package apitest provides an in-process stand-in for the FTX websocket so
+// that code consuming a Stream can be tested without a network connection.
+package apitest
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/pkg/errors"
+
+	"github.com/uscott/go-ftx/api"
+	"github.com/uscott/go-ftx/models"
+)
+
+const connectTimeout = 5 * time.Second
+
+// MockStream is an api.Client whose Stream is connected to a local websocket
+// server. Frames injected with Send go through the same decode path as
+// frames received from FTX.
+type MockStream struct {
+	Client *api.Client
+
+	server     *httptest.Server
+	upgrader   websocket.Upgrader
+	mu         sync.Mutex
+	conn       *websocket.Conn
+	subscribed chan struct{}
+	requests   []models.WSRequest
+}
+
+func NewMockStream() *MockStream {
+
+	m := &MockStream{
+		Client:     api.New(),
+		subscribed: make(chan struct{}, 1),
+	}
+
+	m.server = httptest.NewServer(http.HandlerFunc(m.handle))
+	m.Client.Stream.SetURL("ws" + strings.TrimPrefix(m.server.URL, "http"))
+
+	return m
+}
+
+// Stream returns the Stream connected to the mock server.
+func (m *MockStream) Stream() *api.Stream {
+	return &m.Client.Stream
+}
+
+// Send writes resp to the current connection. If nothing has subscribed yet
+// it first waits for a subscribe request, as FTX only sends data afterwards.
+func (m *MockStream) Send(resp models.WsResponse) error {
+	frame, err := json.Marshal(resp)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	return m.SendRaw(frame)
+}
+
+// SendRaw writes frame to the current connection as is.
+func (m *MockStream) SendRaw(frame []byte) error {
+
+	conn, err := m.waitForConn()
+	if err != nil {
+		return err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return errors.WithStack(conn.WriteMessage(websocket.TextMessage, frame))
+}
+
+// Requests returns the subscribe and unsubscribe requests received so far.
+func (m *MockStream) Requests() []models.WSRequest {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return append([]models.WSRequest(nil), m.requests...)
+}
+
+// Close shuts down the server and any open connection.
+func (m *MockStream) Close() {
+	m.mu.Lock()
+	if m.conn != nil {
+		m.conn.Close()
+	}
+	m.mu.Unlock()
+	m.server.CloseClientConnections()
+	m.server.Close()
+}
+
+func (m *MockStream) waitForConn() (*websocket.Conn, error) {
+
+	m.mu.Lock()
+	conn := m.conn
+	subscribed := len(m.requests) > 0
+	m.mu.Unlock()
+
+	if conn != nil && subscribed {
+		return conn, nil
+	}
+
+	select {
+	case <-m.subscribed:
+	case <-time.After(connectTimeout):
+		return nil, errors.New("No subscription to mock stream")
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return m.conn, nil
+}
+
+func (m *MockStream) handle(w http.ResponseWriter, r *http.Request) {
+
+	conn, err := m.upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		return
+	}
+	defer conn.Close()
+
+	m.mu.Lock()
+	m.conn = conn
+	m.mu.Unlock()
+
+	for {
+		request := models.WSRequest{}
+		if err := conn.ReadJSON(&request); err != nil {
+			return
+		}
+
+		var reply models.ResponseType
+		switch request.Op {
+		case models.Subscribe:
+			reply = models.Subscribed
+		case models.UnSubscribe:
+			reply = models.UnSubscribed
+		default:
+			continue
+		}
+
+		m.mu.Lock()
+		m.requests = append(m.requests, request)
+		err := conn.WriteJSON(models.WsResponse{
+			ChannelType:  request.ChannelType,
+			Market:       request.Market,
+			ResponseType: reply,
+		})
+		m.mu.Unlock()
+
+		if err != nil {
+			return
+		}
+
+		select {
+		case m.subscribed <- struct{}{}:
+		default:
+		}
+	}
+}
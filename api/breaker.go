@@ -0,0 +1,76 @@
+package api
+
+import (
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// ErrCircuitOpen is returned without sending the request while the circuit
+// breaker set with SetCircuitBreaker is open.
+var ErrCircuitOpen = errors.New("Circuit breaker open")
+
+// circuitBreaker opens after threshold consecutive failed requests, failing
+// the requests made during the cooldown that follows. Once it is over a
+// single probe request is let through, which closes the breaker if it
+// succeeds and opens it again if it fails.
+type circuitBreaker struct {
+	mu        sync.Mutex
+	threshold int
+	cooldown  time.Duration
+	failures  int
+	openUntil time.Time
+	probing   bool
+}
+
+// allow returns ErrCircuitOpen if the breaker is open or half open with a
+// probe already in flight. Otherwise the request may be sent and its outcome
+// must be recorded with done.
+func (b *circuitBreaker) allow() error {
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.threshold <= 0 || b.failures < b.threshold {
+		return nil
+	}
+
+	if b.probing || time.Now().Before(b.openUntil) {
+		return errors.Wrapf(ErrCircuitOpen, "%d consecutive failures", b.failures)
+	}
+
+	b.probing = true
+
+	return nil
+}
+
+func (b *circuitBreaker) done(failed bool) {
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.threshold <= 0 {
+		return
+	}
+
+	b.probing = false
+
+	if !failed {
+		b.failures = 0
+		return
+	}
+
+	b.failures++
+	if b.failures >= b.threshold {
+		b.openUntil = time.Now().Add(b.cooldown)
+	}
+}
+
+// cancelled lets another probe through if the request was one, its outcome
+// being unknown.
+func (b *circuitBreaker) cancelled() {
+	b.mu.Lock()
+	b.probing = false
+	b.mu.Unlock()
+}
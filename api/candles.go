@@ -0,0 +1,160 @@
+package api
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/uscott/go-ftx/models"
+)
+
+const candleBufferSize int = 64
+
+// CandleAggregator builds OHLCV bars of a set of markets from the trades
+// channel. Bars start on multiples of the resolution in UTC and are sent on
+// Candles once complete, which is when a trade of a later bar arrives or the
+// bar's end has passed. Markets without trades during a bar get no bar.
+type CandleAggregator struct {
+	resolution time.Duration
+	mu         sync.Mutex
+	bars       map[string]*models.Candle
+	candlesC   chan *models.Candle
+}
+
+func NewCandleAggregator(
+	ctx context.Context, stream *Stream, resolution time.Duration, markets ...string,
+) (*CandleAggregator, error) {
+
+	if resolution < time.Second {
+		return nil, errors.Errorf("Invalid resolution: %v", resolution)
+	}
+
+	tradesC, err := stream.SubscribeToTrades(ctx, markets...)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	a := newCandleAggregator(resolution)
+
+	go a.run(ctx, tradesC)
+
+	return a, nil
+}
+
+func newCandleAggregator(resolution time.Duration) *CandleAggregator {
+	return &CandleAggregator{
+		resolution: resolution,
+		bars:       make(map[string]*models.Candle),
+		candlesC:   make(chan *models.Candle, candleBufferSize),
+	}
+}
+
+// Candles returns the channel on which completed bars are sent. Trades are
+// not consumed while it is full.
+func (a *CandleAggregator) Candles() chan *models.Candle {
+	return a.candlesC
+}
+
+// Current returns the bar of the market being formed.
+func (a *CandleAggregator) Current(market string) (candle models.Candle, ok bool) {
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	bar := a.bars[market]
+	if bar == nil {
+		return
+	}
+
+	return *bar, true
+}
+
+func (a *CandleAggregator) run(ctx context.Context, tradesC chan *models.TradeResponse) {
+
+	ticker := time.NewTicker(a.resolution / 10)
+	defer ticker.Stop()
+
+	for {
+		var done []*models.Candle
+
+		select {
+		case <-ctx.Done():
+			return
+		case t, ok := <-tradesC:
+			if !ok {
+				return
+			}
+			done = a.add(t.Symbol, &t.Trade)
+		case now := <-ticker.C:
+			done = a.flush(now)
+		}
+
+		for _, c := range done {
+			select {
+			case a.candlesC <- c:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}
+}
+
+// add folds the trade into the bar of its market, returning the previous bar
+// if the trade starts a new one. Trades older than the current bar are
+// dropped.
+func (a *CandleAggregator) add(market string, t *models.Trade) []*models.Candle {
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	start := t.Time.UTC().Truncate(a.resolution)
+
+	var done []*models.Candle
+
+	bar := a.bars[market]
+	if bar != nil && start.Before(bar.StartTime) {
+		return nil
+	}
+	if bar != nil && start.After(bar.StartTime) {
+		done = append(done, bar)
+		bar = nil
+	}
+
+	if bar == nil {
+		bar = &models.Candle{Market: market}
+		bar.StartTime = start
+		bar.Open, bar.High, bar.Low = t.Price, t.Price, t.Price
+		a.bars[market] = bar
+	}
+
+	if t.Price.GreaterThan(bar.High) {
+		bar.High = t.Price
+	}
+	if t.Price.LessThan(bar.Low) {
+		bar.Low = t.Price
+	}
+	bar.Close = t.Price
+	bar.Volume = bar.Volume.Add(t.Size)
+
+	return done
+}
+
+// flush returns the bars which ended by now.
+func (a *CandleAggregator) flush(now time.Time) []*models.Candle {
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	var done []*models.Candle
+
+	for market, bar := range a.bars {
+		if !now.Before(bar.StartTime.Add(a.resolution)) {
+			done = append(done, bar)
+			delete(a.bars, market)
+		}
+	}
+
+	return done
+}
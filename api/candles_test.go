@@ -0,0 +1,84 @@
+package api
+
+import (
+	"testing"
+	"time"
+
+	"github.com/shopspring/decimal"
+	"github.com/uscott/go-ftx/models"
+)
+
+func TestCandleAggregator_add(t *testing.T) {
+
+	a := newCandleAggregator(time.Minute)
+	start := time.Date(2021, 6, 1, 12, 0, 0, 0, time.UTC)
+
+	trade := func(offset time.Duration, price, size int64) *models.Trade {
+		return &models.Trade{
+			Price: decimal.NewFromInt(price),
+			Size:  decimal.NewFromInt(size),
+			Time:  start.Add(offset),
+		}
+	}
+
+	trades := []*models.Trade{
+		trade(5*time.Second, 100, 1),
+		trade(20*time.Second, 105, 2),
+		trade(40*time.Second, 95, 1),
+		trade(59*time.Second, 101, 3),
+	}
+	for _, tr := range trades {
+		if done := a.add("BTC-PERP", tr); len(done) != 0 {
+			t.Fatalf("No bar should be complete: %+v", done)
+		}
+	}
+
+	current, ok := a.Current("BTC-PERP")
+	if !ok || !current.StartTime.Equal(start) {
+		t.Fatalf("Unexpected bar: %+v", current)
+	}
+
+	done := a.add("BTC-PERP", trade(61*time.Second, 102, 1))
+	if len(done) != 1 {
+		t.Fatalf("Length inequality: %d, %d", len(done), 1)
+	}
+
+	bar := done[0]
+	expected := []struct {
+		name     string
+		value    decimal.Decimal
+		expected int64
+	}{
+		{"open", bar.Open, 100},
+		{"high", bar.High, 105},
+		{"low", bar.Low, 95},
+		{"close", bar.Close, 101},
+		{"volume", bar.Volume, 7},
+	}
+	for _, e := range expected {
+		if !e.value.Equal(decimal.NewFromInt(e.expected)) {
+			t.Fatalf("Should be equal: %v, %v - %s", e.value, e.expected, e.name)
+		}
+	}
+	if bar.Market != "BTC-PERP" || !bar.StartTime.Equal(start) {
+		t.Fatalf("Unexpected bar: %+v", bar)
+	}
+
+	// Late trades are dropped
+	if done = a.add("BTC-PERP", trade(30*time.Second, 1, 1)); len(done) != 0 {
+		t.Fatalf("No bar should be complete: %+v", done)
+	}
+	if current, _ = a.Current("BTC-PERP"); !current.Low.Equal(decimal.NewFromInt(102)) {
+		t.Fatalf("Should be equal: %v, %v", current.Low, 102)
+	}
+
+	if done = a.flush(start.Add(119 * time.Second)); len(done) != 0 {
+		t.Fatalf("No bar should be complete: %+v", done)
+	}
+	if done = a.flush(start.Add(2 * time.Minute)); len(done) != 1 {
+		t.Fatalf("Length inequality: %d, %d", len(done), 1)
+	}
+	if _, ok = a.Current("BTC-PERP"); ok {
+		t.Fatal("No bar should be forming")
+	}
+}
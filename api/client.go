@@ -2,16 +2,22 @@ package api
 
 import (
 	"bytes"
+	"context"
 	"crypto/hmac"
 	"crypto/sha256"
 	"encoding/hex"
 	"encoding/json"
 	"fmt"
+	"io"
 	"io/ioutil"
+	"math/rand"
+	"net"
 	"net/http"
 	"net/url"
 	"reflect"
 	"strconv"
+	"strings"
+	"sync"
 	"time"
 
 	"github.com/pkg/errors"
@@ -22,12 +28,61 @@ const (
 	apiUrl    = "https://ftx.com/api"
 	apiOtcUrl = "https://otc.ftx.com/api"
 
+	apiUsUrl = "https://ftx.us/api"
+	wsUsUrl  = "wss://ftx.us/ws/"
+
 	keyHeader     = "FTX-KEY"
 	signHeader    = "FTX-SIGN"
 	tsHeader      = "FTX-TS"
 	subacctHeader = "FTX-SUBACCOUNT"
+
+	usHeaderPrefix = "FTXUS"
+
+	maxIdleConnsPerHost = 32
+	idleConnTimeout     = 90 * time.Second
+
+	// Version is the version of the library.
+	Version = "0.1.0"
+
+	// DefaultUserAgent is the User-Agent of REST requests and websocket
+	// connections unless changed with SetUserAgent.
+	DefaultUserAgent = "go-ftx/" + Version
 )
 
+// Region selects the FTX exchange the client talks to.
+//
+// FTX US (RegionUS) serves the same REST paths and websocket channels on
+// ftx.us but lists a smaller product set: there are no futures, perpetuals,
+// options, leveraged tokens, spot margin or staking, so the corresponding
+// endpoints return errors. Authenticated requests use FTXUS-prefixed headers.
+type Region int
+
+const (
+	RegionGlobal Region = iota
+	RegionUS
+)
+
+func (r Region) apiURL() string {
+	if r == RegionUS {
+		return apiUsUrl
+	}
+	return apiUrl
+}
+
+func (r Region) wsURL() string {
+	if r == RegionUS {
+		return wsUsUrl
+	}
+	return wsUrl
+}
+
+func (r Region) header(h string) string {
+	if r == RegionUS {
+		return usHeaderPrefix + strings.TrimPrefix(h, "FTX")
+	}
+	return h
+}
+
 type Option func(c *Client)
 
 func WithHTTPClient(client *http.Client) Option {
@@ -43,6 +98,76 @@ func WithAuth(key, secret string) Option {
 	}
 }
 
+// WithRegion points both the REST client and the Stream at the given region.
+func WithRegion(region Region) Option {
+	return func(c *Client) {
+		c.region = region
+	}
+}
+
+// SetStrictDecode makes decoding fail on fields the response types do not
+// have, so that changes to the API surface in tests rather than leaving zero
+// values. Websocket messages with unknown fields are logged as warnings. It
+// is off by default.
+func SetStrictDecode(strict bool) Option {
+	return func(c *Client) {
+		c.strictDecode = strict
+	}
+}
+
+// SetRetry makes GET requests failing with a network error or a 5xx status
+// be retried up to maxRetries times with exponential backoff from baseDelay
+// plus jitter. Other requests, order placements among them, are never
+// retried. There are no retries by default.
+func SetRetry(maxRetries int, baseDelay time.Duration) Option {
+	return func(c *Client) {
+		c.maxRetries = maxRetries
+		c.retryBaseDelay = baseDelay
+	}
+}
+
+// SetCircuitBreaker makes requests fail with ErrCircuitOpen for cooldown once
+// failures requests in a row have failed with a network error or a 5xx
+// status, so that an outage is not met with a flood of requests. A single
+// request is then let through to probe FTX, the next ones failing until it
+// returns: it closes the breaker if it succeeds and opens it for another
+// cooldown if it fails. There is no circuit breaker by default.
+func SetCircuitBreaker(failures int, cooldown time.Duration) Option {
+	return func(c *Client) {
+		c.breaker.threshold = failures
+		c.breaker.cooldown = cooldown
+	}
+}
+
+// SetClock makes the client and its Stream take the time from clock instead
+// of time.Now for the timestamps of signed requests, websocket logins and
+// pings, so that tests can freeze it. Timers still run on the real clock.
+func SetClock(clock func() time.Time) Option {
+	return func(c *Client) {
+		c.clock = clock
+	}
+}
+
+// SetProxyFromEnvironment makes both REST requests and websocket connections
+// go through the proxy set by the HTTP_PROXY, HTTPS_PROXY and NO_PROXY
+// environment variables, as the default ones do, whatever the transport
+// given with WithHTTPClient. Only an *http.Transport can be set a proxy, it
+// is cloned first.
+func SetProxyFromEnvironment() Option {
+	return func(c *Client) {
+		c.envProxy = true
+	}
+}
+
+// SetAccountCacheTTL makes GetAccountInformation and GetPositions serve the
+// result of an earlier call for up to ttl instead of asking FTX again, see
+// InvalidateAccountCache. Nothing is cached by default.
+func SetAccountCacheTTL(ttl time.Duration) Option {
+	return func(c *Client) {
+		c.accountCache.ttl = ttl
+	}
+}
+
 func SetSubAccount(nickname string) Option {
 	return func(c *Client) {
 		if len(nickname) > 0 {
@@ -56,6 +181,18 @@ type Client struct {
 	apiKey         string
 	secret         string
 	serverTimeDiff time.Duration
+	region         Region
+	strictDecode   bool
+	maxRetries     int
+	retryBaseDelay time.Duration
+	headersMu      sync.RWMutex
+	headers        http.Header
+	userAgent      string
+	accountCache   accountCache
+	coins          coinsCache
+	breaker        circuitBreaker
+	clock          func() time.Time
+	envProxy       bool
 	SubAccount     *string
 	Logger         *clog.Logger
 	Buf            *bytes.Buffer
@@ -75,12 +212,27 @@ type Client struct {
 	Stream
 }
 
+// newHTTPClient returns the default http.Client of a Client. Its transport
+// keeps enough idle connections to FTX for concurrent requests to reuse TLS
+// sessions rather than dial again, which http.DefaultTransport does not as it
+// allows only two per host.
+func newHTTPClient() *http.Client {
+
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.MaxIdleConns = maxIdleConnsPerHost
+	transport.MaxIdleConnsPerHost = maxIdleConnsPerHost
+	transport.IdleConnTimeout = idleConnTimeout
+
+	return &http.Client{Transport: transport}
+}
+
 func New(opts ...Option) *Client {
 
 	client := &Client{
-		client: http.DefaultClient,
-		Logger: clog.New(),
-		Buf:    bytes.NewBuffer(make([]byte, 128)),
+		client:    newHTTPClient(),
+		userAgent: DefaultUserAgent,
+		Logger:    clog.New(),
+		Buf:       bytes.NewBuffer(make([]byte, 128)),
 	}
 	for _, opt := range opts {
 		opt(client)
@@ -99,9 +251,25 @@ func New(opts ...Option) *Client {
 	client.SubAccounts = SubAccounts{client: client}
 	client.Wallet = Wallet{client: client}
 	client.Stream = *NewStream(client)
+	if client.envProxy {
+		client.useProxyFromEnvironment()
+	}
 	return client
 }
 
+func (c *Client) useProxyFromEnvironment() {
+
+	if transport, ok := c.client.Transport.(*http.Transport); ok {
+		transport = transport.Clone()
+		transport.Proxy = http.ProxyFromEnvironment
+		client := *c.client
+		client.Transport = transport
+		c.client = &client
+	}
+
+	c.Stream.SetProxyFromEnvironment()
+}
+
 func (c *Client) Get(params interface{}, url string, auth bool) ([]byte, error) {
 	return c.GetResponse(params, url, http.MethodGet, auth)
 }
@@ -116,13 +284,22 @@ func (c *Client) Delete(params interface{}, url string) ([]byte, error) {
 
 func (c *Client) GetResponse(
 	params interface{}, url string, method string, auth ...bool) ([]byte, error) {
+	return c.GetResponseWithContext(context.Background(), params, url, method, auth...)
+}
+
+// GetResponseWithContext is GetResponse with the requests made with ctx, which
+// cancels them and the waits between retries and may carry a trace id.
+func (c *Client) GetResponseWithContext(
+	ctx context.Context, params interface{}, url string, method string, auth ...bool,
+) ([]byte, error) {
 
 	if params == nil {
-		return c.GetResponse(&struct{}{}, url, method, auth...)
+		return c.GetResponseWithContext(ctx, &struct{}{}, url, method, auth...)
 	}
 
 	var (
 		err     error
+		r       Request
 		request *http.Request
 	)
 
@@ -143,15 +320,13 @@ func (c *Client) GetResponse(
 			subacct = c.SubAccount
 		}
 
-		request, err = c.prepareRequest(Request{
+		r = Request{
+			Context:    ctx,
 			Auth:       auth[0],
 			Method:     method,
 			URL:        url,
 			SubAccount: subacct,
 			Params:     queryParams,
-		})
-		if err != nil {
-			return nil, errors.WithStack(err)
 		}
 
 	case http.MethodPost, http.MethodDelete:
@@ -161,22 +336,40 @@ func (c *Client) GetResponse(
 			return nil, errors.WithStack(err)
 		}
 
-		request, err = c.prepareRequest(Request{
+		r = Request{
+			Context:    ctx,
 			Auth:       true,
 			Method:     method,
 			URL:        url,
 			SubAccount: c.SubAccount,
 			Body:       body,
-		})
-		if err != nil {
-			return nil, errors.WithStack(err)
 		}
 
 	default:
 		return nil, fmt.Errorf("Invalid http method: %v", method)
 	}
 
+	request, err = c.prepareRequest(r)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
 	response, err := c.do(request)
+
+	// Only GETs are retried since a failed order placement may have landed
+	for attempt := 1; err != nil && method == http.MethodGet &&
+		attempt <= c.maxRetries && isUnknownOutcome(err); attempt++ {
+
+		if err = c.retryWait(request.Context(), attempt); err != nil {
+			return nil, err
+		}
+
+		// Signed again since FTX rejects stale timestamps
+		if request, err = c.prepareRequest(r); err != nil {
+			return nil, errors.WithStack(err)
+		}
+		response, err = c.do(request)
+	}
 	if err != nil {
 		return nil, errors.WithStack(err)
 	}
@@ -184,15 +377,86 @@ func (c *Client) GetResponse(
 	return response, nil
 }
 
+// retryWait sleeps before the given retry, doubling the base delay with each
+// attempt and adding up to as much again in jitter.
+func (c *Client) retryWait(ctx context.Context, attempt int) error {
+
+	delay := c.retryBaseDelay << uint(attempt-1)
+	if delay > 0 {
+		delay += time.Duration(rand.Int63n(int64(delay)))
+	}
+
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-time.After(delay):
+		return nil
+	}
+}
+
+// isUnknownOutcome reports whether the request may have been processed
+// despite err: the connection failed or timed out, or FTX had an internal
+// error.
+func isUnknownOutcome(err error) bool {
+	var netErr net.Error
+	if errors.As(err, &netErr) {
+		return true
+	}
+	var apiErr *APIError
+	return errors.As(err, &apiErr) && apiErr.StatusCode >= http.StatusInternalServerError
+}
+
+// now returns the time of the clock set with SetClock.
+func (c *Client) now() time.Time {
+	if c.clock == nil {
+		return time.Now()
+	}
+	return c.clock()
+}
+
 func (c *Client) SetServerTimeDiff() error {
 	serverTime, err := c.GetServerTime()
 	if err != nil {
 		return errors.WithStack(err)
 	}
-	c.serverTimeDiff = serverTime.Sub(time.Now().UTC())
+	c.serverTimeDiff = serverTime.Sub(c.now().UTC())
 	return nil
 }
 
+// APIError is returned when FTX answers a request with success set to false.
+type APIError struct {
+	StatusCode int
+	Message    string
+}
+
+func (e *APIError) Error() string {
+	return fmt.Sprintf("Status Code: %d	Error: %v", e.StatusCode, e.Message)
+}
+
+// PartialError is returned along with the parts of a result which could be
+// fetched when others could not, Errors holding the error of each failed
+// part. It unwraps to the first of them.
+type PartialError struct {
+	Errors []error
+	Parts  int
+}
+
+func (e *PartialError) Error() string {
+	msgs := make([]string, len(e.Errors))
+	for i, err := range e.Errors {
+		msgs[i] = err.Error()
+	}
+	return fmt.Sprintf("%d of %d requests failed: %s",
+		len(e.Errors), e.Parts, strings.Join(msgs, "; "))
+}
+
+func (e *PartialError) Unwrap() error {
+	if len(e.Errors) == 0 {
+		return nil
+	}
+	return e.Errors[0]
+}
+
 type Response struct {
 	Success bool            `json:"success"`
 	Result  json.RawMessage `json:"result"`
@@ -200,6 +464,7 @@ type Response struct {
 }
 
 type Request struct {
+	Context    context.Context // Background if nil
 	Auth       bool
 	Method     string
 	URL        string
@@ -211,11 +476,32 @@ type Request struct {
 
 func (c *Client) prepareRequest(request Request) (*http.Request, error) {
 
-	req, err := http.NewRequest(request.Method, request.URL, bytes.NewBuffer(request.Body))
+	u := request.URL
+	if c.region != RegionGlobal && strings.HasPrefix(u, apiUrl) {
+		u = c.region.apiURL() + strings.TrimPrefix(u, apiUrl)
+	}
+
+	ctx := request.Context
+	if ctx == nil {
+		ctx = context.Background()
+	}
+
+	req, err := http.NewRequestWithContext(ctx, request.Method, u, bytes.NewBuffer(request.Body))
 	if err != nil {
 		return nil, errors.WithStack(err)
 	}
 
+	c.headersMu.RLock()
+	if c.userAgent != "" {
+		req.Header.Set("User-Agent", c.userAgent)
+	}
+	for k, v := range c.headers {
+		if !c.isAuthHeader(k) {
+			req.Header[k] = append([]string(nil), v...)
+		}
+	}
+	c.headersMu.RUnlock()
+
 	query := req.URL.Query()
 	for k, v := range request.Params {
 		query.Add(k, v)
@@ -223,26 +509,18 @@ func (c *Client) prepareRequest(request Request) (*http.Request, error) {
 	req.URL.RawQuery = query.Encode()
 
 	if request.Auth {
-		c.Buf.Reset()
-		nonce := strconv.FormatInt(time.Now().UTC().Add(c.serverTimeDiff).Unix()*1000, 10)
-		c.Buf.WriteString(nonce)
-		c.Buf.WriteString(req.Method)
-		c.Buf.WriteString(req.URL.Path)
+		ts := c.now().UTC().Add(c.serverTimeDiff).Unix() * 1000
+		nonce := strconv.FormatInt(ts, 10)
+		path := req.URL.Path
 		if req.URL.RawQuery != "" {
-			c.Buf.WriteRune('?')
-			c.Buf.WriteString(req.URL.RawQuery)
+			path += "?" + req.URL.RawQuery
 		}
-		if len(request.Body) > 0 {
-			c.Buf.WriteString(string(request.Body))
-		}
-		payload := c.Buf.String()
-		c.Buf.Reset()
 		req.Header.Set("Content-Type", "application/json")
-		req.Header.Set(keyHeader, c.apiKey)
-		req.Header.Set(signHeader, c.signature(payload))
-		req.Header.Set(tsHeader, nonce)
+		req.Header.Set(c.region.header(keyHeader), c.apiKey)
+		req.Header.Set(c.region.header(signHeader), c.sign(ts, req.Method, path, request.Body))
+		req.Header.Set(c.region.header(tsHeader), nonce)
 		if request.SubAccount != nil {
-			req.Header.Set(subacctHeader, url.QueryEscape(*request.SubAccount))
+			req.Header.Set(c.region.header(subacctHeader), url.QueryEscape(*request.SubAccount))
 		}
 	}
 
@@ -253,11 +531,87 @@ func (c *Client) prepareRequest(request Request) (*http.Request, error) {
 	return req, nil
 }
 
+// SetHeader sets a header sent with every REST request, for instance for a
+// proxy or tracing. The FTX authentication headers cannot be set this way.
+func (c *Client) SetHeader(key, value string) {
+
+	c.headersMu.Lock()
+	defer c.headersMu.Unlock()
+
+	if c.headers == nil {
+		c.headers = make(http.Header)
+	}
+	c.headers.Set(key, value)
+}
+
+// SetUserAgent sets the User-Agent of REST requests and websocket
+// connections, for instance to identify an application to FTX support. An
+// empty user agent leaves the Go default.
+func (c *Client) SetUserAgent(ua string) {
+	c.headersMu.Lock()
+	c.userAgent = ua
+	c.headersMu.Unlock()
+}
+
+// UserAgent returns the user agent set with SetUserAgent.
+func (c *Client) UserAgent() string {
+	c.headersMu.RLock()
+	defer c.headersMu.RUnlock()
+	return c.userAgent
+}
+
+func (c *Client) isAuthHeader(key string) bool {
+	for _, h := range []string{keyHeader, signHeader, tsHeader, subacctHeader} {
+		if strings.EqualFold(key, h) || strings.EqualFold(key, c.region.header(h)) {
+			return true
+		}
+	}
+	return false
+}
+
 func (c *Client) do(req *http.Request) ([]byte, error) {
 
+	if err := c.breaker.allow(); err != nil {
+		return nil, err
+	}
+
+	result, err := c.doTraced(req)
+
+	// Requests the caller cancelled say nothing about the health of FTX
+	if req.Context().Err() != nil {
+		c.breaker.cancelled()
+	} else {
+		c.breaker.done(isUnknownOutcome(err))
+	}
+
+	return result, err
+}
+
+func (c *Client) doTraced(req *http.Request) ([]byte, error) {
+
+	// Requests with a trace id are logged, those without cost nothing more
+	if trace := TraceID(req.Context()); trace != "" {
+		result, err := c.doRequest(req)
+		if err != nil {
+			c.Logger.Debugf("%s %s trace %s: %v", req.Method, req.URL.Path, trace, err)
+			return nil, errors.Wrapf(err, "Trace %s", trace)
+		}
+		c.Logger.Debugf("%s %s trace %s", req.Method, req.URL.Path, trace)
+		return result, nil
+	}
+
+	return c.doRequest(req)
+}
+
+func (c *Client) doRequest(req *http.Request) ([]byte, error) {
+
 	resp, err := c.client.Do(req)
 	if resp != nil {
-		defer resp.Body.Close()
+		// The body must be read to EOF for the connection to be reused
+		defer func() {
+			_, _ = io.Copy(ioutil.Discard, resp.Body)
+			resp.Body.Close()
+		}()
 	}
 	if err != nil {
 		return nil, errors.WithStack(err)
@@ -275,12 +629,28 @@ func (c *Client) do(req *http.Request) ([]byte, error) {
 	}
 
 	if !response.Success {
-		return nil, errors.Errorf("Status Code: %d	Error: %v", resp.StatusCode, response.Error)
+		return nil, errors.WithStack(
+			&APIError{StatusCode: resp.StatusCode, Message: response.Error})
 	}
 
 	return response.Result, nil
 }
 
+// unmarshal decodes the result of a REST response into v, rejecting unknown
+// fields in strict mode.
+func (c *Client) unmarshal(data []byte, v interface{}) error {
+	if !c.strictDecode {
+		return json.Unmarshal(data, v)
+	}
+	return strictUnmarshal(data, v)
+}
+
+func strictUnmarshal(data []byte, v interface{}) error {
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.DisallowUnknownFields()
+	return decoder.Decode(v)
+}
+
 func (c *Client) prepareQueryParams(params interface{}) map[string]string {
 
 	result := make(map[string]string)
@@ -298,12 +668,33 @@ func (c *Client) prepareQueryParams(params interface{}) map[string]string {
 	return result
 }
 
+// sign returns the hex encoded HMAC-SHA256 of ts + method + path + body
+// as required by the FTX-SIGN header. path includes any query string.
+func (c *Client) sign(ts int64, method, path string, body []byte) string {
+	return c.signature(strconv.FormatInt(ts, 10) + method + path + string(body))
+}
+
 func (c *Client) signature(payload string) string {
 	mac := hmac.New(sha256.New, []byte(c.secret))
 	_, _ = mac.Write([]byte(payload))
 	return hex.EncodeToString(mac.Sum(nil))
 }
 
+func (c *Client) signWSLogin(ms int64) map[string]interface{} {
+
+	args := map[string]interface{}{
+		"key":  c.apiKey,
+		"sign": c.signature(fmt.Sprintf("%dwebsocket_login", ms)),
+		"time": ms,
+	}
+
+	if c.SubAccount != nil {
+		args["subaccount"] = *c.SubAccount
+	}
+
+	return args
+}
+
 func (c *Client) GetServerTime() (*time.Time, error) {
 	request, err := c.prepareRequest(Request{
 		Method: http.MethodGet,
@@ -320,7 +711,7 @@ func (c *Client) GetServerTime() (*time.Time, error) {
 
 	var result time.Time
 
-	if err = json.Unmarshal(response, &result); err != nil {
+	if err = c.unmarshal(response, &result); err != nil {
 		return nil, errors.WithStack(err)
 	}
 
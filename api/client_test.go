@@ -0,0 +1,449 @@
+package api
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/uscott/go-ftx/models"
+)
+
+func TestClient_signWSLogin(t *testing.T) {
+
+	c := New(WithAuth("key", "secret"))
+
+	args := c.signWSLogin(1557246346499)
+
+	expected := "b94cff7e4e8d45118550921d8a77393e5b87e26a72052a35da6972f79be26047"
+	if args["sign"] != expected {
+		t.Fatalf("Should be equal: %v, %v", args["sign"], expected)
+	}
+	if args["key"] != "key" || args["time"] != int64(1557246346499) {
+		t.Fatalf("Unexpected args: %+v", args)
+	}
+	if _, ok := args["subaccount"]; ok {
+		t.Fatal("Subaccount should not be set")
+	}
+
+	c = New(WithAuth("key", "secret"), SetSubAccount("sub"))
+	if args = c.signWSLogin(1557246346499); args["subaccount"] != "sub" {
+		t.Fatalf("Should be equal: %v, %v", args["subaccount"], "sub")
+	}
+}
+
+func TestClient_sign(t *testing.T) {
+
+	// Vectors from https://blog.ftx.com/blog/api-authentication/
+	c := New(WithAuth("key", "T4lPid48QtjNxjLUFOcUZghD7CUJ7sTVsfuvQZF2"))
+
+	tests := []struct {
+		ts       int64
+		method   string
+		path     string
+		body     []byte
+		expected string
+	}{
+		{
+			ts:       1588591511721,
+			method:   "GET",
+			path:     "/api/markets",
+			expected: "dbc62ec300b2624c580611858d94f2332ac636bb86eccfa1167a7777c496ee6f",
+		},
+		{
+			ts:     1588591856950,
+			method: "POST",
+			path:   "/api/orders",
+			body: []byte(`{"market": "BTC-PERP", "side": "buy", "price": 8500, "size": 1, ` +
+				`"type": "limit", "reduceOnly": false, "ioc": false, "postOnly": false, ` +
+				`"clientId": null}`),
+			expected: "c4fbabaf178658a59d7bbf57678d44c369382f3da29138f04cd46d3d582ba4ba",
+		},
+	}
+
+	for i, test := range tests {
+		if sign := c.sign(test.ts, test.method, test.path, test.body); sign != test.expected {
+			t.Fatalf("Should be equal: %s, %s - test #%d", sign, test.expected, i+1)
+		}
+	}
+}
+
+func TestClient_prepareRequest(t *testing.T) {
+
+	c := New(WithAuth("key", "T4lPid48QtjNxjLUFOcUZghD7CUJ7sTVsfuvQZF2"))
+
+	req, err := c.prepareRequest(Request{
+		Auth:   true,
+		Method: "GET",
+		URL:    FormURL("/markets"),
+		Params: map[string]string{"depth": "20"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ts, err := strconv.ParseInt(req.Header.Get(tsHeader), 10, 64)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := c.sign(ts, "GET", "/api/markets?depth=20", nil)
+	if sign := req.Header.Get(signHeader); sign != expected {
+		t.Fatalf("Should be equal: %s, %s", sign, expected)
+	}
+	if key := req.Header.Get(keyHeader); key != "key" {
+		t.Fatalf("Should be equal: %s, %s", key, "key")
+	}
+}
+
+func TestClient_SetClock(t *testing.T) {
+
+	frozen := time.Unix(0, 1557246346499*int64(time.Millisecond))
+	c := New(WithAuth("key", "secret"), SetClock(func() time.Time { return frozen }))
+
+	req, err := c.prepareRequest(Request{Auth: true, Method: "GET", URL: FormURL("/account")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ts := req.Header.Get(tsHeader); ts != "1557246346000" {
+		t.Fatalf("Should be equal: %s, %s", ts, "1557246346000")
+	}
+	expected := "8e4bd47227bb228661b020b737ae10f78e5e376643521e65dcb50d29f5d1b792"
+	if sign := req.Header.Get(signHeader); sign != expected {
+		t.Fatalf("Should be equal: %s, %s", sign, expected)
+	}
+
+	auth, err := c.Stream.GetAuthRequest()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ms := auth.Args["time"]; ms != int64(1557246346499) {
+		t.Fatalf("Should be equal: %v, %v", ms, 1557246346499)
+	}
+	expected = "b94cff7e4e8d45118550921d8a77393e5b87e26a72052a35da6972f79be26047"
+	if sign := auth.Args["sign"]; sign != expected {
+		t.Fatalf("Should be equal: %v, %v", sign, expected)
+	}
+}
+
+func TestClient_WithRegion(t *testing.T) {
+
+	c := New(WithAuth("key", "secret"), WithRegion(RegionUS), SetSubAccount("sub"))
+
+	req, err := c.prepareRequest(Request{
+		Auth:       true,
+		Method:     "GET",
+		URL:        FormURL("/markets"),
+		SubAccount: c.SubAccount,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if host := req.URL.Host; host != "ftx.us" {
+		t.Fatalf("Should be equal: %s, %s", host, "ftx.us")
+	}
+	if key := req.Header.Get("FTXUS-KEY"); key != "key" {
+		t.Fatalf("Should be equal: %s, %s", key, "key")
+	}
+	if sub := req.Header.Get("FTXUS-SUBACCOUNT"); sub != "sub" {
+		t.Fatalf("Should be equal: %s, %s", sub, "sub")
+	}
+	if req.Header.Get(keyHeader) != "" {
+		t.Fatalf("%s should not be set", keyHeader)
+	}
+
+	ts, err := strconv.ParseInt(req.Header.Get("FTXUS-TS"), 10, 64)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := c.sign(ts, "GET", "/api/markets", nil)
+	if sign := req.Header.Get("FTXUS-SIGN"); sign != expected {
+		t.Fatalf("Should be equal: %s, %s", sign, expected)
+	}
+
+	if c.Stream.url != wsUsUrl {
+		t.Fatalf("Should be equal: %s, %s", c.Stream.url, wsUsUrl)
+	}
+}
+
+func newCountingServer(conns *int64) *httptest.Server {
+
+	server := httptest.NewUnstartedServer(http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) {
+			_, _ = w.Write([]byte(`{"success": true, "result": []}`))
+		}))
+	server.Config.ConnState = func(conn net.Conn, state http.ConnState) {
+		if state == http.StateNew {
+			atomic.AddInt64(conns, 1)
+		}
+	}
+	server.Start()
+
+	return server
+}
+
+func TestClient_connectionReuse(t *testing.T) {
+
+	var conns int64
+	server := newCountingServer(&conns)
+	defer server.Close()
+
+	c := New()
+	for i := 0; i < 10; i++ {
+		if _, err := c.Get(nil, server.URL+"/markets", false); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if n := atomic.LoadInt64(&conns); n != 1 {
+		t.Fatalf("Should be equal: %d, %d", n, 1)
+	}
+}
+
+func BenchmarkClient_Get(b *testing.B) {
+
+	var conns int64
+	server := newCountingServer(&conns)
+	defer server.Close()
+
+	c := New()
+	url := server.URL + "/markets"
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := c.Get(nil, url, false); err != nil {
+			b.Fatal(err)
+		}
+	}
+	b.ReportMetric(float64(atomic.LoadInt64(&conns)), "conns")
+}
+
+func TestClient_SetStrictDecode(t *testing.T) {
+
+	handler := func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(`{"success": true, "result": ` +
+			`{"name": "BTC-PERP", "type": "future", "newField": 1}}`))
+	}
+
+	for _, strict := range []bool{false, true} {
+
+		c := newTestClient(t, handler, SetStrictDecode(strict))
+
+		market := models.Market{}
+		err := c.Markets.GetMarketByName("BTC-PERP", &market)
+		if strict {
+			if err == nil {
+				t.Fatal("Strict decoding should reject an unknown field")
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		if market.Name != "BTC-PERP" {
+			t.Fatalf("Should be equal: %s, %s", market.Name, "BTC-PERP")
+		}
+	}
+}
+
+func TestClient_SetRetry(t *testing.T) {
+
+	var gets, posts int64
+
+	c := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodPost {
+			atomic.AddInt64(&posts, 1)
+		} else if atomic.AddInt64(&gets, 1) > 2 {
+			_, _ = w.Write([]byte(`{"success": true, "result": []}`))
+			return
+		}
+		w.WriteHeader(http.StatusServiceUnavailable)
+		_, _ = w.Write([]byte(`{"success": false, "error": "Service unavailable"}`))
+	}, SetRetry(3, time.Millisecond))
+
+	if _, err := c.Markets.GetMarkets(); err != nil {
+		t.Fatal(err)
+	}
+	if n := atomic.LoadInt64(&gets); n != 3 {
+		t.Fatalf("Should be equal: %d, %d", n, 3)
+	}
+
+	err := c.Orders.PlaceOrder(&models.OrderParams{}, &models.Order{})
+	if err == nil {
+		t.Fatal("Should have gotten an error")
+	}
+	if n := atomic.LoadInt64(&posts); n != 1 {
+		t.Fatalf("Should be equal: %d, %d", n, 1)
+	}
+}
+
+func TestClient_SetCircuitBreaker(t *testing.T) {
+
+	const cooldown = 50 * time.Millisecond
+
+	var requests, healthy int64
+
+	c := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt64(&requests, 1)
+		if atomic.LoadInt64(&healthy) == 1 {
+			_, _ = w.Write([]byte(`{"success": true, "result": []}`))
+			return
+		}
+		w.WriteHeader(http.StatusServiceUnavailable)
+		_, _ = w.Write([]byte(`{"success": false, "error": "Service unavailable"}`))
+	}, SetCircuitBreaker(3, cooldown))
+
+	expect := func(name string, open bool, sent int64) {
+		_, err := c.Markets.GetMarkets()
+		if open != errors.Is(err, ErrCircuitOpen) {
+			t.Fatalf("%s: Unexpected error: %v", name, err)
+		}
+		if n := atomic.LoadInt64(&requests); n != sent {
+			t.Fatalf("%s: Should be equal: %d, %d", name, n, sent)
+		}
+	}
+
+	// Closed until the third failure in a row
+	expect("closed", false, 1)
+	expect("closed", false, 2)
+	expect("closed", false, 3)
+	expect("open", true, 3)
+
+	// Half open after the cooldown, the failed probe opening it again
+	time.Sleep(cooldown)
+	expect("failed probe", false, 4)
+	expect("reopened", true, 4)
+
+	// The successful probe closes it
+	time.Sleep(cooldown)
+	atomic.StoreInt64(&healthy, 1)
+	expect("successful probe", false, 5)
+	expect("closed again", false, 6)
+
+	// Failures are counted from zero again
+	atomic.StoreInt64(&healthy, 0)
+	expect("closed", false, 7)
+	expect("closed", false, 8)
+	expect("closed", false, 9)
+	expect("open", true, 9)
+}
+
+func TestClient_SetHeader(t *testing.T) {
+
+	c := New(WithAuth("key", "secret"))
+	c.SetHeader("X-Trace-Id", "abc")
+	c.SetHeader(keyHeader, "other")
+	c.SetHeader("ftx-sign", "other")
+
+	req, err := c.prepareRequest(Request{
+		Auth:   true,
+		Method: "GET",
+		URL:    FormURL("/markets"),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if v := req.Header.Get("X-Trace-Id"); v != "abc" {
+		t.Fatalf("Should be equal: %s, %s", v, "abc")
+	}
+	if v := req.Header.Values(keyHeader); len(v) != 1 || v[0] != "key" {
+		t.Fatalf("Should be equal: %v, %v", v, []string{"key"})
+	}
+
+	ts, err := strconv.ParseInt(req.Header.Get(tsHeader), 10, 64)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sign, expected := req.Header.Get(signHeader), c.sign(ts, "GET", "/api/markets", nil); sign != expected {
+		t.Fatalf("Should be equal: %s, %s", sign, expected)
+	}
+}
+
+func TestClient_WithTraceID(t *testing.T) {
+
+	c := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusBadRequest)
+		_, _ = w.Write([]byte(`{"success": false, "error": "Size too small"}`))
+	}, WithAuth("key", "secret"))
+
+	ctx := WithTraceID(context.Background(), "req-42")
+	if id := TraceID(ctx); id != "req-42" {
+		t.Fatalf("Should be equal: %s, %s", id, "req-42")
+	}
+
+	err := c.Orders.PlaceOrderWithContext(ctx, &models.OrderParams{}, &models.Order{})
+	if err == nil || !strings.Contains(err.Error(), "Trace req-42") {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	var apiErr *APIError
+	if !errors.As(err, &apiErr) || apiErr.Message != "Size too small" {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	// Errors of untraced requests are left as they were
+	err = c.Orders.PlaceOrder(&models.OrderParams{}, &models.Order{})
+	if err == nil || strings.Contains(err.Error(), "Trace") {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+}
+
+func TestClient_SetUserAgent(t *testing.T) {
+
+	agents := make(chan string, 1)
+	c := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		agents <- r.Header.Get("User-Agent")
+		_, _ = w.Write([]byte(`{"success": true, "result": []}`))
+	})
+
+	if expected := "go-ftx/" + Version; DefaultUserAgent != expected {
+		t.Fatalf("Should be equal: %s, %s", DefaultUserAgent, expected)
+	}
+
+	for _, expected := range []string{DefaultUserAgent, "my-app/1.2"} {
+		if expected != DefaultUserAgent {
+			c.SetUserAgent(expected)
+		}
+		if _, err := c.Markets.GetMarkets(); err != nil {
+			t.Fatal(err)
+		}
+		if ua := <-agents; ua != expected {
+			t.Fatalf("Should be equal: %s, %s", ua, expected)
+		}
+	}
+}
+
+func TestClient_SetProxyFromEnvironment(t *testing.T) {
+
+	fromEnvironment := reflect.ValueOf(http.ProxyFromEnvironment).Pointer()
+
+	transport := &http.Transport{}
+	c := New(WithHTTPClient(&http.Client{Transport: transport}), SetProxyFromEnvironment())
+	c.SetProxy(nil)
+	if c.Stream.dialer.Proxy != nil {
+		t.Fatal("Should be nil")
+	}
+	c.Stream.SetProxyFromEnvironment()
+
+	used, ok := c.client.Transport.(*http.Transport)
+	if !ok || used == transport {
+		t.Fatal("Should be a copy of the transport")
+	}
+	if transport.Proxy != nil {
+		t.Fatal("Should not change the given transport")
+	}
+
+	for _, proxy := range []func(*http.Request) (*url.URL, error){used.Proxy, c.Stream.dialer.Proxy} {
+		if proxy == nil || reflect.ValueOf(proxy).Pointer() != fromEnvironment {
+			t.Fatal("Should be http.ProxyFromEnvironment")
+		}
+	}
+}
@@ -1,7 +1,6 @@
 package api
 
 import (
-	"encoding/json"
 	"fmt"
 	"net/http"
 
@@ -20,6 +19,8 @@ const (
 	apiAcceptQuote    = "/otc/quotes/%d/accept"
 )
 
+var ErrQuoteExpired = errors.New("Quote expired")
+
 func (c *Convert) RequestQuote(from, to string, size decimal.Decimal) (id int64, err error) {
 
 	params := struct {
@@ -32,13 +33,13 @@ func (c *Convert) RequestQuote(from, to string, size decimal.Decimal) (id int64,
 
 	response, err := c.client.Post(&params, url)
 	if err != nil {
-		return 0, err
+		return 0, errors.WithStack(err)
 	}
 
 	var result = struct {
 		QuoteID int64 `json:"quoteId"`
 	}{}
-	if err = json.Unmarshal(response, &result); err != nil {
+	if err = c.client.unmarshal(response, &result); err != nil {
 		return 0, errors.WithStack(err)
 	}
 	return result.QuoteID, nil
@@ -50,16 +51,18 @@ func (c *Convert) GetQuoteStatus(id int64) (*models.ConvertQuoteStatus, error) {
 	url := fmt.Sprintf("%s%s", apiUrl, path)
 	response, err := c.client.Get(nil, url, true)
 	if err != nil {
-		return nil, err
+		return nil, errors.WithStack(err)
 	}
 
 	var result models.ConvertQuoteStatus
-	if err = json.Unmarshal(response, &result); err != nil {
+	if err = c.client.unmarshal(response, &result); err != nil {
 		return nil, errors.WithStack(err)
 	}
 	return &result, nil
 }
 
+// AcceptQuote returns ErrQuoteExpired (wrapped) when FTX rejects the
+// acceptance because the quote is no longer valid.
 func (c *Convert) AcceptQuote(id int64) error {
 
 	path := fmt.Sprintf(apiAcceptQuote, id)
@@ -78,6 +81,10 @@ func (c *Convert) AcceptQuote(id int64) error {
 	_, errReq := c.client.do(request)
 
 	if errReq != nil {
+		status, err := c.GetQuoteStatus(id)
+		if err == nil && status.Expired {
+			return errors.Wrapf(ErrQuoteExpired, "Quote %d: %v", id, errReq)
+		}
 		return errors.WithStack(errReq)
 	}
 
@@ -0,0 +1,53 @@
+package api
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/pkg/errors"
+)
+
+func TestConvert_AcceptQuote(t *testing.T) {
+
+	tests := []struct {
+		name    string
+		accept  bool
+		expired bool
+		err     error
+	}{
+		{"accepted", true, false, nil},
+		{"expired", false, true, ErrQuoteExpired},
+		{"rejected", false, false, nil},
+	}
+
+	for _, tt := range tests {
+
+		c := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+			switch {
+			case strings.HasSuffix(r.URL.Path, "/accept") && tt.accept:
+				_, _ = w.Write([]byte(`{"success": true, "result": null}`))
+			case strings.HasSuffix(r.URL.Path, "/accept"):
+				w.WriteHeader(http.StatusBadRequest)
+				_, _ = w.Write([]byte(`{"success": false, "error": "Quote not found"}`))
+			case tt.expired:
+				_, _ = w.Write([]byte(`{"success": true, "result": {"id": 1, "expired": true}}`))
+			default:
+				_, _ = w.Write([]byte(`{"success": true, "result": {"id": 1, "expired": false}}`))
+			}
+		}, WithAuth("key", "secret"))
+
+		err := c.Convert.AcceptQuote(1)
+
+		switch {
+		case tt.accept && err != nil:
+			t.Fatalf("%s: %v", tt.name, err)
+		case !tt.accept && err == nil:
+			t.Fatalf("%s: Should have gotten an error", tt.name)
+		case tt.err != nil && !errors.Is(err, tt.err):
+			t.Fatalf("%s: Should be equal: %v, %v", tt.name, err, tt.err)
+		case tt.err == nil && errors.Is(err, ErrQuoteExpired):
+			t.Fatalf("%s: Should not be ErrQuoteExpired: %v", tt.name, err)
+		}
+	}
+}
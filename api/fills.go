@@ -1,20 +1,28 @@
 package api
 
 import (
-	"encoding/json"
+	"context"
+	"sort"
+	"time"
 
 	"github.com/pkg/errors"
+	"github.com/shopspring/decimal"
 	"github.com/uscott/go-ftx/models"
 )
 
 const (
 	apiGetFills = "/fills"
+	// futureLifetime is the longest a dated future is listed before expiry.
+	futureLifetime = 366 * 24 * time.Hour
 )
 
 type Fills struct {
 	client *Client
 }
 
+// GetFills returns the fills of the account, or subaccount, that is its own
+// trades, unlike Markets.GetTrades which returns the trades of everyone in a
+// market.
 func (f *Fills) GetFills(params *models.FillParams) ([]*models.Fill, error) {
 
 	url := FormURL(apiGetFills)
@@ -24,8 +32,242 @@ func (f *Fills) GetFills(params *models.FillParams) ([]*models.Fill, error) {
 	}
 
 	var result []*models.Fill
-	if err = json.Unmarshal(response, &result); err != nil {
+	if err = f.client.unmarshal(response, &result); err != nil {
 		return nil, errors.WithStack(err)
 	}
 	return result, nil
 }
+
+// RealizedPnL replays the fills of the market between start and end with
+// average cost accounting and returns the realized PnL net of fees in the
+// quote currency, the position being taken as flat at start. Fees charged in
+// the base currency are converted at the price of their fill.
+func (f *Fills) RealizedPnL(
+	ctx context.Context, market string, start, end time.Time,
+) (decimal.Decimal, error) {
+
+	fills, err := f.fillsBetween(ctx, &market, start, end)
+	if err != nil {
+		return decimal.Zero, errors.WithStack(err)
+	}
+
+	pnl, err := realizedPnL(fills)
+	return pnl, errors.WithStack(err)
+}
+
+// VolumeByMarket returns the notional traded by the account, or subaccount,
+// in each market between start and end along with the total across markets.
+// It is summed from the fills of the period, fetched page by page.
+func (f *Fills) VolumeByMarket(
+	ctx context.Context, start, end time.Time,
+) (map[string]decimal.Decimal, decimal.Decimal, error) {
+
+	fills, err := f.fillsBetween(ctx, nil, start, end)
+	if err != nil {
+		return nil, decimal.Zero, errors.WithStack(err)
+	}
+
+	volumes, total := make(map[string]decimal.Decimal), decimal.Zero
+	for _, fill := range fills {
+		if fill.Time.Before(start) || fill.Time.After(end) {
+			continue
+		}
+		notional := fill.Price.Mul(fill.Size).Abs()
+		volumes[fill.Market] = volumes[fill.Market].Add(notional)
+		total = total.Add(notional)
+	}
+
+	return volumes, total, nil
+}
+
+// GetSettlements returns the settlements of the positions held in futures
+// which expired between start and end, sorted by time. FTX keeps no record
+// of them so the position at expiry and its average cost are found replaying
+// the fills of each future over the year before its expiry, no future being
+// listed longer, and the mark price of the expired future is taken as the
+// settlement price.
+func (f *Fills) GetSettlements(
+	ctx context.Context, start, end time.Time,
+) ([]*models.Settlement, error) {
+
+	expired, err := f.client.Futures.GetExpiredFutures()
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	result := make([]*models.Settlement, 0)
+
+	for _, future := range expired {
+
+		if future.Expiry.Before(start) || future.Expiry.After(end) {
+			continue
+		}
+
+		name := future.Name
+		fills, err := f.fillsBetween(ctx, &name, future.Expiry.Add(-futureLifetime), future.Expiry)
+		if err != nil {
+			return nil, errors.WithStack(err)
+		}
+
+		own := fills[:0]
+		for _, fill := range fills {
+			if fill.Market == name && !fill.Time.After(future.Expiry) {
+				own = append(own, fill)
+			}
+		}
+
+		_, position, avgCost, err := replayFills(own)
+		if err != nil {
+			return nil, errors.WithStack(err)
+		}
+		if position.IsZero() {
+			continue
+		}
+
+		result = append(result, &models.Settlement{
+			Future:      name,
+			Time:        future.Expiry,
+			Price:       future.Mark,
+			Size:        position,
+			AverageCost: avgCost,
+			PnL:         future.Mark.Sub(avgCost).Mul(position),
+		})
+	}
+
+	sort.Slice(result, func(i, j int) bool {
+		if result[i].Time.Equal(result[j].Time) {
+			return result[i].Future < result[j].Future
+		}
+		return result[i].Time.Before(result[j].Time)
+	})
+
+	return result, nil
+}
+
+// fillsBetween returns the fills of the market, or of all markets if it is
+// nil, between start and end.
+func (f *Fills) fillsBetween(
+	ctx context.Context, market *string, start, end time.Time,
+) ([]*models.Fill, error) {
+
+	var fills []*models.Fill
+	seen := make(map[int64]struct{})
+
+	err := pageBackwards(start.Unix(), end.Unix(), func(params *models.NumberTimeLimit) (int64, int, int, error) {
+		if err := ctx.Err(); err != nil {
+			return 0, 0, 0, err
+		}
+		page, err := f.GetFills(&models.FillParams{
+			Market:    market,
+			Limit:     params.Limit,
+			StartTime: params.StartTime,
+			EndTime:   params.EndTime,
+		})
+		if err != nil {
+			return 0, 0, 0, err
+		}
+		earliest, added := *params.EndTime, 0
+		for _, fill := range page {
+			if t := fill.Time.Unix(); t < earliest {
+				earliest = t
+			}
+			if _, ok := seen[fill.ID]; ok {
+				continue
+			}
+			seen[fill.ID] = struct{}{}
+			fills = append(fills, fill)
+			added++
+		}
+		return earliest, len(page), added, nil
+	})
+
+	return fills, err
+}
+
+// realizedPnL sorts the fills of a single market by time and replays them.
+func realizedPnL(fills []*models.Fill) (decimal.Decimal, error) {
+	pnl, _, _, err := replayFills(fills)
+	return pnl, err
+}
+
+// replayFills sorts the fills of a single market by time and replays them,
+// returning the realized PnL along with the position left and its average
+// cost.
+func replayFills(fills []*models.Fill) (pnl, position, avgCost decimal.Decimal, err error) {
+
+	sort.SliceStable(fills, func(i, j int) bool {
+		if fills[i].Time.Equal(fills[j].Time) {
+			return fills[i].ID < fills[j].ID
+		}
+		return fills[i].Time.Before(fills[j].Time)
+	})
+
+	state := positionState{pnl: decimal.Zero, size: decimal.Zero, avgCost: decimal.Zero}
+
+	for _, fill := range fills {
+		if err = state.apply(fill); err != nil {
+			return decimal.Zero, decimal.Zero, decimal.Zero, err
+		}
+	}
+
+	return state.pnl, state.size, state.avgCost, nil
+}
+
+// positionState is a position in a single market replayed from its fills:
+// the realized PnL, the net size, negative when short, and its average cost.
+type positionState struct {
+	pnl, size, avgCost decimal.Decimal
+}
+
+// apply adds the fill to the position, realizing the PnL of the part it
+// closes.
+func (p *positionState) apply(fill *models.Fill) error {
+
+	fee, err := quoteFee(fill)
+	if err != nil {
+		return err
+	}
+	p.pnl = p.pnl.Sub(fee)
+
+	size := fill.Size
+	if fill.Side == string(models.Sell) {
+		size = size.Neg()
+	}
+
+	// Adding to the position, or opening one, moves the average cost
+	if p.size.IsZero() || p.size.Sign() == size.Sign() {
+		total := p.size.Abs().Add(size.Abs())
+		p.avgCost = p.avgCost.Mul(p.size.Abs()).Add(fill.Price.Mul(size.Abs())).Div(total)
+		p.size = p.size.Add(size)
+		return nil
+	}
+
+	closed := decimal.Min(size.Abs(), p.size.Abs())
+	p.pnl = p.pnl.Add(fill.Price.Sub(p.avgCost).Mul(closed).Mul(decimal.NewFromInt(int64(p.size.Sign()))))
+
+	flipped := size.Abs().GreaterThan(p.size.Abs())
+	p.size = p.size.Add(size)
+
+	switch {
+	case p.size.IsZero():
+		p.avgCost = decimal.Zero
+	case flipped:
+		p.avgCost = fill.Price
+	}
+
+	return nil
+}
+
+// quoteFee returns the fee of the fill in the quote currency. Futures fills
+// have no quote currency and are charged in USD.
+func quoteFee(fill *models.Fill) (decimal.Decimal, error) {
+	switch {
+	case fill.FeeCurrency == fill.QuoteCurrency,
+		fill.QuoteCurrency == "" && fill.FeeCurrency == "USD":
+		return fill.Fee, nil
+	case fill.FeeCurrency == fill.BaseCurrency:
+		return fill.Fee.Mul(fill.Price), nil
+	}
+	return decimal.Zero, errors.Errorf(
+		"Fill %d: fee in %s cannot be converted to %s", fill.ID, fill.FeeCurrency, fill.QuoteCurrency)
+}
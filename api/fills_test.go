@@ -0,0 +1,208 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/shopspring/decimal"
+	"github.com/uscott/go-ftx/models"
+)
+
+func TestFills_realizedPnL(t *testing.T) {
+
+	first := time.Date(2021, 3, 1, 0, 0, 0, 0, time.UTC)
+
+	fill := func(i int, side models.OrderSide, size, price, fee float64) *models.Fill {
+		return &models.Fill{
+			ID:          int64(i),
+			Future:      "BTC-PERP",
+			Market:      "BTC-PERP",
+			Side:        string(side),
+			Size:        decimal.NewFromFloat(size),
+			Price:       decimal.NewFromFloat(price),
+			Fee:         decimal.NewFromFloat(fee),
+			FeeCurrency: "USD",
+			Time:        first.Add(time.Duration(i) * time.Minute),
+		}
+	}
+
+	// Out of order as FTX returns the most recent first
+	fills := []*models.Fill{
+		fill(5, models.Buy, 2, 90, 0.2),   // Covers the short for 20
+		fill(4, models.Sell, 5, 100, 0.5), // Closes 3 for -15 and opens a short at 100
+		fill(3, models.Sell, 1, 120, 0.1), // Closes 1 for 15
+		fill(2, models.Buy, 2, 110, 0.2),  // Average cost 105
+		fill(1, models.Buy, 2, 100, 0.2),
+	}
+
+	pnl, err := realizedPnL(fills)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected := decimal.NewFromFloat(18.8); !pnl.Equal(expected) {
+		t.Fatalf("Should be equal: %v, %v", pnl, expected)
+	}
+
+	// Spot fees in the base currency are converted at the fill price
+	spot := []*models.Fill{
+		fill(1, models.Buy, 1, 100, 0.001),
+		fill(2, models.Sell, 1, 110, 0.11),
+	}
+	for _, f := range spot {
+		f.Future, f.Market, f.BaseCurrency, f.QuoteCurrency = "", "BTC/USD", "BTC", "USD"
+	}
+	spot[0].FeeCurrency = "BTC"
+
+	if pnl, err = realizedPnL(spot); err != nil {
+		t.Fatal(err)
+	}
+	if expected := decimal.NewFromFloat(9.79); !pnl.Equal(expected) {
+		t.Fatalf("Should be equal: %v, %v", pnl, expected)
+	}
+
+	spot[1].FeeCurrency = "FTT"
+	if _, err = realizedPnL(spot); err == nil {
+		t.Fatal("Fee in FTT should not be converted")
+	}
+}
+
+func TestFills_GetSettlements(t *testing.T) {
+
+	expiry := time.Date(2021, 6, 25, 3, 0, 0, 0, time.UTC)
+
+	fills := []string{
+		`{"id": 1, "market": "BTC-0625", "future": "BTC-0625", "side": "buy", "size": 2, "price": 30000, ` +
+			`"fee": 1, "feeCurrency": "USD", "time": "2021-06-01T00:00:00+00:00"}`,
+		`{"id": 2, "market": "BTC-0625", "future": "BTC-0625", "side": "buy", "size": 2, "price": 34000, ` +
+			`"fee": 1, "feeCurrency": "USD", "time": "2021-06-02T00:00:00+00:00"}`,
+		`{"id": 3, "market": "BTC-0625", "future": "BTC-0625", "side": "sell", "size": 1, "price": 35000, ` +
+			`"fee": 1, "feeCurrency": "USD", "time": "2021-06-03T00:00:00+00:00"}`,
+		`{"id": 4, "market": "ETH-0625", "future": "ETH-0625", "side": "sell", "size": 1, "price": 2000, ` +
+			`"fee": 1, "feeCurrency": "USD", "time": "2021-06-03T00:00:00+00:00"}`,
+		`{"id": 5, "market": "BTC/USD", "side": "buy", "size": 1, "price": 35000, ` +
+			`"fee": 1, "feeCurrency": "USD", "quoteCurrency": "USD", "time": "2021-06-03T00:00:00+00:00"}`,
+	}
+
+	// The fills of each expired future are fetched on their own
+	var mu sync.Mutex
+	markets := make(map[string][]int64)
+
+	c := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		if strings.HasSuffix(r.URL.Path, "/expired_futures") {
+			_, _ = w.Write([]byte(`{"success": true, "result": [` +
+				`{"name": "BTC-0625", "expiry": "2021-06-25T03:00:00+00:00", "mark": 35500},` +
+				`{"name": "ETH-0625", "expiry": "2021-06-25T03:00:00+00:00", "mark": 1900},` +
+				`{"name": "BTC-0326", "expiry": "2021-03-26T03:00:00+00:00", "mark": 55000}]}`))
+			return
+		}
+		query := r.URL.Query()
+		start, _ := strconv.ParseInt(query.Get("start_time"), 10, 64)
+		end, _ := strconv.ParseInt(query.Get("end_time"), 10, 64)
+		mu.Lock()
+		markets[query.Get("market")] = append(markets[query.Get("market")], start)
+		mu.Unlock()
+		// All fills are on the first page
+		if end < time.Date(2021, 6, 3, 0, 0, 0, 0, time.UTC).Unix() {
+			_, _ = w.Write([]byte(`{"success": true, "result": []}`))
+			return
+		}
+		_, _ = w.Write([]byte(`{"success": true, "result": [` + strings.Join(fills, ",") + `]}`))
+	}, WithAuth("key", "secret"))
+
+	settlements, err := c.GetSettlements(
+		context.Background(), time.Date(2021, 4, 1, 0, 0, 0, 0, time.UTC), time.Date(2021, 7, 1, 0, 0, 0, 0, time.UTC))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(settlements) != 2 {
+		t.Fatalf("Unexpected settlements: %+v", settlements)
+	}
+
+	if len(markets) != 2 || len(markets["BTC-0625"]) == 0 || len(markets["ETH-0625"]) == 0 {
+		t.Fatalf("Unexpected fill requests: %v", markets)
+	}
+	for market, starts := range markets {
+		for _, start := range starts {
+			if start < expiry.Add(-futureLifetime).Unix() {
+				t.Fatalf("Unbounded fill request for %s: %d", market, start)
+			}
+		}
+	}
+
+	// BTC: long 3 at 32000, ETH: short 1 at 2000
+	expected := []models.Settlement{
+		{Future: "BTC-0625", Size: decimal.NewFromInt(3), AverageCost: decimal.NewFromInt(32000), PnL: decimal.NewFromInt(10500)},
+		{Future: "ETH-0625", Size: decimal.NewFromInt(-1), AverageCost: decimal.NewFromInt(2000), PnL: decimal.NewFromInt(100)},
+	}
+	for i, e := range expected {
+		s := settlements[i]
+		if s.Future != e.Future || !s.Time.Equal(expiry) || !s.Size.Equal(e.Size) ||
+			!s.AverageCost.Equal(e.AverageCost) || !s.PnL.Equal(e.PnL) {
+			t.Fatalf("Should be equal: %+v, %+v", s, e)
+		}
+	}
+}
+
+func TestFills_VolumeByMarket(t *testing.T) {
+
+	start := time.Date(2021, 6, 1, 0, 0, 0, 0, time.UTC)
+	end := start.Add(24 * time.Hour)
+
+	// Newest first, as FTX returns them
+	fills := []*models.Fill{
+		{ID: 5, Market: "ETH-PERP", Price: decimal.NewFromInt(2000), Size: decimal.NewFromInt(2), Time: end.Add(time.Hour)},
+		{ID: 4, Market: "BTC-PERP", Price: decimal.NewFromInt(35000), Size: decimal.RequireFromString("0.5"), Time: end.Add(-time.Hour)},
+		{ID: 3, Market: "ETH-PERP", Price: decimal.NewFromInt(2100), Size: decimal.NewFromInt(1), Time: start.Add(3 * time.Hour)},
+		{ID: 2, Market: "BTC/USD", Price: decimal.NewFromInt(34000), Size: decimal.RequireFromString("0.1"), Time: start.Add(2 * time.Hour)},
+		{ID: 1, Market: "BTC-PERP", Price: decimal.NewFromInt(33000), Size: decimal.NewFromInt(1), Time: start.Add(time.Hour)},
+	}
+
+	var pages int
+	c := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		pages++
+		query := r.URL.Query()
+		from, _ := strconv.ParseInt(query.Get("start_time"), 10, 64)
+		to, _ := strconv.ParseInt(query.Get("end_time"), 10, 64)
+		// Two fills a page so that the window takes several pages
+		var page []*models.Fill
+		for _, f := range fills {
+			if ts := f.Time.Unix(); ts >= from && ts <= to && len(page) < 2 {
+				page = append(page, f)
+			}
+		}
+		b, _ := json.Marshal(page)
+		_, _ = w.Write([]byte(`{"success": true, "result": ` + string(b) + `}`))
+	}, WithAuth("key", "secret"))
+
+	volumes, total, err := c.VolumeByMarket(context.Background(), start, end)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if pages < 2 {
+		t.Fatalf("Should have paged: %d", pages)
+	}
+
+	expected := map[string]decimal.Decimal{
+		"BTC-PERP": decimal.NewFromInt(50500),
+		"BTC/USD":  decimal.NewFromInt(3400),
+		"ETH-PERP": decimal.NewFromInt(2100),
+	}
+	if len(volumes) != len(expected) {
+		t.Fatalf("Should be equal: %v, %v", volumes, expected)
+	}
+	for market, volume := range expected {
+		if !volumes[market].Equal(volume) {
+			t.Fatalf("Should be equal: %v, %v", volumes[market], volume)
+		}
+	}
+	if !total.Equal(decimal.NewFromInt(56000)) {
+		t.Fatalf("Should be equal: %v, %v", total, 56000)
+	}
+}
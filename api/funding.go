@@ -1,9 +1,11 @@
 package api
 
 import (
-	"encoding/json"
+	"context"
+	"time"
 
 	"github.com/pkg/errors"
+	"github.com/shopspring/decimal"
 	"github.com/uscott/go-ftx/models"
 )
 
@@ -31,8 +33,50 @@ func (f *Funding) GetFundingPayments(
 		return nil, errors.WithStack(err)
 	}
 	var result []*models.FundingPayment
-	if err = json.Unmarshal(response, &result); err != nil {
+	if err = f.client.unmarshal(response, &result); err != nil {
 		return nil, errors.WithStack(err)
 	}
 	return result, nil
 }
+
+// TotalFundingPaid sums the funding payments of the future between start and
+// end, fetching as many pages as the window needs. The total is positive when
+// funding was paid on net and negative when it was received. The number of
+// payments is returned along with it.
+func (f *Funding) TotalFundingPaid(
+	ctx context.Context, future string, start, end time.Time,
+) (decimal.Decimal, int, error) {
+
+	total, n, seen := decimal.Zero, 0, make(map[int64]struct{})
+
+	err := pageBackwards(start.Unix(), end.Unix(), func(params *models.NumberTimeLimit) (int64, int, int, error) {
+		if err := ctx.Err(); err != nil {
+			return 0, 0, 0, err
+		}
+		page, err := f.GetFundingPayments(&future, params.StartTime, params.EndTime)
+		if err != nil {
+			return 0, 0, 0, err
+		}
+		earliest, added := *params.EndTime, 0
+		for _, p := range page {
+			if t := p.Time.Unix(); t < earliest {
+				earliest = t
+			}
+			if _, ok := seen[p.ID]; ok {
+				continue
+			}
+			seen[p.ID] = struct{}{}
+			added++
+			if p.Future == future {
+				total = total.Add(p.Payment)
+				n++
+			}
+		}
+		return earliest, len(page), added, nil
+	})
+	if err != nil {
+		return decimal.Zero, 0, errors.WithStack(err)
+	}
+
+	return total, n, nil
+}
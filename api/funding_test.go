@@ -0,0 +1,70 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/shopspring/decimal"
+	"github.com/uscott/go-ftx/models"
+)
+
+func TestFunding_TotalFundingPaid(t *testing.T) {
+
+	const (
+		hours    = 250
+		pageSize = 100
+	)
+
+	first := time.Date(2021, 3, 1, 0, 0, 0, 0, time.UTC)
+
+	// Hourly payments alternating between paying 2 and receiving 1
+	payments := make([]*models.FundingPayment, hours)
+	for i := range payments {
+		payment := decimal.NewFromInt(2)
+		if i%2 == 1 {
+			payment = decimal.NewFromInt(-1)
+		}
+		payments[i] = &models.FundingPayment{
+			Future:  "BTC-PERP",
+			ID:      int64(i + 1),
+			Payment: payment,
+			Time:    first.Add(time.Duration(i) * time.Hour),
+		}
+	}
+
+	c := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		query := r.URL.Query()
+		start, _ := strconv.ParseInt(query.Get("start_time"), 10, 64)
+		end, _ := strconv.ParseInt(query.Get("end_time"), 10, 64)
+		// Newest first as FTX does
+		var page []*models.FundingPayment
+		for i := len(payments) - 1; i >= 0 && len(page) < pageSize; i-- {
+			if ts := payments[i].Time.Unix(); ts >= start && ts <= end {
+				page = append(page, payments[i])
+			}
+		}
+		b, _ := json.Marshal(page)
+		_, _ = w.Write([]byte(`{"success": true, "result": ` + string(b) + `}`))
+	})
+
+	// Starting mid hour in another time zone excludes the first payment
+	est := time.FixedZone("EST", -5*3600)
+	start := first.Add(30 * time.Minute).In(est)
+	end := first.Add(hours * time.Hour).In(est)
+
+	total, n, err := c.Funding.TotalFundingPaid(context.Background(), "BTC-PERP", start, end)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != hours-1 {
+		t.Fatalf("Should be equal: %d, %d", n, hours-1)
+	}
+	// 124 payments of 2 and 125 of -1
+	if expected := decimal.NewFromInt(123); !total.Equal(expected) {
+		t.Fatalf("Should be equal: %v, %v", total, expected)
+	}
+}
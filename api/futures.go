@@ -1,11 +1,15 @@
 package api
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"net/http"
+	"sort"
+	"time"
 
 	"github.com/pkg/errors"
+	"github.com/shopspring/decimal"
 	"github.com/uscott/go-ftx/models"
 	"github.com/uscott/go-tools/errs"
 )
@@ -19,6 +23,12 @@ const (
 	apiGetHistoricalIndex = "/indexes/%s/candles"
 )
 
+var ErrIndexNotFound = errors.New("Index not found")
+
+// statsSampleInterval is how often SampleFutureStats polls the stats of a
+// future by default.
+var statsSampleInterval = time.Minute
+
 type Futures struct {
 	client *Client
 }
@@ -39,7 +49,7 @@ func (f *Futures) GetFutures() ([]*models.Future, error) {
 	}
 
 	var result []*models.Future
-	err = json.Unmarshal(response, &result)
+	err = f.client.unmarshal(response, &result)
 	if err != nil {
 		return nil, errors.WithStack(err)
 	}
@@ -58,7 +68,7 @@ func (f *Futures) GetFutureByName(name string, future *models.Future) (err error
 		return errors.WithStack(err)
 	}
 
-	if err = json.Unmarshal(response, future); err != nil {
+	if err = f.client.unmarshal(response, future); err != nil {
 		return errors.WithStack(err)
 	}
 
@@ -78,13 +88,112 @@ func (f *Futures) GetFutureStats(future string, stats *models.FutureStats) (err
 		return errors.WithStack(err)
 	}
 
-	if err = json.Unmarshal(response, stats); err != nil {
+	if err = f.client.unmarshal(response, stats); err != nil {
 		return errors.WithStack(err)
 	}
 
 	return nil
 }
 
+// GetFutureStatsHistory returns the realised funding rates of the future
+// between start and end in ascending time order. FTX keeps no history of the
+// other stats, use SampleFutureStats to record them.
+func (f *Futures) GetFutureStatsHistory(
+	ctx context.Context, future string, start, end time.Time,
+) ([]*models.FutureStatsPoint, error) {
+
+	var points []*models.FutureStatsPoint
+
+	seen := make(map[int64]struct{})
+	err := pageBackwards(start.Unix(), end.Unix(), func(params *models.NumberTimeLimit) (int64, int, int, error) {
+		if err := ctx.Err(); err != nil {
+			return 0, 0, 0, err
+		}
+		response, err := f.client.Get(
+			&models.FundingRatesParams{
+				Future:    &future,
+				StartTime: params.StartTime,
+				EndTime:   params.EndTime,
+			},
+			FormURL(apiGetFundingRates),
+			false)
+		if err != nil {
+			return 0, 0, 0, err
+		}
+		var page []*models.FundingRates
+		if err = f.client.unmarshal(response, &page); err != nil {
+			return 0, 0, 0, err
+		}
+		earliest, added := *params.EndTime, 0
+		for _, r := range page {
+			ts := r.Time.Unix()
+			if ts < earliest {
+				earliest = ts
+			}
+			if _, ok := seen[ts]; ok || r.Future != future {
+				continue
+			}
+			seen[ts] = struct{}{}
+			rate := r.Rate
+			points = append(points, &models.FutureStatsPoint{Time: r.Time, FundingRate: &rate})
+			added++
+		}
+		return earliest, len(page), added, nil
+	})
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	sort.SliceStable(points, func(i, j int) bool {
+		return points[i].Time.Before(points[j].Time)
+	})
+
+	return points, nil
+}
+
+// SampleFutureStats polls the stats of the future every interval, or every
+// statsSampleInterval if interval is not positive, and sends them on the
+// returned channel until ctx is done, when the channel is closed. Failed
+// polls are logged and skipped.
+func (f *Futures) SampleFutureStats(
+	ctx context.Context, future string, interval time.Duration,
+) <-chan *models.FutureStatsPoint {
+
+	if interval <= 0 {
+		interval = statsSampleInterval
+	}
+
+	c := make(chan *models.FutureStatsPoint)
+
+	go func() {
+		defer close(c)
+
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			stats := &models.FutureStats{}
+			if err := f.GetFutureStats(future, stats); err != nil {
+				f.client.Logger.Debugf("sample %s stats: %v", future, err)
+			} else {
+				select {
+				case c <- &models.FutureStatsPoint{Time: f.client.now(), Stats: stats}:
+				case <-ctx.Done():
+					return
+				}
+			}
+
+			select {
+			case <-ticker.C:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	return c
+}
+
 func (f *Futures) GetFundingRates() ([]*models.FundingRates, error) {
 
 	url := FormURL(apiGetFundingRates)
@@ -95,28 +204,123 @@ func (f *Futures) GetFundingRates() ([]*models.FundingRates, error) {
 	}
 
 	var result []*models.FundingRates
-	if err = json.Unmarshal(response, &result); err != nil {
+	if err = f.client.unmarshal(response, &result); err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	return result, nil
+}
+
+// GetCurrentFundingRate returns the most recent funding rate of the future
+// together with the predicted next rate and its time.
+func (f *Futures) GetCurrentFundingRate(future string) (*models.FundingRate, error) {
+
+	stats := models.FutureStats{}
+	if err := f.GetFutureStats(future, &stats); err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	response, err := f.client.Get(
+		&models.FundingRatesParams{Future: &future},
+		FormURL(apiGetFundingRates),
+		false)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	var rates []*models.FundingRates
+	if err = f.client.unmarshal(response, &rates); err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	result := &models.FundingRate{
+		Future:          future,
+		NextFundingRate: stats.NextFundingRate,
+		NextFundingTime: stats.NextFundingTime,
+	}
+
+	for _, r := range rates {
+		if r != nil && r.Time.After(result.Time) {
+			result.Rate, result.Time = r.Rate, r.Time
+		}
+	}
+
+	return result, nil
+}
+
+// EstimateNextFunding returns the next funding rate of the perpetual future
+// as predicted by FTX along with the rate implied by the premium of its mark
+// over its index, FTX charging a 24th of the premium every hour.
+func (f *Futures) EstimateNextFunding(
+	ctx context.Context, future string) (*models.FundingEstimate, error) {
+
+	response, err := f.client.GetResponseWithContext(
+		ctx, nil, FormURL(fmt.Sprintf(apiGetFutureStats, future)), http.MethodGet, false)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	// Only the funding fields are decoded, a null or missing rate being nil
+	var stats struct {
+		NextFundingRate *decimal.Decimal `json:"nextFundingRate"`
+		NextFundingTime time.Time        `json:"nextFundingTime"`
+	}
+	if err = json.Unmarshal(response, &stats); err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	response, err = f.client.GetResponseWithContext(
+		ctx, nil, FormURL(fmt.Sprintf("%s/%s", apiGetFutures, future)), http.MethodGet, false)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	var market models.Future
+	if err = f.client.unmarshal(response, &market); err != nil {
 		return nil, errors.WithStack(err)
 	}
 
+	result := &models.FundingEstimate{
+		Future:          future,
+		Provided:        stats.NextFundingRate,
+		Computed:        fundingFromPremium(market.Mark, decimal.NewFromFloat(market.Index)),
+		NextFundingTime: stats.NextFundingTime,
+	}
+
+	result.Rate = result.Computed
+	if result.Provided != nil {
+		result.Rate = *result.Provided
+	}
+
 	return result, nil
 }
 
-func (f *Futures) GetIndexWeights(index string) (*map[string]float64, error) {
+// fundingFromPremium returns the hourly funding rate implied by mark trading
+// at a premium to index, zero if index is not positive.
+func fundingFromPremium(mark, index decimal.Decimal) decimal.Decimal {
+	if !index.IsPositive() {
+		return decimal.Zero
+	}
+	return mark.Sub(index).Div(index).Div(decimal.NewFromInt(24))
+}
+
+// GetIndexWeights returns the coin to weight composition of an index such
+// as SHIT or ALT. Futures names are not accepted, use the underlying instead.
+func (f *Futures) GetIndexWeights(index string) (map[string]float64, error) {
 
 	url := FormURL(fmt.Sprintf(apiGetIndexWeights, index))
 
 	response, err := f.client.Get(nil, url, false)
 	if err != nil {
-		return nil, errors.WithStack(err)
+		return nil, indexError(index, err)
 	}
 
 	var result map[string]float64
-	if err = json.Unmarshal(response, &result); err != nil {
+	if err = f.client.unmarshal(response, &result); err != nil {
 		return nil, errors.WithStack(err)
 	}
 
-	return &result, nil
+	return result, nil
 }
 
 func (f *Futures) GetExpiredFutures() ([]*models.FutureExpired, error) {
@@ -129,7 +333,7 @@ func (f *Futures) GetExpiredFutures() ([]*models.FutureExpired, error) {
 	}
 
 	var result []*models.FutureExpired
-	if err = json.Unmarshal(response, &result); err != nil {
+	if err = f.client.unmarshal(response, &result); err != nil {
 		return nil, errors.WithStack(err)
 	}
 
@@ -144,13 +348,28 @@ func (f *Futures) GetHistoricalIndex(
 
 	response, err := f.client.Get(params, url, false)
 	if err != nil {
-		return nil, errors.WithStack(err)
+		return nil, indexError(indexName, err)
 	}
 
 	var result []*models.HistoricalIndex
-	if err = json.Unmarshal(response, &result); err != nil {
+	if err = f.client.unmarshal(response, &result); err != nil {
 		return nil, errors.WithStack(err)
 	}
 
 	return result, nil
 }
+
+// GetIndexCandles is an alias of GetHistoricalIndex.
+func (f *Futures) GetIndexCandles(
+	indexName string,
+	params *models.HistoricalIndexParams) ([]*models.HistoricalIndex, error) {
+	return f.GetHistoricalIndex(indexName, params)
+}
+
+func indexError(index string, err error) error {
+	var apiErr *APIError
+	if errors.As(err, &apiErr) && apiErr.StatusCode == http.StatusNotFound {
+		return errors.Wrapf(ErrIndexNotFound, "%s: %s", index, apiErr.Message)
+	}
+	return errors.WithStack(err)
+}
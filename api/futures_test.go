@@ -0,0 +1,145 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/shopspring/decimal"
+	"github.com/uscott/go-ftx/models"
+)
+
+func TestFutures_GetFutureStatsHistory(t *testing.T) {
+
+	now := time.Now().Truncate(time.Hour)
+	rates := []*models.FundingRates{
+		{Future: "BTC-PERP", Rate: 0.0003, Time: now},
+		{Future: "BTC-PERP", Rate: 0.0002, Time: now.Add(-time.Hour)},
+		{Future: "BTC-PERP", Rate: 0.0001, Time: now.Add(-2 * time.Hour)},
+		{Future: "BTC-PERP", Rate: 0.0009, Time: now.Add(-5 * time.Hour)},
+	}
+
+	var samples int64
+	c := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		var result interface{}
+		switch r.URL.Path {
+		case "/api/futures/BTC-PERP/stats":
+			n := atomic.AddInt64(&samples, 1)
+			result = map[string]interface{}{"openInterest": 100 * n}
+		case "/api/funding_rates":
+			query := r.URL.Query()
+			start, _ := strconv.ParseInt(query.Get("start_time"), 10, 64)
+			end, _ := strconv.ParseInt(query.Get("end_time"), 10, 64)
+			page := []*models.FundingRates{}
+			for _, rate := range rates {
+				if ts := rate.Time.Unix(); ts >= start && ts <= end {
+					page = append(page, rate)
+				}
+			}
+			result = page
+		default:
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		b, _ := json.Marshal(result)
+		_, _ = w.Write([]byte(`{"success": true, "result": ` + string(b) + `}`))
+	})
+
+	start := now.Add(-3 * time.Hour)
+
+	// A window reaching into the future returns at once, without sampling
+	points, err := c.GetFutureStatsHistory(context.Background(), "BTC-PERP", start, now.Add(time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(points) != 3 || atomic.LoadInt64(&samples) != 0 {
+		t.Fatalf("Unexpected points: %d, samples: %d", len(points), atomic.LoadInt64(&samples))
+	}
+	for i, expected := range []float64{0.0001, 0.0002, 0.0003} {
+		if p := points[i]; p.FundingRate == nil || *p.FundingRate != expected || p.Stats != nil {
+			t.Fatalf("Unexpected point %d: %+v", i, p)
+		}
+	}
+
+	points, err = c.GetFutureStatsHistory(context.Background(), "BTC-PERP", start, now.Add(-time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(points) != 2 {
+		t.Fatalf("Should be equal: %d, %d", len(points), 2)
+	}
+	if *points[0].FundingRate != 0.0001 || *points[1].FundingRate != 0.0002 {
+		t.Fatalf("Unexpected rates: %v, %v", *points[0].FundingRate, *points[1].FundingRate)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	sampled := c.SampleFutureStats(ctx, "BTC-PERP", 10*time.Millisecond)
+
+	for i := 1; i <= 3; i++ {
+		p := <-sampled
+		if p.Stats == nil || p.FundingRate != nil {
+			t.Fatalf("Unexpected point %d: %+v", i, p)
+		}
+		if expected := float64(100 * i); p.Stats.OpenInterest != expected {
+			t.Fatalf("Should be equal: %v, %v", p.Stats.OpenInterest, expected)
+		}
+	}
+
+	cancel()
+	for range sampled {
+	}
+}
+
+func TestFutures_EstimateNextFunding(t *testing.T) {
+
+	stats := `{"volume": 1000.23, "nextFundingRate": 0.00025, "nextFundingTime": "2021-03-29T03:00:00+00:00"}`
+
+	c := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/api/futures/BTC-PERP/stats":
+			_, _ = w.Write([]byte(`{"success": true, "result": ` + stats + `}`))
+		case "/api/futures/BTC-PERP":
+			_, _ = w.Write([]byte(`{"success": true, "result": ` +
+				`{"name": "BTC-PERP", "mark": 50120, "index": 50000, "perpetual": true}}`))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	})
+
+	// (50120 - 50000) / 50000 / 24
+	computed := decimal.RequireFromString("0.0001")
+
+	estimate, err := c.EstimateNextFunding(context.Background(), "BTC-PERP")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if estimate.Provided == nil || !estimate.Provided.Equal(decimal.RequireFromString("0.00025")) {
+		t.Fatalf("Should be equal: %v, %v", estimate.Provided, 0.00025)
+	}
+	if !estimate.Rate.Equal(*estimate.Provided) {
+		t.Fatalf("Should be equal: %v, %v", estimate.Rate, estimate.Provided)
+	}
+	if !estimate.Computed.Equal(computed) {
+		t.Fatalf("Should be equal: %v, %v", estimate.Computed, computed)
+	}
+	if expected := time.Date(2021, 3, 29, 3, 0, 0, 0, time.UTC); !estimate.NextFundingTime.Equal(expected) {
+		t.Fatalf("Should be equal: %v, %v", estimate.NextFundingTime, expected)
+	}
+
+	stats = `{"volume": 1000.23, "nextFundingRate": null}`
+
+	estimate, err = c.EstimateNextFunding(context.Background(), "BTC-PERP")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if estimate.Provided != nil {
+		t.Fatalf("Should be nil: %v", estimate.Provided)
+	}
+	if !estimate.Rate.Equal(computed) {
+		t.Fatalf("Should be equal: %v, %v", estimate.Rate, computed)
+	}
+}
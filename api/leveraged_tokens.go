@@ -1,7 +1,6 @@
 package api
 
 import (
-	"encoding/json"
 	"fmt"
 
 	"github.com/pkg/errors"
@@ -33,7 +32,7 @@ func (l *LeveragedTokens) ListLeveragedTokens() ([]*models.LeveragedToken, error
 	}
 
 	var result []*models.LeveragedToken
-	if err = json.Unmarshal(response, &result); err != nil {
+	if err = l.client.unmarshal(response, &result); err != nil {
 		return nil, errors.WithStack(err)
 	}
 	return result, nil
@@ -49,7 +48,7 @@ func (l *LeveragedTokens) GetTokenInfo(token string) (*models.TokenInfo, error)
 	}
 
 	result := models.TokenInfo{}
-	if err = json.Unmarshal(response, &result); err != nil {
+	if err = l.client.unmarshal(response, &result); err != nil {
 		return nil, errors.WithStack(err)
 	}
 	return &result, nil
@@ -66,7 +65,7 @@ func (l *LeveragedTokens) GetLeveragedTokenBalances() (
 	}
 
 	var result []*models.LeveragedTokenBalance
-	if err = json.Unmarshal(response, &result); err != nil {
+	if err = l.client.unmarshal(response, &result); err != nil {
 		return nil, errors.WithStack(err)
 	}
 	return result, nil
@@ -83,7 +82,7 @@ func (l *LeveragedTokens) ListLeveragedTokenCreationRequests() (
 	}
 
 	var result []*models.LeveragedTokenCreationRequest
-	if err = json.Unmarshal(response, &result); err != nil {
+	if err = l.client.unmarshal(response, &result); err != nil {
 		return nil, errors.WithStack(err)
 	}
 	return result, nil
@@ -105,7 +104,7 @@ func (l *LeveragedTokens) RequestLeveragedTokenCreation(
 	}
 
 	result := models.LeveragedTokenCreation{}
-	if err = json.Unmarshal(response, &result); err != nil {
+	if err = l.client.unmarshal(response, &result); err != nil {
 		return nil, errors.WithStack(err)
 	}
 	return &result, nil
@@ -122,7 +121,7 @@ func (l *LeveragedTokens) ListLeveragedTokenRedemptionRequests() (
 	}
 
 	var result []*models.LeveragedTokenRedemptionRequest
-	if err = json.Unmarshal(response, &result); err != nil {
+	if err = l.client.unmarshal(response, &result); err != nil {
 		return nil, errors.WithStack(err)
 	}
 	return result, nil
@@ -144,7 +143,7 @@ func (l *LeveragedTokens) RequestLeveragedTokenRedemption(
 	}
 
 	result := models.LeveragedTokenRedemption{}
-	if err = json.Unmarshal(response, &result); err != nil {
+	if err = l.client.unmarshal(response, &result); err != nil {
 		return nil, errors.WithStack(err)
 	}
 	return &result, nil
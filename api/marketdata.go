@@ -0,0 +1,105 @@
+package api
+
+import (
+	"context"
+
+	"github.com/pkg/errors"
+
+	"github.com/uscott/go-ftx/models"
+)
+
+// MarketDataFeed subscribes to the ticker, trades and order book of the
+// market over a single connection and sends every event on the returned
+// channel as a MarketUpdate carrying the best bid and ask of the book, which
+// is maintained internally and resynced on checksum mismatch. Updates have
+// no best bid and ask until the first order book partial has arrived.
+func (s *Stream) MarketDataFeed(
+	ctx context.Context, market string) (chan *models.MarketUpdate, error) {
+
+	if market == "" {
+		return nil, errors.New("Market missing")
+	}
+
+	ws := NewWsSub()
+	ws.AppendRequests(models.TickerChannel, market)
+	ws.AppendRequests(models.TradesChannel, market)
+	ws.AppendRequests(models.OrderBookChannel, market)
+
+	if err := s.ServeSub(ctx, ws); err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	c := make(chan *models.MarketUpdate)
+	book := &orderBook{}
+
+	go func() {
+		defer close(c)
+		forward(ctx, ws, func(e interface{}) bool {
+
+			update := &models.MarketUpdate{Market: market}
+
+			switch r := e.(type) {
+			case *models.TickerResponse:
+				ticker := r.Ticker
+				update.Kind, update.Time, update.Ticker = models.TickerUpdate, ticker.Time.Time, &ticker
+			case *models.TradeResponse:
+				trade := r.Trade
+				update.Kind, update.Time, update.Trade = models.TradeUpdate, trade.Time, &trade
+			case *models.OrderBookResponse:
+				if !s.applyBook(book, r) {
+					return true
+				}
+				update.Kind, update.Time = models.BookUpdate, r.Time.Time
+			default:
+				return true
+			}
+
+			if book.valid && len(book.bids) > 0 {
+				update.BestBid, update.BestBidSize = book.bids[0][0], book.bids[0][1]
+			}
+			if book.valid && len(book.asks) > 0 {
+				update.BestAsk, update.BestAskSize = book.asks[0][0], book.asks[0][1]
+			}
+
+			select {
+			case c <- update:
+				return true
+			case <-ctx.Done():
+				return false
+			}
+		})
+	}()
+
+	return c, nil
+}
+
+// applyBook updates book with r, resubscribing on checksum mismatch. It
+// reports whether the book is valid afterwards.
+func (s *Stream) applyBook(book *orderBook, r *models.OrderBookResponse) bool {
+
+	switch r.ResponseType {
+	case models.Partial:
+		*book = orderBook{valid: true}
+	case models.Update:
+		if !book.valid {
+			return false
+		}
+	default:
+		return false
+	}
+
+	book.update(&r.OrderBook)
+
+	if r.Checksum == 0 || book.checksum() == r.Checksum {
+		return true
+	}
+
+	book.valid = false
+	s.sendError(errors.Wrapf(ErrChecksumMismatch, "%s: resubscribing", r.Symbol))
+
+	if err := s.resubscribe(models.OrderBookChannel, r.Symbol); err != nil {
+		s.sendError(errors.WithStack(err))
+	}
+
+	return false
+}
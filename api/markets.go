@@ -1,10 +1,14 @@
 package api
 
 import (
-	"encoding/json"
+	"context"
 	"fmt"
 	"net/http"
+	"sort"
 	"strconv"
+	"strings"
+	"sync"
+	"time"
 
 	"github.com/pkg/errors"
 
@@ -19,27 +23,122 @@ const (
 	apiGetHistoricalPrices = "/markets/%s/candles"
 )
 
+// ErrUnsupportedResolution is returned for candle resolutions FTX does not
+// serve, before any request is made.
+var ErrUnsupportedResolution = errors.New(
+	"Unsupported resolution, allowed: 15, 60, 300, 900, 3600, 14400 " +
+		"or a multiple of 86400 up to 2592000 seconds")
+
+var ErrMarketNotFound = errors.New("Market not found")
+
+// batchConcurrency is how many requests the methods fetching many markets or
+// days at once, such as GetHistoricalPricesBatch, or placing many orders at
+// once, such as ReplaceOrders, have in flight.
+const batchConcurrency = 8
+
+// MarketErrors holds the error of every market that failed in a batch.
+type MarketErrors map[string]error
+
+func (e MarketErrors) Error() string {
+	markets := make([]string, 0, len(e))
+	for market := range e {
+		markets = append(markets, market)
+	}
+	sort.Strings(markets)
+	msgs := make([]string, len(markets))
+	for i, market := range markets {
+		msgs[i] = fmt.Sprintf("%s: %v", market, e[market])
+	}
+	return strings.Join(msgs, "; ")
+}
+
 type Markets struct {
 	client *Client
 }
 
 func (m *Markets) GetMarkets() ([]*models.Market, error) {
+	return m.getMarkets(context.Background())
+}
+
+func (m *Markets) getMarkets(ctx context.Context) ([]*models.Market, error) {
 
 	url := FormURL(apiGetMarkets)
-	response, err := m.client.Get(nil, url, false)
+	response, err := m.client.GetResponseWithContext(ctx, nil, url, http.MethodGet, false)
 	if err != nil {
 		return nil, errors.WithStack(err)
 	}
 
 	var result []*models.Market
 
-	if err = json.Unmarshal(response, &result); err != nil {
+	if err = m.client.unmarshal(response, &result); err != nil {
 		return nil, errors.WithStack(err)
 	}
 
 	return result, nil
 }
 
+// GetAllTickers returns the best bid and ask and the last price of every
+// market keyed by name, all of them coming with a single GetMarkets call. The
+// time of the tickers is the time of the response as markets have none.
+func (m *Markets) GetAllTickers(ctx context.Context) (map[string]*models.Ticker, error) {
+
+	markets, err := m.getMarkets(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	now := models.FTXTime{Time: time.Now()}
+
+	result := make(map[string]*models.Ticker, len(markets))
+	for _, market := range markets {
+		result[market.Name] = &models.Ticker{
+			Bid:  market.Bid,
+			Ask:  market.Ask,
+			Last: market.Last,
+			Time: now,
+		}
+	}
+
+	return result, nil
+}
+
+// GetSpotMarkets returns the spot markets. FTX cannot filter markets by
+// type so GetMarkets is filtered instead.
+func (m *Markets) GetSpotMarkets() ([]*models.Market, error) {
+	return m.getMarketsWhere(func(market *models.Market) bool {
+		return market.Type == models.SpotMarket
+	})
+}
+
+// GetFutureMarkets returns the dated and perpetual futures markets.
+func (m *Markets) GetFutureMarkets() ([]*models.Market, error) {
+	return m.getMarketsWhere(func(market *models.Market) bool {
+		return market.Type == models.FutureMarket
+	})
+}
+
+// GetPerpetualMarkets returns the perpetual futures markets.
+func (m *Markets) GetPerpetualMarkets() ([]*models.Market, error) {
+	return m.getMarketsWhere((*models.Market).IsPerpetual)
+}
+
+func (m *Markets) getMarketsWhere(keep func(*models.Market) bool) ([]*models.Market, error) {
+
+	markets, err := m.GetMarkets()
+	if err != nil {
+		return nil, err
+	}
+
+	result := make([]*models.Market, 0, len(markets))
+	for _, market := range markets {
+		if keep(market) {
+			result = append(result, market)
+		}
+	}
+
+	return result, nil
+}
+
 func (m *Markets) GetMarketByName(name string, market *models.Market) (err error) {
 
 	url := FormURL(fmt.Sprintf("%s/%s", apiGetMarkets, name))
@@ -48,7 +147,7 @@ func (m *Markets) GetMarketByName(name string, market *models.Market) (err error
 		return errors.WithStack(err)
 	}
 
-	if err = json.Unmarshal(response, market); err != nil {
+	if err = m.client.unmarshal(response, market); err != nil {
 		return errors.WithStack(err)
 	}
 
@@ -85,7 +184,7 @@ func (m *Markets) GetOrderBook(market string, depth *int, ob *models.OrderBook)
 		}
 	}
 
-	if err = json.Unmarshal(response, ob); err != nil {
+	if err = m.client.unmarshal(response, ob); err != nil {
 		return errors.WithStack(err)
 	}
 
@@ -94,28 +193,102 @@ func (m *Markets) GetOrderBook(market string, depth *int, ob *models.OrderBook)
 
 func (m *Markets) GetTrades(
 	market string, params *models.GetTradesParams) ([]*models.Trade, error) {
+	return m.getTrades(context.Background(), market, params)
+}
+
+func (m *Markets) getTrades(
+	ctx context.Context, market string, params *models.GetTradesParams) ([]*models.Trade, error) {
 
 	url := FormURL(fmt.Sprintf(apiGetTrades, market))
 
-	response, err := m.client.Get(params, url, false)
+	response, err := m.client.GetResponseWithContext(ctx, params, url, http.MethodGet, false)
 	if err != nil {
 		return nil, errors.WithStack(err)
 	}
 
 	var result []*models.Trade
 
-	if err = json.Unmarshal(response, &result); err != nil {
+	if err = m.client.unmarshal(response, &result); err != nil {
 		return nil, errors.WithStack(err)
 	}
 
 	return result, nil
 }
 
+// tradesBetween returns the trades of the market from start to end sorted by
+// time. Each page ends on the second the previous one started so that trades
+// cut off in the middle of a second are not missed, those seen twice being
+// dropped.
+func (m *Markets) tradesBetween(
+	ctx context.Context, market string, start, end time.Time) ([]*models.Trade, error) {
+
+	var (
+		result             []*models.Trade
+		limit              = historicalPageLimit
+		startTime, endTime = start.Unix(), end.Unix()
+		seen               = make(map[int64]struct{})
+	)
+
+	for endTime >= startTime {
+
+		pageEnd := endTime
+		page, err := m.getTrades(ctx, market, &models.GetTradesParams{
+			Limit:     &limit,
+			StartTime: &startTime,
+			EndTime:   &pageEnd,
+		})
+		if err != nil {
+			return nil, err
+		}
+
+		added, earliest := 0, endTime
+		for _, trade := range page {
+			if t := trade.Time.Unix(); t < earliest {
+				earliest = t
+			}
+			if _, ok := seen[trade.ID]; ok || trade.Time.Before(start) {
+				continue
+			}
+			seen[trade.ID] = struct{}{}
+			result = append(result, trade)
+			added++
+		}
+
+		if len(page) < limit {
+			break
+		}
+
+		// Moving on when a second has more trades than a page
+		if added == 0 || earliest == endTime {
+			earliest--
+		}
+		endTime = earliest
+	}
+
+	sort.SliceStable(result, func(i, j int) bool {
+		if result[i].Time.Equal(result[j].Time) {
+			return result[i].ID < result[j].ID
+		}
+		return result[i].Time.Before(result[j].Time)
+	})
+
+	return result, nil
+}
+
+// GetHistoricalPrices returns the candles of the market at the resolution of
+// params, which must be one FTX serves, see models.Resolution.IsValid.
 func (m *Markets) GetHistoricalPrices(
 	market string,
 	params *models.GetHistoricalPricesParams,
 ) ([]*models.HistoricalPrice, error) {
 
+	if params == nil {
+		return nil, errs.NilPtrArg
+	}
+	if !params.Resolution.IsValid() {
+		return nil, errors.Wrapf(ErrUnsupportedResolution, "%d", params.Resolution)
+	}
+
 	url := FormURL(fmt.Sprintf(apiGetHistoricalPrices, market))
 
 	response, err := m.client.Get(params, url, false)
@@ -125,9 +298,240 @@ func (m *Markets) GetHistoricalPrices(
 
 	var result []*models.HistoricalPrice
 
-	if err = json.Unmarshal(response, &result); err != nil {
+	if err = m.client.unmarshal(response, &result); err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	return result, nil
+}
+
+// GetHistoricalPricesInt is GetHistoricalPrices with the resolution given in
+// seconds, overriding that of params, which may be nil.
+func (m *Markets) GetHistoricalPricesInt(
+	market string,
+	resolution int,
+	params *models.GetHistoricalPricesParams,
+) ([]*models.HistoricalPrice, error) {
+
+	p := models.GetHistoricalPricesParams{}
+	if params != nil {
+		p = *params
+	}
+	p.Resolution = models.Resolution(resolution)
+
+	return m.GetHistoricalPrices(market, &p)
+}
+
+// GetHistoricalPricesBatch returns the candles of each market between start
+// and end, oldest first, keyed by market. Markets are fetched concurrently
+// and those that fail are left out of the result and reported in a
+// MarketErrors, so the candles of the others are returned along with it.
+func (m *Markets) GetHistoricalPricesBatch(
+	ctx context.Context,
+	markets []string,
+	resolution models.Resolution,
+	start, end time.Time,
+) (map[string][]*models.Candle, error) {
+
+	if !resolution.IsValid() {
+		return nil, errors.Wrapf(ErrUnsupportedResolution, "%d", resolution)
+	}
+
+	var (
+		mu     sync.Mutex
+		wg     sync.WaitGroup
+		sem    = make(chan struct{}, batchConcurrency)
+		result = make(map[string][]*models.Candle, len(markets))
+		failed = make(MarketErrors)
+	)
+
+	for _, market := range markets {
+		wg.Add(1)
+		go func(market string) {
+			defer wg.Done()
+
+			var (
+				candles []*models.Candle
+				err     error
+			)
+
+			select {
+			case sem <- struct{}{}:
+				candles, err = m.historicalPricesRange(ctx, market, resolution, start, end)
+				<-sem
+			case <-ctx.Done():
+				err = ctx.Err()
+			}
+
+			mu.Lock()
+			defer mu.Unlock()
+			if err != nil {
+				failed[market] = err
+				return
+			}
+			result[market] = candles
+		}(market)
+	}
+	wg.Wait()
+
+	if len(failed) > 0 {
+		return result, failed
+	}
+
+	return result, nil
+}
+
+// historicalPricesRange returns the candles of the market between start and
+// end, oldest first, fetching as many pages as the window needs.
+func (m *Markets) historicalPricesRange(
+	ctx context.Context,
+	market string,
+	resolution models.Resolution,
+	start, end time.Time,
+) ([]*models.Candle, error) {
+
+	var result []*models.Candle
+	seen := make(map[int64]struct{})
+
+	err := pageBackwards(start.Unix(), end.Unix(), func(params *models.NumberTimeLimit) (int64, int, int, error) {
+		if err := ctx.Err(); err != nil {
+			return 0, 0, 0, err
+		}
+		page, err := m.GetHistoricalPrices(market, &models.GetHistoricalPricesParams{
+			Resolution: resolution,
+			Limit:      params.Limit,
+			StartTime:  params.StartTime,
+			EndTime:    params.EndTime,
+		})
+		if err != nil {
+			return 0, 0, 0, err
+		}
+		earliest, added := *params.EndTime, 0
+		for _, p := range page {
+			t := p.StartTime.Unix()
+			if t < earliest {
+				earliest = t
+			}
+			if _, ok := seen[t]; ok {
+				continue
+			}
+			seen[t] = struct{}{}
+			result = append(result, &models.Candle{Market: market, HistoricalPrice: *p})
+			added++
+		}
+		return earliest, len(page), added, nil
+	})
+	if err != nil {
 		return nil, errors.WithStack(err)
 	}
 
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].StartTime.Before(result[j].StartTime)
+	})
+
 	return result, nil
 }
+
+func (m *Markets) GetMarketStats(name string) (*models.MarketStats, error) {
+
+	market := models.Market{}
+	if err := m.GetMarketByName(name, &market); err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	return m.marketStats(&market)
+}
+
+// GetAllMarketStats returns the stats of the named markets keyed by name,
+// all taken from a single GetMarkets response besides the candles giving the
+// 24h high and low, which are fetched batchConcurrency markets at a time.
+// Markets that are not listed or whose candles cannot be fetched are left out
+// of the result and reported in a MarketErrors.
+func (m *Markets) GetAllMarketStats(names ...string) (map[string]*models.MarketStats, error) {
+
+	if len(names) == 0 {
+		return nil, errors.New("Markets missing")
+	}
+
+	markets, err := m.GetMarkets()
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	byName := make(map[string]*models.Market, len(markets))
+	for _, market := range markets {
+		byName[market.Name] = market
+	}
+
+	var (
+		mu     sync.Mutex
+		wg     sync.WaitGroup
+		sem    = make(chan struct{}, batchConcurrency)
+		result = make(map[string]*models.MarketStats, len(names))
+		failed = make(MarketErrors)
+	)
+
+	for _, name := range names {
+		market, ok := byName[name]
+		if !ok {
+			failed[name] = ErrMarketNotFound
+			continue
+		}
+		wg.Add(1)
+		go func(market *models.Market) {
+			defer wg.Done()
+
+			sem <- struct{}{}
+			stats, err := m.marketStats(market)
+			<-sem
+
+			mu.Lock()
+			defer mu.Unlock()
+			if err != nil {
+				failed[market.Name] = err
+				return
+			}
+			result[market.Name] = stats
+		}(market)
+	}
+	wg.Wait()
+
+	if len(failed) > 0 {
+		return result, failed
+	}
+
+	return result, nil
+}
+
+func (m *Markets) marketStats(market *models.Market) (*models.MarketStats, error) {
+
+	end := time.Now().UTC().Unix()
+	start := end - int64(24*time.Hour/time.Second)
+
+	prices, err := m.GetHistoricalPrices(market.Name, &models.GetHistoricalPricesParams{
+		Resolution: models.Hour,
+		StartTime:  &start,
+		EndTime:    &end,
+	})
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	stats := &models.MarketStats{
+		Name:         market.Name,
+		Last:         market.Last,
+		Change24h:    market.Change24h,
+		VolumeUsd24h: market.VolumeUsd24h,
+	}
+
+	for i, p := range prices {
+		if i == 0 || p.High.GreaterThan(stats.High24h) {
+			stats.High24h = p.High
+		}
+		if i == 0 || p.Low.LessThan(stats.Low24h) {
+			stats.Low24h = p.Low
+		}
+	}
+
+	return stats, nil
+}
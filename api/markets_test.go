@@ -0,0 +1,256 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/shopspring/decimal"
+	"github.com/uscott/go-ftx/models"
+)
+
+func TestMarkets_GetHistoricalPricesBatch(t *testing.T) {
+
+	const (
+		hours    = 72
+		pageSize = 50
+	)
+
+	first := time.Date(2021, 3, 1, 0, 0, 0, 0, time.UTC)
+
+	var inFlight, maxInFlight int32
+	c := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+
+		n := atomic.AddInt32(&inFlight, 1)
+		defer atomic.AddInt32(&inFlight, -1)
+		for {
+			peak := atomic.LoadInt32(&maxInFlight)
+			if n <= peak || atomic.CompareAndSwapInt32(&maxInFlight, peak, n) {
+				break
+			}
+		}
+		time.Sleep(time.Millisecond)
+
+		market := strings.TrimSuffix(strings.TrimPrefix(r.URL.Path, "/api/markets/"), "/candles")
+		if market == "BAD-PERP" {
+			w.WriteHeader(http.StatusNotFound)
+			_, _ = w.Write([]byte(`{"success": false, "error": "No such market: BAD-PERP"}`))
+			return
+		}
+
+		query := r.URL.Query()
+		start, _ := strconv.ParseInt(query.Get("start_time"), 10, 64)
+		end, _ := strconv.ParseInt(query.Get("end_time"), 10, 64)
+		// The most recent candles of the window as FTX does
+		var page []*models.HistoricalPrice
+		for i := hours - 1; i >= 0 && len(page) < pageSize; i-- {
+			ts := first.Add(time.Duration(i) * time.Hour)
+			if ts.Unix() >= start && ts.Unix() <= end {
+				page = append([]*models.HistoricalPrice{{
+					StartTime: ts,
+					Close:     decimal.NewFromInt(int64(i)),
+				}}, page...)
+			}
+		}
+		b, _ := json.Marshal(page)
+		_, _ = w.Write([]byte(`{"success": true, "result": ` + string(b) + `}`))
+	})
+
+	markets := []string{"BAD-PERP"}
+	for i := 0; i < 2*batchConcurrency; i++ {
+		markets = append(markets, "COIN"+strconv.Itoa(i)+"-PERP")
+	}
+
+	result, err := c.GetHistoricalPricesBatch(
+		context.Background(), markets, models.Hour, first, first.Add((hours-1)*time.Hour))
+
+	var failed MarketErrors
+	if !errors.As(err, &failed) || len(failed) != 1 || failed["BAD-PERP"] == nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if len(result) != len(markets)-1 {
+		t.Fatalf("Should be equal: %d, %d", len(result), len(markets)-1)
+	}
+	for _, market := range markets[1:] {
+		candles := result[market]
+		if len(candles) != hours {
+			t.Fatalf("Should be equal: %d, %d", len(candles), hours)
+		}
+		for i, candle := range candles {
+			if candle.Market != market || !candle.Close.Equal(decimal.NewFromInt(int64(i))) {
+				t.Fatalf("Unexpected candle %d: %+v", i, candle)
+			}
+		}
+	}
+
+	if n := atomic.LoadInt32(&maxInFlight); n > batchConcurrency {
+		t.Fatalf("Too many requests in flight: %d", n)
+	}
+}
+
+func TestMarkets_GetAllMarketStats(t *testing.T) {
+
+	var listed int32
+	c := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/api/markets":
+			atomic.AddInt32(&listed, 1)
+			_, _ = w.Write([]byte(`{"success": true, "result": [` +
+				`{"name": "BTC-PERP", "last": 50000, "volumeUsd24h": 1000},` +
+				`{"name": "ETH-PERP", "last": 3000, "volumeUsd24h": 500},` +
+				`{"name": "SOL-PERP", "last": 100, "volumeUsd24h": 50}]}`))
+		case "/api/markets/BTC-PERP/candles", "/api/markets/ETH-PERP/candles":
+			_, _ = w.Write([]byte(`{"success": true, "result": [` +
+				`{"high": 10, "low": 5}, {"high": 12, "low": 7}]}`))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	})
+
+	if _, err := c.GetAllMarketStats(); err == nil {
+		t.Fatal("Should fail without markets")
+	}
+
+	result, err := c.GetAllMarketStats("BTC-PERP", "ETH-PERP", "BAD-PERP")
+
+	var failed MarketErrors
+	if !errors.As(err, &failed) || len(failed) != 1 || !errors.Is(failed["BAD-PERP"], ErrMarketNotFound) {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if n := atomic.LoadInt32(&listed); n != 1 {
+		t.Fatalf("Should be equal: %d, %d", n, 1)
+	}
+	if len(result) != 2 {
+		t.Fatalf("Should be equal: %d, %d", len(result), 2)
+	}
+	btc := result["BTC-PERP"]
+	if !btc.Last.Equal(decimal.NewFromInt(50000)) ||
+		!btc.High24h.Equal(decimal.NewFromInt(12)) || !btc.Low24h.Equal(decimal.NewFromInt(5)) {
+		t.Fatalf("Unexpected stats: %+v", btc)
+	}
+}
+
+func TestMarkets_GetMarketsByType(t *testing.T) {
+
+	c := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(`{"success": true, "result": [` +
+			`{"name": "BTC/USD", "type": "spot"},` +
+			`{"name": "BTC-PERP", "type": "future"},` +
+			`{"name": "BTC-0924", "type": "future"},` +
+			`{"name": "ETH/USD", "type": "spot"}]}`))
+	})
+
+	tests := []struct {
+		name     string
+		get      func() ([]*models.Market, error)
+		expected []string
+	}{
+		{"spot", c.GetSpotMarkets, []string{"BTC/USD", "ETH/USD"}},
+		{"future", c.GetFutureMarkets, []string{"BTC-PERP", "BTC-0924"}},
+		{"perpetual", c.GetPerpetualMarkets, []string{"BTC-PERP"}},
+	}
+
+	for _, tt := range tests {
+		markets, err := tt.get()
+		if err != nil {
+			t.Fatal(err)
+		}
+		names := make([]string, len(markets))
+		for i, market := range markets {
+			names[i] = market.Name
+		}
+		if strings.Join(names, ",") != strings.Join(tt.expected, ",") {
+			t.Fatalf("%s: Should be equal: %v, %v", tt.name, names, tt.expected)
+		}
+	}
+}
+
+func TestMarkets_GetAllTickers(t *testing.T) {
+
+	var calls int32
+	c := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&calls, 1)
+		_, _ = w.Write([]byte(`{"success": true, "result": [` +
+			`{"name": "BTC/USD", "type": "spot", "bid": 100, "ask": 101, "last": 100.5},` +
+			`{"name": "BTC-PERP", "type": "future", "bid": 99, "ask": 100, "last": 99.5}]}`))
+	})
+
+	before := time.Now()
+	tickers, err := c.GetAllTickers(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if n := atomic.LoadInt32(&calls); n != 1 {
+		t.Fatalf("Should be equal: %d, %d", n, 1)
+	}
+	if len(tickers) != 2 {
+		t.Fatalf("Unexpected tickers: %+v", tickers)
+	}
+	ticker := tickers["BTC-PERP"]
+	if ticker == nil || !ticker.Bid.Equal(decimal.NewFromInt(99)) ||
+		!ticker.Ask.Equal(decimal.NewFromInt(100)) || !ticker.Last.Equal(decimal.RequireFromString("99.5")) {
+		t.Fatalf("Unexpected ticker: %+v", ticker)
+	}
+	if ticker.Time.Time.Before(before) {
+		t.Fatalf("Unexpected ticker time: %v", ticker.Time.Time)
+	}
+}
+
+func TestMarkets_GetHistoricalPricesResolution(t *testing.T) {
+
+	var requests int64
+	resolutions := make(chan string, 8)
+
+	c := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt64(&requests, 1)
+		resolutions <- r.URL.Query().Get("resolution")
+		_, _ = w.Write([]byte(`{"success": true, "result": []}`))
+	})
+
+	for _, resolution := range []models.Resolution{
+		models.Resolution15s, models.Resolution1m, models.Resolution5m, models.Resolution15m,
+		models.Resolution1h, models.Resolution4h, models.Resolution1d, 7 * models.Resolution1d,
+		30 * models.Resolution1d,
+	} {
+		if !resolution.IsValid() {
+			t.Fatalf("Should be valid: %d", resolution)
+		}
+	}
+
+	for _, resolution := range []models.Resolution{0, -60, 30, 120, 7200, 86401, 31 * models.Resolution1d} {
+		_, err := c.GetHistoricalPrices("BTC-PERP", &models.GetHistoricalPricesParams{Resolution: resolution})
+		if !errors.Is(err, ErrUnsupportedResolution) {
+			t.Fatalf("%d: Unexpected error: %v", resolution, err)
+		}
+	}
+
+	_, err := c.GetHistoricalPricesBatch(context.Background(), []string{"BTC-PERP"}, 120, time.Now(), time.Now())
+	if !errors.Is(err, ErrUnsupportedResolution) {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	if n := atomic.LoadInt64(&requests); n != 0 {
+		t.Fatalf("No request should have been made: %d", n)
+	}
+
+	if _, err = c.GetHistoricalPrices("BTC-PERP", &models.GetHistoricalPricesParams{
+		Resolution: models.Resolution4h,
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = c.GetHistoricalPricesInt("BTC-PERP", 300, nil); err != nil {
+		t.Fatal(err)
+	}
+	for _, expected := range []string{"14400", "300"} {
+		if resolution := <-resolutions; resolution != expected {
+			t.Fatalf("Should be equal: %s, %s", resolution, expected)
+		}
+	}
+}
@@ -1,8 +1,9 @@
 package api
 
 import (
-	"encoding/json"
 	"fmt"
+	"sort"
+	"time"
 
 	"github.com/pkg/errors"
 	"github.com/shopspring/decimal"
@@ -29,6 +30,8 @@ const (
 	apiGetOptionsHistoricalOpenInterest   = "/options/historical_open_interest/BTC"
 )
 
+const historicalPageLimit = 1000
+
 type Options struct {
 	client *Client
 }
@@ -43,7 +46,7 @@ func (o *Options) ListQuoteRequests() ([]*models.OptionQuoteRequest, error) {
 	}
 
 	var result []*models.OptionQuoteRequest
-	if err = json.Unmarshal(response, &result); err != nil {
+	if err = o.client.unmarshal(response, &result); err != nil {
 		return nil, errors.WithStack(err)
 	}
 	return result, nil
@@ -59,7 +62,7 @@ func (o *Options) ListUserQuoteRequests() ([]*models.OptionQuoteRequest, error)
 	}
 
 	var result []*models.OptionQuoteRequest
-	if err = json.Unmarshal(response, &result); err != nil {
+	if err = o.client.unmarshal(response, &result); err != nil {
 		return nil, errors.WithStack(err)
 	}
 	return result, nil
@@ -77,7 +80,7 @@ func (o *Options) CreateQuoteRequest(
 	}
 
 	result := models.CreateQuoteRequest{}
-	if err = json.Unmarshal(response, &result); err != nil {
+	if err = o.client.unmarshal(response, &result); err != nil {
 		return nil, errors.WithStack(err)
 	}
 	return &result, nil
@@ -93,7 +96,7 @@ func (o *Options) CancelQuoteRequest(id int64) (*models.CancelQuoteRequest, erro
 	}
 
 	result := models.CancelQuoteRequest{}
-	if err = json.Unmarshal(response, &result); err != nil {
+	if err = o.client.unmarshal(response, &result); err != nil {
 		return nil, errors.WithStack(err)
 	}
 	return &result, nil
@@ -110,7 +113,7 @@ func (o *Options) GetQuotesForUserQuoteRequest(
 	}
 
 	var result []*models.QuotesForOptionQuoteRequest
-	if err = json.Unmarshal(response, &result); err != nil {
+	if err = o.client.unmarshal(response, &result); err != nil {
 		return nil, errors.WithStack(err)
 	}
 	return result, nil
@@ -132,7 +135,7 @@ func (o *Options) CreateQuote(
 	}
 
 	result := models.UserOptionQuote{}
-	if err = json.Unmarshal(response, &result); err != nil {
+	if err = o.client.unmarshal(response, &result); err != nil {
 		return nil, errors.WithStack(err)
 	}
 	return &result, nil
@@ -147,7 +150,7 @@ func (o *Options) GetUserQuotes() ([]*models.UserOptionQuote, error) {
 	}
 
 	var result []*models.UserOptionQuote
-	if err = json.Unmarshal(response, &result); err != nil {
+	if err = o.client.unmarshal(response, &result); err != nil {
 		return nil, errors.WithStack(err)
 	}
 	return result, nil
@@ -163,7 +166,7 @@ func (o *Options) CancelQuote(id int64) (*models.UserOptionQuote, error) {
 	}
 
 	result := models.UserOptionQuote{}
-	if err = json.Unmarshal(response, &result); err != nil {
+	if err = o.client.unmarshal(response, &result); err != nil {
 		return nil, errors.WithStack(err)
 	}
 	return &result, nil
@@ -179,7 +182,7 @@ func (o *Options) AcceptQuote(id int64) (*models.UserOptionQuote, error) {
 	}
 
 	result := models.UserOptionQuote{}
-	if err = json.Unmarshal(response, &result); err != nil {
+	if err = o.client.unmarshal(response, &result); err != nil {
 		return nil, errors.WithStack(err)
 	}
 	return &result, nil
@@ -195,7 +198,7 @@ func (o *Options) GetAccountOptionsInfo() (*models.AccountOptionsInfo, error) {
 	}
 
 	result := models.AccountOptionsInfo{}
-	if err = json.Unmarshal(response, &result); err != nil {
+	if err = o.client.unmarshal(response, &result); err != nil {
 		return nil, errors.WithStack(err)
 	}
 	return &result, nil
@@ -211,7 +214,7 @@ func (o *Options) GetOptionsPositions() ([]*models.OptionPosition, error) {
 	}
 
 	var result []*models.OptionPosition
-	if err = json.Unmarshal(response, &result); err != nil {
+	if err = o.client.unmarshal(response, &result); err != nil {
 		return nil, errors.WithStack(err)
 	}
 	return result, nil
@@ -229,7 +232,7 @@ func (o *Options) GetPublicOptionsTrades(
 	}
 
 	var result []*models.PublicOptionTrade
-	if err = json.Unmarshal(response, &result); err != nil {
+	if err = o.client.unmarshal(response, &result); err != nil {
 		return nil, errors.WithStack(err)
 	}
 	return result, nil
@@ -247,7 +250,7 @@ func (o *Options) GetOptionsFills(
 	}
 
 	var result []*models.OptionFill
-	if err = json.Unmarshal(response, &result); err != nil {
+	if err = o.client.unmarshal(response, &result); err != nil {
 		return nil, errors.WithStack(err)
 	}
 	return result, nil
@@ -263,7 +266,7 @@ func (o *Options) Get24hOptionVolume() (*models.OptionsVolume, error) {
 	}
 
 	result := models.OptionsVolume{}
-	if err = json.Unmarshal(response, &result); err != nil {
+	if err = o.client.unmarshal(response, &result); err != nil {
 		return nil, errors.WithStack(err)
 	}
 	return &result, nil
@@ -281,7 +284,7 @@ func (o *Options) GetOptionsHistoricalVolumes(
 	}
 
 	var result []*models.OptionsHistoricalVolumes
-	if err = json.Unmarshal(response, &result); err != nil {
+	if err = o.client.unmarshal(response, &result); err != nil {
 		return nil, errors.WithStack(err)
 	}
 	return result, nil
@@ -299,7 +302,7 @@ func (o *Options) GetOptionsOpenInterest() (openInterest decimal.Decimal, err er
 	result := struct {
 		OpenInterest decimal.Decimal `json:"openInterest"`
 	}{}
-	if err = json.Unmarshal(response, &result); err != nil {
+	if err = o.client.unmarshal(response, &result); err != nil {
 		return decimal.Decimal{}, errors.WithStack(err)
 	}
 	return result.OpenInterest, nil
@@ -317,8 +320,127 @@ func (o *Options) GetHistoricalOpenInterest(
 	}
 
 	var result []*models.OptionsHistoricalOpenInterest
-	if err = json.Unmarshal(response, &result); err != nil {
+	if err = o.client.unmarshal(response, &result); err != nil {
 		return nil, errors.WithStack(err)
 	}
 	return result, nil
 }
+
+// GetOptionsHistoricalVolumesRange returns the BTC options volumes between
+// start and end, oldest first, fetching as many pages as the window needs.
+func (o *Options) GetOptionsHistoricalVolumesRange(
+	start, end time.Time,
+) ([]*models.OptionsHistoricalVolumes, error) {
+
+	var result []*models.OptionsHistoricalVolumes
+	seen := make(map[int64]struct{})
+
+	err := pageBackwards(start.Unix(), end.Unix(), func(params *models.NumberTimeLimit) (int64, int, int, error) {
+		page, err := o.GetOptionsHistoricalVolumes(params)
+		if err != nil {
+			return 0, 0, 0, err
+		}
+		earliest, added := *params.EndTime, 0
+		for _, v := range page {
+			t := v.StartTime.Unix()
+			if t < earliest {
+				earliest = t
+			}
+			if _, ok := seen[t]; ok {
+				continue
+			}
+			seen[t] = struct{}{}
+			result = append(result, v)
+			added++
+		}
+		return earliest, len(page), added, nil
+	})
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].StartTime.Before(result[j].StartTime)
+	})
+
+	return result, nil
+}
+
+// GetHistoricalOpenInterestRange returns the BTC options open interest
+// between start and end, oldest first, fetching as many pages as needed.
+func (o *Options) GetHistoricalOpenInterestRange(
+	start, end time.Time,
+) ([]*models.OptionsHistoricalOpenInterest, error) {
+
+	var result []*models.OptionsHistoricalOpenInterest
+	seen := make(map[int64]struct{})
+
+	err := pageBackwards(start.Unix(), end.Unix(), func(params *models.NumberTimeLimit) (int64, int, int, error) {
+		page, err := o.GetHistoricalOpenInterest(params)
+		if err != nil {
+			return 0, 0, 0, err
+		}
+		earliest, added := *params.EndTime, 0
+		for _, v := range page {
+			t := v.Time.Unix()
+			if t < earliest {
+				earliest = t
+			}
+			if _, ok := seen[t]; ok {
+				continue
+			}
+			seen[t] = struct{}{}
+			result = append(result, v)
+			added++
+		}
+		return earliest, len(page), added, nil
+	})
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].Time.Before(result[j].Time)
+	})
+
+	return result, nil
+}
+
+// pageBackwards covers [start, end] with requests ending ever earlier since
+// FTX returns the most recent entries of a window first. fetch returns the
+// earliest time in the page, the page size and how many entries of the page
+// it had not seen yet. Each page ends at the earliest second of the previous
+// one, which is only partly returned when a page ends within it, so fetch
+// has to drop the entries it has already seen.
+func pageBackwards(
+	start, end int64,
+	fetch func(params *models.NumberTimeLimit) (earliest int64, n, added int, err error),
+) error {
+
+	limit := historicalPageLimit
+
+	for end >= start {
+
+		endTime := end
+		earliest, n, added, err := fetch(&models.NumberTimeLimit{
+			Limit:     &limit,
+			StartTime: &start,
+			EndTime:   &endTime,
+		})
+		if err != nil {
+			return err
+		}
+
+		if n == 0 {
+			return nil
+		}
+
+		// Moving on when a second has more entries than a page
+		if added == 0 || earliest == end {
+			earliest--
+		}
+		end = earliest
+	}
+
+	return nil
+}
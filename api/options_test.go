@@ -0,0 +1,72 @@
+package api
+
+import (
+	"testing"
+
+	"github.com/uscott/go-ftx/models"
+)
+
+func TestOptions_pageBackwards(t *testing.T) {
+
+	// entries are served newest first, a page at most, as FTX does
+	page := func(times []int64, params *models.NumberTimeLimit) []int {
+		var ids []int
+		for id := len(times) - 1; id >= 0 && len(ids) < *params.Limit; id-- {
+			if ts := times[id]; ts >= *params.StartTime && ts <= *params.EndTime {
+				ids = append(ids, id)
+			}
+		}
+		return ids
+	}
+
+	collect := func(times []int64, start, end int64) (map[int]struct{}, int) {
+		seen, requests := make(map[int]struct{}), 0
+		err := pageBackwards(start, end, func(params *models.NumberTimeLimit) (int64, int, int, error) {
+			requests++
+			ids := page(times, params)
+			earliest, added := *params.EndTime, 0
+			for _, id := range ids {
+				if times[id] < earliest {
+					earliest = times[id]
+				}
+				if _, ok := seen[id]; ok {
+					continue
+				}
+				seen[id] = struct{}{}
+				added++
+			}
+			return earliest, len(ids), added, nil
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return seen, requests
+	}
+
+	// Seven entries a second, pages ending partway through a second
+	times := make([]int64, 2500)
+	for id := range times {
+		times[id] = 10 + int64(id/7)
+	}
+	seen, requests := collect(times, 10, times[len(times)-1])
+	if len(seen) != len(times) {
+		t.Fatalf("Should be equal: %d, %d", len(seen), len(times))
+	}
+	if requests > 5 {
+		t.Fatalf("Too many requests: %d", requests)
+	}
+
+	// A second with more entries than a page is moved past, only a page of
+	// it being returned, rather than requested forever
+	times = make([]int64, 0, 1300)
+	for ts := int64(1); ts <= 100; ts++ {
+		times = append(times, ts)
+	}
+	for i := 0; i < 1200; i++ {
+		times = append(times, 101)
+	}
+	seen, _ = collect(times, 1, 101)
+	if expected := 100 + historicalPageLimit; len(seen) != expected {
+		t.Fatalf("Should be equal: %d, %d", len(seen), expected)
+	}
+}
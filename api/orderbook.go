@@ -0,0 +1,294 @@
+package api
+
+import (
+	"context"
+	"sort"
+	"sync"
+
+	"github.com/pkg/errors"
+	"github.com/shopspring/decimal"
+
+	"github.com/uscott/go-ftx/models"
+)
+
+const checksumDepth int = 100
+
+var ErrChecksumMismatch = errors.New("Order book checksum mismatch")
+
+// OrderBookCache maintains the order books of a set of markets from the
+// orderbook channel. Books are reset on every partial so they survive
+// reconnects, and are resynced on checksum mismatch.
+type OrderBookCache struct {
+	stream *Stream
+	mu     sync.RWMutex
+	books  map[string]*orderBook
+	depth  int
+	errC   chan error
+}
+
+type orderBook struct {
+	bids  [][]decimal.Decimal // Descending by price
+	asks  [][]decimal.Decimal // Ascending by price
+	time  models.FTXTime
+	valid bool
+}
+
+func NewOrderBookCache(
+	ctx context.Context, stream *Stream, markets ...string) (*OrderBookCache, error) {
+
+	booksC, err := stream.SubscribeToOrderBooks(ctx, markets...)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	c := &OrderBookCache{
+		stream: stream,
+		books:  make(map[string]*orderBook, len(markets)),
+		errC:   make(chan error, errorsBufferSize),
+	}
+
+	go c.run(ctx, booksC)
+
+	return c, nil
+}
+
+// Errors returns the channel on which resync events are reported.
+func (c *OrderBookCache) Errors() chan error {
+	return c.errC
+}
+
+// SetDepth makes Book return only the best depth levels of each side. The
+// books are still verified against the checksum, which covers the best 100
+// levels, and levels deeper than both are dropped after each update. Zero,
+// the default, keeps every level.
+func (c *OrderBookCache) SetDepth(depth int) {
+	c.mu.Lock()
+	c.depth = depth
+	c.mu.Unlock()
+}
+
+// Book returns the order book of the market, limited to the depth set with
+// SetDepth. It reports false until the first partial has arrived and while
+// the book is resynced.
+func (c *OrderBookCache) Book(market string) (*models.OrderBook, bool) {
+
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	b := c.books[market]
+	if b == nil || !b.valid {
+		return nil, false
+	}
+
+	return &models.OrderBook{
+		Bids: copyLevels(b.bids, c.depth),
+		Asks: copyLevels(b.asks, c.depth),
+		Time: b.time,
+	}, true
+}
+
+func (c *OrderBookCache) BestBid(market string) (price, size decimal.Decimal, ok bool) {
+
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	b := c.books[market]
+	if b == nil || !b.valid || len(b.bids) == 0 {
+		return
+	}
+
+	return b.bids[0][0], b.bids[0][1], true
+}
+
+func (c *OrderBookCache) BestAsk(market string) (price, size decimal.Decimal, ok bool) {
+
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	b := c.books[market]
+	if b == nil || !b.valid || len(b.asks) == 0 {
+		return
+	}
+
+	return b.asks[0][0], b.asks[0][1], true
+}
+
+func (c *OrderBookCache) Spread(market string) (spread decimal.Decimal, ok bool) {
+
+	bid, _, ok := c.BestBid(market)
+	if !ok {
+		return
+	}
+
+	ask, _, ok := c.BestAsk(market)
+	if !ok {
+		return
+	}
+
+	return ask.Sub(bid), true
+}
+
+func (c *OrderBookCache) run(ctx context.Context, booksC chan *models.OrderBookResponse) {
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case r, ok := <-booksC:
+			if !ok {
+				return
+			}
+			c.apply(r)
+		}
+	}
+}
+
+func (c *OrderBookCache) apply(r *models.OrderBookResponse) {
+
+	c.mu.Lock()
+
+	b := c.books[r.Symbol]
+
+	switch r.ResponseType {
+	case models.Partial:
+		b = &orderBook{valid: true}
+		c.books[r.Symbol] = b
+	case models.Update:
+		if b == nil || !b.valid {
+			c.mu.Unlock()
+			return
+		}
+	default:
+		c.mu.Unlock()
+		return
+	}
+
+	b.update(&r.OrderBook)
+
+	if r.Checksum == 0 || b.checksum() == r.Checksum {
+		b.trim(c.depth)
+		c.mu.Unlock()
+		return
+	}
+
+	b.valid = false
+	c.mu.Unlock()
+
+	c.sendError(errors.Wrapf(ErrChecksumMismatch, "%s: resubscribing", r.Symbol))
+
+	if err := c.stream.resubscribe(models.OrderBookChannel, r.Symbol); err != nil {
+		c.sendError(errors.WithStack(err))
+	}
+}
+
+func (c *OrderBookCache) sendError(err error) {
+	select {
+	case c.errC <- err:
+	default:
+	}
+}
+
+func (b *orderBook) update(ob *models.OrderBook) {
+
+	b.time = ob.Time
+
+	for _, level := range ob.Bids {
+		b.bids = updateLevels(b.bids, level, true)
+	}
+	for _, level := range ob.Asks {
+		b.asks = updateLevels(b.asks, level, false)
+	}
+}
+
+func (b *orderBook) checksum() uint32 {
+	return checksum(b.bids, b.asks)
+}
+
+// trim drops the levels deeper than both depth, if positive, and the
+// checksum depth.
+func (b *orderBook) trim(depth int) {
+
+	if depth <= 0 {
+		return
+	}
+	if depth < checksumDepth {
+		depth = checksumDepth
+	}
+
+	if len(b.bids) > depth {
+		b.bids = b.bids[:depth]
+	}
+	if len(b.asks) > depth {
+		b.asks = b.asks[:depth]
+	}
+}
+
+// copyLevels returns a copy of the best depth levels, or of all of them if
+// depth is not positive. Levels are replaced rather than modified by
+// updateLevels so they are shared.
+func copyLevels(levels [][]decimal.Decimal, depth int) [][]decimal.Decimal {
+
+	if depth > 0 && len(levels) > depth {
+		levels = levels[:depth]
+	}
+
+	result := make([][]decimal.Decimal, len(levels))
+	copy(result, levels)
+
+	return result
+}
+
+func updateLevels(
+	levels [][]decimal.Decimal, level []decimal.Decimal, desc bool) [][]decimal.Decimal {
+
+	if len(level) < 2 {
+		return levels
+	}
+
+	price, size := level[0], level[1]
+
+	i := sort.Search(len(levels), func(i int) bool {
+		if desc {
+			return levels[i][0].LessThanOrEqual(price)
+		}
+		return levels[i][0].GreaterThanOrEqual(price)
+	})
+
+	found := i < len(levels) && levels[i][0].Equal(price)
+
+	switch {
+	case size.IsZero() && found:
+		return append(levels[:i], levels[i+1:]...)
+	case size.IsZero():
+		return levels
+	case found:
+		levels[i] = []decimal.Decimal{price, size}
+		return levels
+	}
+
+	levels = append(levels, nil)
+	copy(levels[i+1:], levels[i:])
+	levels[i] = []decimal.Decimal{price, size}
+
+	return levels
+}
+
+// checksum implements the FTX order book checksum, see
+// models.ComputeOrderBookChecksum.
+func checksum(bids, asks [][]decimal.Decimal) uint32 {
+	return models.ComputeOrderBookChecksum(checksumLevels(bids), checksumLevels(asks))
+}
+
+func checksumLevels(levels [][]decimal.Decimal) []models.PriceLevel {
+
+	if len(levels) > checksumDepth {
+		levels = levels[:checksumDepth]
+	}
+
+	result := make([]models.PriceLevel, len(levels))
+	for i, level := range levels {
+		result[i] = models.PriceLevel{Price: level[0], Size: level[1]}
+	}
+
+	return result
+}
@@ -1,11 +1,17 @@
 package api
 
 import (
-	"encoding/json"
+	"context"
 	"fmt"
 	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
 
 	"github.com/pkg/errors"
+	"github.com/shopspring/decimal"
 	"github.com/uscott/go-ftx/models"
 	"github.com/uscott/go-tools/errs"
 )
@@ -29,6 +35,32 @@ const (
 	apiCancelAll                = apiGetOpenOrders
 )
 
+const (
+	defaultPollInterval = time.Second
+
+	idempotentAttempts      = 3
+	idempotentRetryInterval = 250 * time.Millisecond
+)
+
+var (
+	ErrClientIDRequired  = errors.New("Client ID required")
+	ErrClientIDNotInt    = errors.New("Client ID must be an integer")
+	ErrClientIDCollision = errors.New("Client ID used by a different order")
+
+	ErrReduceOnlyExceedsPosition = errors.New("Reduce only order exceeds position")
+	ErrPostOnlyWouldCross        = errors.New("Post only order would cross the book")
+)
+
+// OrderChecks holds the snapshots an order is validated against before it is
+// sent. A nil snapshot skips the corresponding check.
+type OrderChecks struct {
+	// Positions is checked against reduce only orders.
+	Positions []*models.Position
+	// Book is the order book of the market, checked against post only
+	// limit orders.
+	Book *models.OrderBook
+}
+
 type Orders struct {
 	client *Client
 }
@@ -66,7 +98,7 @@ func (o *Orders) GetOpenOrders(market *string) ([]*models.Order, error) {
 	}
 
 	var result []*models.Order
-	if err = json.Unmarshal(response, &result); err != nil {
+	if err = o.client.unmarshal(response, &result); err != nil {
 		return nil, errors.WithStack(err)
 	}
 	return result, nil
@@ -83,7 +115,7 @@ func (o *Orders) GetOrdersHistory(
 	}
 
 	var result []*models.Order
-	if err = json.Unmarshal(response, &result); err != nil {
+	if err = o.client.unmarshal(response, &result); err != nil {
 		return nil, errors.WithStack(err)
 	}
 
@@ -91,7 +123,7 @@ func (o *Orders) GetOrdersHistory(
 }
 
 func (o *Orders) GetOpenTriggerOrders(
-	market, triggerType *string) ([]*models.TriggerOrder, error) {
+	market *string, triggerType *models.TriggerOrderType) ([]*models.TriggerOrder, error) {
 
 	url := FormURL(apiGetTriggerOrders)
 
@@ -103,7 +135,7 @@ func (o *Orders) GetOpenTriggerOrders(
 
 	var result []*models.TriggerOrder
 
-	if err = json.Unmarshal(response, &result); err != nil {
+	if err = o.client.unmarshal(response, &result); err != nil {
 		return nil, errors.WithStack(err)
 	}
 
@@ -121,7 +153,7 @@ func (o *Orders) GetTriggerOrderTriggers(orderID int64) ([]*models.Trigger, erro
 
 	var result []*models.Trigger
 
-	if err = json.Unmarshal(response, &result); err != nil {
+	if err = o.client.unmarshal(response, &result); err != nil {
 		return nil, errors.WithStack(err)
 	}
 
@@ -140,14 +172,70 @@ func (o *Orders) GetTriggerOrdersHistory(
 
 	var result []*models.TriggerOrder
 
-	if err = json.Unmarshal(response, &result); err != nil {
+	if err = o.client.unmarshal(response, &result); err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	return result, nil
+}
+
+// GetTriggerOrdersHistoryRange returns the trigger orders created between
+// start and end, oldest first, fetching as many pages as the window needs.
+// The orders carry when and at what price they triggered along with the ID
+// of the resulting order; GetTriggerOrderTriggers has the fills of each.
+func (o *Orders) GetTriggerOrdersHistoryRange(
+	market *string, start, end time.Time,
+) ([]*models.TriggerOrder, error) {
+
+	var result []*models.TriggerOrder
+	seen := make(map[int64]struct{})
+
+	err := pageBackwards(start.Unix(), end.Unix(), func(params *models.NumberTimeLimit) (int64, int, int, error) {
+		page, err := o.GetTriggerOrdersHistory(&models.TriggerOrdersHistoryParams{
+			Market:    market,
+			Limit:     params.Limit,
+			StartTime: params.StartTime,
+			EndTime:   params.EndTime,
+		})
+		if err != nil {
+			return 0, 0, 0, err
+		}
+		earliest, added := *params.EndTime, 0
+		for _, v := range page {
+			if t := v.CreatedAt.Unix(); t < earliest {
+				earliest = t
+			}
+			if _, ok := seen[v.ID]; ok {
+				continue
+			}
+			seen[v.ID] = struct{}{}
+			result = append(result, v)
+			added++
+		}
+		return earliest, len(page), added, nil
+	})
+	if err != nil {
 		return nil, errors.WithStack(err)
 	}
 
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].CreatedAt.Before(result[j].CreatedAt)
+	})
+
 	return result, nil
 }
 
+// PlaceOrder places the order, returning an *models.OrderRejectedError if FTX
+// rejects it.
 func (o *Orders) PlaceOrder(params *models.OrderParams, order *models.Order) (err error) {
+	return o.PlaceOrderWithContext(context.Background(), params, order)
+}
+
+// PlaceOrderWithContext is PlaceOrder with the request made with ctx, for
+// instance to tag it with WithTraceID. Orders FTX rejects return an
+// *models.OrderRejectedError.
+func (o *Orders) PlaceOrderWithContext(
+	ctx context.Context, params *models.OrderParams, order *models.Order) (err error) {
 
 	if order == nil {
 		return errs.NilPtr
@@ -155,18 +243,89 @@ func (o *Orders) PlaceOrder(params *models.OrderParams, order *models.Order) (er
 
 	url := fmt.Sprintf("%s%s", apiUrl, apiPlaceOrder)
 
-	response, err := o.client.Post(params, url)
+	response, err := o.client.GetResponseWithContext(ctx, params, url, http.MethodPost)
 	if err != nil {
-		return err
+		return orderRejected(err, params)
 	}
 
-	if err = json.Unmarshal(response, &order); err != nil {
+	if err = o.client.unmarshal(response, &order); err != nil {
 		return errors.WithStack(err)
 	}
 
 	return nil
 }
 
+// PlaceOrderChecked places the order after validating it with CheckOrder so
+// that orders FTX would reject are not sent.
+func (o *Orders) PlaceOrderChecked(
+	params *models.OrderParams, order *models.Order, checks OrderChecks,
+) error {
+
+	if err := CheckOrder(params, checks); err != nil {
+		return err
+	}
+
+	return o.PlaceOrder(params, order)
+}
+
+// CheckOrder returns ErrReduceOnlyExceedsPosition if a reduce only order
+// would increase or flip the net position and ErrPostOnlyWouldCross if a post
+// only limit order would trade immediately against the book.
+func CheckOrder(params *models.OrderParams, checks OrderChecks) error {
+
+	if params == nil {
+		return errs.NilPtrArg
+	}
+	if params.Market == nil || params.Side == nil {
+		return nil
+	}
+
+	if checks.Positions != nil && params.ReduceOnly != nil && *params.ReduceOnly &&
+		params.Size != nil {
+
+		net := decimal.Zero
+		for _, p := range checks.Positions {
+			if p != nil && p.Future == *params.Market {
+				net = p.NetSize
+				break
+			}
+		}
+
+		reducible := net
+		if *params.Side == models.Buy {
+			reducible = net.Neg()
+		}
+		if params.Size.GreaterThan(reducible) {
+			return errors.Wrapf(ErrReduceOnlyExceedsPosition,
+				"%s %v %s with net position %v", *params.Side, *params.Size, *params.Market, net)
+		}
+	}
+
+	if checks.Book != nil && params.PostOnly != nil && *params.PostOnly &&
+		params.Price != nil && (params.Type == nil || *params.Type == models.LimitOrder) {
+
+		var (
+			levels  [][]decimal.Decimal
+			crosses func(price decimal.Decimal) bool
+		)
+		if *params.Side == models.Buy {
+			levels = checks.Book.Asks
+			crosses = func(ask decimal.Decimal) bool { return params.Price.GreaterThanOrEqual(ask) }
+		} else {
+			levels = checks.Book.Bids
+			crosses = func(bid decimal.Decimal) bool { return params.Price.LessThanOrEqual(bid) }
+		}
+		for _, level := range levels {
+			if len(level) > 0 && crosses(level[0]) {
+				return errors.Wrapf(ErrPostOnlyWouldCross,
+					"%s %s at %v against %v", *params.Side, *params.Market, *params.Price, level[0])
+			}
+		}
+	}
+
+	return nil
+}
+
 func (o *Orders) PlaceTriggerOrder(
 	params *models.TriggerOrderParams, order *models.TriggerOrder) (err error) {
 
@@ -181,7 +340,7 @@ func (o *Orders) PlaceTriggerOrder(
 		return errors.WithStack(err)
 	}
 
-	if err = json.Unmarshal(response, order); err != nil {
+	if err = o.client.unmarshal(response, order); err != nil {
 		return errors.WithStack(err)
 	}
 
@@ -204,7 +363,7 @@ func (o *Orders) ModifyOrder(
 		return errors.WithStack(err)
 	}
 
-	if err = json.Unmarshal(response, order); err != nil {
+	if err = o.client.unmarshal(response, order); err != nil {
 		return errors.WithStack(err)
 	}
 
@@ -228,7 +387,7 @@ func (o *Orders) ModifyOrderByClientID(
 		return errors.WithStack(err)
 	}
 
-	if err = json.Unmarshal(response, order); err != nil {
+	if err = o.client.unmarshal(response, order); err != nil {
 		return errors.WithStack(err)
 	}
 
@@ -251,7 +410,7 @@ func (o *Orders) ModifyTriggerOrder(
 		return errors.WithStack(err)
 	}
 
-	if err = json.Unmarshal(response, order); err != nil {
+	if err = o.client.unmarshal(response, order); err != nil {
 		return errors.WithStack(err)
 	}
 
@@ -271,7 +430,7 @@ func (o *Orders) GetOrderStatus(orderID int64, order *models.Order) (err error)
 		return errors.WithStack(err)
 	}
 
-	if err = json.Unmarshal(response, order); err != nil {
+	if err = o.client.unmarshal(response, order); err != nil {
 		return errors.WithStack(err)
 	}
 
@@ -291,7 +450,7 @@ func (o *Orders) GetOrderStatusByClientID(clientID int64, order *models.Order) (
 		return errors.WithStack(err)
 	}
 
-	if err = json.Unmarshal(response, order); err != nil {
+	if err = o.client.unmarshal(response, order); err != nil {
 		return errors.WithStack(err)
 	}
 
@@ -307,7 +466,7 @@ func (o *Orders) CancelOrder(orderID int64) (result string, err error) {
 		return result, errors.WithStack(err)
 	}
 
-	if err = json.Unmarshal(response, &result); err != nil {
+	if err = o.client.unmarshal(response, &result); err != nil {
 		return result, errors.WithStack(err)
 	}
 
@@ -323,7 +482,7 @@ func (o *Orders) CancelOrderByClientID(clientID int64) (result string, err error
 		return result, errors.WithStack(err)
 	}
 
-	if err = json.Unmarshal(response, &result); err != nil {
+	if err = o.client.unmarshal(response, &result); err != nil {
 		return result, errors.WithStack(err)
 	}
 
@@ -339,7 +498,7 @@ func (o *Orders) CancelTriggerOrder(orderID int64) (result string, err error) {
 		return result, errors.WithStack(err)
 	}
 
-	if err = json.Unmarshal(response, &result); err != nil {
+	if err = o.client.unmarshal(response, &result); err != nil {
 		return result, errors.WithStack(err)
 	}
 
@@ -356,9 +515,386 @@ func (o *Orders) CancelAllOrders(
 		return result, errors.WithStack(err)
 	}
 
-	if err = json.Unmarshal(response, &result); err != nil {
+	if err = o.client.unmarshal(response, &result); err != nil {
 		return result, errors.WithStack(err)
 	}
 
 	return
 }
+
+// CancelAllOrdersWithResult is CancelAllOrders returning the IDs of the
+// orders cancelled when snapshot is set, which are those found open with the
+// filters of params just before the cancel all is sent.
+func (o *Orders) CancelAllOrdersWithResult(
+	params *models.CancelAllParams, snapshot bool) (*models.CancelAllResult, error) {
+
+	result := &models.CancelAllResult{}
+
+	if snapshot {
+		if err := o.snapshotOpenOrders(params, result); err != nil {
+			return nil, err
+		}
+	}
+
+	msg, err := o.CancelAllOrders(params)
+	if err != nil {
+		return nil, err
+	}
+	result.Message = msg
+
+	return result, nil
+}
+
+func (o *Orders) snapshotOpenOrders(
+	params *models.CancelAllParams, result *models.CancelAllResult) error {
+
+	var (
+		market                 *string
+		conditional, limitOnly bool
+	)
+	if params != nil {
+		market = params.Market
+		conditional = params.ConditionalOrdersOnly != nil && *params.ConditionalOrdersOnly
+		limitOnly = params.LimitOrdersOnly != nil && *params.LimitOrdersOnly
+	}
+
+	if !conditional {
+		orders, err := o.GetOpenOrders(market)
+		if err != nil {
+			return err
+		}
+		for _, order := range orders {
+			if !limitOnly || order.Type == models.LimitOrder {
+				result.OrderIDs = append(result.OrderIDs, order.ID)
+			}
+		}
+	}
+
+	if !limitOnly {
+		orders, err := o.GetOpenTriggerOrders(market, nil)
+		if err != nil {
+			return err
+		}
+		for _, order := range orders {
+			result.TriggerOrderIDs = append(result.TriggerOrderIDs, order.ID)
+		}
+	}
+
+	return nil
+}
+
+// WaitForOrder blocks until the order is closed (filled or cancelled) or ctx
+// is done, returning the final state of the order. Updates from an active
+// orders stream are used when available with REST polling as the fallback.
+func (o *Orders) WaitForOrder(
+	ctx context.Context, orderID int64, pollInterval time.Duration,
+) (*models.Order, error) {
+
+	if pollInterval <= 0 {
+		pollInterval = defaultPollInterval
+	}
+
+	var updates chan *models.Order
+	if o.client.Stream.isSubscribed(models.OrdersChannel) {
+		var unwatch func()
+		updates, unwatch = o.client.Stream.watchOrder(orderID)
+		defer unwatch()
+	}
+
+	ticker := time.NewTicker(pollInterval)
+	defer ticker.Stop()
+
+	order := &models.Order{}
+	if err := o.GetOrderStatus(orderID, order); err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	for order.Status != models.Closed {
+		select {
+		case <-ctx.Done():
+			return order, ctx.Err()
+		case update := <-updates:
+			order = update
+		case <-ticker.C:
+			if err := o.GetOrderStatus(orderID, order); err != nil {
+				return nil, errors.WithStack(err)
+			}
+		}
+	}
+
+	return order, nil
+}
+
+// ExecuteTWAP splits totalSize into equal market orders placed every interval,
+// the first one immediately. Slice sizes are rounded down to the market's size
+// increment with the remainder going to the last slice. On error or when ctx
+// is done the orders placed so far are returned along with the error.
+func (o *Orders) ExecuteTWAP(
+	ctx context.Context,
+	market string,
+	totalSize decimal.Decimal,
+	side models.OrderSide,
+	slices int,
+	interval time.Duration,
+) ([]*models.Order, error) {
+
+	m := models.Market{}
+	if err := o.client.Markets.GetMarketByName(market, &m); err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	sizes, err := twapSlices(totalSize, m.SizeIncrement, slices)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	orders := make([]*models.Order, 0, len(sizes))
+
+	for i, size := range sizes {
+
+		if i > 0 {
+			select {
+			case <-ctx.Done():
+				return orders, ctx.Err()
+			case <-time.After(interval):
+			}
+		}
+
+		order := &models.Order{}
+		err = o.PlaceOrder(&models.OrderParams{
+			Market: &market,
+			Side:   &side,
+			Type:   PtrOrderType(models.MarketOrder),
+			Size:   PtrDecimal(size),
+		}, order)
+		if err != nil {
+			return orders, errors.WithStack(err)
+		}
+
+		orders = append(orders, order)
+	}
+
+	return orders, nil
+}
+
+// ReplaceOrders cancels the given orders concurrently and, once every cancel
+// has returned, places the new orders concurrently, batchConcurrency requests
+// at a time. The placed orders are returned in the same order as newOrders.
+// Nothing is placed if any cancel fails so that a refresh never leaves both
+// the old and new quotes resting. Orders not yet placed once ctx is done fail
+// with its error.
+func (o *Orders) ReplaceOrders(
+	ctx context.Context, cancelIDs []int64, newOrders []*models.OrderParams,
+) ([]*models.Order, error) {
+
+	for _, params := range newOrders {
+		if params == nil {
+			return nil, errs.NilPtrArg
+		}
+	}
+
+	var (
+		wg  sync.WaitGroup
+		sem = make(chan struct{}, batchConcurrency)
+	)
+
+	cancelErrs := make([]error, len(cancelIDs))
+	for i, id := range cancelIDs {
+		wg.Add(1)
+		go func(i int, id int64) {
+			defer wg.Done()
+			sem <- struct{}{}
+			defer func() { <-sem }()
+			if _, err := o.CancelOrder(id); err != nil {
+				cancelErrs[i] = errors.Wrapf(err, "Cancel order %d", id)
+			}
+		}(i, id)
+	}
+	wg.Wait()
+
+	for _, err := range cancelErrs {
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	orders := make([]*models.Order, len(newOrders))
+	placeErrs := make([]error, len(newOrders))
+	for i, params := range newOrders {
+		wg.Add(1)
+		go func(i int, params *models.OrderParams) {
+			defer wg.Done()
+
+			var err error
+			order := &models.Order{}
+
+			select {
+			case sem <- struct{}{}:
+				err = o.PlaceOrderWithContext(ctx, params, order)
+				<-sem
+			case <-ctx.Done():
+				err = ctx.Err()
+			}
+
+			if err != nil {
+				placeErrs[i] = errors.Wrapf(err, "Place order %d", i)
+				return
+			}
+			orders[i] = order
+		}(i, params)
+	}
+	wg.Wait()
+
+	for _, err := range placeErrs {
+		if err != nil {
+			return orders, err
+		}
+	}
+
+	return orders, nil
+}
+
+// PlaceOrderIdempotent places the order at most once, using its client ID to
+// find out whether an attempt that failed with a timeout, a network error or a
+// server error actually went through before sending it again. The order is
+// sent up to three times. The client ID must be an integer as orders are
+// looked up with GetOrderStatusByClientID. ErrClientIDCollision is returned
+// when the client ID belongs to an order for a different market, side or size.
+func (o *Orders) PlaceOrderIdempotent(
+	ctx context.Context, params *models.OrderParams,
+) (*models.Order, error) {
+
+	if params == nil {
+		return nil, errs.NilPtrArg
+	}
+	if params.ClientID == nil || *params.ClientID == "" {
+		return nil, ErrClientIDRequired
+	}
+
+	clientID, err := strconv.ParseInt(*params.ClientID, 10, 64)
+	if err != nil {
+		return nil, errors.Wrapf(ErrClientIDNotInt, "%q", *params.ClientID)
+	}
+
+	for attempt := 1; ; attempt++ {
+
+		order := &models.Order{}
+		err := o.PlaceOrderWithContext(ctx, params, order)
+		if err == nil {
+			return order, nil
+		}
+		if !isDuplicateClientID(err) && !isUnknownOutcome(err) {
+			return nil, err
+		}
+
+		// The order may or may not have landed so look it up before resending
+		order, err = o.resolveClientID(ctx, clientID, params)
+		if err != nil || order != nil {
+			return order, err
+		}
+
+		if attempt == idempotentAttempts {
+			return nil, errors.Errorf(
+				"Order %s not placed after %d attempts", *params.ClientID, attempt)
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-time.After(idempotentRetryInterval):
+		}
+	}
+}
+
+// resolveClientID looks up the order with the client ID of params, returning
+// nil if there is none. Lookups are retried until ctx is done while their
+// outcome is unknown since resending could otherwise duplicate the order.
+func (o *Orders) resolveClientID(
+	ctx context.Context, clientID int64, params *models.OrderParams,
+) (*models.Order, error) {
+
+	for {
+		order := &models.Order{}
+		err := o.GetOrderStatusByClientID(clientID, order)
+		if err == nil {
+			if !orderMatches(order, params) {
+				return nil, errors.Wrapf(ErrClientIDCollision, "%s: order %d",
+					*params.ClientID, order.ID)
+			}
+			return order, nil
+		}
+
+		var apiErr *APIError
+		if errors.As(err, &apiErr) && apiErr.StatusCode == http.StatusNotFound {
+			return nil, nil
+		}
+		if !isUnknownOutcome(err) {
+			return nil, err
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-time.After(idempotentRetryInterval):
+		}
+	}
+}
+
+func orderMatches(order *models.Order, params *models.OrderParams) bool {
+	return (params.Market == nil || *params.Market == order.Market) &&
+		(params.Side == nil || *params.Side == order.Side) &&
+		(params.Size == nil || params.Size.Equal(order.Size))
+}
+
+// orderRejected returns an OrderRejectedError if err is FTX rejecting the
+// order placed with params, as opposed to failing to process it.
+func orderRejected(err error, params *models.OrderParams) error {
+	var apiErr *APIError
+	if !errors.As(err, &apiErr) || apiErr.StatusCode >= http.StatusInternalServerError {
+		return err
+	}
+	return errors.WithStack(&models.OrderRejectedError{
+		Message:    apiErr.Message,
+		StatusCode: apiErr.StatusCode,
+		Params:     params,
+		Err:        err,
+	})
+}
+
+func isDuplicateClientID(err error) bool {
+	var apiErr *APIError
+	return errors.As(err, &apiErr) &&
+		strings.Contains(strings.ToLower(apiErr.Message), "duplicate client")
+}
+
+func twapSlices(total, increment decimal.Decimal, slices int) ([]decimal.Decimal, error) {
+
+	if slices < 1 {
+		return nil, errors.Errorf("Invalid number of slices: %d", slices)
+	}
+
+	roundDown := func(d decimal.Decimal) decimal.Decimal {
+		if increment.IsPositive() {
+			return d.Div(increment).Floor().Mul(increment)
+		}
+		return d
+	}
+
+	slice := roundDown(total.Div(decimal.NewFromInt(int64(slices))))
+	if !slice.IsPositive() {
+		return nil, errors.Errorf(
+			"Size %v too small for %d slices of increment %v", total, slices, increment)
+	}
+
+	sizes := make([]decimal.Decimal, slices)
+	for i := range sizes[:slices-1] {
+		sizes[i] = slice
+	}
+	sizes[slices-1] = roundDown(total.Sub(slice.Mul(decimal.NewFromInt(int64(slices - 1)))))
+
+	return sizes, nil
+}
@@ -0,0 +1,462 @@
+package api
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/shopspring/decimal"
+	"github.com/uscott/go-ftx/models"
+)
+
+func TestOrders_twapSlices(t *testing.T) {
+
+	tests := []struct {
+		total     string
+		increment string
+		slices    int
+		expected  []string
+	}{
+		{"1", "0.001", 4, []string{"0.25", "0.25", "0.25", "0.25"}},
+		{"1", "0.001", 3, []string{"0.333", "0.333", "0.334"}},
+		{"1.0005", "0.001", 3, []string{"0.333", "0.333", "0.334"}},
+		{"10", "1", 1, []string{"10"}},
+	}
+
+	for i, test := range tests {
+		sizes, err := twapSlices(
+			decimal.RequireFromString(test.total),
+			decimal.RequireFromString(test.increment),
+			test.slices)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(sizes) != len(test.expected) {
+			t.Fatalf("Length inequality: %d, %d - test #%d", len(sizes), len(test.expected), i+1)
+		}
+		for j, e := range test.expected {
+			if !sizes[j].Equal(decimal.RequireFromString(e)) {
+				t.Fatalf("Should be equal: %v, %v - test #%d", sizes[j], e, i+1)
+			}
+		}
+	}
+
+	if _, err := twapSlices(decimal.NewFromInt(1), decimal.NewFromInt(1), 2); err == nil {
+		t.Fatal("Should have gotten an error")
+	}
+	if _, err := twapSlices(decimal.NewFromInt(1), decimal.NewFromInt(1), 0); err == nil {
+		t.Fatal("Should have gotten an error")
+	}
+}
+
+// rewriteTransport sends every request to the test server instead of FTX.
+type rewriteTransport struct {
+	target *url.URL
+}
+
+func (t rewriteTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	req.URL.Scheme = t.target.Scheme
+	req.URL.Host = t.target.Host
+	return http.DefaultTransport.RoundTrip(req)
+}
+
+// newTestClient returns a client whose requests are served by handler, on a
+// test server closed at the end of the test.
+func newTestClient(t *testing.T, handler http.HandlerFunc, opts ...Option) *Client {
+
+	server := httptest.NewServer(handler)
+	t.Cleanup(server.Close)
+
+	target, _ := url.Parse(server.URL)
+	opts = append([]Option{WithHTTPClient(&http.Client{Transport: rewriteTransport{target: target}})}, opts...)
+
+	return New(opts...)
+}
+
+func TestOrders_PlaceOrderIdempotent(t *testing.T) {
+
+	const order = `{"success": true, "result": ` +
+		`{"id": 1, "market": "BTC-PERP", "side": "buy", "size": 1, "clientId": "123"}}`
+
+	tests := []struct {
+		name     string
+		place    func(w http.ResponseWriter, n int, placed func())
+		lookup   func(w http.ResponseWriter)
+		posts    int
+		expected error
+	}{
+		{
+			name: "timeout resolved by lookup",
+			place: func(w http.ResponseWriter, n int, placed func()) {
+				placed()
+				time.Sleep(200 * time.Millisecond)
+				_, _ = w.Write([]byte(order))
+			},
+			posts: 1,
+		},
+		{
+			name: "server error resent",
+			place: func(w http.ResponseWriter, n int, placed func()) {
+				if n == 1 {
+					w.WriteHeader(http.StatusInternalServerError)
+					_, _ = w.Write([]byte(`{"success": false, "error": "Internal error"}`))
+					return
+				}
+				placed()
+				_, _ = w.Write([]byte(order))
+			},
+			posts: 2,
+		},
+		{
+			name: "collision",
+			place: func(w http.ResponseWriter, n int, placed func()) {
+				w.WriteHeader(http.StatusBadRequest)
+				_, _ = w.Write([]byte(`{"success": false, "error": "Duplicate client order ID"}`))
+			},
+			lookup: func(w http.ResponseWriter) {
+				_, _ = w.Write([]byte(`{"success": true, "result": ` +
+					`{"id": 2, "market": "ETH-PERP", "side": "buy", "size": 1, "clientId": "123"}}`))
+			},
+			posts:    1,
+			expected: ErrClientIDCollision,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+
+			var (
+				mu     sync.Mutex
+				posts  int
+				placed bool
+			)
+
+			c := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+				if r.Method == http.MethodPost {
+					mu.Lock()
+					posts++
+					n := posts
+					mu.Unlock()
+					test.place(w, n, func() {
+						mu.Lock()
+						placed = true
+						mu.Unlock()
+					})
+					return
+				}
+				if !strings.HasSuffix(r.URL.Path, "/orders/by_client_id/123") {
+					t.Errorf("Unexpected path: %s", r.URL.Path)
+				}
+				if test.lookup != nil {
+					test.lookup(w)
+					return
+				}
+				mu.Lock()
+				done := placed
+				mu.Unlock()
+				if !done {
+					w.WriteHeader(http.StatusNotFound)
+					_, _ = w.Write([]byte(`{"success": false, "error": "Order not found"}`))
+					return
+				}
+				_, _ = w.Write([]byte(order))
+			}, WithAuth("key", "secret"))
+			c.client.Timeout = 100 * time.Millisecond
+
+			result, err := c.Orders.PlaceOrderIdempotent(context.Background(), &models.OrderParams{
+				Market:   PtrString("BTC-PERP"),
+				Side:     PtrOrderSide(models.Buy),
+				Type:     PtrOrderType(models.MarketOrder),
+				Size:     PtrDecimal(decimal.NewFromInt(1)),
+				ClientID: PtrString("123"),
+			})
+			if test.expected != nil {
+				if !errors.Is(err, test.expected) {
+					t.Fatalf("Should be equal: %v, %v", err, test.expected)
+				}
+			} else if err != nil {
+				t.Fatal(err)
+			} else if result.ID != 1 {
+				t.Fatalf("Should be equal: %d, %d", result.ID, 1)
+			}
+
+			mu.Lock()
+			defer mu.Unlock()
+			if posts != test.posts {
+				t.Fatalf("Should be equal: %d, %d", posts, test.posts)
+			}
+		})
+	}
+
+	c := New()
+	_, err := c.Orders.PlaceOrderIdempotent(context.Background(), &models.OrderParams{})
+	if err != ErrClientIDRequired {
+		t.Fatalf("Should be equal: %v, %v", err, ErrClientIDRequired)
+	}
+
+	_, err = c.Orders.PlaceOrderIdempotent(context.Background(), &models.OrderParams{
+		ClientID: PtrString("abc"),
+	})
+	if !errors.Is(err, ErrClientIDNotInt) {
+		t.Fatalf("Should be equal: %v, %v", err, ErrClientIDNotInt)
+	}
+}
+
+func TestOrders_CheckOrder(t *testing.T) {
+
+	d := func(s string) *decimal.Decimal { return PtrDecimal(decimal.RequireFromString(s)) }
+
+	checks := OrderChecks{
+		Positions: []*models.Position{
+			{Future: "BTC-PERP", NetSize: decimal.RequireFromString("0.5")},
+			{Future: "ETH-PERP", NetSize: decimal.RequireFromString("-2")},
+		},
+		Book: &models.OrderBook{
+			Bids: [][]decimal.Decimal{{decimal.NewFromInt(100), decimal.NewFromInt(1)}},
+			Asks: [][]decimal.Decimal{{decimal.NewFromInt(101), decimal.NewFromInt(1)}},
+		},
+	}
+
+	tests := []struct {
+		market   string
+		side     models.OrderSide
+		size     string
+		price    string
+		reduce   bool
+		post     bool
+		expected error
+	}{
+		{"BTC-PERP", models.Sell, "0.5", "", true, false, nil},
+		{"BTC-PERP", models.Sell, "0.6", "", true, false, ErrReduceOnlyExceedsPosition},
+		{"BTC-PERP", models.Buy, "0.1", "", true, false, ErrReduceOnlyExceedsPosition},
+		{"ETH-PERP", models.Buy, "2", "", true, false, nil},
+		{"SOL-PERP", models.Sell, "1", "", true, false, ErrReduceOnlyExceedsPosition},
+		{"BTC-PERP", models.Buy, "1", "100.5", false, true, nil},
+		{"BTC-PERP", models.Buy, "1", "101", false, true, ErrPostOnlyWouldCross},
+		{"BTC-PERP", models.Sell, "1", "100.5", false, true, nil},
+		{"BTC-PERP", models.Sell, "1", "99", false, true, ErrPostOnlyWouldCross},
+		{"BTC-PERP", models.Sell, "1", "99", false, false, nil},
+	}
+
+	for i, test := range tests {
+		params := &models.OrderParams{
+			Market:     PtrString(test.market),
+			Side:       PtrOrderSide(test.side),
+			Type:       PtrOrderType(models.LimitOrder),
+			Size:       d(test.size),
+			ReduceOnly: PtrBool(test.reduce),
+			PostOnly:   PtrBool(test.post),
+		}
+		if test.price != "" {
+			params.Price = d(test.price)
+		}
+		if err := CheckOrder(params, checks); !errors.Is(err, test.expected) {
+			t.Fatalf("Should be equal: %v, %v - test #%d", err, test.expected, i+1)
+		}
+	}
+
+	params := &models.OrderParams{
+		Market:     PtrString("BTC-PERP"),
+		Side:       PtrOrderSide(models.Buy),
+		Size:       d("1"),
+		ReduceOnly: PtrBool(true),
+	}
+	if err := CheckOrder(params, OrderChecks{}); err != nil {
+		t.Fatalf("Checks without snapshots should pass: %v", err)
+	}
+}
+
+func TestOrders_CancelAllOrdersWithResult(t *testing.T) {
+
+	var (
+		mu       sync.Mutex
+		requests []string
+	)
+
+	c := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		requests = append(requests, r.Method+" "+r.URL.Path)
+		mu.Unlock()
+		switch {
+		case r.Method == http.MethodDelete:
+			_, _ = w.Write([]byte(`{"success": true, "result": "Orders queued for cancelation"}`))
+		case strings.HasSuffix(r.URL.Path, "/conditional_orders"):
+			_, _ = w.Write([]byte(`{"success": true, "result": [{"id": 3, "market": "BTC-PERP"}]}`))
+		default:
+			_, _ = w.Write([]byte(`{"success": true, "result": [` +
+				`{"id": 1, "market": "BTC-PERP", "type": "limit"},` +
+				`{"id": 2, "market": "BTC-PERP", "type": "market"}]}`))
+		}
+	}, WithAuth("key", "secret"))
+
+	tests := []struct {
+		name     string
+		params   *models.CancelAllParams
+		snapshot bool
+		orders   []int64
+		triggers []int64
+		requests int
+	}{
+		{"no snapshot", nil, false, nil, nil, 1},
+		{"all", &models.CancelAllParams{Market: PtrString("BTC-PERP")}, true, []int64{1, 2}, []int64{3}, 3},
+		{"limit only", &models.CancelAllParams{LimitOrdersOnly: PtrBool(true)}, true, []int64{1}, nil, 2},
+		{"conditional only", &models.CancelAllParams{ConditionalOrdersOnly: PtrBool(true)}, true, nil, []int64{3}, 2},
+	}
+
+	for _, tt := range tests {
+
+		mu.Lock()
+		requests = nil
+		mu.Unlock()
+
+		result, err := c.CancelAllOrdersWithResult(tt.params, tt.snapshot)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if result.Message != "Orders queued for cancelation" {
+			t.Fatalf("%s: Unexpected message: %s", tt.name, result.Message)
+		}
+		if fmt.Sprint(result.OrderIDs) != fmt.Sprint(tt.orders) {
+			t.Fatalf("%s: Should be equal: %v, %v", tt.name, result.OrderIDs, tt.orders)
+		}
+		if fmt.Sprint(result.TriggerOrderIDs) != fmt.Sprint(tt.triggers) {
+			t.Fatalf("%s: Should be equal: %v, %v", tt.name, result.TriggerOrderIDs, tt.triggers)
+		}
+
+		mu.Lock()
+		n, last := len(requests), requests[len(requests)-1]
+		mu.Unlock()
+		if n != tt.requests || !strings.HasPrefix(last, http.MethodDelete) {
+			t.Fatalf("%s: Unexpected requests: %v", tt.name, requests)
+		}
+	}
+}
+
+func TestOrders_PlaceOrderRejected(t *testing.T) {
+
+	c := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		if strings.Contains(string(body), "ETH-PERP") {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			_, _ = w.Write([]byte(`{"success": false, "error": "Please retry request"}`))
+			return
+		}
+		w.WriteHeader(http.StatusBadRequest)
+		_, _ = w.Write([]byte(`{"success": false, "error": "Size too small"}`))
+	}, WithAuth("key", "secret"))
+
+	params := &models.OrderParams{
+		Market: PtrString("BTC-PERP"),
+		Side:   PtrOrderSide(models.Buy),
+		Type:   PtrOrderType(models.LimitOrder),
+		Price:  PtrDecimal(decimal.NewFromInt(30000)),
+		Size:   PtrDecimal(decimal.RequireFromString("0.00001")),
+	}
+
+	err := c.PlaceOrder(params, &models.Order{})
+
+	var rejected *models.OrderRejectedError
+	if !errors.As(err, &rejected) {
+		t.Fatalf("Should be an OrderRejectedError: %v", err)
+	}
+	if rejected.Message != "Size too small" {
+		t.Fatalf("Should be equal: %s, %s", rejected.Message, "Size too small")
+	}
+	if rejected.StatusCode != http.StatusBadRequest {
+		t.Fatalf("Should be equal: %d, %d", rejected.StatusCode, http.StatusBadRequest)
+	}
+	if rejected.Params != params {
+		t.Fatalf("Should be the order sent: %+v", rejected.Params)
+	}
+
+	var apiErr *APIError
+	if !errors.As(err, &apiErr) || apiErr.Message != "Size too small" {
+		t.Fatalf("Should wrap the API error: %v", err)
+	}
+
+	// The order may have been placed despite a server error
+	params.Market = PtrString("ETH-PERP")
+	err = c.PlaceOrder(params, &models.Order{})
+	if err == nil || errors.As(err, &rejected) {
+		t.Fatalf("Should not be an OrderRejectedError: %v", err)
+	}
+}
+
+func TestOrders_ReplaceOrders(t *testing.T) {
+
+	var inFlight, maxInFlight, placed int32
+	c := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodDelete {
+			_, _ = w.Write([]byte(`{"success": true, "result": "Order queued for cancellation"}`))
+			return
+		}
+		n := atomic.AddInt32(&inFlight, 1)
+		defer atomic.AddInt32(&inFlight, -1)
+		for {
+			max := atomic.LoadInt32(&maxInFlight)
+			if n <= max || atomic.CompareAndSwapInt32(&maxInFlight, max, n) {
+				break
+			}
+		}
+		time.Sleep(10 * time.Millisecond)
+		id := atomic.AddInt32(&placed, 1)
+		_, _ = fmt.Fprintf(w, `{"success": true, "result": {"id": %d, "market": "BTC-PERP"}}`, id)
+	}, WithAuth("key", "secret"))
+
+	newOrders := make([]*models.OrderParams, 3*batchConcurrency)
+	for i := range newOrders {
+		newOrders[i] = &models.OrderParams{
+			Market: PtrString("BTC-PERP"),
+			Side:   PtrOrderSide(models.Buy),
+			Type:   PtrOrderType(models.LimitOrder),
+			Price:  PtrDecimal(decimal.NewFromInt(30000)),
+			Size:   PtrDecimal(decimal.NewFromInt(1)),
+		}
+	}
+
+	orders, err := c.ReplaceOrders(context.Background(), []int64{1, 2}, newOrders)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, order := range orders {
+		if order == nil || order.ID == 0 {
+			t.Fatalf("Order %d not placed: %+v", i, order)
+		}
+	}
+	if n := atomic.LoadInt32(&maxInFlight); n > batchConcurrency {
+		t.Fatalf("Too many placements in flight: %d", n)
+	}
+
+	// Placements in flight or queued fail once ctx is done
+	arrived := make(chan struct{})
+	var once sync.Once
+	c = newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		// The disconnect of the client is only noticed once the body is read
+		_, _ = ioutil.ReadAll(r.Body)
+		once.Do(func() { close(arrived) })
+		<-r.Context().Done()
+	}, WithAuth("key", "secret"))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	go func() {
+		<-arrived
+		cancel()
+	}()
+
+	orders, err = c.ReplaceOrders(ctx, nil, newOrders)
+	if !errors.Is(err, context.Canceled) {
+		t.Fatalf("Should be equal: %v, %v", err, context.Canceled)
+	}
+	if len(orders) != len(newOrders) || orders[0] != nil {
+		t.Fatalf("Unexpected orders: %+v", orders)
+	}
+}
@@ -0,0 +1,221 @@
+package api
+
+import (
+	"context"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/shopspring/decimal"
+
+	"github.com/uscott/go-ftx/models"
+)
+
+const positionUpdatesBufferSize int = 64
+
+// PositionTracker keeps the net size, average entry price and realized PnL
+// of the positions in futures from the fills channel, starting from those
+// returned by FTX. Spot fills are ignored.
+//
+// The fills channel is subscribed to before the positions are fetched so
+// that no fill is missed. Fills executed before the positions were requested
+// are part of them and skipped. A fill executed while they were being fetched
+// may or may not be, so they are fetched again, after which it is skipped
+// like the earlier ones. Fill times are compared to the client clock
+// corrected by SetServerTimeDiff.
+type PositionTracker struct {
+	client     *Client
+	mu         sync.Mutex
+	positions  map[string]*trackedPosition
+	seededFrom time.Time
+	seededTo   time.Time
+	updatesC   chan models.TrackedPosition
+}
+
+type trackedPosition struct {
+	positionState
+	time time.Time
+}
+
+// NewPositionTracker subscribes to the fills channel of the client stream and
+// fetches the positions, keeping them up to date until ctx is done.
+func NewPositionTracker(ctx context.Context, client *Client) (*PositionTracker, error) {
+
+	fillsC, err := client.Stream.SubscribeToFills(ctx)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	t := newPositionTracker(client)
+	if err = t.seed(ctx); err != nil {
+		return nil, err
+	}
+
+	go t.run(ctx, fillsC)
+
+	return t, nil
+}
+
+func newPositionTracker(client *Client) *PositionTracker {
+	return &PositionTracker{
+		client:    client,
+		positions: make(map[string]*trackedPosition),
+		updatesC:  make(chan models.TrackedPosition, positionUpdatesBufferSize),
+	}
+}
+
+// Updates returns the channel on which positions are sent whenever a fill
+// changes them, and all of them after they are fetched again. Fills are not
+// consumed while it is full.
+func (t *PositionTracker) Updates() chan models.TrackedPosition {
+	return t.updatesC
+}
+
+// Position returns the position in the future, ok being false if there is
+// none.
+func (t *PositionTracker) Position(future string) (position models.TrackedPosition, ok bool) {
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	p := t.positions[future]
+	if p == nil {
+		return
+	}
+
+	return p.snapshot(future), true
+}
+
+// Positions returns every position sorted by future.
+func (t *PositionTracker) Positions() []models.TrackedPosition {
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	result := make([]models.TrackedPosition, 0, len(t.positions))
+	for future, p := range t.positions {
+		result = append(result, p.snapshot(future))
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i].Future < result[j].Future })
+
+	return result
+}
+
+func (t *PositionTracker) run(ctx context.Context, fillsC chan *models.FillResponse) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case f, ok := <-fillsC:
+			if !ok {
+				return
+			}
+			updates, err := t.add(ctx, &f.Fill)
+			if err != nil {
+				t.client.Stream.sendError(err)
+			}
+			for _, u := range updates {
+				select {
+				case t.updatesC <- u:
+				case <-ctx.Done():
+					return
+				}
+			}
+		}
+	}
+}
+
+// seed replaces the positions with those fetched from FTX, bypassing the
+// account cache, and records when they were requested.
+func (t *PositionTracker) seed(ctx context.Context) error {
+
+	from := t.client.now().Add(t.client.serverTimeDiff)
+
+	response, err := t.client.GetResponseWithContext(
+		ctx, nil, FormURL(apiGetPositions), http.MethodGet, true)
+	if err != nil {
+		return errors.Wrap(err, "Get positions")
+	}
+
+	var result []*models.Position
+	if err = t.client.unmarshal(response, &result); err != nil {
+		return errors.WithStack(err)
+	}
+
+	to := t.client.now().Add(t.client.serverTimeDiff)
+
+	positions := make(map[string]*trackedPosition, len(result))
+	for _, p := range result {
+		if p == nil {
+			continue
+		}
+		avgCost := p.EntryPrice
+		if p.NetSize.IsZero() {
+			avgCost = decimal.Zero
+		}
+		positions[p.Future] = &trackedPosition{
+			positionState: positionState{pnl: p.RealizedPnl, size: p.NetSize, avgCost: avgCost},
+			time:          to,
+		}
+	}
+
+	t.mu.Lock()
+	t.positions, t.seededFrom, t.seededTo = positions, from, to
+	t.mu.Unlock()
+
+	return nil
+}
+
+// add applies the fill to the position in its future and returns the
+// positions that changed. Fills the positions were fetched after are
+// skipped, and those executed while they were being fetched make them be
+// fetched again. If that fails the fill is applied.
+func (t *PositionTracker) add(ctx context.Context, fill *models.Fill) ([]models.TrackedPosition, error) {
+
+	if fill.Future == "" {
+		return nil, nil
+	}
+
+	t.mu.Lock()
+	from, to := t.seededFrom, t.seededTo
+	t.mu.Unlock()
+
+	var seedErr error
+
+	if !fill.Time.After(to) {
+		if fill.Time.Before(from) {
+			return nil, nil
+		}
+		if seedErr = t.seed(ctx); seedErr == nil {
+			return t.Positions(), nil
+		}
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	p := t.positions[fill.Future]
+	if p == nil {
+		p = &trackedPosition{}
+		t.positions[fill.Future] = p
+	}
+
+	if err := p.apply(fill); err != nil {
+		return nil, errors.WithStack(err)
+	}
+	p.time = fill.Time
+
+	return []models.TrackedPosition{p.snapshot(fill.Future)}, seedErr
+}
+
+func (p *trackedPosition) snapshot(future string) models.TrackedPosition {
+	return models.TrackedPosition{
+		Future:      future,
+		NetSize:     p.size,
+		EntryPrice:  p.avgCost,
+		RealizedPnl: p.pnl,
+		Time:        p.time,
+	}
+}
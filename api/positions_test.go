@@ -0,0 +1,95 @@
+package api
+
+import (
+	"context"
+	"net/http"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/shopspring/decimal"
+	"github.com/uscott/go-ftx/models"
+)
+
+func TestPositionTracker_add(t *testing.T) {
+
+	var seeds int64
+
+	// Every reading of the clock is a second later than the previous one
+	start := time.Date(2021, 6, 1, 12, 0, 0, 0, time.UTC)
+	var ticks int64
+	clock := func() time.Time {
+		return start.Add(time.Duration(atomic.AddInt64(&ticks, 1)-1) * time.Second)
+	}
+
+	// The second fetch includes the fill executed during the first one
+	c := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		size := "1"
+		if atomic.AddInt64(&seeds, 1) > 1 {
+			size = "3"
+		}
+		_, _ = w.Write([]byte(`{"success": true, "result": [{"future": "BTC-PERP", ` +
+			`"netSize": ` + size + `, "entryPrice": 100, "realizedPnl": 5}]}`))
+	}, WithAuth("key", "secret"), SetClock(clock))
+
+	ctx := context.Background()
+	tracker := newPositionTracker(c)
+
+	// Requested at 12:00:00, signed at 12:00:01 and received at 12:00:02
+	if err := tracker.seed(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	d := decimal.RequireFromString
+	fill := func(seconds int, side models.OrderSide, size, price string) *models.Fill {
+		return &models.Fill{
+			Future: "BTC-PERP", Market: "BTC-PERP", Side: string(side), Size: d(size), Price: d(price),
+			FeeCurrency: "USD", Time: start.Add(time.Duration(seconds) * time.Second),
+		}
+	}
+	expect := func(name, size, entry, pnl string) {
+		p, ok := tracker.Position("BTC-PERP")
+		if !ok || !p.NetSize.Equal(d(size)) || !p.EntryPrice.Equal(d(entry)) || !p.RealizedPnl.Equal(d(pnl)) {
+			t.Fatalf("%s: Unexpected position: %+v", name, p)
+		}
+	}
+
+	expect("seeded", "1", "100", "5")
+
+	// Executed before the positions were requested, so part of them
+	if updates, err := tracker.add(ctx, fill(-1, models.Buy, "1", "90")); err != nil || len(updates) != 0 {
+		t.Fatalf("Unexpected updates: %v, %v", updates, err)
+	}
+	expect("earlier fill", "1", "100", "5")
+
+	// Executed after they were received
+	if updates, err := tracker.add(ctx, fill(3, models.Buy, "1", "110")); err != nil || len(updates) != 1 {
+		t.Fatalf("Unexpected updates: %v, %v", updates, err)
+	}
+	expect("later fill", "2", "105", "5")
+
+	// Executed while they were being fetched: fetched again and skipped
+	if _, err := tracker.add(ctx, fill(1, models.Buy, "2", "100")); err != nil {
+		t.Fatal(err)
+	}
+	if n := atomic.LoadInt64(&seeds); n != 2 {
+		t.Fatalf("Should be equal: %d, %d", n, 2)
+	}
+	expect("fill during fetch", "3", "100", "5")
+
+	// Closing realizes the PnL
+	if _, err := tracker.add(ctx, fill(10, models.Sell, "3", "120")); err != nil {
+		t.Fatal(err)
+	}
+	expect("closed", "0", "0", "65")
+
+	// Spot fills are ignored
+	spot := fill(10, models.Buy, "1", "120")
+	spot.Future, spot.Market = "", "BTC/USD"
+	if updates, err := tracker.add(ctx, spot); err != nil || len(updates) != 0 {
+		t.Fatalf("Unexpected updates: %v, %v", updates, err)
+	}
+	if positions := tracker.Positions(); len(positions) != 1 {
+		t.Fatalf("Unexpected positions: %+v", positions)
+	}
+}
@@ -0,0 +1,94 @@
+package api
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/uscott/go-ftx/models"
+)
+
+type wsEvent struct {
+	ChannelType models.ChannelType
+	Market      string
+	Response    interface{}
+	Received    time.Time
+}
+
+// eventQueue is a FIFO handing events from the websocket read loop to the
+// delivery loop so that a slow consumer never blocks reads, and with them the
+// processing of pongs and other control frames. Rather than blocking, push
+// drops the oldest market data event once the queue is full.
+type eventQueue struct {
+	mu       sync.Mutex
+	events   []wsEvent
+	ready    chan struct{}
+	overflow bool
+}
+
+func newEventQueue() *eventQueue {
+	return &eventQueue{
+		events: make([]wsEvent, 0, 64),
+		ready:  make(chan struct{}, 1),
+	}
+}
+
+// push appends e. When limit is positive and as many events are queued, the
+// oldest market data event is dropped first, if any: dropped reports it and
+// first whether it is the first drop since the queue was last empty.
+func (q *eventQueue) push(e wsEvent, limit int) (dropped, first bool) {
+
+	q.mu.Lock()
+	if limit > 0 && len(q.events) >= limit {
+		for i := range q.events {
+			if !droppable(q.events[i].ChannelType) {
+				continue
+			}
+			copy(q.events[i:], q.events[i+1:])
+			q.events[len(q.events)-1] = wsEvent{}
+			q.events = q.events[:len(q.events)-1]
+			dropped, first = true, !q.overflow
+			q.overflow = true
+			break
+		}
+	}
+	q.events = append(q.events, e)
+	q.mu.Unlock()
+
+	select {
+	case q.ready <- struct{}{}:
+	default:
+	}
+
+	return dropped, first
+}
+
+func (q *eventQueue) pop(ctx context.Context) (e wsEvent, ok bool) {
+
+	for {
+		q.mu.Lock()
+		if len(q.events) > 0 {
+			e = q.events[0]
+			q.events[0] = wsEvent{}
+			q.events = q.events[1:]
+			if len(q.events) == 0 {
+				q.overflow = false
+			}
+			q.mu.Unlock()
+			return e, true
+		}
+		q.mu.Unlock()
+
+		select {
+		case <-q.ready:
+		case <-ctx.Done():
+			return e, false
+		}
+	}
+}
+
+// droppable reports whether events of the channel may be dropped to keep up
+// with a slow consumer. Fills and orders events never are.
+func droppable(ct models.ChannelType) bool {
+	return ct != models.FillsChannel && ct != models.OrdersChannel
+}
@@ -0,0 +1,147 @@
+package api
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"io"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/uscott/go-ftx/models"
+)
+
+// maxReplayLine is the longest frame Replay reads, order book snapshots
+// being the largest frames FTX sends.
+const maxReplayLine = 16 << 20
+
+// SetReplaySpeed sets how many times faster than recorded Replay sends the
+// events. Zero or less sends them without waiting. It is 1 by default.
+func (s *Stream) SetReplaySpeed(speed float64) {
+	s.mu.Lock()
+	s.replaySpeed = speed
+	s.mu.Unlock()
+}
+
+// Replay reads frames recorded from FTX, one WsResponse per line as passed to
+// the SetOnRawMessage function, and decodes them as the read loop does. The
+// events are sent on the returned channel, which is closed at the end of r or
+// once ctx is done. The time between frames is taken from the time field of
+// their data and scaled by the replay speed. Error frames and lines which
+// cannot be decoded are sent to Errors.
+func (s *Stream) Replay(ctx context.Context, r io.Reader) (<-chan interface{}, error) {
+
+	if r == nil {
+		return nil, errors.New("Nil reader")
+	}
+
+	s.mu.Lock()
+	speed := s.replaySpeed
+	s.mu.Unlock()
+
+	c := make(chan interface{})
+
+	go func() {
+
+		defer close(c)
+
+		ws := NewWsSub()
+		scanner := bufio.NewScanner(r)
+		scanner.Buffer(make([]byte, 0, 64*1024), maxReplayLine)
+
+		var last time.Time
+
+		for line := 1; scanner.Scan(); line++ {
+
+			if len(scanner.Bytes()) == 0 {
+				continue
+			}
+
+			msg := models.WsResponse{}
+			if err := json.Unmarshal(scanner.Bytes(), &msg); err != nil {
+				s.sendError(errors.Wrapf(err, "Replay line %d", line))
+				continue
+			}
+
+			if t, ok := frameTime(msg.Data); ok {
+				if !last.IsZero() && t.After(last) && speed > 0 {
+					if !sleep(ctx, time.Duration(float64(t.Sub(last))/speed)) {
+						return
+					}
+				}
+				if t.After(last) {
+					last = t
+				}
+			}
+
+			e, ok, err := s.decodeResponse(ws, &msg)
+			if err != nil {
+				s.sendError(errors.Wrapf(err, "Replay line %d", line))
+				continue
+			}
+			if !ok {
+				continue
+			}
+
+			for _, event := range splitEvent(e) {
+				select {
+				case c <- event:
+				case <-ctx.Done():
+					return
+				}
+			}
+		}
+
+		if err := scanner.Err(); err != nil {
+			s.sendError(errors.Wrap(err, "Replay"))
+		}
+	}()
+
+	return c, nil
+}
+
+// frameTime returns the time of a frame, which is either a number of seconds
+// or an RFC 3339 time in its data or, for trades, in its first item.
+func frameTime(data json.RawMessage) (time.Time, bool) {
+
+	var item struct {
+		Time json.RawMessage `json:"time"`
+	}
+
+	if err := json.Unmarshal(data, &item); err != nil {
+		var items []struct {
+			Time json.RawMessage `json:"time"`
+		}
+		if err = json.Unmarshal(data, &items); err != nil || len(items) == 0 {
+			return time.Time{}, false
+		}
+		item.Time = items[0].Time
+	}
+
+	var seconds float64
+	if err := json.Unmarshal(item.Time, &seconds); err == nil && seconds > 0 {
+		return time.Unix(0, int64(seconds*float64(time.Second))), true
+	}
+
+	var t time.Time
+	if err := json.Unmarshal(item.Time, &t); err == nil && !t.IsZero() {
+		return t, true
+	}
+
+	return time.Time{}, false
+}
+
+// sleep waits for d and reports whether ctx was still not done.
+func sleep(ctx context.Context, d time.Duration) bool {
+
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+
+	select {
+	case <-timer.C:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
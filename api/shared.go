@@ -0,0 +1,367 @@
+package api
+
+import (
+	"context"
+
+	"github.com/pkg/errors"
+
+	"github.com/uscott/go-ftx/models"
+)
+
+// A shared connection is served by a host WsSub which is never handed out.
+// Each SubscribeTo call gets a view, a WsSub without a connection of its own
+// whose requests are added to those of the host and whose EventC is fed the
+// events of its channels and markets by the delivery goroutine of the host.
+// Requests are counted so that FTX is only sent the first subscribe and the
+// last unsubscribe of a channel and market.
+
+// subscribeShared subscribes to the channel for the markets of opts on the
+// shared connection, opening it first if there is none.
+func (s *Stream) subscribeShared(
+	ctx context.Context, ct models.ChannelType, opts SubscribeOptions) (*WsSub, error) {
+
+	view := NewWsSub()
+	view.AppendRequests(ct, opts.Markets...)
+
+	ctx, cancel := s.baseContext(ctx)
+	view.mu.Lock()
+	view.cancel = cancel
+	view.mu.Unlock()
+	view.viewDone = ctx.Done()
+
+	if opts.WaitForAck {
+		view.acksC = make(chan models.WsResponse, len(view.Requests))
+	}
+
+	s.shareMu.Lock()
+
+	host := s.sharedHost()
+	if host == nil {
+		host = NewWsSub()
+		host.refs = make(map[models.WSRequest]int)
+		if err := s.ServeSub(context.Background(), host); err != nil {
+			s.shareMu.Unlock()
+			cancel()
+			return nil, errors.WithStack(err)
+		}
+	}
+
+	added, err := s.attach(host, view)
+	s.shareMu.Unlock()
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+
+	go func() {
+		select {
+		case <-ctx.Done():
+		case <-view.closed:
+		}
+		s.detach(view)
+	}()
+
+	if err = s.sendShared(host, view, added); err != nil {
+		cancel()
+		return nil, errors.WithStack(err)
+	}
+
+	if opts.WaitForAck {
+		s.mu.Lock()
+		timeout := s.wsAckTimeout
+		s.mu.Unlock()
+		if err = view.waitForAcks(ctx, len(added), timeout); err != nil {
+			cancel()
+			return nil, errors.WithStack(err)
+		}
+	}
+
+	return view, nil
+}
+
+// sharedHost returns a host which is still connected and taking new views.
+func (s *Stream) sharedHost() *WsSub {
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, ws := range s.Subs {
+		if !ws.isHost() {
+			continue
+		}
+		ws.mu.Lock()
+		retired := ws.retired
+		ws.mu.Unlock()
+		select {
+		case <-ws.readDone:
+		default:
+			if !retired {
+				return ws
+			}
+		}
+	}
+
+	return nil
+}
+
+// attach adds the requests of view to host and returns those FTX has to be
+// sent, failing if they would exceed the subscription limit.
+func (s *Stream) attach(host, view *WsSub) ([]models.WSRequest, error) {
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	host.mu.Lock()
+	defer host.mu.Unlock()
+
+	var added []models.WSRequest
+	for _, r := range view.Requests {
+		if host.refs[r] == 0 {
+			added = append(added, r)
+		}
+	}
+
+	if limit := s.maxSubscriptions; limit > 0 && s.subscriptions+len(added) > limit {
+		return nil, errors.Wrapf(ErrTooManySubscriptions,
+			"%d active, %d requested, limit %d", s.subscriptions, len(added), limit)
+	}
+
+	for _, r := range view.Requests {
+		host.refs[r]++
+	}
+	for _, r := range added {
+		if host.ChannelTypes[r.ChannelType] == nil {
+			host.ChannelTypes[r.ChannelType] = make(TrivialMap)
+		}
+		if r.Market != "" {
+			host.ChannelTypes[r.ChannelType][r.Market] = struct{}{}
+		}
+		host.Requests = append(host.Requests, r)
+	}
+	s.subscriptions += len(added)
+
+	view.host = host
+	host.views = append(host.views, view)
+
+	return added, nil
+}
+
+// sendShared logs the host in if view needs it and sends the new requests.
+func (s *Stream) sendShared(host, view *WsSub, added []models.WSRequest) error {
+
+	s.mu.Lock()
+	always := s.alwaysAuthenticate
+	s.mu.Unlock()
+
+	if (always || view.isPrivate()) && !host.IsLoggedIn() {
+		if err := s.authorize(host); err != nil {
+			return err
+		}
+	}
+
+	for _, r := range added {
+		if err := host.writeJSON(r); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// detach removes view from its host, unsubscribing from what no other view
+// needs, and closes its EventC. The host is closed along with its last view.
+func (s *Stream) detach(view *WsSub) {
+
+	s.shareMu.Lock()
+	defer s.shareMu.Unlock()
+
+	host := view.host
+
+	// Wait for a dispatch to view in progress to give up
+	host.dispatchMu.Lock()
+	s.mu.Lock()
+	host.mu.Lock()
+
+	found := false
+	for i, v := range host.views {
+		if v == view {
+			host.views = append(host.views[:i], host.views[i+1:]...)
+			found = true
+			break
+		}
+	}
+
+	var removed []models.WSRequest
+	if found {
+		for _, r := range view.Requests {
+			if host.refs[r]--; host.refs[r] > 0 {
+				continue
+			}
+			delete(host.refs, r)
+			removed = append(removed, r)
+		}
+		host.Requests = withoutRequests(host.Requests, removed)
+		for _, r := range removed {
+			delete(host.ChannelTypes[r.ChannelType], r.Market)
+		}
+		for ct := range host.ChannelTypes {
+			if !host.hasChannel(ct) {
+				delete(host.ChannelTypes, ct)
+			}
+		}
+		s.subscriptions -= len(removed)
+	}
+
+	last := len(host.views) == 0
+	if last {
+		host.retired = true
+	}
+
+	host.mu.Unlock()
+	s.mu.Unlock()
+	host.dispatchMu.Unlock()
+
+	view.cancelServe()
+	view.finish()
+
+	if !found {
+		return
+	}
+
+	if last {
+		host.cancelServe()
+		return
+	}
+
+	for _, r := range removed {
+		r.Op = models.UnSubscribe
+		if err := host.writeJSON(r); err != nil {
+			s.client.Logger.Debugf("unsubscribe: %v", err)
+			return
+		}
+	}
+}
+
+func withoutRequests(requests, removed []models.WSRequest) []models.WSRequest {
+
+	drop := make(map[models.WSRequest]struct{}, len(removed))
+	for _, r := range removed {
+		drop[r] = struct{}{}
+	}
+
+	result := requests[:0]
+	for _, r := range requests {
+		if _, ok := drop[r]; !ok {
+			result = append(result, r)
+		}
+	}
+
+	return result
+}
+
+// hasChannel reports whether a host still has a request on the channel.
+func (ws *WsSub) hasChannel(ct models.ChannelType) bool {
+	for r := range ws.refs {
+		if r.ChannelType == ct {
+			return true
+		}
+	}
+	return false
+}
+
+// isHost reports whether ws serves the views of a shared connection.
+func (ws *WsSub) isHost() bool {
+	return ws.refs != nil
+}
+
+// dispatch sends the events to the views subscribed to their channel and
+// market. Events no view wants are dropped.
+func (ws *WsSub) dispatch(ctx context.Context, e wsEvent, events []interface{}) {
+
+	ws.dispatchMu.Lock()
+	defer ws.dispatchMu.Unlock()
+
+	ws.mu.Lock()
+	views := append([]*WsSub(nil), ws.views...)
+	ws.mu.Unlock()
+
+	for _, event := range events {
+		market := eventMarket(e, event)
+		for _, view := range views {
+			if !view.wants(e.ChannelType, market) {
+				continue
+			}
+			select {
+			case view.EventC <- event:
+			case <-view.viewDone:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}
+}
+
+// wants reports whether a view is subscribed to the channel for the market.
+// Channels subscribed to without markets match every market.
+func (ws *WsSub) wants(ct models.ChannelType, market string) bool {
+	markets, ok := ws.ChannelTypes[ct]
+	if !ok {
+		return false
+	}
+	_, ok = markets[market]
+	return ok || len(markets) == 0
+}
+
+// eventMarket returns the market of an event, which fills and orders frames
+// only carry in their data.
+func eventMarket(e wsEvent, event interface{}) string {
+	switch v := event.(type) {
+	case *models.FillResponse:
+		return v.Market
+	case *models.OrdersResponse:
+		return v.Market
+	}
+	return e.Market
+}
+
+// ackViews passes a subscribed or error frame on to the views of a host
+// waiting for it. Error frames go to every waiting view.
+func (ws *WsSub) ackViews(msg *models.WsResponse) {
+
+	if !ws.isHost() {
+		return
+	}
+
+	ws.mu.Lock()
+	views := append([]*WsSub(nil), ws.views...)
+	ws.mu.Unlock()
+
+	for _, view := range views {
+		if view.acksC == nil {
+			continue
+		}
+		if msg.ResponseType == models.Error || view.wants(msg.ChannelType, msg.Market) {
+			select {
+			case view.acksC <- *msg:
+			default:
+			}
+		}
+	}
+}
+
+// finishViews closes the views of a host which has stopped.
+func (ws *WsSub) finishViews() {
+
+	if !ws.isHost() {
+		return
+	}
+
+	ws.mu.Lock()
+	views := ws.views
+	ws.views, ws.retired = nil, true
+	ws.mu.Unlock()
+
+	for _, view := range views {
+		view.cancelServe()
+		view.finish()
+	}
+}
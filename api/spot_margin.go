@@ -1,8 +1,12 @@
 package api
 
 import (
-	"encoding/json"
+	"context"
 	"fmt"
+	"math"
+	"net/http"
+	"sort"
+	"time"
 
 	"github.com/pkg/errors"
 	"github.com/shopspring/decimal"
@@ -15,16 +19,27 @@ const (
 	apiGetBorrowSummary   = "/spot_margin/borrow_summary"
 	apiGetMarketInfo      = "/spot_margin/market_info"
 	apiGetBorrowHistory   = "/spot_margin/borrow_history"
+	apiGetMarginHistory   = "/spot_margin/history"
 	apiGetLendingHistory  = "/spot_margin/lending_history"
 	apiGetLendingOffers   = "/spot_margin/offers"
 	apiGetLendingInfo     = "/spot_margin/lending_info"
 	apiSubmitLendingOffer = "/spot_margin/offers"
 )
 
+// hoursPerYear annualizes the hourly lending rates.
+const hoursPerYear = 365 * 24
+
 type SpotMargin struct {
 	client *Client
 }
 
+// historyKey identifies an hourly entry of the borrow or lending history,
+// which has one per coin.
+type historyKey struct {
+	coin string
+	time int64
+}
+
 func (s *SpotMargin) GetBorrowRates() ([]*models.BorrowRate, error) {
 
 	url := fmt.Sprintf("%s%s", apiUrl, apiGetBorrowRates)
@@ -36,7 +51,7 @@ func (s *SpotMargin) GetBorrowRates() ([]*models.BorrowRate, error) {
 
 	var result []*models.BorrowRate
 
-	if err = json.Unmarshal(response, &result); err != nil {
+	if err = s.client.unmarshal(response, &result); err != nil {
 		return nil, errors.WithStack(err)
 	}
 	return result, nil
@@ -52,7 +67,7 @@ func (s *SpotMargin) GetLendingRates() ([]*models.LendingRate, error) {
 	}
 	var result []*models.LendingRate
 
-	if err = json.Unmarshal(response, &result); err != nil {
+	if err = s.client.unmarshal(response, &result); err != nil {
 		return nil, errors.WithStack(err)
 	}
 
@@ -70,7 +85,7 @@ func (s *SpotMargin) GetBorrowSummary() ([]*models.BorrowedAmount, error) {
 
 	var result []*models.BorrowedAmount
 
-	if err = json.Unmarshal(response, &result); err != nil {
+	if err = s.client.unmarshal(response, &result); err != nil {
 		return nil, errors.WithStack(err)
 	}
 
@@ -92,7 +107,7 @@ func (s *SpotMargin) GetMarketInfo(market string) (*models.SpotMarginMarketInfo,
 
 	result := models.SpotMarginMarketInfo{}
 
-	if err = json.Unmarshal(response, &result); err != nil {
+	if err = s.client.unmarshal(response, &result); err != nil {
 		return nil, errors.WithStack(err)
 	}
 
@@ -111,7 +126,80 @@ func (s *SpotMargin) GetBorrowHistory() ([]*models.BorrowHistory, error) {
 
 	var result []*models.BorrowHistory
 
-	if err = json.Unmarshal(response, &result); err != nil {
+	if err = s.client.unmarshal(response, &result); err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	return result, nil
+}
+
+// GetMyBorrowHistory returns the hourly borrow costs of the account between
+// start and end, oldest first, fetching as many pages as the window needs.
+func (s *SpotMargin) GetMyBorrowHistory(start, end time.Time) ([]*models.BorrowHistory, error) {
+
+	url := FormURL(apiGetBorrowHistory)
+
+	var result []*models.BorrowHistory
+	seen := make(map[historyKey]struct{})
+
+	err := pageBackwards(start.Unix(), end.Unix(), func(params *models.NumberTimeLimit) (int64, int, int, error) {
+		response, err := s.client.Get(params, url, true)
+		if err != nil {
+			return 0, 0, 0, err
+		}
+		var page []*models.BorrowHistory
+		if err = s.client.unmarshal(response, &page); err != nil {
+			return 0, 0, 0, err
+		}
+		earliest, added := *params.EndTime, 0
+		for _, v := range page {
+			t := v.Time.Unix()
+			if t < earliest {
+				earliest = t
+			}
+			key := historyKey{coin: v.Coin, time: t}
+			if _, ok := seen[key]; ok {
+				continue
+			}
+			seen[key] = struct{}{}
+			result = append(result, v)
+			added++
+		}
+		return earliest, len(page), added, nil
+	})
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].Time.Before(result[j].Time)
+	})
+
+	return result, nil
+}
+
+// GetSpotMarginHistory returns the hourly borrow rates and sizes across all
+// users between start and end, optionally for a single coin.
+func (s *SpotMargin) GetSpotMarginHistory(
+	coin *string, start, end *int64,
+) ([]*models.SpotMarginHistory, error) {
+
+	url := FormURL(apiGetMarginHistory)
+
+	params := &models.SpotMarginHistoryParams{
+		Coin:      coin,
+		StartTime: start,
+		EndTime:   end,
+	}
+
+	response, err := s.client.Get(params, url, false)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	var result []*models.SpotMarginHistory
+
+	if err = s.client.unmarshal(response, &result); err != nil {
 		return nil, errors.WithStack(err)
 	}
 
@@ -130,13 +218,102 @@ func (s *SpotMargin) GetLendingHistory() ([]*models.LendingHistory, error) {
 
 	var result []*models.LendingHistory
 
-	if err = json.Unmarshal(response, &result); err != nil {
+	if err = s.client.unmarshal(response, &result); err != nil {
 		return nil, errors.WithStack(err)
 	}
 
 	return result, nil
 }
 
+// GetMyLendingHistory returns the hourly lending proceeds of the account
+// between start and end, oldest first, fetching as many pages as the window
+// needs.
+func (s *SpotMargin) GetMyLendingHistory(start, end time.Time) ([]*models.LendingHistory, error) {
+	result, err := s.myLendingHistory(context.Background(), start, end)
+	return result, errors.WithStack(err)
+}
+
+// LendingAPY returns the yield realized lending the coin over the last days,
+// as an annual rate: the hourly rates paid are compounded over the hours the
+// coin was lent and the mean hourly growth is compounded over a year. It is
+// zero if the coin was not lent.
+func (s *SpotMargin) LendingAPY(ctx context.Context, coin string, days int) (float64, error) {
+
+	if coin == "" {
+		return 0, errors.New("Coin missing")
+	}
+	if days <= 0 {
+		return 0, errors.Errorf("Invalid number of days: %d", days)
+	}
+
+	end := s.client.now()
+	history, err := s.myLendingHistory(ctx, end.AddDate(0, 0, -days), end)
+	if err != nil {
+		return 0, errors.WithStack(err)
+	}
+
+	var growth float64
+	var hours int
+	for _, h := range history {
+		if h.Coin != coin {
+			continue
+		}
+		rate, _ := h.Rate.Float64()
+		growth += math.Log1p(rate)
+		hours++
+	}
+	if hours == 0 {
+		return 0, nil
+	}
+
+	return math.Expm1(growth / float64(hours) * hoursPerYear), nil
+}
+
+func (s *SpotMargin) myLendingHistory(
+	ctx context.Context, start, end time.Time,
+) ([]*models.LendingHistory, error) {
+
+	url := FormURL(apiGetLendingHistory)
+
+	var result []*models.LendingHistory
+	seen := make(map[historyKey]struct{})
+
+	err := pageBackwards(start.Unix(), end.Unix(), func(params *models.NumberTimeLimit) (int64, int, int, error) {
+		response, err := s.client.GetResponseWithContext(ctx, params, url, http.MethodGet, true)
+		if err != nil {
+			return 0, 0, 0, err
+		}
+		var page []*models.LendingHistory
+		if err = s.client.unmarshal(response, &page); err != nil {
+			return 0, 0, 0, err
+		}
+		earliest, added := *params.EndTime, 0
+		for _, v := range page {
+			t := v.Time.Unix()
+			if t < earliest {
+				earliest = t
+			}
+			key := historyKey{coin: v.Coin, time: t}
+			if _, ok := seen[key]; ok {
+				continue
+			}
+			seen[key] = struct{}{}
+			result = append(result, v)
+			added++
+		}
+		return earliest, len(page), added, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].Time.Before(result[j].Time)
+	})
+
+	return result, nil
+}
+
 func (s *SpotMargin) GetLendingOffers() ([]*models.LendingOffer, error) {
 
 	url := FormURL(apiGetLendingOffers)
@@ -149,7 +326,7 @@ func (s *SpotMargin) GetLendingOffers() ([]*models.LendingOffer, error) {
 
 	var result []*models.LendingOffer
 
-	if err = json.Unmarshal(response, &result); err != nil {
+	if err = s.client.unmarshal(response, &result); err != nil {
 		return nil, errors.WithStack(err)
 	}
 
@@ -168,7 +345,7 @@ func (s *SpotMargin) GetLendingInfo() ([]*models.LendingInfo, error) {
 
 	var result []*models.LendingInfo
 
-	if err = json.Unmarshal(response, &result); err != nil {
+	if err = s.client.unmarshal(response, &result); err != nil {
 		return nil, errors.WithStack(err)
 	}
 
@@ -190,7 +367,7 @@ func (s *SpotMargin) SubmitLendingOffer(
 		return result, errors.WithStack(err)
 	}
 
-	if err = json.Unmarshal(response, &result); err != nil {
+	if err = s.client.unmarshal(response, &result); err != nil {
 		return result, errors.WithStack(err)
 	}
 
@@ -0,0 +1,74 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"math"
+	"net/http"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/shopspring/decimal"
+	"github.com/uscott/go-ftx/models"
+)
+
+func TestSpotMargin_LendingAPY(t *testing.T) {
+
+	const pageSize = 100
+
+	now := time.Date(2021, 6, 4, 0, 0, 0, 0, time.UTC)
+
+	// Four days of USD lent at alternating hourly rates, the day before the
+	// window at a much higher one, and BTC lent alongside
+	var history []*models.LendingHistory
+	for i := 0; i < 4*24; i++ {
+		rate := "0.00001"
+		if i >= 24 && i%2 == 1 {
+			rate = "0.00003"
+		} else if i < 24 {
+			rate = "0.01"
+		}
+		ts := now.Add(time.Duration(i-4*24)*time.Hour + 30*time.Minute)
+		history = append(history,
+			&models.LendingHistory{Coin: "USD", Rate: decimal.RequireFromString(rate), Size: decimal.NewFromInt(1000), Time: ts},
+			&models.LendingHistory{Coin: "BTC", Rate: decimal.RequireFromString("0.001"), Size: decimal.NewFromInt(1), Time: ts},
+		)
+	}
+
+	var pages int
+	c := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		pages++
+		query := r.URL.Query()
+		start, _ := strconv.ParseInt(query.Get("start_time"), 10, 64)
+		end, _ := strconv.ParseInt(query.Get("end_time"), 10, 64)
+		// Newest first as FTX does
+		var page []*models.LendingHistory
+		for i := len(history) - 1; i >= 0 && len(page) < pageSize; i-- {
+			if ts := history[i].Time.Unix(); ts >= start && ts <= end {
+				page = append(page, history[i])
+			}
+		}
+		b, _ := json.Marshal(page)
+		_, _ = w.Write([]byte(`{"success": true, "result": ` + string(b) + `}`))
+	}, WithAuth("key", "secret"), SetClock(func() time.Time { return now }))
+
+	apy, err := c.LendingAPY(context.Background(), "USD", 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if pages < 2 {
+		t.Fatalf("Should have paged: %d", pages)
+	}
+	// Half the hours at 0.001% and half at 0.003%, compounded hourly
+	if expected := 0.1914818803827249; math.Abs(apy-expected) > 1e-9 {
+		t.Fatalf("Should be equal: %v, %v", apy, expected)
+	}
+
+	if apy, err = c.LendingAPY(context.Background(), "ETH", 3); err != nil || apy != 0 {
+		t.Fatalf("Should be zero: %v, %v", apy, err)
+	}
+	if _, err = c.LendingAPY(context.Background(), "USD", 0); err == nil {
+		t.Fatal("Should fail")
+	}
+}
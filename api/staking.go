@@ -1,7 +1,6 @@
 package api
 
 import (
-	"encoding/json"
 	"fmt"
 
 	"github.com/pkg/errors"
@@ -33,7 +32,7 @@ func (s *Staking) GetStakes() ([]*models.Stake, error) {
 	}
 
 	var result []*models.Stake
-	if err = json.Unmarshal(response, &result); err != nil {
+	if err = s.client.unmarshal(response, &result); err != nil {
 		return nil, errors.WithStack(err)
 	}
 
@@ -50,7 +49,7 @@ func (s *Staking) GetUnstakeRequests() ([]*models.UnstakeRequest, error) {
 	}
 
 	var result []*models.UnstakeRequest
-	if err = json.Unmarshal(response, &result); err != nil {
+	if err = s.client.unmarshal(response, &result); err != nil {
 		return nil, errors.WithStack(err)
 	}
 
@@ -67,7 +66,7 @@ func (s *Staking) GetStakeBalances() ([]*models.StakeBalance, error) {
 	}
 
 	var result []*models.StakeBalance
-	if err = json.Unmarshal(response, &result); err != nil {
+	if err = s.client.unmarshal(response, &result); err != nil {
 		return nil, errors.WithStack(err)
 	}
 
@@ -88,7 +87,7 @@ func (s *Staking) RequestUnstake(
 	}
 
 	result := models.UnstakeRequest{}
-	if err = json.Unmarshal(response, &result); err != nil {
+	if err = s.client.unmarshal(response, &result); err != nil {
 		return nil, errors.WithStack(err)
 	}
 
@@ -104,7 +103,7 @@ func (s *Staking) CancelUnstakeRequest(id int64) (result string, err error) {
 		return result, errors.WithStack(err)
 	}
 
-	if err = json.Unmarshal(response, &result); err != nil {
+	if err = s.client.unmarshal(response, &result); err != nil {
 		return result, errors.WithStack(err)
 	}
 	return
@@ -120,13 +119,16 @@ func (s *Staking) GetStakingRewards() ([]*models.StakingReward, error) {
 	}
 
 	var result []*models.StakingReward
-	if err = json.Unmarshal(response, &result); err != nil {
+	if err = s.client.unmarshal(response, &result); err != nil {
 		return nil, errors.WithStack(err)
 	}
 
 	return result, nil
 }
 
+// RequestStake stakes size of coin. Locked SRM, with its fixed lock periods
+// and unlock schedule, is not offered by the FTX REST API so there is no
+// counterpart for it here.
 func (s *Staking) RequestStake(coin string, size decimal.Decimal) (*models.Stake, error) {
 
 	url := FormURL(apiRequestStake)
@@ -138,7 +140,7 @@ func (s *Staking) RequestStake(coin string, size decimal.Decimal) (*models.Stake
 	}
 
 	result := models.Stake{}
-	if err = json.Unmarshal(response, &result); err != nil {
+	if err = s.client.unmarshal(response, &result); err != nil {
 		return nil, errors.WithStack(err)
 	}
 
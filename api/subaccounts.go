@@ -1,7 +1,6 @@
 package api
 
 import (
-	"encoding/json"
 	"fmt"
 
 	"github.com/pkg/errors"
@@ -30,7 +29,7 @@ func (s *SubAccounts) GetSubaccounts() ([]*models.SubAccount, error) {
 
 	var result []*models.SubAccount
 
-	if err = json.Unmarshal(response, &result); err != nil {
+	if err = s.client.unmarshal(response, &result); err != nil {
 		return nil, errors.WithStack(err)
 	}
 
@@ -53,7 +52,7 @@ func (s *SubAccounts) CreateSubaccount(nickname string) (*models.SubAccount, err
 
 	var result models.SubAccount
 
-	if err = json.Unmarshal(response, &result); err != nil {
+	if err = s.client.unmarshal(response, &result); err != nil {
 		return nil, errors.WithStack(err)
 	}
 
@@ -74,7 +73,7 @@ func (s *SubAccounts) ChangeSubaccount(nickname, newNickname string) (result str
 		return result, errors.WithStack(err)
 	}
 
-	if err = json.Unmarshal(response, &result); err != nil {
+	if err = s.client.unmarshal(response, &result); err != nil {
 		return result, errors.WithStack(err)
 	}
 	return
@@ -94,7 +93,7 @@ func (s *SubAccounts) DeleteSubaccount(nickname string) (result string, err erro
 		return result, errors.WithStack(err)
 	}
 
-	if err = json.Unmarshal(response, &result); err != nil {
+	if err = s.client.unmarshal(response, &result); err != nil {
 		return result, errors.WithStack(err)
 	}
 
@@ -112,7 +111,7 @@ func (s *SubAccounts) GetSubaccountBalances(nickname string) ([]*models.Balance,
 
 	var result []*models.Balance
 
-	if err = json.Unmarshal(response, &result); err != nil {
+	if err = s.client.unmarshal(response, &result); err != nil {
 		return nil, errors.WithStack(err)
 	}
 
@@ -130,7 +129,7 @@ func (s *SubAccounts) Transfer(payload *models.TransferPayload) (*models.Transfe
 	}
 
 	var result models.TransferResponse
-	err = json.Unmarshal(response, &result)
+	err = s.client.unmarshal(response, &result)
 	if err != nil {
 		return nil, errors.WithStack(err)
 	}
@@ -0,0 +1,19 @@
+package api
+
+import (
+	"context"
+)
+
+type traceIDKey struct{}
+
+// WithTraceID returns a copy of ctx carrying id, which requests made with the
+// context are logged with and their errors mention.
+func WithTraceID(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, traceIDKey{}, id)
+}
+
+// TraceID returns the trace id of ctx set with WithTraceID, if any.
+func TraceID(ctx context.Context) string {
+	id, _ := ctx.Value(traceIDKey{}).(string)
+	return id
+}
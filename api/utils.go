@@ -8,6 +8,7 @@ import (
 
 	"github.com/pkg/errors"
 	"github.com/shopspring/decimal"
+	"github.com/uscott/go-ftx/models"
 )
 
 const (
@@ -89,3 +90,15 @@ func PtrBool(b bool) *bool {
 func PtrDuration(d time.Duration) *time.Duration {
 	return &d
 }
+
+func PtrOrderSide(s models.OrderSide) *models.OrderSide {
+	return &s
+}
+
+func PtrOrderType(t models.OrderType) *models.OrderType {
+	return &t
+}
+
+func PtrTriggerOrderType(t models.TriggerOrderType) *models.TriggerOrderType {
+	return &t
+}
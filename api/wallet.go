@@ -1,10 +1,15 @@
 package api
 
 import (
-	"encoding/json"
+	"context"
 	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
 
 	"github.com/pkg/errors"
+	"github.com/shopspring/decimal"
 	"github.com/uscott/go-ftx/models"
 	"github.com/uscott/go-tools/errs"
 )
@@ -17,16 +22,54 @@ const (
 	apiGetDepositHistory    = "/wallet/deposits"
 	apiGetWithdrawalHistory = "/wallet/withdrawals"
 	apiRequestWithdrawal    = apiGetWithdrawalHistory
+	apiGetWithdrawalFee     = "/wallet/withdrawal_fee"
 	apiGetAirdrops          = "/wallet/airdrops"
 	apiGetSavedAddresses    = "/wallet/saved_addresses"
 	apiCreateSavedAddresses = apiGetSavedAddresses
 	apiDeleteSavedAddresses = apiGetSavedAddresses
+	apiHistoricalBalances   = "/historical_balances/requests"
+	apiHistoricalBalance    = "/historical_balances/requests/%d"
 )
 
+const (
+	mainAccount   = "main"
+	coinsCacheTTL = time.Hour
+)
+
+var ErrInvalidDepositMethod = errors.New("Invalid deposit method")
+
 type Wallet struct {
 	client *Client
 }
 
+// coinsCache holds the deposit methods of each coin last fetched with
+// GetCoins, which GetDepositAddress checks the method against.
+type coinsCache struct {
+	mu        sync.Mutex
+	methods   map[string][]models.DepositMethod
+	fetchedAt time.Time
+}
+
+func (c *coinsCache) get(coin string) (methods []models.DepositMethod, known, ok bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.methods == nil || time.Since(c.fetchedAt) >= coinsCacheTTL {
+		return nil, false, false
+	}
+	methods, known = c.methods[coin]
+	return methods, known, true
+}
+
+func (c *coinsCache) set(coins []*models.Coin) {
+	methods := make(map[string][]models.DepositMethod, len(coins))
+	for _, coin := range coins {
+		methods[coin.ID] = append([]models.DepositMethod(nil), coin.Methods...)
+	}
+	c.mu.Lock()
+	c.methods, c.fetchedAt = methods, time.Now()
+	c.mu.Unlock()
+}
+
 func (w *Wallet) GetCoins() ([]*models.Coin, error) {
 
 	url := FormURL(apiGetCoins)
@@ -37,10 +80,12 @@ func (w *Wallet) GetCoins() ([]*models.Coin, error) {
 	}
 
 	var result []*models.Coin
-	if err = json.Unmarshal(response, &result); err != nil {
+	if err = w.client.unmarshal(response, &result); err != nil {
 		return nil, errors.WithStack(err)
 	}
 
+	w.client.coins.set(result)
+
 	return result, nil
 }
 
@@ -54,7 +99,7 @@ func (w *Wallet) GetBalances() ([]*models.Balance, error) {
 	}
 
 	var result []*models.Balance
-	if err = json.Unmarshal(response, &result); err != nil {
+	if err = w.client.unmarshal(response, &result); err != nil {
 		return nil, errors.WithStack(err)
 	}
 
@@ -71,17 +116,26 @@ func (w *Wallet) GetBalancesAllAccts() (map[string][]*models.Balance, error) {
 	}
 
 	var result map[string][]*models.Balance
-	if err = json.Unmarshal(response, &result); err != nil {
+	if err = w.client.unmarshal(response, &result); err != nil {
 		return nil, errors.WithStack(err)
 	}
 
 	return result, nil
 }
 
+// GetDepositAddress returns the address, and the tag if the coin needs one,
+// to deposit the coin to with the method. The method is checked against
+// those of the coin returned by GetCoins, which are fetched at most once an
+// hour, and must be given for coins which can be deposited on several
+// networks since sending on the wrong one loses the funds.
 func (w *Wallet) GetDepositAddress(
 	coin string, method *models.DepositMethod,
 ) (address, tag string, err error) {
 
+	if err = w.checkDepositMethod(coin, method); err != nil {
+		return address, tag, errors.WithStack(err)
+	}
+
 	url := FormURL(fmt.Sprintf(apiGetDepositAddress, coin))
 
 	params := &struct {
@@ -94,7 +148,7 @@ func (w *Wallet) GetDepositAddress(
 	}
 
 	result := models.DepositAddress{}
-	if err = json.Unmarshal(response, &result); err != nil {
+	if err = w.client.unmarshal(response, &result); err != nil {
 		return address, tag, errors.WithStack(err)
 	}
 
@@ -103,6 +157,51 @@ func (w *Wallet) GetDepositAddress(
 	return
 }
 
+// checkDepositMethod returns ErrInvalidDepositMethod, listing the methods of
+// the coin, if the method is not one of them or is missing while the coin has
+// several. Coins GetCoins does not return are left for FTX to reject.
+func (w *Wallet) checkDepositMethod(coin string, method *models.DepositMethod) error {
+
+	methods, known, ok := w.client.coins.get(coin)
+	if !ok {
+		if _, err := w.GetCoins(); err != nil {
+			return err
+		}
+		methods, known, _ = w.client.coins.get(coin)
+	}
+	if !known {
+		return nil
+	}
+
+	if method == nil || *method == "" {
+		if len(methods) > 1 {
+			return errors.Wrapf(ErrInvalidDepositMethod,
+				"%s: method required, one of %s", coin, joinMethods(methods))
+		}
+		return nil
+	}
+
+	if len(methods) == 0 {
+		return nil
+	}
+	for _, m := range methods {
+		if m == *method {
+			return nil
+		}
+	}
+
+	return errors.Wrapf(ErrInvalidDepositMethod,
+		"%s: %s, one of %s", coin, *method, joinMethods(methods))
+}
+
+func joinMethods(methods []models.DepositMethod) string {
+	names := make([]string, len(methods))
+	for i, m := range methods {
+		names[i] = string(m)
+	}
+	return strings.Join(names, ", ")
+}
+
 func (w *Wallet) GetDepositHistory(pars *models.DepositHistoryParams) ([]*models.Deposit, error) {
 
 	url := FormURL(apiGetDepositHistory)
@@ -113,7 +212,7 @@ func (w *Wallet) GetDepositHistory(pars *models.DepositHistoryParams) ([]*models
 	}
 
 	var result []*models.Deposit
-	if err = json.Unmarshal(response, &result); err != nil {
+	if err = w.client.unmarshal(response, &result); err != nil {
 		return nil, errors.WithStack(err)
 	}
 
@@ -132,7 +231,7 @@ func (w *Wallet) GetWithdrawalHistory(
 	}
 
 	var result []*models.Withdrawal
-	if err = json.Unmarshal(response, &result); err != nil {
+	if err = w.client.unmarshal(response, &result); err != nil {
 		return nil, errors.WithStack(err)
 	}
 
@@ -155,13 +254,45 @@ func (w *Wallet) RequestWithdrawal(
 		return errors.WithStack(err)
 	}
 
-	if err = json.Unmarshal(response, withdrawal); err != nil {
+	if err = w.client.unmarshal(response, withdrawal); err != nil {
 		return errors.WithStack(err)
 	}
 
 	return
 }
 
+// GetWithdrawalFee returns the fee FTX would charge for the withdrawal,
+// which is checked for a coin, a positive size and an address first.
+func (w *Wallet) GetWithdrawalFee(
+	ctx context.Context, params *models.WithdrawalFeeParams,
+) (*models.WithdrawalFee, error) {
+
+	switch {
+	case params == nil:
+		return nil, errs.NilPtrArg
+	case params.Coin == nil || *params.Coin == "":
+		return nil, errors.New("Coin missing")
+	case params.Size == nil || params.Size.Sign() <= 0:
+		return nil, errors.New("Size must be positive")
+	case params.Address == nil || *params.Address == "":
+		return nil, errors.New("Address missing")
+	}
+
+	url := FormURL(apiGetWithdrawalFee)
+
+	response, err := w.client.GetResponseWithContext(ctx, params, url, http.MethodGet, true)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	result := &models.WithdrawalFee{}
+	if err = w.client.unmarshal(response, result); err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	return result, nil
+}
+
 func (w *Wallet) GetAirdrops(params *models.AirDropParams) ([]*models.AirDrop, error) {
 
 	url := FormURL(apiGetAirdrops)
@@ -172,7 +303,7 @@ func (w *Wallet) GetAirdrops(params *models.AirDropParams) ([]*models.AirDrop, e
 	}
 
 	var result []*models.AirDrop
-	if err = json.Unmarshal(response, &result); err != nil {
+	if err = w.client.unmarshal(response, &result); err != nil {
 		return nil, errors.WithStack(err)
 	}
 
@@ -192,7 +323,7 @@ func (w *Wallet) GetSavedAddresses(coin *string) ([]*models.SavedAddress, error)
 	}
 
 	var result []*models.SavedAddress
-	if err = json.Unmarshal(response, &result); err != nil {
+	if err = w.client.unmarshal(response, &result); err != nil {
 		return nil, errors.WithStack(err)
 	}
 
@@ -211,7 +342,7 @@ func (w *Wallet) CreateSavedAddresses(
 	}
 
 	var result []*models.SavedAddress
-	if err = json.Unmarshal(response, &result); err != nil {
+	if err = w.client.unmarshal(response, &result); err != nil {
 		return nil, errors.WithStack(err)
 	}
 
@@ -231,9 +362,159 @@ func (w *Wallet) DeleteSavedAddress(address int64) (result string, err error) {
 		return result, errors.WithStack(err)
 	}
 
-	if err = json.Unmarshal(response, &result); err != nil {
+	if err = w.client.unmarshal(response, &result); err != nil {
 		return result, errors.WithStack(err)
 	}
 
 	return
 }
+
+// RequestHistoricalBalances asks FTX to compute a snapshot of the accounts'
+// balances and positions at end. The snapshot is computed asynchronously and
+// retrieved with GetHistoricalBalances using the returned id.
+func (w *Wallet) RequestHistoricalBalances(accounts []string, end time.Time) (int64, error) {
+
+	url := FormURL(apiHistoricalBalances)
+
+	response, err := w.client.Post(&models.HistoricalBalancesParams{
+		Accounts: accounts,
+		EndTime:  end.Unix(),
+	}, url)
+	if err != nil {
+		return 0, errors.WithStack(err)
+	}
+
+	var id int64
+	if err = w.client.unmarshal(response, &id); err != nil {
+		return 0, errors.WithStack(err)
+	}
+
+	return id, nil
+}
+
+func (w *Wallet) GetHistoricalBalances(id int64) (*models.HistoricalBalances, error) {
+
+	url := FormURL(fmt.Sprintf(apiHistoricalBalance, id))
+
+	response, err := w.client.Get(nil, url, true)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	result := models.HistoricalBalances{}
+	if err = w.client.unmarshal(response, &result); err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	return &result, nil
+}
+
+// GetBalanceHistory returns daily snapshots of the balances of the client's
+// account, the subaccount if one is set, from start to end inclusive. Each
+// snapshot is requested from FTX and polled until complete, batchConcurrency
+// days at a time. The first failure stops the others and is returned.
+func (w *Wallet) GetBalanceHistory(
+	ctx context.Context, start, end time.Time) ([]*models.BalanceSnapshot, error) {
+
+	accounts := []string{mainAccount}
+	if w.client.SubAccount != nil {
+		accounts = []string{*w.client.SubAccount}
+	}
+
+	var days []time.Time
+	for t := start; !t.After(end); t = t.Add(24 * time.Hour) {
+		days = append(days, t)
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	var (
+		mu     sync.Mutex
+		wg     sync.WaitGroup
+		sem    = make(chan struct{}, batchConcurrency)
+		result = make([]*models.BalanceSnapshot, len(days))
+		failed error
+	)
+
+	for i, t := range days {
+		wg.Add(1)
+		go func(i int, t time.Time) {
+			defer wg.Done()
+
+			var err error
+			select {
+			case sem <- struct{}{}:
+				result[i], err = w.balanceSnapshot(ctx, accounts, t)
+				<-sem
+			case <-ctx.Done():
+				err = ctx.Err()
+			}
+
+			if err != nil {
+				mu.Lock()
+				if failed == nil {
+					failed = err
+					cancel()
+				}
+				mu.Unlock()
+			}
+		}(i, t)
+	}
+	wg.Wait()
+
+	if failed != nil {
+		return nil, errors.WithStack(failed)
+	}
+
+	return result, nil
+}
+
+// balanceSnapshot requests the balances of the accounts at t and sums them by
+// ticker once FTX has computed them.
+func (w *Wallet) balanceSnapshot(
+	ctx context.Context, accounts []string, t time.Time) (*models.BalanceSnapshot, error) {
+
+	id, err := w.RequestHistoricalBalances(accounts, t)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	snapshot, err := w.waitForHistoricalBalances(ctx, id)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	balances := make(map[string]decimal.Decimal, len(snapshot.Results))
+	for _, r := range snapshot.Results {
+		balances[r.Ticker] = balances[r.Ticker].Add(r.Size)
+	}
+
+	return &models.BalanceSnapshot{Time: t, Balances: balances}, nil
+}
+
+func (w *Wallet) waitForHistoricalBalances(
+	ctx context.Context, id int64) (*models.HistoricalBalances, error) {
+
+	ticker := time.NewTicker(defaultPollInterval)
+	defer ticker.Stop()
+
+	for {
+		snapshot, err := w.GetHistoricalBalances(id)
+		if err != nil {
+			return nil, errors.WithStack(err)
+		}
+		if snapshot.Error {
+			return nil, errors.Errorf("Historical balances request %d failed", id)
+		}
+		if snapshot.Status == models.HistoricalBalancesComplete {
+			return snapshot, nil
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-ticker.C:
+		}
+	}
+}
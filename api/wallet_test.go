@@ -0,0 +1,161 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/shopspring/decimal"
+	"github.com/uscott/go-ftx/models"
+)
+
+func TestWallet_GetWithdrawalFee(t *testing.T) {
+
+	var query url.Values
+
+	c := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		query = r.URL.Query()
+		_, _ = w.Write([]byte(`{"success": true, "result": ` +
+			`{"method": "erc20", "address": "0x83a1", "fee": 0.5, "congested": true}}`))
+	}, WithAuth("key", "secret"))
+
+	method := models.Erc20
+	params := &models.WithdrawalFeeParams{
+		Coin:    PtrString("USDT"),
+		Size:    PtrDecimal(decimal.RequireFromString("20.5")),
+		Address: PtrString("0x83a1"),
+		Method:  &method,
+	}
+
+	fee, err := c.GetWithdrawalFee(context.Background(), params)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !fee.Fee.Equal(decimal.RequireFromString("0.5")) || !fee.Congested || fee.Method != models.Erc20 {
+		t.Fatalf("Unexpected fee: %+v", fee)
+	}
+	for k, v := range map[string]string{"coin": "USDT", "size": "20.5", "address": "0x83a1", "method": "erc20"} {
+		if query.Get(k) != v {
+			t.Fatalf("Should be equal: %s, %s", query.Get(k), v)
+		}
+	}
+
+	invalid := []*models.WithdrawalFeeParams{
+		nil,
+		{Size: params.Size, Address: params.Address},
+		{Coin: params.Coin, Size: PtrDecimal(decimal.Zero), Address: params.Address},
+		{Coin: params.Coin, Size: params.Size},
+	}
+	for i, p := range invalid {
+		if _, err := c.GetWithdrawalFee(context.Background(), p); err == nil {
+			t.Fatalf("Should have gotten an error - test #%d", i+1)
+		}
+	}
+}
+
+func TestWallet_GetDepositAddressMethod(t *testing.T) {
+
+	var coinRequests, addressRequests int32
+
+	c := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/api/wallet/coins" {
+			atomic.AddInt32(&coinRequests, 1)
+			_, _ = w.Write([]byte(`{"success": true, "result": [` +
+				`{"id": "USDC", "methods": ["erc20", "sol", "trx"]},` +
+				`{"id": "BTC", "methods": ["btc"]}]}`))
+			return
+		}
+		atomic.AddInt32(&addressRequests, 1)
+		_, _ = w.Write([]byte(`{"success": true, "result": {"address": "0x83a1", "tag": null}}`))
+	}, WithAuth("key", "secret"))
+
+	erc20, omni, empty := models.Erc20, models.Omni, models.DepositMethod("")
+	for _, method := range []*models.DepositMethod{nil, &empty, &omni} {
+		_, _, err := c.GetDepositAddress("USDC", method)
+		if !errors.Is(err, ErrInvalidDepositMethod) || !strings.Contains(err.Error(), "erc20, sol, trx") {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+	}
+
+	valid := []struct {
+		coin   string
+		method *models.DepositMethod
+	}{
+		{"USDC", &erc20},
+		{"BTC", nil},
+		{"UNLISTED", nil},
+	}
+	for _, v := range valid {
+		if address, _, err := c.GetDepositAddress(v.coin, v.method); err != nil || address != "0x83a1" {
+			t.Fatalf("Unexpected address: %s, %v", address, err)
+		}
+	}
+
+	if n := atomic.LoadInt32(&coinRequests); n != 1 {
+		t.Fatalf("Should be equal: %d, %d", n, 1)
+	}
+	if n := atomic.LoadInt32(&addressRequests); n != int32(len(valid)) {
+		t.Fatalf("Should be equal: %d, %d", n, len(valid))
+	}
+}
+
+func TestWallet_GetBalanceHistory(t *testing.T) {
+
+	const days = 3 * batchConcurrency
+
+	start := time.Date(2021, 6, 1, 0, 0, 0, 0, time.UTC)
+
+	var inFlight, maxInFlight int32
+	c := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+
+		if r.Method == http.MethodPost {
+			params := models.HistoricalBalancesParams{}
+			_ = json.NewDecoder(r.Body).Decode(&params)
+			_, _ = w.Write([]byte(`{"success": true, "result": ` + strconv.FormatInt(params.EndTime, 10) + `}`))
+			return
+		}
+
+		n := atomic.AddInt32(&inFlight, 1)
+		defer atomic.AddInt32(&inFlight, -1)
+		for {
+			peak := atomic.LoadInt32(&maxInFlight)
+			if n <= peak || atomic.CompareAndSwapInt32(&maxInFlight, peak, n) {
+				break
+			}
+		}
+		time.Sleep(5 * time.Millisecond)
+
+		// The size is the day of the snapshot
+		id, _ := strconv.ParseInt(strings.TrimPrefix(r.URL.Path, "/api/historical_balances/requests/"), 10, 64)
+		day := time.Unix(id, 0).UTC().Day()
+		_, _ = w.Write([]byte(`{"success": true, "result": {"id": ` + strconv.FormatInt(id, 10) +
+			`, "status": "complete", "results": [{"ticker": "USD", "size": ` + strconv.Itoa(day) + `}]}}`))
+	}, WithAuth("key", "secret"))
+
+	history, err := c.GetBalanceHistory(context.Background(), start, start.Add((days-1)*24*time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(history) != days {
+		t.Fatalf("Should be equal: %d, %d", len(history), days)
+	}
+	for i, snapshot := range history {
+		if expected := start.Add(time.Duration(i) * 24 * time.Hour); !snapshot.Time.Equal(expected) {
+			t.Fatalf("Should be equal: %v, %v", snapshot.Time, expected)
+		}
+		if size := snapshot.Balances["USD"]; !size.Equal(decimal.NewFromInt(int64(i + 1))) {
+			t.Fatalf("Should be equal: %v, %d", size, i+1)
+		}
+	}
+
+	if n := atomic.LoadInt32(&maxInFlight); n < 2 || n > batchConcurrency {
+		t.Fatalf("Unexpected requests in flight: %d", n)
+	}
+}
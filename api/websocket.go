@@ -2,11 +2,13 @@ package api
 
 import (
 	"context"
-	"crypto/hmac"
-	"crypto/sha256"
-	"encoding/hex"
+	"crypto/tls"
 	"encoding/json"
 	"fmt"
+	"math/rand"
+	"net/http"
+	"net/url"
+	"strings"
 	"sync"
 	"time"
 
@@ -19,51 +21,151 @@ import (
 const (
 	wsUrl                 = "wss://ftx.com/ws/"
 	websocketTimeout      = time.Second * 60
-	pingPeriod            = (websocketTimeout * 9) / 10
+	writeWait             = time.Second * 10
+	closeWait             = time.Second
+	handshakeTimeout      = 45 * time.Second
 	reconnectCount    int = 10
 	reconnectInterval     = time.Second
+	authConfirmWindow     = 5 * time.Second
+	ackTimeout            = 10 * time.Second
+	errorsBufferSize  int = 64
+	pingRTTWindow     int = 20
+	pingInterval          = 15 * time.Second
+	maxMissedPongs    int = 3
+	maxBackoff            = time.Minute
+	maintenanceWait       = 30 * time.Second
+	maxQueuedEvents   int = 10000
+)
+
+var (
+	ErrNoCredentials        = errors.New("API key and secret required to log in")
+	ErrTooManySubscriptions = errors.New("Subscription limit reached")
+	ErrNoData               = errors.New("No data received")
+	ErrMaintenance          = errors.New("Connection closed for maintenance")
+	ErrNotSent              = errors.New("Not sent after a failed write")
+	ErrAlreadyServed        = errors.New("Subscription already served")
+	ErrQueueFull            = errors.New("Event queue full, dropping events")
 )
 
 type Stream struct {
 	client                 *Client
 	mu                     *sync.Mutex
+	ctx                    context.Context
+	cancel                 context.CancelFunc
 	url                    string
-	conn                   *websocket.Conn
 	dialer                 *websocket.Dialer
 	wsReconnectionCount    int
 	wsReconnectionInterval time.Duration
-	isLoggedIn             bool
-	WsSub                  *WsSub
-	tickersC               chan *models.TickerResponse
-	marketsC               chan *models.Market
-	tradesC                chan *models.TradeResponse
-	booksC                 chan *models.OrderBookResponse
-	fillsC                 chan *models.FillResponse
-	ordersC                chan *models.OrdersResponse
+	wsMaintenanceBackoff   time.Duration
+	wsTimeout              time.Duration
+	wsPingInterval         time.Duration
+	wsAckTimeout           time.Duration
+	waitForAck             bool
+	alwaysAuthenticate     bool
+	autoReconnect          bool
+	shareConnections       bool
+	shareMu                *sync.Mutex
+	replaySpeed            float64
+	maxSubscriptions       int
+	onRawMessage           func(messageType int, data []byte)
+	onMaintenance          func(maintenance bool)
+	subscriptions          int
+	reconnects             int
+	maxEventAge            time.Duration
+	maxQueuedEvents        int
+	firstDataTimeout       time.Duration
+	dropped                int
+	Subs                   []*WsSub
+	errorsC                chan error
+	orderWatchers          map[int64][]chan *models.Order
+
+	// Deprecated: WsSub is only served by Serve, use NewWsSub and ServeSub.
+	WsSub *WsSub
 }
 
 type TrivialMap map[string]struct{}
 
+// SubscribeOptions configures a market data subscription.
+type SubscribeOptions struct {
+	Markets []string
+	// Buffer is the capacity of the returned channel.
+	Buffer int
+	// WaitForAck makes the subscribe call wait until FTX has confirmed every
+	// market, failing on an error frame or after ackTimeout.
+	WaitForAck bool
+}
+
+// WsSub is a set of subscriptions served over a single connection. Decoded
+// events are delivered on EventC.
 type WsSub struct {
-	ChannelTypes map[models.ChannelType]TrivialMap
-	Requests     []models.WSRequest
+	ChannelTypes  map[models.ChannelType]TrivialMap
+	Requests      []models.WSRequest
+	EventC        chan interface{}
+	conn          *websocket.Conn
+	queue         *eventQueue
+	readDone      chan struct{}
+	deliverDone   chan struct{}
+	closed        chan struct{}
+	closeOnce     sync.Once
+	handlers      map[models.ChannelType]func(interface{})
+	taps          []chan interface{}
+	tapsClosed    bool
+	host          *WsSub
+	views         []*WsSub
+	refs          map[models.WSRequest]int
+	dispatchMu    sync.Mutex
+	viewDone      <-chan struct{}
+	retired       bool
+	served        bool
+	sent          int
+	cancel        context.CancelFunc
+	acksC         chan models.WsResponse
+	acks          int
+	mu            sync.Mutex
+	isLoggedIn    bool
+	authenticated bool
+	loginPending  bool
+	loginSentAt   time.Time
+	pingSentAt    time.Time
+	missedPongs   int
+	lastMessageAt time.Time
+	dropped       int
+	pingRTTs      []time.Duration
+	lastPingRTT   time.Duration
+	firstData     map[models.WSRequest]*time.Timer
+	closeErr      error
 }
 
 func NewStream(client *Client) *Stream {
 	return &Stream{
 		client:                 client,
 		mu:                     &sync.Mutex{},
-		url:                    wsUrl,
-		dialer:                 websocket.DefaultDialer,
+		shareMu:                &sync.Mutex{},
+		replaySpeed:            1,
+		url:                    client.region.wsURL(),
+		dialer:                 newDialer(),
 		wsReconnectionCount:    reconnectCount,
 		wsReconnectionInterval: reconnectInterval,
+		wsMaintenanceBackoff:   maintenanceWait,
+		wsTimeout:              websocketTimeout,
+		wsPingInterval:         pingInterval,
+		wsAckTimeout:           ackTimeout,
+		maxQueuedEvents:        maxQueuedEvents,
+		autoReconnect:          true,
+		Subs:                   make([]*WsSub, 0, 8),
 		WsSub:                  NewWsSub(),
-		tickersC:               make(chan *models.TickerResponse),
-		marketsC:               make(chan *models.Market),
-		tradesC:                make(chan *models.TradeResponse),
-		booksC:                 make(chan *models.OrderBookResponse),
-		fillsC:                 make(chan *models.FillResponse),
-		ordersC:                make(chan *models.OrdersResponse),
+		errorsC:                make(chan error, errorsBufferSize),
+		orderWatchers:          make(map[int64][]chan *models.Order),
+	}
+}
+
+// newDialer returns a dialer like websocket.DefaultDialer which also offers
+// permessage-deflate compression.
+func newDialer() *websocket.Dialer {
+	return &websocket.Dialer{
+		Proxy:             http.ProxyFromEnvironment,
+		HandshakeTimeout:  handshakeTimeout,
+		EnableCompression: true,
 	}
 }
 
@@ -71,15 +173,33 @@ func NewWsSub() *WsSub {
 	return &WsSub{
 		ChannelTypes: make(map[models.ChannelType]TrivialMap),
 		Requests:     make([]models.WSRequest, 0, 64),
+		EventC:       make(chan interface{}),
+		queue:        newEventQueue(),
+		readDone:     make(chan struct{}),
+		deliverDone:  make(chan struct{}),
+		closed:       make(chan struct{}),
 	}
 }
 
+// RequestOptions holds the channel specific fields of subscription requests.
+type RequestOptions struct {
+	// Grouping is required by the orderbookGrouped channel.
+	Grouping float64
+}
+
 func MakeRequests(
 	chantype models.ChannelType, symbols TrivialMap) []models.WSRequest {
+	return MakeRequestsWithOptions(chantype, symbols, RequestOptions{})
+}
+
+// MakeRequestsWithOptions is MakeRequests with the channel specific fields
+// of opts set on every request.
+func MakeRequestsWithOptions(
+	chantype models.ChannelType, symbols TrivialMap, opts RequestOptions) []models.WSRequest {
 
 	if len(symbols) == 0 {
 		return []models.WSRequest{
-			{ChannelType: chantype, Op: models.Subscribe},
+			{ChannelType: chantype, Op: models.Subscribe, Grouping: opts.Grouping},
 		}
 	}
 
@@ -91,6 +211,7 @@ func MakeRequests(
 			ChannelType: chantype,
 			Market:      s,
 			Op:          models.Subscribe,
+			Grouping:    opts.Grouping,
 		}
 		i++
 	}
@@ -98,15 +219,17 @@ func MakeRequests(
 	return requests
 }
 
-func (s *Stream) Authorize() (err error) {
+func (s *Stream) authorize(ws *WsSub) (err error) {
 
-	if s.conn == nil {
-		if err = s.CreateNewConnection(); err != nil {
-			return
-		}
+	if ws.Conn() == nil {
+		return errors.New("Not connected")
+	}
+
+	if s.client.apiKey == "" || s.client.secret == "" {
+		return ErrNoCredentials
 	}
 
-	if s.isLoggedIn {
+	if ws.IsLoggedIn() {
 		return nil
 	}
 
@@ -115,390 +238,1504 @@ func (s *Stream) Authorize() (err error) {
 		return
 	}
 
-	if err = s.conn.WriteJSON(wsra); err != nil {
+	if err = ws.writeJSON(wsra); err != nil {
 		return errors.WithStack(err)
 	}
 
-	s.isLoggedIn = true
+	ws.loginSent()
 
 	return
 }
 
-func (s *Stream) Connect(requests ...models.WSRequest) (err error) {
+func (s *Stream) connect(ws *WsSub) (err error) {
 
-	if err = s.CreateNewConnection(); err != nil {
+	if err = s.Dial(ws); err != nil {
 		return
 	}
 
 	s.client.Logger.Debugf("connected to %v", s.url)
 
-	if err = s.Subscribe(); err != nil {
+	if err = s.sendRequests(ws); err != nil {
 		return errors.WithStack(err)
 	}
 
-	lastPong := time.Now()
-	s.conn.SetPongHandler(
-		func(msg string) error {
-			lastPong = time.Now()
-			if time.Since(lastPong) > websocketTimeout {
-				// TODO handle this case
-				errmsg := "PONG response time has been exceeded"
-				s.client.Logger.Debug(errmsg)
-				return errors.New(errmsg) // Handled?
-			}
-			s.client.Logger.Debug("PONG")
-			return nil
-		})
 	return nil
 }
 
-func (s *Stream) CreateNewConnection() (err error) {
+// Dial connects ws to FTX, closing its previous connection if any.
+func (s *Stream) Dial(ws *WsSub) (err error) {
 
-	s.isLoggedIn = false
+	s.mu.Lock()
+	dialer, target := s.dialer, s.url
+	s.mu.Unlock()
+
+	var header http.Header
+	if ua := s.client.UserAgent(); ua != "" {
+		header = http.Header{"User-Agent": []string{ua}}
+	}
 
-	s.conn, _, err = s.dialer.Dial(s.url, nil)
+	conn, _, err := dialer.Dial(target, header)
 	if err != nil {
 		return errors.WithStack(err)
 	}
 
+	if err = s.extendReadDeadline(conn); err != nil {
+		conn.Close()
+		return
+	}
+
+	// FTX pings are answered as gorilla does by default, the connection
+	// being alive extending its read deadline as well
+	conn.SetPingHandler(
+		func(msg string) error {
+			s.client.Logger.Debug("PING received")
+			err := conn.WriteControl(websocket.PongMessage, []byte(msg), time.Now().Add(writeWait))
+			if err != nil && err != websocket.ErrCloseSent {
+				return errors.WithStack(err)
+			}
+			return s.extendReadDeadline(conn)
+		})
+
+	ws.setConn(conn)
+
 	return
 }
 
-func (s *Stream) GetAuthRequest() (*models.WSRequestAuthorize, error) {
+// extendReadDeadline pushes the read deadline out by the websocket timeout so
+// that a silently dead connection makes the blocked read fail, which in turn
+// triggers the reconnect path.
+func (s *Stream) extendReadDeadline(conn *websocket.Conn) error {
+	return errors.WithStack(conn.SetReadDeadline(time.Now().Add(s.wsTimeout)))
+}
 
-	ms := time.Now().UTC().UnixNano() / int64(time.Millisecond)
-	mac := hmac.New(sha256.New, []byte(s.client.secret))
+// pingPeriod returns the ping interval, shortened to 90% of the websocket
+// timeout if need be so that a live connection never hits its read deadline.
+func (s *Stream) pingPeriod() time.Duration {
 
-	_, err := mac.Write([]byte(fmt.Sprintf("%dwebsocket_login", ms)))
-	if err != nil {
-		return nil, errors.WithStack(err)
-	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
 
-	args := map[string]interface{}{
-		"key":  s.client.apiKey,
-		"sign": hex.EncodeToString(mac.Sum(nil)),
-		"time": ms,
+	if period := (s.wsTimeout * 9) / 10; period < s.wsPingInterval {
+		return period
 	}
+	return s.wsPingInterval
+}
 
-	if s.client.SubAccount != nil {
-		args["subaccount"] = *s.client.SubAccount
-	}
+func (s *Stream) GetAuthRequest() (*models.WSRequestAuthorize, error) {
+
+	ms := s.client.now().UTC().UnixNano() / int64(time.Millisecond)
 
 	return &models.WSRequestAuthorize{
 		Op:   "login",
-		Args: args,
+		Args: s.client.signWSLogin(ms),
 	}, nil
 }
 
-func (s *Stream) GetEventResponse(ctx context.Context, msg *models.WsResponse) (err error) {
+func (s *Stream) getEventResponse(
+	ctx context.Context, ws *WsSub, msg *models.WsResponse) (err error) {
 
 	if msg == nil {
 		return errors.New("Nil pointer")
 	}
 
-	if err = s.conn.ReadJSON(&msg); err != nil {
+	*msg = models.WsResponse{}
+
+	conn := ws.Conn()
+
+	messageType, data, err := conn.ReadMessage()
+	if err == nil {
+		ws.messageReceived()
+		s.mu.Lock()
+		onRawMessage := s.onRawMessage
+		s.mu.Unlock()
+		if onRawMessage != nil {
+			onRawMessage(messageType, data)
+		}
+		err = json.Unmarshal(data, msg)
+	}
+
+	if err != nil {
 
 		s.client.Logger.Debugf("read msg: %v", err)
 
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+
 		if websocket.IsCloseError(err, websocket.CloseNormalClosure) {
 			return
 		}
 
-		if err = s.Reconnect(ctx); err != nil {
+		s.mu.Lock()
+		autoReconnect := s.autoReconnect
+		s.mu.Unlock()
+
+		if !autoReconnect {
+			return errors.WithStack(err)
+		}
+
+		maintenance := isMaintenanceClose(err)
+		if maintenance {
+			if err = s.waitMaintenance(ctx, err); err != nil {
+				return
+			}
+		}
+
+		if err = s.reconnect(ctx, ws); err != nil {
 			s.client.Logger.Debugf("reconnect: %+v", err)
 			return
 		}
 
+		if maintenance {
+			s.maintenanceOver()
+		}
+
+		return nil
+	}
+
+	if err = s.extendReadDeadline(conn); err != nil {
+		return
+	}
+
+	// Pings sent as data are answered in kind
+	if msg.ResponseType == models.PingResponse {
+		return errors.WithStack(ws.writeJSON(map[string]models.Operation{"op": models.PongOp}))
+	}
+
+	if msg.ResponseType == models.PongResponse {
+		if rtt, ok := ws.pongReceived(s.client.now()); ok {
+			s.client.Logger.Debugf("PONG %v", rtt)
+		} else {
+			s.client.Logger.Debug("PONG")
+		}
 		return nil
 	}
 
+	if msg.ResponseType == models.Subscribed {
+		s.awaitFirstData(ws, msg.ChannelType, msg.Market)
+	}
+
+	e, ok, err := s.decodeResponse(ws, msg)
+	if ok {
+		ws.firstDataReceived(msg.ChannelType, msg.Market)
+		e.Received = time.Now()
+		s.mu.Lock()
+		limit := s.maxQueuedEvents
+		s.mu.Unlock()
+		if dropped, first := ws.queue.push(e, limit); dropped {
+			s.eventDropped(ws)
+			if first {
+				s.sendError(errors.Wrapf(ErrQueueFull, "%d events queued", limit))
+			}
+		}
+	}
+
+	return
+}
+
+// decodeResponse handles the control frames read on the connection of ws and
+// maps data frames to the event pushed to its queue.
+func (s *Stream) decodeResponse(
+	ws *WsSub, msg *models.WsResponse) (e wsEvent, ok bool, err error) {
+
+	if msg.ResponseType == models.Subscribed || msg.ResponseType == models.Error {
+		ws.ack(msg)
+	}
+
 	if msg.ResponseType == models.Subscribed || msg.ResponseType == models.UnSubscribed {
 		return
 	}
 
-	var response interface{}
+	if msg.ResponseType == models.Error {
+		if isAuthError(msg) {
+			ws.setAuthenticated(false)
+		}
+		s.sendError(errors.Errorf("Code: %d	Error: %s", msg.Code, msg.Message))
+		return
+	}
+
+	if msg.ChannelType == models.FillsChannel || msg.ChannelType == models.OrdersChannel {
+		ws.setAuthenticated(true)
+	}
+
+	var (
+		response interface{}
+		schema   interface{}
+	)
 
 	switch msg.ChannelType {
 	case models.TickerChannel:
 		response, err = msg.MapToTickerResponse()
+		schema = &models.Ticker{}
 	case models.TradesChannel:
 		response, err = msg.MapToTradesResponse()
+		schema = &[]models.Trade{}
 	case models.OrderBookChannel:
 		response, err = msg.MapToOrderBookResponse()
+		schema = &models.OrderBook{}
 	case models.MarketsChannel:
 		response = msg.Data
 	case models.FillsChannel:
 		response, err = msg.MapToFillResponse()
+		schema = &models.Fill{}
 	case models.OrdersChannel:
 		response, err = msg.MapToOrdersResponse()
+		schema = &models.Order{}
+	default:
+		// Channels without a mapper are delivered raw, see WsResponse.MapTo
+		if msg.ChannelType != "" {
+			response = msg.Data
+		}
 	}
 
-	if err != nil {
+	if err != nil || response == nil {
 		return
 	}
 
-	go s.SendToChannel(msg.ChannelType, response)
+	if s.client.strictDecode && schema != nil {
+		if err := strictUnmarshal(msg.Data, schema); err != nil {
+			s.client.Logger.Warnf("%s message: %v", msg.ChannelType, err)
+		}
+	}
 
-	return
+	e = wsEvent{ChannelType: msg.ChannelType, Market: msg.Market, Response: response}
+	return e, true, nil
 }
 
-func (s *Stream) IsLoggedIn() bool {
-	return s.isLoggedIn
+// Errors returns the channel on which asynchronous stream errors, such as
+// error frames sent by FTX, are delivered. Errors are dropped when the
+// channel is full.
+func (s *Stream) Errors() chan error {
+	return s.errorsC
 }
 
-func (s *Stream) Reconnect(ctx context.Context) (err error) {
-
-	for i := 0; i < s.wsReconnectionCount; i++ {
-		if err = s.Connect(); err == nil {
-			return nil
-		}
-		select {
-		case <-time.After(s.wsReconnectionInterval):
-			if err = s.Connect(); err != nil {
-				continue
-			}
-			return nil
-		case <-ctx.Done():
-			return ctx.Err()
-		}
+func (s *Stream) sendError(err error) {
+	select {
+	case s.errorsC <- err:
+	default:
+		s.client.Logger.Debugf("errors channel full, dropping: %v", err)
 	}
+}
 
-	return errors.New("Reconnection failed")
+func isAuthError(msg *models.WsResponse) bool {
+	m := strings.ToLower(msg.Message)
+	return strings.Contains(m, "login") ||
+		(strings.Contains(m, "logged in") && !strings.Contains(m, "already"))
 }
 
-func (s *Stream) SetReconnectionCount(count int) {
-	s.mu.Lock()
-	s.wsReconnectionCount = count
-	s.mu.Unlock()
+// isMaintenanceClose reports whether err is the close frame FTX sends to all
+// connections before a maintenance, with a service restart or try again
+// later code or a reason mentioning maintenance.
+func isMaintenanceClose(err error) bool {
+
+	var closeErr *websocket.CloseError
+	if !errors.As(err, &closeErr) {
+		return false
+	}
+
+	return closeErr.Code == websocket.CloseServiceRestart ||
+		closeErr.Code == websocket.CloseTryAgainLater ||
+		strings.Contains(strings.ToLower(closeErr.Text), "maintenance")
 }
 
-func (s *Stream) SetReconnectionInterval(interval time.Duration) {
+// waitMaintenance reports the maintenance and waits for the maintenance
+// backoff plus up to as much again in jitter before reconnecting, so that
+// reconnections are neither refused during the maintenance nor all made at
+// once after it.
+func (s *Stream) waitMaintenance(ctx context.Context, err error) error {
+
 	s.mu.Lock()
-	s.wsReconnectionInterval = interval
+	delay := s.wsMaintenanceBackoff
+	onMaintenance := s.onMaintenance
 	s.mu.Unlock()
-}
 
-func (s *Stream) sub() (err error) {
-	for _, r := range s.WsSub.Requests {
-		if err = s.conn.WriteJSON(r); err != nil {
-			return errors.WithStack(err)
-		}
+	if delay > 0 {
+		delay += time.Duration(rand.Int63n(int64(delay)))
+	}
+
+	s.sendError(errors.Wrapf(ErrMaintenance, "%v: reconnecting in %v", err, delay))
+	if onMaintenance != nil {
+		onMaintenance(true)
 	}
+
+	if !sleep(ctx, delay) {
+		return ctx.Err()
+	}
+
 	return nil
 }
 
-func (s *Stream) Subscribe() (err error) {
+// maintenanceOver calls the maintenance function once reconnected.
+func (s *Stream) maintenanceOver() {
 
-	if !s.isLoggedIn {
-		for _, r := range s.WsSub.Requests {
-			ct := r.ChannelType
-			if ct == models.FillsChannel || ct == models.OrdersChannel {
-				if err = s.Authorize(); err != nil {
-					return
-				}
-				break
-			}
-		}
-	}
+	s.mu.Lock()
+	onMaintenance := s.onMaintenance
+	s.mu.Unlock()
 
-	return s.sub()
+	if onMaintenance != nil {
+		onMaintenance(false)
+	}
 }
 
-func (s *Stream) SendToChannel(ct models.ChannelType, response interface{}) {
+func (s *Stream) reconnect(ctx context.Context, ws *WsSub) (err error) {
 
-	switch ct {
-	case models.TickerChannel:
-		ticker, ok := response.(*models.TickerResponse)
-		if ok && ticker != nil {
-			s.tickersC <- ticker
-		}
-	case models.TradesChannel:
-		trades, ok := response.(*models.TradesResponse)
-		if ok && trades != nil {
-			for _, t := range trades.Trades {
-				s.tradesC <- &models.TradeResponse{
-					Trade:        t,
-					BaseResponse: trades.BaseResponse,
-				}
-			}
-		}
-	case models.OrderBookChannel:
-		book, ok := response.(*models.OrderBookResponse)
-		if ok && book != nil {
-			s.booksC <- book
-		}
-	case models.MarketsChannel:
-		markets, err := MapToMarketData(response)
+	defer func() {
 		if err == nil {
-			for _, m := range markets {
-				if m != nil {
-					s.marketsC <- m
-				}
-			}
+			s.mu.Lock()
+			s.reconnects++
+			s.mu.Unlock()
 		}
-	case models.FillsChannel:
-		fill, ok := response.(*models.FillResponse)
-		if ok && fill != nil {
-			s.fillsC <- fill
+	}()
+
+	for i := 0; i < s.wsReconnectionCount; i++ {
+		if err = s.connect(ws); err == nil {
+			return nil
 		}
-	case models.OrdersChannel:
-		order, ok := response.(*models.OrdersResponse)
-		if ok && order != nil {
-			s.ordersC <- order
+		select {
+		case <-time.After(s.wsReconnectionInterval):
+			if err = s.connect(ws); err != nil {
+				continue
+			}
+			return nil
+		case <-ctx.Done():
+			return ctx.Err()
 		}
 	}
+
+	return errors.New("Reconnection failed")
 }
 
-func (s *Stream) Serve(ctx context.Context) (err error) {
+// Close sends a close frame on the connection of ws, waits briefly for FTX
+// to acknowledge it and closes the connection, removing ws from Subs and
+// closing its EventC. It is the same as cancelling the context ws is served
+// with, see WsSub.Close.
+func (s *Stream) Close(ws *WsSub) error {
 
-	if err = s.Connect(); err != nil {
-		return errors.WithStack(err)
+	if ws == nil {
+		return errors.New("Nil subscription")
 	}
 
-	msg := models.WsResponse{}
-
-	go func() {
-
-		go func() {
-			for {
-				s.client.mu.Lock()
-				if err = s.GetEventResponse(ctx, &msg); err != nil {
-					s.client.mu.Unlock()
-					return
-				}
-				s.client.mu.Unlock()
-			}
-		}()
+	return ws.Close()
+}
 
-		for {
+// SetOnRawMessage sets a function called with every frame read from FTX
+// before it is decoded, for instance to record the stream for replay. It is
+// called from the read loop of the connection so it must not block.
+func (s *Stream) SetOnRawMessage(f func(messageType int, data []byte)) {
+	s.mu.Lock()
+	s.onRawMessage = f
+	s.mu.Unlock()
+}
 
-			select {
+func (s *Stream) SetURL(url string) {
+	s.mu.Lock()
+	s.url = url
+	s.mu.Unlock()
+}
 
-			case <-ctx.Done():
+// SetTimeout sets how long the connection may stay silent, pongs included,
+// before it is considered dead and reconnected. Pings are sent at 90% of it
+// when that is shorter than the ping interval.
+func (s *Stream) SetTimeout(timeout time.Duration) {
+	s.mu.Lock()
+	s.wsTimeout = timeout
+	s.mu.Unlock()
+}
 
-				s.client.mu.Lock()
-				err = s.conn.WriteMessage(
-					websocket.CloseMessage,
-					websocket.FormatCloseMessage(websocket.CloseNormalClosure, ""))
+// SetPingInterval sets how often {"op": "ping"} is sent to FTX, which answers
+// with a pong. The connection is closed and reconnected after 3 pings in a
+// row go unanswered. It is 15 seconds by default, as FTX recommends.
+func (s *Stream) SetPingInterval(interval time.Duration) {
+	s.mu.Lock()
+	s.wsPingInterval = interval
+	s.mu.Unlock()
+}
 
-				if err != nil {
-					s.client.Logger.Debugf("write close msg: %v", err)
-					s.client.mu.Unlock()
-					return
-				}
-				s.client.mu.Unlock()
+// SetCompression sets whether permessage-deflate compression is offered to
+// FTX on new connections. It is on by default.
+func (s *Stream) SetCompression(enabled bool) {
+	s.mu.Lock()
+	s.dialer.EnableCompression = enabled
+	s.mu.Unlock()
+}
 
-				time.Sleep(time.Second)
+// SetDialer sets the dialer used for new connections, for instance to go
+// through a proxy or pin certificates. A nil dialer restores the default.
+func (s *Stream) SetDialer(dialer *websocket.Dialer) {
+	if dialer == nil {
+		dialer = newDialer()
+	}
+	s.mu.Lock()
+	s.dialer = dialer
+	s.mu.Unlock()
+}
 
-				return
+// SetProxy makes new connections go through the proxy at proxyURL instead of
+// the one given by the environment. A nil proxyURL connects directly.
+func (s *Stream) SetProxy(proxyURL *url.URL) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	dialer := *s.dialer
+	if proxyURL == nil {
+		dialer.Proxy = nil
+	} else {
+		dialer.Proxy = http.ProxyURL(proxyURL)
+	}
+	s.dialer = &dialer
+}
 
-			case <-time.After(pingPeriod):
+// SetProxyFromEnvironment makes new connections go through the proxy set by
+// the HTTP_PROXY, HTTPS_PROXY and NO_PROXY environment variables, which the
+// default dialer does, for instance after SetDialer or SetProxy.
+func (s *Stream) SetProxyFromEnvironment() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	dialer := *s.dialer
+	dialer.Proxy = http.ProxyFromEnvironment
+	s.dialer = &dialer
+}
 
-				s.client.Logger.Debug("PING")
+// SetTLSConfig sets the TLS configuration of new connections.
+func (s *Stream) SetTLSConfig(config *tls.Config) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	dialer := *s.dialer
+	dialer.TLSClientConfig = config
+	s.dialer = &dialer
+}
 
-				s.client.mu.Lock()
-				err = s.conn.WriteControl(
-					websocket.PingMessage,
-					[]byte(`{"op": "pong"}`),
-					time.Now().UTC().Add(10*time.Second))
+// SetAutoReconnect sets whether a connection that fails is reconnected and
+// resubscribed. When it is off the failure is sent to Errors() and the
+// subscription is closed, closing its EventC. It is on by default.
+func (s *Stream) SetAutoReconnect(enabled bool) {
+	s.mu.Lock()
+	s.autoReconnect = enabled
+	s.mu.Unlock()
+}
 
-				if err != nil && err != websocket.ErrCloseSent {
-					s.client.Logger.Debugf("write ping: %v", err)
-				}
-				s.client.mu.Unlock()
+// SetMaxEventAge sets how long market data events may wait to be delivered.
+// Older ones, held up by a slow consumer, are dropped and counted in Stats.
+// Fills and orders events are always delivered. Zero, the default, keeps
+// every event.
+func (s *Stream) SetMaxEventAge(d time.Duration) {
+	s.mu.Lock()
+	s.maxEventAge = d
+	s.mu.Unlock()
+}
 
-			}
-		}
-	}()
+// SetMaxQueuedEvents sets how many events received on a connection may wait
+// for a slow consumer, 10000 by default. Once as many are queued the oldest
+// market data event is dropped for every new one and counted in Stats, and
+// ErrQueueFull is sent to Errors() each time dropping starts. Fills and orders
+// events are never dropped. Zero or less keeps every event, the queue then
+// growing without bound for as long as the consumer lags: SetMaxEventAge
+// drops events which waited too long instead.
+func (s *Stream) SetMaxQueuedEvents(n int) {
+	s.mu.Lock()
+	s.maxQueuedEvents = n
+	s.mu.Unlock()
+}
 
-	return err
+// SetFirstDataTimeout makes ErrNoData be sent to Errors() when a confirmed
+// subscription gets no data within d, as happens with illiquid markets. The
+// subscription is left open. Zero, the default, never sends it.
+func (s *Stream) SetFirstDataTimeout(d time.Duration) {
+	s.mu.Lock()
+	s.firstDataTimeout = d
+	s.mu.Unlock()
 }
 
-func (s *Stream) SubscribeToTickers(
-	ctx context.Context, symbols ...string) (chan *models.TickerResponse, error) {
+// SetShareConnections sets whether the SubscribeTo helpers and ReadN add
+// their subscriptions to a connection opened by an earlier call rather than
+// dialing a new one each time. A shared connection is closed once all its
+// subscriptions are. It is off by default.
+func (s *Stream) SetShareConnections(share bool) {
+	s.mu.Lock()
+	s.shareConnections = share
+	s.mu.Unlock()
+}
 
-	if len(symbols) == 0 {
-		return nil, errors.New("symbols missing")
-	}
+// SetAlwaysAuthenticate sets whether every connection logs in before
+// subscribing, not only those carrying private channels, so that private
+// channels can later be added to any of them. Subscribing fails with
+// ErrNoCredentials if the client has no API key and secret.
+func (s *Stream) SetAlwaysAuthenticate(always bool) {
+	s.mu.Lock()
+	s.alwaysAuthenticate = always
+	s.mu.Unlock()
+}
 
-	ct, ws := models.TickerChannel, s.WsSub
+// SetWaitForAck sets whether ServeSub waits for FTX to confirm the first
+// subscription of a connection before returning, so that a nil error means
+// the stream is live. It fails on an error frame or once the ack timeout has
+// elapsed. It is off by default, which saves the round trip.
+func (s *Stream) SetWaitForAck(wait bool) {
+	s.mu.Lock()
+	s.waitForAck = wait
+	s.mu.Unlock()
+}
 
-	ws.AppendRequests(ct, symbols...)
+// SetAckTimeout sets how long subscriptions wait for confirmation.
+func (s *Stream) SetAckTimeout(timeout time.Duration) {
+	s.mu.Lock()
+	s.wsAckTimeout = timeout
+	s.mu.Unlock()
+}
 
-	err := s.Serve(ctx)
-	if err != nil {
-		return nil, errors.WithStack(err)
-	}
+// SetMaxSubscriptions caps the number of channel and market subscriptions
+// across all connections of the stream. Subscribing beyond it fails with
+// ErrTooManySubscriptions without contacting FTX. There is no cap by default
+// or when n is zero.
+func (s *Stream) SetMaxSubscriptions(n int) {
+	s.mu.Lock()
+	s.maxSubscriptions = n
+	s.mu.Unlock()
+}
 
-	return s.tickersC, nil
+// Subscriptions returns the number of channel and market subscriptions
+// across all connections of the stream.
+func (s *Stream) Subscriptions() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.subscriptions
 }
 
-func (s *Stream) SubscribeToMarkets(ctx context.Context) (chan *models.Market, error) {
+// Stats returns a snapshot of the subscriptions and connections of the
+// Stream, for instance for a health check.
+func (s *Stream) Stats() models.StreamStats {
 
-	ct, ws := models.MarketsChannel, s.WsSub
+	s.mu.Lock()
+	defer s.mu.Unlock()
 
-	ws.AppendRequests(ct)
+	stats := models.StreamStats{
+		Subscriptions: s.subscriptions,
+		Reconnects:    s.reconnects,
+		Dropped:       s.dropped,
+		Subs:          make([]models.SubStats, 0, len(s.Subs)),
+	}
 
-	err := s.Serve(ctx)
-	if err != nil {
-		return nil, errors.WithStack(err)
+	for _, ws := range s.Subs {
+		ws.mu.Lock()
+		if ws.conn != nil {
+			stats.Connections++
+		}
+		stats.Subs = append(stats.Subs, models.SubStats{
+			Subscriptions: len(ws.Requests),
+			LastMessage:   ws.lastMessageAt,
+			Dropped:       ws.dropped,
+		})
+		ws.mu.Unlock()
 	}
 
-	return s.marketsC, nil
+	return stats
 }
 
-func (s *Stream) SubscribeToTrades(
-	ctx context.Context, symbols ...string) (chan *models.TradeResponse, error) {
-
-	if len(symbols) == 0 {
+// SetMaintenanceBackoff sets how long to wait before reconnecting after FTX
+// closed the connection for maintenance, up to as much again being added in
+// jitter. It is 30 seconds by default.
+func (s *Stream) SetMaintenanceBackoff(backoff time.Duration) {
+	s.mu.Lock()
+	s.wsMaintenanceBackoff = backoff
+	s.mu.Unlock()
+}
+
+// SetOnMaintenance sets a function called with true when FTX closes a
+// connection for maintenance, for instance to pause trading, and with false
+// once it is reconnected.
+func (s *Stream) SetOnMaintenance(f func(maintenance bool)) {
+	s.mu.Lock()
+	s.onMaintenance = f
+	s.mu.Unlock()
+}
+
+func (s *Stream) SetReconnectionCount(count int) {
+	s.mu.Lock()
+	s.wsReconnectionCount = count
+	s.mu.Unlock()
+}
+
+func (s *Stream) SetReconnectionInterval(interval time.Duration) {
+	s.mu.Lock()
+	s.wsReconnectionInterval = interval
+	s.mu.Unlock()
+}
+
+// resubscribe unsubscribes and subscribes again to the channel for the market
+// so that FTX sends a fresh partial.
+func (s *Stream) resubscribe(ct models.ChannelType, market string) (err error) {
+
+	ws := s.findSub(ct, market)
+	if ws == nil || ws.Conn() == nil {
+		return errors.New("Not connected")
+	}
+
+	// Send the request ws subscribed with so that options such as the
+	// grouping of the book are kept
+	request := models.WSRequest{ChannelType: ct, Market: market}
+	for _, r := range ws.Requests {
+		if r.ChannelType == ct && r.Market == market {
+			request = r
+			break
+		}
+	}
+
+	for _, op := range []models.Operation{models.UnSubscribe, models.Subscribe} {
+		request.Op = op
+		err = ws.writeJSON(request)
+		if err != nil {
+			return
+		}
+	}
+
+	return nil
+}
+
+// findSub returns the first tracked sub on the channel for the market, or on
+// the channel for any market when market is empty.
+func (s *Stream) findSub(ct models.ChannelType, market string) *WsSub {
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, ws := range s.Subs {
+		if markets, ok := ws.ChannelTypes[ct]; ok {
+			if _, ok = markets[market]; ok || market == "" {
+				return ws
+			}
+		}
+	}
+
+	return nil
+}
+
+func (s *Stream) removeSub(ws *WsSub) {
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for i, sub := range s.Subs {
+		if sub == ws {
+			s.Subs = append(s.Subs[:i], s.Subs[i+1:]...)
+			s.subscriptions -= len(ws.Requests)
+			return
+		}
+	}
+}
+
+// sendRequests sends the subscription requests of ws, preceded by the login if
+// ws has a private channel. FTX processes the frames of a connection in order
+// and sends no reply to a login, so the requests are written right after it.
+func (s *Stream) sendRequests(ws *WsSub) (err error) {
+
+	s.mu.Lock()
+	always := s.alwaysAuthenticate
+	s.mu.Unlock()
+
+	if (always || ws.isPrivate()) && !ws.IsLoggedIn() {
+		if err = s.authorize(ws); err != nil {
+			return
+		}
+	}
+
+	return ws.Subscribe()
+}
+
+func (s *Stream) deliver(ctx context.Context, ws *WsSub) {
+
+	defer close(ws.deliverDone)
+
+	// A panicking handler closes the connection rather than the program
+	defer func() {
+		if r := recover(); r != nil {
+			s.sendError(errors.Errorf("Panic delivering events, closing connection: %v", r))
+			ws.cancelServe()
+		}
+	}()
+
+	for {
+		e, ok := ws.queue.pop(ctx)
+		if !ok {
+			return
+		}
+		if s.isStale(e) {
+			s.eventDropped(ws)
+			continue
+		}
+		if order, ok := e.Response.(*models.OrdersResponse); ok && order != nil {
+			s.notifyOrderWatchers(&order.Order)
+		}
+		events := splitEvent(e)
+		s.sendToTaps(ws, events)
+		if ws.isHost() {
+			ws.dispatch(ctx, e, events)
+			continue
+		}
+		if handler, ok := ws.handler(e.ChannelType); ok {
+			for _, event := range events {
+				if handler != nil {
+					handler(event)
+				}
+			}
+			continue
+		}
+		for _, event := range events {
+			select {
+			case ws.EventC <- event:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}
+}
+
+// isStale reports whether e is a market data event older than the maximum
+// event age.
+func (s *Stream) isStale(e wsEvent) bool {
+
+	if !droppable(e.ChannelType) {
+		return false
+	}
+
+	s.mu.Lock()
+	maxAge := s.maxEventAge
+	s.mu.Unlock()
+
+	return maxAge > 0 && time.Since(e.Received) > maxAge
+}
+
+func (s *Stream) eventDropped(ws *WsSub) {
+	s.mu.Lock()
+	s.dropped++
+	s.mu.Unlock()
+	ws.mu.Lock()
+	ws.dropped++
+	ws.mu.Unlock()
+}
+
+// sendToTaps offers the events to every tap of ws, dropping those a tap has
+// no room for.
+func (s *Stream) sendToTaps(ws *WsSub, events []interface{}) {
+
+	ws.mu.Lock()
+	defer ws.mu.Unlock()
+
+	for i, tap := range ws.taps {
+		for _, event := range events {
+			select {
+			case tap <- event:
+			default:
+				s.client.Logger.Debugf("tap %d full, dropping event", i)
+			}
+		}
+	}
+}
+
+// splitEvent unpacks a decoded response into the events delivered on EventC:
+// trades and markets frames carry several items which are sent one by one.
+func splitEvent(e wsEvent) []interface{} {
+
+	switch e.ChannelType {
+	case models.TradesChannel:
+		trades, ok := e.Response.(*models.TradesResponse)
+		if !ok || trades == nil {
+			return nil
+		}
+		events := make([]interface{}, len(trades.Trades))
+		for i, t := range trades.Trades {
+			events[i] = &models.TradeResponse{
+				Trade:        t,
+				BaseResponse: trades.BaseResponse,
+			}
+		}
+		return events
+	case models.MarketsChannel:
+		markets, err := MapToMarketData(e.Response)
+		if err != nil {
+			return nil
+		}
+		events := make([]interface{}, 0, len(markets))
+		for _, m := range markets {
+			if m != nil {
+				events = append(events, m)
+			}
+		}
+		return events
+	}
+
+	return []interface{}{e.Response}
+}
+
+// watchOrder registers for updates of the given order on the orders channel.
+// The returned func must be called to unregister.
+func (s *Stream) watchOrder(id int64) (chan *models.Order, func()) {
+
+	c := make(chan *models.Order, 1)
+
+	s.mu.Lock()
+	s.orderWatchers[id] = append(s.orderWatchers[id], c)
+	s.mu.Unlock()
+
+	return c, func() {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		watchers := s.orderWatchers[id]
+		for i, w := range watchers {
+			if w == c {
+				watchers = append(watchers[:i], watchers[i+1:]...)
+				break
+			}
+		}
+		if len(watchers) == 0 {
+			delete(s.orderWatchers, id)
+		} else {
+			s.orderWatchers[id] = watchers
+		}
+	}
+}
+
+func (s *Stream) notifyOrderWatchers(order *models.Order) {
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, c := range s.orderWatchers[order.ID] {
+		o := *order
+		select {
+		case c <- &o:
+		default:
+		}
+	}
+}
+
+func (s *Stream) isSubscribed(ct models.ChannelType) bool {
+	ws := s.findSub(ct, "")
+	return ws != nil && ws.Conn() != nil
+}
+
+// ServeSub connects ws and starts its read, delivery and ping goroutines, which
+// run until ctx is done. On cancellation a close frame is sent and the
+// connection is closed once FTX acknowledges it, or after closeWait, which
+// unblocks the read goroutine.
+func (s *Stream) ServeSub(ctx context.Context, ws *WsSub) (err error) {
+
+	// Serving twice would run two sets of goroutines on ws
+	ws.mu.Lock()
+	if ws.served {
+		ws.mu.Unlock()
+		return errors.WithStack(ErrAlreadyServed)
+	}
+	ws.served, ws.sent = true, len(ws.Requests)
+	ws.mu.Unlock()
+
+	ctx, cancel := s.baseContext(ctx)
+	ws.mu.Lock()
+	ws.cancel = cancel
+	ws.mu.Unlock()
+	if err = ctx.Err(); err != nil {
+		cancel()
+		ws.finish()
+		return errors.WithStack(err)
+	}
+
+	s.mu.Lock()
+	wait, timeout := s.waitForAck, s.wsAckTimeout
+	active, limit := s.subscriptions, s.maxSubscriptions
+	if limit <= 0 || active+len(ws.Requests) <= limit {
+		s.subscriptions += len(ws.Requests)
+	}
+	s.mu.Unlock()
+
+	if limit > 0 && active+len(ws.Requests) > limit {
+		cancel()
+		ws.finish()
+		return errors.Wrapf(ErrTooManySubscriptions,
+			"%d active, %d requested, limit %d", active, len(ws.Requests), limit)
+	}
+
+	if wait && ws.acksC == nil {
+		ws.acksC = make(chan models.WsResponse, len(ws.Requests))
+	}
+
+	if err = s.connect(ws); err != nil {
+		cancel()
+		ws.closeConn()
+		s.mu.Lock()
+		s.subscriptions -= len(ws.Requests)
+		s.mu.Unlock()
+		ws.finish()
+		return errors.WithStack(err)
+	}
+
+	s.mu.Lock()
+	s.Subs = append(s.Subs, ws)
+	s.mu.Unlock()
+
+	go s.deliver(ctx, ws)
+
+	go func() {
+		defer close(ws.readDone)
+		defer ws.closeConn()
+		msg := models.WsResponse{}
+		for {
+			if err := s.readEvent(ctx, ws, &msg); err != nil {
+				if ctx.Err() == nil {
+					s.sendError(errors.Wrap(err, "Read failed, closing connection"))
+				}
+				return
+			}
+		}
+	}()
+
+	go func() {
+
+		defer s.stop(ws)
+
+		for {
+
+			select {
+
+			case <-ctx.Done():
+				return
+
+			case <-ws.readDone:
+				cancel()
+				return
+
+			case <-time.After(s.pingPeriod()):
+
+				// Closing the connection makes the read fail, which
+				// reconnects it unless auto reconnect is off
+				if missed := ws.pongMissed(); missed >= maxMissedPongs {
+					s.client.Logger.Warnf("%d pongs missed, closing connection", missed)
+					if conn := ws.Conn(); conn != nil {
+						conn.Close()
+					}
+					continue
+				}
+
+				s.client.Logger.Debug("PING")
+
+				err := ws.writeJSON(map[string]models.Operation{"op": models.PingOp})
+				if err == nil {
+					ws.pingSent(s.client.now())
+				} else if !errors.Is(err, websocket.ErrCloseSent) {
+					s.client.Logger.Debugf("write ping: %v", err)
+				}
+
+			}
+		}
+	}()
+
+	if wait && len(ws.Requests) > 0 {
+		if err = ws.waitForAcks(ctx, 1, timeout); err != nil {
+			cancel()
+			return err
+		}
+	}
+
+	return nil
+}
+
+// WithContext sets a context all subscriptions made afterwards are served
+// with besides their own, so that they are all closed once it is done or
+// Shutdown is called.
+func (s *Stream) WithContext(ctx context.Context) {
+	ctx, cancel := context.WithCancel(ctx)
+	s.mu.Lock()
+	s.ctx, s.cancel = ctx, cancel
+	s.mu.Unlock()
+}
+
+// Shutdown cancels the context set with WithContext and closes every
+// subscription of the Stream, waiting for their goroutines to return. It
+// returns the first error writing a close frame, if any. Subscribing fails
+// afterwards until WithContext is called again.
+func (s *Stream) Shutdown() error {
+
+	s.mu.Lock()
+	if s.cancel == nil {
+		s.ctx, s.cancel = context.WithCancel(context.Background())
+	}
+	cancel := s.cancel
+	subs := append([]*WsSub(nil), s.Subs...)
+	s.mu.Unlock()
+
+	cancel()
+
+	var err error
+	for _, ws := range subs {
+		if closeErr := ws.Close(); closeErr != nil && err == nil {
+			err = closeErr
+		}
+	}
+
+	return err
+}
+
+// baseContext returns a context derived from ctx which is also done once
+// the context set with WithContext is.
+func (s *Stream) baseContext(ctx context.Context) (context.Context, context.CancelFunc) {
+
+	s.mu.Lock()
+	base := s.ctx
+	s.mu.Unlock()
+
+	ctx, cancel := context.WithCancel(ctx)
+	if base == nil {
+		return ctx, cancel
+	}
+	if base.Err() != nil {
+		cancel()
+		return ctx, cancel
+	}
+
+	go func() {
+		select {
+		case <-base.Done():
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+
+	return ctx, cancel
+}
+
+// readEvent calls getEventResponse, returning a panic raised decoding a
+// malformed frame as an error so that the connection is closed like on any
+// other read failure.
+func (s *Stream) readEvent(ctx context.Context, ws *WsSub, msg *models.WsResponse) (err error) {
+
+	defer func() {
+		if r := recover(); r != nil {
+			err = errors.Errorf("Panic reading %s message: %v", msg.ChannelType, r)
+		}
+	}()
+
+	return s.getEventResponse(ctx, ws, msg)
+}
+
+// stop closes the connection of ws, sending a close frame first unless the
+// reader has already exited, removes ws from Subs and closes EventC once the
+// reader and the delivery goroutine have returned.
+func (s *Stream) stop(ws *WsSub) {
+
+	select {
+	case <-ws.readDone:
+	default:
+		err := ws.writeControl(
+			websocket.CloseMessage,
+			websocket.FormatCloseMessage(websocket.CloseNormalClosure, ""))
+
+		if err != nil {
+			s.client.Logger.Debugf("write close msg: %v", err)
+			if err != websocket.ErrCloseSent {
+				ws.mu.Lock()
+				ws.closeErr = errors.WithStack(err)
+				ws.mu.Unlock()
+			}
+		} else {
+			select {
+			case <-ws.readDone:
+			case <-time.After(closeWait):
+			}
+		}
+	}
+
+	ws.closeConn()
+	<-ws.readDone
+	ws.stopFirstData()
+	s.removeSub(ws)
+	<-ws.deliverDone
+	ws.finishViews()
+	ws.finish()
+}
+
+func (s *Stream) subscribe(
+	ctx context.Context, ct models.ChannelType, opts SubscribeOptions) (*WsSub, error) {
+
+	s.mu.Lock()
+	share := s.shareConnections
+	s.mu.Unlock()
+
+	if share {
+		return s.subscribeShared(ctx, ct, opts)
+	}
+
+	ws := NewWsSub()
+	ws.AppendRequests(ct, opts.Markets...)
+
+	if opts.WaitForAck {
+		ws.acksC = make(chan models.WsResponse, len(ws.Requests))
+	}
+
+	if err := s.ServeSub(ctx, ws); err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	if opts.WaitForAck {
+		s.mu.Lock()
+		timeout := s.wsAckTimeout
+		s.mu.Unlock()
+		if err := ws.waitForAcks(ctx, len(ws.Requests), timeout); err != nil {
+			ws.cancelServe()
+			return nil, errors.WithStack(err)
+		}
+	}
+
+	return ws, nil
+}
+
+// ReadN subscribes to the channel for the symbols, collects the first n
+// events and then unsubscribes and closes the connection. The events
+// received so far are returned along with the error if ctx is done first.
+func (s *Stream) ReadN(
+	ctx context.Context, ct models.ChannelType, n int, symbols ...string,
+) ([]interface{}, error) {
+
+	if n < 1 {
+		return nil, errors.Errorf("Invalid number of events: %d", n)
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	ws, err := s.subscribe(ctx, ct, SubscribeOptions{Markets: symbols})
+	if err != nil {
+		return nil, err
+	}
+
+	events := make([]interface{}, 0, n)
+
+	for len(events) < n {
+		select {
+		case e, ok := <-ws.EventC:
+			if !ok {
+				return events, errors.New("Subscription closed")
+			}
+			events = append(events, e)
+		case <-ctx.Done():
+			return events, ctx.Err()
+		}
+	}
+
+	if err = ws.unsubscribe(); err != nil {
+		s.client.Logger.Debugf("unsubscribe: %v", err)
+	}
+
+	return events, nil
+}
+
+// SubscribeForever subscribes to the channel for the symbols and sends its
+// events on the returned channel until ctx is done or the Stream is shut
+// down, which alone closes it.
+// Failed subscriptions, and subscriptions closed because their connection
+// could not be reconnected, are made again after a backoff starting at the
+// reconnection interval and doubling up to a minute, the errors being sent
+// to Errors().
+func (s *Stream) SubscribeForever(
+	ctx context.Context, ct models.ChannelType, symbols ...string) <-chan interface{} {
+
+	c := make(chan interface{})
+
+	go func() {
+
+		defer close(c)
+
+		ctx, cancel := s.baseContext(ctx)
+		defer cancel()
+
+		for failures := 0; ; failures++ {
+
+			if failures > 0 && !sleep(ctx, s.resubscribeBackoff(failures)) {
+				return
+			}
+
+			ws, err := s.subscribe(ctx, ct, SubscribeOptions{Markets: symbols})
+			if err != nil {
+				if ctx.Err() != nil {
+					return
+				}
+				s.sendError(errors.Wrapf(err, "Subscribe to %s", ct))
+				continue
+			}
+
+			forward(ctx, ws, func(e interface{}) bool {
+				select {
+				case c <- e:
+					failures = 0
+					return true
+				case <-ctx.Done():
+					return false
+				}
+			})
+
+			if err = s.Close(ws); err != nil {
+				s.client.Logger.Debugf("close: %v", err)
+			}
+			if ctx.Err() != nil {
+				return
+			}
+		}
+	}()
+
+	return c
+}
+
+// resubscribeBackoff returns how long SubscribeForever waits after the given
+// number of failures in a row.
+func (s *Stream) resubscribeBackoff(failures int) time.Duration {
+
+	s.mu.Lock()
+	backoff := s.wsReconnectionInterval
+	s.mu.Unlock()
+
+	for i := 1; i < failures && backoff < maxBackoff; i++ {
+		backoff *= 2
+	}
+	if backoff > maxBackoff {
+		backoff = maxBackoff
+	}
+
+	return backoff
+}
+
+// forward calls send with every event of ws until ctx is done, ws is closed
+// or send returns false. The SubscribeTo helpers close the channel they
+// return once it has returned.
+func forward(ctx context.Context, ws *WsSub, send func(e interface{}) bool) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case e, ok := <-ws.EventC:
+			if !ok || !send(e) {
+				return
+			}
+		}
+	}
+}
+
+func (s *Stream) SubscribeToTickers(
+	ctx context.Context, symbols ...string) (chan *models.TickerResponse, error) {
+	return s.SubscribeToTickersWithOptions(ctx, SubscribeOptions{Markets: symbols})
+}
+
+func (s *Stream) SubscribeToTickersWithOptions(
+	ctx context.Context, opts SubscribeOptions) (chan *models.TickerResponse, error) {
+
+	if len(opts.Markets) == 0 {
+		return nil, errors.New("symbols missing")
+	}
+
+	ws, err := s.subscribe(ctx, models.TickerChannel, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	c := make(chan *models.TickerResponse, opts.Buffer)
+	go func() {
+		defer close(c)
+		forward(ctx, ws, func(e interface{}) bool {
+			select {
+			case c <- e.(*models.TickerResponse):
+				return true
+			case <-ctx.Done():
+				return false
+			}
+		})
+	}()
+
+	return c, nil
+}
+
+func (s *Stream) SubscribeToMarkets(ctx context.Context) (chan *models.Market, error) {
+
+	ws, err := s.subscribe(ctx, models.MarketsChannel, SubscribeOptions{})
+	if err != nil {
+		return nil, err
+	}
+
+	c := make(chan *models.Market)
+	go func() {
+		defer close(c)
+		forward(ctx, ws, func(e interface{}) bool {
+			select {
+			case c <- e.(*models.Market):
+				return true
+			case <-ctx.Done():
+				return false
+			}
+		})
+	}()
+
+	return c, nil
+}
+
+func (s *Stream) SubscribeToTrades(
+	ctx context.Context, symbols ...string) (chan *models.TradeResponse, error) {
+	return s.SubscribeToTradesWithOptions(ctx, SubscribeOptions{Markets: symbols})
+}
+
+func (s *Stream) SubscribeToTradesWithOptions(
+	ctx context.Context, opts SubscribeOptions) (chan *models.TradeResponse, error) {
+
+	if len(opts.Markets) == 0 {
 		return nil, errors.New("symbols missing")
 	}
 
-	ct, ws := models.TradesChannel, s.WsSub
+	ws, err := s.subscribe(ctx, models.TradesChannel, opts)
+	if err != nil {
+		return nil, err
+	}
 
-	ws.AppendRequests(ct, symbols...)
+	c := make(chan *models.TradeResponse, opts.Buffer)
+	go func() {
+		defer close(c)
+		forward(ctx, ws, func(e interface{}) bool {
+			select {
+			case c <- e.(*models.TradeResponse):
+				return true
+			case <-ctx.Done():
+				return false
+			}
+		})
+	}()
+
+	return c, nil
+}
+
+// SubscribeToTradesWithBackfill sends the trades of the market since the
+// given time, fetched over REST, followed by the live trades. The market is
+// subscribed to before the backfill is fetched and live trades are held back
+// until it has been sent, so that there is no gap between the two. Trades
+// found in both are sent once. Backfilled trades have no response type.
+func (s *Stream) SubscribeToTradesWithBackfill(
+	ctx context.Context, market string, since time.Time,
+) (chan *models.TradeResponse, error) {
 
-	err := s.Serve(ctx)
+	ctx, cancel := context.WithCancel(ctx)
+
+	live, err := s.SubscribeToTrades(ctx, market)
 	if err != nil {
+		cancel()
 		return nil, err
 	}
 
-	return s.tradesC, nil
+	backfill, err := s.client.Markets.tradesBetween(ctx, market, since, time.Now())
+	if err != nil {
+		cancel()
+		return nil, errors.WithStack(err)
+	}
+
+	c := make(chan *models.TradeResponse)
+
+	go func() {
+
+		defer close(c)
+		defer cancel()
+
+		sent := make(map[int64]struct{}, len(backfill))
+		for _, trade := range backfill {
+			sent[trade.ID] = struct{}{}
+			select {
+			case c <- &models.TradeResponse{
+				Trade:        *trade,
+				BaseResponse: models.BaseResponse{Symbol: market},
+			}:
+			case <-ctx.Done():
+				return
+			}
+		}
+
+		for {
+			select {
+			case trade, ok := <-live:
+				if !ok {
+					return
+				}
+				if _, ok := sent[trade.ID]; ok {
+					continue
+				}
+				select {
+				case c <- trade:
+				case <-ctx.Done():
+					return
+				}
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	return c, nil
 }
 
 func (s *Stream) SubscribeToOrderBooks(
 	ctx context.Context, symbols ...string) (chan *models.OrderBookResponse, error) {
+	return s.SubscribeToOrderBooksWithOptions(ctx, SubscribeOptions{Markets: symbols})
+}
 
-	if len(symbols) == 0 {
+func (s *Stream) SubscribeToOrderBooksWithOptions(
+	ctx context.Context, opts SubscribeOptions) (chan *models.OrderBookResponse, error) {
+
+	if len(opts.Markets) == 0 {
 		return nil, errors.New("symbols is missing")
 	}
 
-	ct, ws := models.OrderBookChannel, s.WsSub
-
-	ws.AppendRequests(ct, symbols...)
-
-	err := s.Serve(ctx)
+	ws, err := s.subscribe(ctx, models.OrderBookChannel, opts)
 	if err != nil {
 		return nil, err
 	}
 
-	return s.booksC, nil
+	c := make(chan *models.OrderBookResponse, opts.Buffer)
+	go func() {
+		defer close(c)
+		forward(ctx, ws, func(e interface{}) bool {
+			select {
+			case c <- e.(*models.OrderBookResponse):
+				return true
+			case <-ctx.Done():
+				return false
+			}
+		})
+	}()
+
+	return c, nil
 }
 
 // TODO: Get fill and order streams to actually work right
 
-func (s *Stream) SubscribeToFills(ctx context.Context) (chan *models.FillResponse, error) {
-
-	ct, ws := models.FillsChannel, s.WsSub
-
-	ws.AppendRequests(ct)
+// SubscribeToFills subscribes to the fills of the account, or only to those
+// of the given markets. Fills of other markets are dropped should FTX send
+// them anyway.
+func (s *Stream) SubscribeToFills(
+	ctx context.Context, symbols ...string) (chan *models.FillResponse, error) {
 
-	err := s.Serve(ctx)
+	ws, err := s.subscribe(ctx, models.FillsChannel, SubscribeOptions{Markets: symbols})
 	if err != nil {
 		return nil, err
 	}
 
-	return s.fillsC, nil
+	markets := ws.ChannelTypes[models.FillsChannel]
+
+	c := make(chan *models.FillResponse)
+	go func() {
+		defer close(c)
+		forward(ctx, ws, func(e interface{}) bool {
+			fill := e.(*models.FillResponse)
+			if _, ok := markets[fill.Market]; len(markets) > 0 && !ok {
+				return true
+			}
+			select {
+			case c <- fill:
+				return true
+			case <-ctx.Done():
+				return false
+			}
+		})
+	}()
+
+	return c, nil
 }
 
 func (s *Stream) SubscribeToOrders(
@@ -508,20 +1745,138 @@ func (s *Stream) SubscribeToOrders(
 		return nil, errors.New("symbols missing")
 	}
 
-	ct, ws := models.OrdersChannel, s.WsSub
-
-	ws.AppendRequests(ct, symbols...)
-
-	err := s.Serve(ctx)
+	ws, err := s.subscribe(ctx, models.OrdersChannel, SubscribeOptions{Markets: symbols})
 	if err != nil {
 		return nil, err
 	}
 
-	return s.ordersC, nil
+	c := make(chan *models.OrdersResponse)
+	go func() {
+		defer close(c)
+		forward(ctx, ws, func(e interface{}) bool {
+			select {
+			case c <- e.(*models.OrdersResponse):
+				return true
+			case <-ctx.Done():
+				return false
+			}
+		})
+	}()
+
+	return c, nil
+}
+
+// Authorize logs in on the connection of WsSub.
+//
+// Deprecated: ServeSub logs in as needed.
+func (s *Stream) Authorize() error {
+	return s.authorize(s.WsSub)
+}
+
+// Connect connects WsSub and sends its requests. The requests passed are
+// ignored.
+//
+// Deprecated: use ServeSub, which connects the WsSub it serves.
+func (s *Stream) Connect(requests ...models.WSRequest) error {
+	return s.connect(s.WsSub)
+}
+
+// CreateNewConnection connects WsSub, closing its previous connection if any.
+//
+// Deprecated: use Dial.
+func (s *Stream) CreateNewConnection() error {
+	return s.Dial(s.WsSub)
+}
+
+// GetEventResponse reads and handles the next message on the connection of
+// WsSub.
+//
+// Deprecated: messages are read by the goroutines started by ServeSub.
+func (s *Stream) GetEventResponse(ctx context.Context, msg *models.WsResponse) error {
+	return s.getEventResponse(ctx, s.WsSub, msg)
+}
+
+// IsLoggedIn reports whether a login was sent on the connection of WsSub.
+//
+// Deprecated: use WsSub.IsLoggedIn.
+func (s *Stream) IsLoggedIn() bool {
+	return s.WsSub.IsLoggedIn()
+}
+
+// Reconnect connects WsSub again and sends its requests.
+//
+// Deprecated: connections are reconnected by ServeSub, see SetAutoReconnect.
+func (s *Stream) Reconnect(ctx context.Context) error {
+	return s.reconnect(ctx, s.WsSub)
+}
+
+// Subscribe sends the requests of WsSub, logging in first if needed.
+//
+// Deprecated: use ServeSub, or WsSub.Resubscribe.
+func (s *Stream) Subscribe() error {
+	return s.sendRequests(s.WsSub)
+}
+
+// SendToChannel sends the events of response on the EventC of WsSub.
+//
+// Deprecated: events are sent on EventC by ServeSub.
+func (s *Stream) SendToChannel(ct models.ChannelType, response interface{}) {
+	for _, e := range splitEvent(wsEvent{ChannelType: ct, Response: response}) {
+		s.WsSub.EventC <- e
+	}
+}
+
+// Serve serves WsSub as ServeSub does. Once WsSub is served, the requests
+// appended to it since are sent on its connection instead.
+//
+// Deprecated: use NewWsSub and ServeSub, or the SubscribeTo methods.
+func (s *Stream) Serve(ctx context.Context) error {
+	err := s.ServeSub(ctx, s.WsSub)
+	if errors.Is(err, ErrAlreadyServed) {
+		return s.sendAppended(s.WsSub)
+	}
+	return err
+}
+
+// sendAppended sends the requests appended to the served ws since they were
+// last sent, logging it in first if they need it.
+func (s *Stream) sendAppended(ws *WsSub) error {
+
+	s.mu.Lock()
+	always := s.alwaysAuthenticate
+	ws.mu.Lock()
+	added := append([]models.WSRequest(nil), ws.Requests[ws.sent:]...)
+	if limit := s.maxSubscriptions; limit > 0 && s.subscriptions+len(added) > limit {
+		ws.mu.Unlock()
+		s.mu.Unlock()
+		return errors.Wrapf(ErrTooManySubscriptions,
+			"%d active, %d requested, limit %d", s.subscriptions, len(added), limit)
+	}
+	ws.sent = len(ws.Requests)
+	s.subscriptions += len(added)
+	ws.mu.Unlock()
+	s.mu.Unlock()
+
+	if (always || ws.isPrivate()) && !ws.IsLoggedIn() {
+		if err := s.authorize(ws); err != nil {
+			return errors.WithStack(err)
+		}
+	}
+
+	for _, r := range added {
+		if err := ws.writeJSON(r); err != nil {
+			return errors.Wrapf(err, "Subscribe to %s", requestName(r))
+		}
+	}
+
+	return nil
 }
 
+// WSConn returns the connection of WsSub.
+//
+// Deprecated: use WsSub.Conn.
 func (s *Stream) WSConn() *websocket.Conn {
-	return s.conn
+	return s.WsSub.Conn()
 }
 
 func MapToMarketData(event interface{}) (map[string]*models.Market, error) {
@@ -543,6 +1898,16 @@ func MapToMarketData(event interface{}) (map[string]*models.Market, error) {
 }
 
 func (ws *WsSub) AppendRequests(ct models.ChannelType, symbols ...string) {
+	ws.AppendRequestsWithOptions(ct, RequestOptions{}, symbols...)
+}
+
+// AppendRequestsWithOptions is AppendRequests with the channel specific
+// fields of opts set on the new requests, for instance:
+//
+//	ws.AppendRequestsWithOptions(
+//		models.OrderBookGroupedChannel, api.RequestOptions{Grouping: 500}, "BTC-PERP")
+func (ws *WsSub) AppendRequestsWithOptions(
+	ct models.ChannelType, opts RequestOptions, symbols ...string) {
 
 	ctypes, tm := ws.ChannelTypes, make(TrivialMap)
 
@@ -557,7 +1922,7 @@ func (ws *WsSub) AppendRequests(ct models.ChannelType, symbols ...string) {
 		}
 
 		ctypes[ct] = tm
-		ws.Requests = append(ws.Requests, MakeRequests(ct, tm)...)
+		ws.Requests = append(ws.Requests, MakeRequestsWithOptions(ct, tm, opts)...)
 
 		return
 	}
@@ -571,6 +1936,447 @@ func (ws *WsSub) AppendRequests(ct models.ChannelType, symbols ...string) {
 	}
 
 	if len(tm) > 0 {
-		ws.Requests = append(ws.Requests, MakeRequests(ct, tm)...)
+		ws.Requests = append(ws.Requests, MakeRequestsWithOptions(ct, tm, opts)...)
+	}
+}
+
+// Subscribe sends the subscription requests of ws on its connection. A
+// connection fails every write after a failed one, so sending stops at the
+// first request which cannot be written. The error is then a *PartialError
+// holding the write error of that request followed by an ErrNotSent for each
+// request left, all naming their channel and market.
+func (ws *WsSub) Subscribe() error {
+
+	ws.mu.Lock()
+	requests := append([]models.WSRequest(nil), ws.Requests...)
+	ws.mu.Unlock()
+
+	for i, r := range requests {
+
+		err := ws.writeJSON(r)
+		if err == nil {
+			continue
+		}
+
+		errs := []error{errors.Wrapf(err, "Subscribe to %s", requestName(r))}
+		for _, unsent := range requests[i+1:] {
+			errs = append(errs, errors.Wrapf(ErrNotSent, "Subscribe to %s", requestName(unsent)))
+		}
+
+		return errors.WithStack(&PartialError{Errors: errs, Parts: len(requests)})
+	}
+
+	return nil
+}
+
+// requestName returns the channel and market of r.
+func requestName(r models.WSRequest) string {
+	if r.Market == "" {
+		return string(r.ChannelType)
+	}
+	return string(r.ChannelType) + " " + r.Market
+}
+
+// ack passes a subscribed or error frame on to waitForAcks if it is waiting.
+func (ws *WsSub) ack(msg *models.WsResponse) {
+	ws.ackViews(msg)
+	if ws.acksC == nil {
+		return
+	}
+	select {
+	case ws.acksC <- *msg:
+	default:
+	}
+}
+
+// waitForAcks waits until n requests of ws have been confirmed, counting
+// those confirmed during earlier waits.
+func (ws *WsSub) waitForAcks(ctx context.Context, n int, timeout time.Duration) error {
+
+	timeoutC := time.After(timeout)
+
+	for ws.acks < n {
+		select {
+		case msg := <-ws.acksC:
+			if msg.ResponseType == models.Error {
+				return errors.Errorf("Subscribe: Code: %d	Error: %s", msg.Code, msg.Message)
+			}
+			ws.acks++
+		case <-timeoutC:
+			return errors.New("Subscribe: no confirmation received")
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+
+	return nil
+}
+
+func (ws *WsSub) unsubscribe() (err error) {
+	// Views are unsubscribed from their host once they are closed
+	if ws.host != nil {
+		return nil
+	}
+	for _, r := range ws.Requests {
+		r.Op = models.UnSubscribe
+		if err = ws.writeJSON(r); err != nil {
+			return
+		}
+	}
+	return nil
+}
+
+// Resubscribe logs in again if ws has a private channel and resends its
+// subscriptions. It is meant for custom reconnection strategies, after the
+// connection of ws has been replaced with Stream.Dial.
+func (ws *WsSub) Resubscribe(s *Stream) error {
+
+	ws.mu.Lock()
+	ws.isLoggedIn = false
+	ws.mu.Unlock()
+
+	return errors.WithStack(s.sendRequests(ws))
+}
+
+// On registers handler for the events of the channel. Once a handler is
+// registered events are no longer sent on EventC but dispatched to the
+// handler of their channel, or dropped if there is none, one at a time from
+// the goroutine delivering the events of the connection.
+func (ws *WsSub) On(channel models.ChannelType, handler func(interface{})) {
+
+	ws.mu.Lock()
+	defer ws.mu.Unlock()
+
+	if ws.handlers == nil {
+		ws.handlers = make(map[models.ChannelType]func(interface{}))
+	}
+	ws.handlers[channel] = handler
+}
+
+// handler returns the handler of the channel, ok being false if events are
+// to be sent on EventC.
+func (ws *WsSub) handler(channel models.ChannelType) (handler func(interface{}), ok bool) {
+	ws.mu.Lock()
+	defer ws.mu.Unlock()
+	if ws.handlers == nil {
+		return nil, false
+	}
+	return ws.handlers[channel], true
+}
+
+// Tap returns a channel receiving the events of ws as well, for another
+// consumer of the same connection. It holds up to buffer events and drops
+// the events it has no room for so that a slow tap never holds up the
+// others. EventC, or the handlers registered with On, must still be read.
+// The channel is closed along with EventC.
+func (ws *WsSub) Tap(buffer int) <-chan interface{} {
+
+	ws.mu.Lock()
+	defer ws.mu.Unlock()
+
+	tap := make(chan interface{}, buffer)
+	if ws.tapsClosed {
+		close(tap)
+		return tap
+	}
+	ws.taps = append(ws.taps, tap)
+
+	return tap
+}
+
+// finish closes EventC and the taps once nothing sends on them anymore.
+func (ws *WsSub) finish() {
+	ws.closeOnce.Do(func() {
+		ws.mu.Lock()
+		for _, tap := range ws.taps {
+			close(tap)
+		}
+		ws.taps, ws.tapsClosed = nil, true
+		ws.mu.Unlock()
+		close(ws.EventC)
+		close(ws.closed)
+	})
+}
+
+// Close sends a close frame on the connection of ws, closes the connection
+// and, once the goroutines serving ws have returned, closes EventC so that
+// ranging over it ends. It returns the error writing the close frame, if
+// any. Closing a closed subscription does nothing and returns nil, and
+// closing one which was never served only closes EventC.
+func (ws *WsSub) Close() error {
+
+	if !ws.cancelServe() {
+		ws.finish()
+		return nil
+	}
+
+	<-ws.closed
+
+	ws.mu.Lock()
+	defer ws.mu.Unlock()
+
+	err := ws.closeErr
+	ws.closeErr = nil
+
+	return err
+}
+
+// cancelServe cancels the context ws is served with, reporting false if ws
+// was never served.
+func (ws *WsSub) cancelServe() bool {
+
+	ws.mu.Lock()
+	cancel := ws.cancel
+	ws.mu.Unlock()
+
+	if cancel == nil {
+		return false
+	}
+	cancel()
+
+	return true
+}
+
+// Subscriptions returns the number of channel and market subscriptions on
+// the connection of ws.
+func (ws *WsSub) Subscriptions() int {
+	ws.mu.Lock()
+	defer ws.mu.Unlock()
+	return len(ws.Requests)
+}
+
+func (ws *WsSub) isPrivate() bool {
+	ws.mu.Lock()
+	defer ws.mu.Unlock()
+	return ws.ChannelTypes[models.FillsChannel] != nil ||
+		ws.ChannelTypes[models.OrdersChannel] != nil
+}
+
+func (ws *WsSub) Conn() *websocket.Conn {
+	ws.mu.Lock()
+	defer ws.mu.Unlock()
+	return ws.conn
+}
+
+func (ws *WsSub) IsLoggedIn() bool {
+	ws.mu.Lock()
+	defer ws.mu.Unlock()
+	return ws.isLoggedIn
+}
+
+func (ws *WsSub) setConn(conn *websocket.Conn) {
+
+	ws.mu.Lock()
+	defer ws.mu.Unlock()
+
+	if ws.conn != nil {
+		ws.conn.Close()
+	}
+	ws.conn = conn
+	ws.isLoggedIn, ws.authenticated, ws.loginPending = false, false, false
+	ws.pingSentAt, ws.missedPongs = time.Time{}, 0
+}
+
+func (ws *WsSub) messageReceived() {
+	ws.mu.Lock()
+	ws.lastMessageAt = time.Now()
+	ws.mu.Unlock()
+}
+
+func (ws *WsSub) closeConn() {
+	ws.mu.Lock()
+	if ws.conn != nil {
+		ws.conn.Close()
+	}
+	ws.mu.Unlock()
+}
+
+func (ws *WsSub) writeJSON(v interface{}) error {
+
+	if ws.host != nil {
+		return ws.host.writeJSON(v)
+	}
+
+	ws.mu.Lock()
+	defer ws.mu.Unlock()
+
+	if err := ws.conn.SetWriteDeadline(time.Now().Add(writeWait)); err != nil {
+		return errors.WithStack(err)
+	}
+
+	return errors.WithStack(ws.conn.WriteJSON(v))
+}
+
+func (ws *WsSub) writeControl(messageType int, data []byte) error {
+	ws.mu.Lock()
+	defer ws.mu.Unlock()
+	return ws.conn.WriteControl(messageType, data, time.Now().Add(writeWait))
+}
+
+// Authenticated reports whether the login on the connection has been
+// accepted. FTX does not acknowledge a successful login so it is assumed
+// once private channel data arrives or no auth error has been received
+// within a short window after the login was sent.
+func (ws *WsSub) Authenticated() bool {
+
+	ws.mu.Lock()
+	defer ws.mu.Unlock()
+
+	if ws.loginPending && time.Since(ws.loginSentAt) > authConfirmWindow {
+		ws.authenticated, ws.loginPending = true, false
+	}
+
+	return ws.authenticated
+}
+
+func (ws *WsSub) loginSent() {
+	ws.mu.Lock()
+	ws.isLoggedIn = true
+	ws.authenticated, ws.loginPending, ws.loginSentAt = false, true, time.Now()
+	ws.mu.Unlock()
+}
+
+func (ws *WsSub) setAuthenticated(authenticated bool) {
+	ws.mu.Lock()
+	ws.authenticated, ws.loginPending = authenticated, false
+	ws.mu.Unlock()
+}
+
+// LastPingRTT returns the round trip time of the last answered ping, zero if
+// none has been answered yet.
+func (ws *WsSub) LastPingRTT() time.Duration {
+	ws.mu.Lock()
+	defer ws.mu.Unlock()
+	return ws.lastPingRTT
+}
+
+// AvgPingRTT returns the average round trip time of the last 20 answered
+// pings, zero if none has been answered yet.
+func (ws *WsSub) AvgPingRTT() time.Duration {
+
+	ws.mu.Lock()
+	defer ws.mu.Unlock()
+
+	if len(ws.pingRTTs) == 0 {
+		return 0
+	}
+
+	var total time.Duration
+	for _, rtt := range ws.pingRTTs {
+		total += rtt
+	}
+
+	return total / time.Duration(len(ws.pingRTTs))
+}
+
+func (ws *WsSub) pingSent(now time.Time) {
+	ws.mu.Lock()
+	ws.pingSentAt = now
+	ws.mu.Unlock()
+}
+
+// awaitFirstData sends ErrNoData to Errors() unless an event of the channel
+// for the market is read within the first data timeout.
+func (s *Stream) awaitFirstData(ws *WsSub, ct models.ChannelType, market string) {
+
+	s.mu.Lock()
+	timeout := s.firstDataTimeout
+	s.mu.Unlock()
+
+	if timeout <= 0 {
+		return
+	}
+
+	key := models.WSRequest{ChannelType: ct, Market: market}
+
+	ws.mu.Lock()
+	defer ws.mu.Unlock()
+
+	if ws.firstData == nil {
+		ws.firstData = make(map[models.WSRequest]*time.Timer)
+	}
+	if timer, ok := ws.firstData[key]; ok {
+		timer.Stop()
 	}
+
+	var timer *time.Timer
+	timer = time.AfterFunc(timeout, func() {
+		ws.mu.Lock()
+		pending := ws.firstData[key] == timer
+		if pending {
+			delete(ws.firstData, key)
+		}
+		ws.mu.Unlock()
+		if pending {
+			s.sendError(errors.Wrapf(ErrNoData, "%s %s within %v", ct, market, timeout))
+		}
+	})
+	ws.firstData[key] = timer
+}
+
+func (ws *WsSub) firstDataReceived(ct models.ChannelType, market string) {
+
+	ws.mu.Lock()
+	defer ws.mu.Unlock()
+
+	if len(ws.firstData) == 0 {
+		return
+	}
+
+	key := models.WSRequest{ChannelType: ct, Market: market}
+	if timer, ok := ws.firstData[key]; ok {
+		timer.Stop()
+		delete(ws.firstData, key)
+	}
+}
+
+func (ws *WsSub) stopFirstData() {
+
+	ws.mu.Lock()
+	defer ws.mu.Unlock()
+
+	for key, timer := range ws.firstData {
+		timer.Stop()
+		delete(ws.firstData, key)
+	}
+}
+
+// pongMissed counts the outstanding ping, if any, as unanswered and returns
+// how many pings in a row have gone unanswered.
+func (ws *WsSub) pongMissed() int {
+
+	ws.mu.Lock()
+	defer ws.mu.Unlock()
+
+	if !ws.pingSentAt.IsZero() {
+		ws.missedPongs++
+		ws.pingSentAt = time.Time{}
+	}
+
+	return ws.missedPongs
+}
+
+// pongReceived records the round trip time of the outstanding ping, if any,
+// the pong being received at now.
+func (ws *WsSub) pongReceived(now time.Time) (time.Duration, bool) {
+
+	ws.mu.Lock()
+	defer ws.mu.Unlock()
+
+	ws.missedPongs = 0
+
+	if ws.pingSentAt.IsZero() {
+		return 0, false
+	}
+
+	rtt := now.Sub(ws.pingSentAt)
+	ws.pingSentAt = time.Time{}
+	ws.lastPingRTT = rtt
+
+	if len(ws.pingRTTs) == pingRTTWindow {
+		ws.pingRTTs = ws.pingRTTs[1:]
+	}
+	ws.pingRTTs = append(ws.pingRTTs, rtt)
+
+	return rtt, true
 }
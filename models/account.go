@@ -1,23 +1,33 @@
 package models
 
-import "github.com/shopspring/decimal"
+import (
+	"time"
 
+	"github.com/shopspring/decimal"
+)
+
+// AccountInformation is the account as returned by FTX. PositionLimit and
+// PositionLimitUsed are nil when the account has no position limit.
 type AccountInformation struct {
-	BackstopProvider             bool            `json:"backstopProvider"`
-	Collateral                   decimal.Decimal `json:"collateral"`
-	FreeCollateral               decimal.Decimal `json:"freeCollateral"`
-	InitialMarginRequirement     decimal.Decimal `json:"initialMarginRequirement"`
-	Liquidating                  bool            `json:"liquidating"`
-	MaintenanceMarginRequirement decimal.Decimal `json:"maintenanceMarginRequirement"`
-	MakerFee                     decimal.Decimal `json:"makerFee"`
-	MarginFraction               decimal.Decimal `json:"marginFraction"`
-	OpenMarginFraction           decimal.Decimal `json:"openMarginFraction"`
-	TakerFee                     decimal.Decimal `json:"takerFee"`
-	TotalAccountValue            decimal.Decimal `json:"totalAccountValue"`
-	TotalPositionSize            decimal.Decimal `json:"totalPositionSize"`
-	Username                     string          `json:"username"`
-	Leverage                     decimal.Decimal `json:"leverage"`
-	Positions                    []Position      `json:"positions"`
+	BackstopProvider             bool             `json:"backstopProvider"`
+	Collateral                   decimal.Decimal  `json:"collateral"`
+	FreeCollateral               decimal.Decimal  `json:"freeCollateral"`
+	InitialMarginRequirement     decimal.Decimal  `json:"initialMarginRequirement"`
+	Liquidating                  bool             `json:"liquidating"`
+	MaintenanceMarginRequirement decimal.Decimal  `json:"maintenanceMarginRequirement"`
+	MakerFee                     decimal.Decimal  `json:"makerFee"`
+	MarginFraction               decimal.Decimal  `json:"marginFraction"`
+	OpenMarginFraction           decimal.Decimal  `json:"openMarginFraction"`
+	PositionLimit                *decimal.Decimal `json:"positionLimit"`
+	PositionLimitUsed            *decimal.Decimal `json:"positionLimitUsed"`
+	SpotLendingEnabled           bool             `json:"spotLendingEnabled"`
+	SpotMarginEnabled            bool             `json:"spotMarginEnabled"`
+	TakerFee                     decimal.Decimal  `json:"takerFee"`
+	TotalAccountValue            decimal.Decimal  `json:"totalAccountValue"`
+	TotalPositionSize            decimal.Decimal  `json:"totalPositionSize"`
+	Username                     string           `json:"username"`
+	Leverage                     decimal.Decimal  `json:"leverage"`
+	Positions                    []Position       `json:"positions"`
 }
 
 type Position struct {
@@ -37,3 +47,53 @@ type Position struct {
 	UnrealizedPnl                decimal.Decimal `json:"unrealizedPnl"`
 	CollateralUsed               decimal.Decimal `json:"collateralUsed"`
 }
+
+// TrackedPosition is the position in a future kept by a PositionTracker.
+// NetSize is negative for a short position, EntryPrice is its average entry
+// price and Time that of the last fill applied to it.
+type TrackedPosition struct {
+	Future      string
+	NetSize     decimal.Decimal
+	EntryPrice  decimal.Decimal
+	RealizedPnl decimal.Decimal
+	Time        time.Time
+}
+
+// Fees are the fee rates of the account, as fractions of the notional.
+type Fees struct {
+	MakerFee decimal.Decimal
+	TakerFee decimal.Decimal
+}
+
+// AccountState is a snapshot of the account, its positions and its open
+// orders taken at Time, the parts which could not be fetched being nil.
+type AccountState struct {
+	Time          time.Time
+	Account       *AccountInformation
+	Positions     []*Position
+	OpenOrders    []*Order
+	TriggerOrders []*TriggerOrder
+}
+
+// PortfolioSummary aggregates the account and its open positions valued at
+// the current marks.
+type PortfolioSummary struct {
+	Time              time.Time
+	TotalAccountValue decimal.Decimal
+	Collateral        decimal.Decimal
+	FreeCollateral    decimal.Decimal
+	MarginFraction    decimal.Decimal
+	UnrealizedPnl     decimal.Decimal
+	// Notional is the sum of the absolute position values.
+	Notional  decimal.Decimal
+	Positions []PositionSummary
+}
+
+type PositionSummary struct {
+	Future        string
+	NetSize       decimal.Decimal
+	EntryPrice    decimal.Decimal
+	Mark          decimal.Decimal
+	Notional      decimal.Decimal
+	UnrealizedPnl decimal.Decimal
+}
@@ -8,6 +8,7 @@ type ConvertQuoteStatus struct {
 	BaseCoin  string          `json:"baseCoin"`
 	Cost      decimal.Decimal `json:"cost"`
 	Expired   bool            `json:"expired"`
+	Expiry    FTXTime         `json:"expiry"`
 	Filled    bool            `json:"filled"`
 	FromCoin  string          `json:"fromCoin"`
 	ID        int64           `json:"id"`
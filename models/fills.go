@@ -16,9 +16,9 @@ type FillParams struct {
 }
 
 type Fill struct {
-	Fee           float64         `json:"fee"`
+	Fee           decimal.Decimal `json:"fee"`
 	FeeCurrency   string          `json:"feeCurrency"`
-	FeeRate       float64         `json:"feeRate"`
+	FeeRate       decimal.Decimal `json:"feeRate"`
 	Future        string          `json:"future"`
 	ID            int64           `json:"id"`
 	Liquidity     string          `json:"liquidity"`
@@ -33,3 +33,15 @@ type Fill struct {
 	Time          time.Time       `json:"time"`
 	Type          string          `json:"type"`
 }
+
+// Settlement is the settlement of a position in a dated future at its expiry.
+// Size is negative for a short position and PnL is that of the settlement
+// alone, fees excluded.
+type Settlement struct {
+	Future      string          `json:"future"`
+	Time        time.Time       `json:"time"`
+	Price       decimal.Decimal `json:"price"`
+	Size        decimal.Decimal `json:"size"`
+	AverageCost decimal.Decimal `json:"averageCost"`
+	PnL         decimal.Decimal `json:"pnl"`
+}
@@ -44,9 +44,19 @@ type FutureStats struct {
 	OpenInterest             float64         `json:"openInterest"`
 }
 
+// FutureStatsPoint is one point of a future's stats history. FTX keeps no
+// history of open interest so Stats is only set on points sampled live, while
+// FundingRate is set on points taken from the realised funding rates.
+type FutureStatsPoint struct {
+	Time        time.Time
+	FundingRate *float64
+	Stats       *FutureStats
+}
+
 type FundingRatesParams struct {
-	StartTime *int64 `json:"start_time,omitempty"`
-	EndTime   *int64 `json:"end_time,omitempty"`
+	Future    *string `json:"future,omitempty"`
+	StartTime *int64  `json:"start_time,omitempty"`
+	EndTime   *int64  `json:"end_time,omitempty"`
 }
 
 type FundingRates struct {
@@ -55,6 +65,28 @@ type FundingRates struct {
 	Time   time.Time `json:"time"`
 }
 
+// FundingRate is the last funding rate of a perpetual future along with the
+// predicted rate for the next funding.
+type FundingRate struct {
+	Future          string    `json:"future"`
+	Rate            float64   `json:"rate"`
+	Time            time.Time `json:"time"`
+	NextFundingRate float64   `json:"nextFundingRate"`
+	NextFundingTime time.Time `json:"nextFundingTime"`
+}
+
+// FundingEstimate is the next funding rate of a perpetual future. Provided is
+// the rate FTX predicts, nil if it sent none, and Computed the rate implied
+// by the current premium of the mark over the index. Rate is Provided if set
+// and Computed otherwise.
+type FundingEstimate struct {
+	Future          string
+	Rate            decimal.Decimal
+	Provided        *decimal.Decimal
+	Computed        decimal.Decimal
+	NextFundingTime time.Time
+}
+
 type FutureExpired struct {
 	Ask                   decimal.Decimal `json:"ask"`
 	Bid                   decimal.Decimal `json:"bid"`
@@ -1,32 +1,155 @@
 package models
 
 import (
+	"hash/crc32"
+	"math"
+	"strconv"
+	"strings"
 	"time"
 
 	"github.com/shopspring/decimal"
 )
 
+type MarketType string
+
+// Types of Market
+const (
+	SpotMarket   = MarketType("spot")
+	FutureMarket = MarketType("future")
+)
+
 type Market struct {
 	Name           string          `json:"name"`
 	Underlying     string          `json:"underlying"`
 	BaseCurrency   string          `json:"baseCurrency"`
 	QuoteCurrency  string          `json:"quoteCurrency"`
-	Type           string          `json:"type"`
+	Type           MarketType      `json:"type"`
 	Enabled        bool            `json:"enabled"`
 	Ask            decimal.Decimal `json:"ask"`
 	Bid            decimal.Decimal `json:"bid"`
 	Last           decimal.Decimal `json:"last"`
+	Price          decimal.Decimal `json:"price"`
 	PostOnly       bool            `json:"postOnly"`
 	PriceIncrement decimal.Decimal `json:"priceIncrement"`
 	SizeIncrement  decimal.Decimal `json:"sizeIncrement"`
 	Restricted     bool            `json:"restricted"`
+	Change1h       decimal.Decimal `json:"change1h"`
+	Change24h      decimal.Decimal `json:"change24h"`
+	ChangeBod      decimal.Decimal `json:"changeBod"`
+	QuoteVolume24h decimal.Decimal `json:"quoteVolume24h"`
+	VolumeUsd24h   decimal.Decimal `json:"volumeUsd24h"`
+}
+
+// IsSpot reports whether m is a spot market. Markets without a type, such as
+// those only known by name, are classified by name, spot markets being named
+// BASE/QUOTE and futures UNDERLYING-EXPIRY.
+func (m *Market) IsSpot() bool {
+	if m.Type != "" {
+		return m.Type == SpotMarket
+	}
+	return strings.Contains(m.Name, "/")
+}
+
+// IsFuture reports whether m is a future, perpetual or dated, classifying
+// markets without a type by name as IsSpot does.
+func (m *Market) IsFuture() bool {
+	if m.Type != "" {
+		return m.Type == FutureMarket
+	}
+	return strings.Contains(m.Name, "-") && !strings.Contains(m.Name, "/")
+}
+
+// IsPerpetual reports whether m is a perpetual future, which FTX names after
+// the underlying with a -PERP suffix.
+func (m *Market) IsPerpetual() bool {
+	return m.IsFuture() && strings.HasSuffix(m.Name, "-PERP")
+}
+
+// IsMove reports whether m is a MOVE contract, such as BTC-MOVE-1231 or
+// BTC-MOVE-WK-0101, which settles to the absolute move of the underlying.
+func (m *Market) IsMove() bool {
+	return m.IsFuture() && strings.Contains(m.Name, "-MOVE-")
+}
+
+// BaseAsset returns the asset m trades: the base currency of a spot market
+// and the underlying of a future, taken from the name if not set, so BTC for
+// both BTC/USD and BTC-MOVE-1231. The Underlying field is empty for spot
+// markets, hence the separate name.
+func (m *Market) BaseAsset() string {
+
+	if m.IsSpot() {
+		if m.BaseCurrency != "" {
+			return m.BaseCurrency
+		}
+		return strings.SplitN(m.Name, "/", 2)[0]
+	}
+
+	if m.Underlying != "" {
+		return m.Underlying
+	}
+	return strings.SplitN(m.Name, "-", 2)[0]
+}
+
+// RoundPrice rounds p to the nearest multiple of the price increment, half
+// away from zero. Prices are returned as is by markets without an increment.
+func (m *Market) RoundPrice(p decimal.Decimal) decimal.Decimal {
+	return toIncrement(p, m.PriceIncrement, decimal.Decimal.Round)
+}
+
+// FloorPrice rounds p down to a multiple of the price increment.
+func (m *Market) FloorPrice(p decimal.Decimal) decimal.Decimal {
+	return toIncrement(p, m.PriceIncrement, floor)
+}
+
+// CeilPrice rounds p up to a multiple of the price increment.
+func (m *Market) CeilPrice(p decimal.Decimal) decimal.Decimal {
+	return toIncrement(p, m.PriceIncrement, ceil)
+}
+
+// RoundSize rounds s to the nearest multiple of the size increment, half
+// away from zero.
+func (m *Market) RoundSize(s decimal.Decimal) decimal.Decimal {
+	return toIncrement(s, m.SizeIncrement, decimal.Decimal.Round)
+}
+
+// FloorSize rounds s down to a multiple of the size increment, which never
+// sizes an order above what was intended.
+func (m *Market) FloorSize(s decimal.Decimal) decimal.Decimal {
+	return toIncrement(s, m.SizeIncrement, floor)
+}
+
+// CeilSize rounds s up to a multiple of the size increment.
+func (m *Market) CeilSize(s decimal.Decimal) decimal.Decimal {
+	return toIncrement(s, m.SizeIncrement, ceil)
+}
+
+func toIncrement(
+	v, increment decimal.Decimal, round func(decimal.Decimal, int32) decimal.Decimal,
+) decimal.Decimal {
+	if increment.Sign() <= 0 {
+		return v
+	}
+	return round(v.Div(increment), 0).Mul(increment)
+}
+
+func floor(d decimal.Decimal, _ int32) decimal.Decimal { return d.Floor() }
+
+func ceil(d decimal.Decimal, _ int32) decimal.Decimal { return d.Ceil() }
+
+type MarketStats struct {
+	Name         string          `json:"name"`
+	Last         decimal.Decimal `json:"last"`
+	Change24h    decimal.Decimal `json:"change24h"`
+	High24h      decimal.Decimal `json:"high24h"`
+	Low24h       decimal.Decimal `json:"low24h"`
+	VolumeUsd24h decimal.Decimal `json:"volumeUsd24h"`
 }
 
 // The bids and asks are formatted like so:
 // [[best price, size at price], [next next best price, size at price], ...]
 //
 // Checksum
-// Every message contains a signed 32-bit integer checksum of the orderbook.
+// Every message contains an unsigned 32-bit integer checksum of the orderbook.
 // You can run the same checksum on your client orderbook state and compare it to checksum field.
 // If they are the same, your client's state is correct.
 // If not, you have likely lost or mishandled a packet and should re-subscribe to receive the initial snapshot.
@@ -50,13 +173,62 @@ type Market struct {
 //
 // The final checksum is the crc32 value of this string.
 
+// Action is Partial for a full snapshot, which replaces the book, and Update
+// for a diff where a level of size 0 is removed. It is empty for books
+// fetched over REST.
 type OrderBook struct {
+	Action   ResponseType        `json:"action,omitempty"`
 	Asks     [][]decimal.Decimal `json:"asks"`
 	Bids     [][]decimal.Decimal `json:"bids"`
-	Checksum int64               `json:"checksum,omitempty"`
+	Checksum uint32              `json:"checksum,omitempty"`
 	Time     FTXTime             `json:"time"`
 }
 
+// checksumDepth is the number of levels of each side in the checksum.
+const checksumDepth = 100
+
+// ComputeOrderBookChecksum returns the checksum FTX sends with order book
+// messages for a book with the given bids, best first, and asks, best first.
+// Only the first 100 levels of each side are used.
+func ComputeOrderBookChecksum(bids, asks []PriceLevel) uint32 {
+
+	var sb strings.Builder
+
+	for i := 0; i < checksumDepth; i++ {
+		for _, levels := range [][]PriceLevel{bids, asks} {
+			if i >= len(levels) {
+				continue
+			}
+			if sb.Len() > 0 {
+				sb.WriteByte(':')
+			}
+			sb.WriteString(formatChecksumFloat(levels[i].Price))
+			sb.WriteByte(':')
+			sb.WriteString(formatChecksumFloat(levels[i].Size))
+		}
+	}
+
+	return crc32.ChecksumIEEE([]byte(sb.String()))
+}
+
+// formatChecksumFloat formats like Python's str(float) which FTX uses
+// server side, e.g. 10 -> "10.0" and 0.00001 -> "1e-05".
+func formatChecksumFloat(d decimal.Decimal) string {
+
+	f, _ := d.Float64()
+
+	if abs := math.Abs(f); f != 0 && (abs < 1e-4 || abs >= 1e16) {
+		return strconv.FormatFloat(f, 'e', -1, 64)
+	}
+
+	s := strconv.FormatFloat(f, 'f', -1, 64)
+	if !strings.Contains(s, ".") {
+		s += ".0"
+	}
+
+	return s
+}
+
 type Trade struct {
 	ID          int64           `json:"id"`
 	Liquidation bool            `json:"liquidation"`
@@ -75,6 +247,34 @@ type HistoricalPrice struct {
 	Volume    decimal.Decimal `json:"volume"`
 }
 
+// Candle is a bar of a market built from its trades.
+type Candle struct {
+	Market string
+	HistoricalPrice
+}
+
+type MarketUpdateKind string
+
+const (
+	TickerUpdate MarketUpdateKind = "ticker"
+	TradeUpdate  MarketUpdateKind = "trade"
+	BookUpdate   MarketUpdateKind = "book"
+)
+
+// MarketUpdate is an event of a market data feed along with the top of the
+// book at that point. Ticker or Trade is set depending on Kind.
+type MarketUpdate struct {
+	Market      string
+	Kind        MarketUpdateKind
+	Time        time.Time
+	Ticker      *Ticker
+	Trade       *Trade
+	BestBid     decimal.Decimal
+	BestBidSize decimal.Decimal
+	BestAsk     decimal.Decimal
+	BestAskSize decimal.Decimal
+}
+
 type Ticker struct {
 	Bid     decimal.Decimal `json:"bid"`
 	Ask     decimal.Decimal `json:"ask"`
@@ -1,6 +1,7 @@
 package models
 
 import (
+	"encoding/json"
 	"time"
 
 	"github.com/shopspring/decimal"
@@ -54,12 +55,17 @@ type OptionQuoteRequestParams struct {
 	CounterpartyID *int64           `json:"counterpartyId,omitempty"`
 }
 
-type CreateQuoteRequest struct {
-	OptionInput
-	Side OrderSide       `json:"side"`
-	Size decimal.Decimal `json:"size"`
+// MarshalJSON fails on an unknown side.
+func (p OptionQuoteRequestParams) MarshalJSON() ([]byte, error) {
+	if err := checkSideAndType(p.Side, nil); err != nil {
+		return nil, err
+	}
+	type params OptionQuoteRequestParams
+	return json.Marshal(params(p))
 }
 
+type CreateQuoteRequest OptionQuoteRequest
+
 type CancelQuoteRequest OptionQuoteRequest
 
 type QuotesForOptionQuoteRequest struct {
@@ -1,6 +1,8 @@
 package models
 
 import (
+	"encoding/json"
+	"fmt"
 	"time"
 
 	"github.com/shopspring/decimal"
@@ -62,44 +64,89 @@ type OpenTriggerOrdersParams struct {
 }
 
 type Trigger struct {
-	Error      string    `json:"error"`
-	FilledSize float64   `json:"filledSize"`
-	OrderSize  float64   `json:"orderSize"`
-	OrderID    int64     `json:"orderId"`
-	Time       time.Time `json:"time"`
+	Error      string          `json:"error"`
+	FilledSize decimal.Decimal `json:"filledSize"`
+	OrderSize  decimal.Decimal `json:"orderSize"`
+	OrderID    int64           `json:"orderId"`
+	Time       time.Time       `json:"time"`
 }
 
 type TriggerOrdersHistoryParams struct {
-	Market    *string `json:"market"`
-	Limit     *int    `json:"limit"`
-	StartTime *int    `json:"start_time"`
-	EndTime   *int    `json:"end_time"`
-	Side      *string `json:"side"`
-	Type      *string `json:"type"`
-	OrderType *string `json:"orderType"`
+	Market    *string           `json:"market"`
+	Limit     *int              `json:"limit"`
+	StartTime *int64            `json:"start_time"`
+	EndTime   *int64            `json:"end_time"`
+	Side      *OrderSide        `json:"side"`
+	Type      *TriggerOrderType `json:"type"`
+	OrderType *OrderType        `json:"orderType"`
 }
 
 type OrderParams struct {
-	Market     *string          `json:"market"`
-	Side       *string          `json:"side"`
-	Price      *decimal.Decimal `json:"price"`
-	Type       *string          `json:"type"`
-	Size       *decimal.Decimal `json:"size"`
-	ReduceOnly *bool            `json:"reduceOnly,omitempty"`
-	IOC        *bool            `json:"ioc,omitempty"`
-	PostOnly   *bool            `json:"postOnly,omitempty"`
-	ClientID   *string          `json:"clientId,omitempty"`
+	Market                  *string          `json:"market"`
+	Side                    *OrderSide       `json:"side"`
+	Price                   *decimal.Decimal `json:"price"`
+	Type                    *OrderType       `json:"type"`
+	Size                    *decimal.Decimal `json:"size"`
+	ReduceOnly              *bool            `json:"reduceOnly,omitempty"`
+	IOC                     *bool            `json:"ioc,omitempty"`
+	PostOnly                *bool            `json:"postOnly,omitempty"`
+	ClientID                *string          `json:"clientId,omitempty"`
+	RejectOnPriceBand       *bool            `json:"rejectOnPriceBand,omitempty"`
+	ExternalReferralProgram *string          `json:"externalReferralProgram,omitempty"`
+}
+
+// MarshalJSON fails on an unknown side or order type.
+func (p OrderParams) MarshalJSON() ([]byte, error) {
+	if err := checkSideAndType(p.Side, p.Type); err != nil {
+		return nil, err
+	}
+	type params OrderParams
+	return json.Marshal(params(p))
+}
+
+// OrderRejectedError is returned when FTX rejects an order, Message being
+// the reason it gave, such as "Size too small", and Params the order sent.
+// Err is the error the request failed with, which wraps the API error.
+type OrderRejectedError struct {
+	Message    string
+	StatusCode int
+	Params     *OrderParams
+	Err        error
+}
+
+func (e *OrderRejectedError) Error() string {
+	order := "Order"
+	if e.Params != nil && e.Params.Market != nil {
+		order += " " + *e.Params.Market
+	}
+	if e.Err == nil {
+		return fmt.Sprintf("%s rejected: Status Code: %d	Error: %s", order, e.StatusCode, e.Message)
+	}
+	return fmt.Sprintf("%s rejected: %v", order, e.Err)
+}
+
+func (e *OrderRejectedError) Unwrap() error {
+	return e.Err
 }
 
 type TriggerOrderParams struct {
-	Market       *string          `json:"market"`
-	Side         *string          `json:"side"`
-	Size         *decimal.Decimal `json:"size"`
-	Type         *string          `json:"type"`
-	TriggerPrice *decimal.Decimal `json:"triggerPrice"`
-	OrderPrice   *decimal.Decimal `json:"orderPrice"`
-	ReduceOnly   *bool            `json:"reduceOnly,omitempty"`
-	TrailValue   *decimal.Decimal `json:"trailValue,omitempty"`
+	Market       *string           `json:"market"`
+	Side         *OrderSide        `json:"side"`
+	Size         *decimal.Decimal  `json:"size"`
+	Type         *TriggerOrderType `json:"type"`
+	TriggerPrice *decimal.Decimal  `json:"triggerPrice"`
+	OrderPrice   *decimal.Decimal  `json:"orderPrice"`
+	ReduceOnly   *bool             `json:"reduceOnly,omitempty"`
+	TrailValue   *decimal.Decimal  `json:"trailValue,omitempty"`
+}
+
+// MarshalJSON fails on an unknown side.
+func (p TriggerOrderParams) MarshalJSON() ([]byte, error) {
+	if err := checkSideAndType(p.Side, nil); err != nil {
+		return nil, err
+	}
+	type params TriggerOrderParams
+	return json.Marshal(params(p))
 }
 
 type ModifyOrderParams struct {
@@ -124,3 +171,11 @@ type CancelAllParams struct {
 	ConditionalOrdersOnly *bool   `json:"conditionalOrdersOnly,omitempty"`
 	LimitOrdersOnly       *bool   `json:"limitOrdersOnly"`
 }
+
+// CancelAllResult is the message FTX acknowledges a cancel all with and, if
+// requested, the IDs of the orders which were open when it was sent.
+type CancelAllResult struct {
+	Message         string
+	OrderIDs        []int64
+	TriggerOrderIDs []int64
+}
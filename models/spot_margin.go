@@ -37,6 +37,20 @@ type BorrowHistory struct {
 
 type LendingHistory BorrowHistory
 
+// SpotMarginHistory is the hourly borrowing across all users of a coin.
+type SpotMarginHistory struct {
+	Coin string          `json:"coin"`
+	Rate float64         `json:"rate"`
+	Size decimal.Decimal `json:"size"`
+	Time time.Time       `json:"time"`
+}
+
+type SpotMarginHistoryParams struct {
+	Coin      *string `json:"coin,omitempty"`
+	StartTime *int64  `json:"start_time,omitempty"`
+	EndTime   *int64  `json:"end_time,omitempty"`
+}
+
 type LendingOffer struct {
 	Coin string          `json:"coin"`
 	Rate float64         `json:"rate"`
@@ -27,7 +27,7 @@ type StakeBalance struct {
 }
 
 type StakingReward struct {
-	Coin   string          `json:"coid"`
+	Coin   string          `json:"coin"`
 	ID     int64           `json:"id"`
 	Size   decimal.Decimal `json:"size"`
 	Status string          `json:"status"`
@@ -1,12 +1,15 @@
 package models
 
 import (
+	"bytes"
 	"encoding/json"
 	"fmt"
 	"math"
+	"strconv"
 	"time"
 )
 
+// Resolution is the length of a candle in seconds.
 type Resolution int
 
 const (
@@ -19,6 +22,30 @@ const (
 	Day      = 86400
 )
 
+// Resolutions FTX serves candles at, along with any multiple of a day up to
+// 30 days.
+const (
+	Resolution15s Resolution = Sec15
+	Resolution1m  Resolution = Minute
+	Resolution5m  Resolution = Minute5
+	Resolution15m Resolution = Minute15
+	Resolution1h  Resolution = Hour
+	Resolution4h  Resolution = Hour4
+	Resolution1d  Resolution = Day
+)
+
+// maxResolutionDays is the longest resolution FTX serves, in days.
+const maxResolutionDays = 30
+
+// IsValid reports whether FTX serves candles at r.
+func (r Resolution) IsValid() bool {
+	switch r {
+	case Resolution15s, Resolution1m, Resolution5m, Resolution15m, Resolution1h, Resolution4h:
+		return true
+	}
+	return r > 0 && r%Resolution1d == 0 && r <= maxResolutionDays*Resolution1d
+}
+
 type NumberTimeLimit struct {
 	Limit     *int   `json:"limit,omitempty"`
 	StartTime *int64 `json:"start_time,omitempty"`
@@ -28,12 +55,13 @@ type NumberTimeLimit struct {
 type ChannelType string
 
 const (
-	OrderBookChannel = ChannelType("orderbook")
-	TradesChannel    = ChannelType("trades")
-	TickerChannel    = ChannelType("ticker")
-	MarketsChannel   = ChannelType("markets")
-	FillsChannel     = ChannelType("fills")
-	OrdersChannel    = ChannelType("orders")
+	OrderBookChannel        = ChannelType("orderbook")
+	OrderBookGroupedChannel = ChannelType("orderbookGrouped")
+	TradesChannel           = ChannelType("trades")
+	TickerChannel           = ChannelType("ticker")
+	MarketsChannel          = ChannelType("markets")
+	FillsChannel            = ChannelType("fills")
+	OrdersChannel           = ChannelType("orders")
 )
 
 type Operation string
@@ -41,6 +69,8 @@ type Operation string
 const (
 	Subscribe   = Operation("subscribe")
 	UnSubscribe = Operation("unsubscribe")
+	PingOp      = Operation("ping")
+	PongOp      = Operation("pong")
 )
 
 type ResponseType string
@@ -52,6 +82,8 @@ const (
 	Info         = ResponseType("info")
 	Partial      = ResponseType("partial")
 	Update       = ResponseType("update")
+	PingResponse = ResponseType("ping")
+	PongResponse = ResponseType("pong")
 )
 
 type TransferStatus string
@@ -72,6 +104,27 @@ const (
 	Buy  = OrderSide("buy")
 )
 
+func (t OrderType) Valid() bool {
+	return t == LimitOrder || t == MarketOrder
+}
+
+func (s OrderSide) Valid() bool {
+	return s == Buy || s == Sell
+}
+
+// checkSideAndType fails on an unknown side or order type so that request
+// params are checked when they are encoded. Unset ones are left for FTX to
+// reject. Responses are not checked, FTX being free to add new values.
+func checkSideAndType(side *OrderSide, orderType *OrderType) error {
+	if side != nil && *side != "" && !side.Valid() {
+		return fmt.Errorf("Invalid order side: %q", string(*side))
+	}
+	if orderType != nil && *orderType != "" && !orderType.Valid() {
+		return fmt.Errorf("Invalid order type: %q", string(*orderType))
+	}
+	return nil
+}
+
 type OrderStatus string
 
 const (
@@ -124,6 +177,35 @@ type Result struct {
 	Result string `json:"result"`
 }
 
+// JSONFloat is a float64 which FTX sends as a number in some responses and
+// as a string in others. Null and the empty string decode to 0. It is
+// encoded as a number.
+type JSONFloat float64
+
+func (f *JSONFloat) UnmarshalJSON(data []byte) error {
+
+	data = bytes.TrimSpace(data)
+	if len(data) > 1 && data[0] == '"' {
+		var s string
+		if err := json.Unmarshal(data, &s); err != nil {
+			return err
+		}
+		data = bytes.TrimSpace([]byte(s))
+	}
+
+	if len(data) == 0 || string(data) == "null" {
+		*f = 0
+		return nil
+	}
+
+	v, err := strconv.ParseFloat(string(data), 64)
+	if err != nil {
+		return fmt.Errorf("Invalid number: %q", string(data))
+	}
+	*f = JSONFloat(v)
+	return nil
+}
+
 type FTXTime struct {
 	Time time.Time
 }
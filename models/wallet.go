@@ -76,6 +76,25 @@ type RequestWithdrawalParams struct {
 	Tag      *string          `json:"tag,omitempty"`
 }
 
+// WithdrawalFeeParams describes a withdrawal to estimate the fee of. The
+// method is needed for coins with more than one, e.g. USDT.
+type WithdrawalFeeParams struct {
+	Coin    *string          `json:"coin"`
+	Size    *decimal.Decimal `json:"size"`
+	Address *string          `json:"address"`
+	Tag     *string          `json:"tag,omitempty"`
+	Method  *DepositMethod   `json:"method,omitempty"`
+}
+
+// WithdrawalFee is the estimated fee of a withdrawal. Congested is set when
+// the blockchain is congested and the withdrawal may be delayed.
+type WithdrawalFee struct {
+	Method    DepositMethod   `json:"method"`
+	Address   string          `json:"address"`
+	Fee       decimal.Decimal `json:"fee"`
+	Congested bool            `json:"congested"`
+}
+
 type AirDropParams NumberTimeLimit
 
 type AirDrop struct {
@@ -106,3 +125,43 @@ type SavedAddress struct {
 	Whitelisted      bool      `json:"whitelisted"`
 	WhitelistedAfter string    `json:"whitelistedAfter"`
 }
+
+type HistoricalBalancesParams struct {
+	Accounts []string `json:"accounts"`
+	EndTime  int64    `json:"endTime"`
+}
+
+type HistoricalBalancesStatus string
+
+const (
+	HistoricalBalancesRequested = HistoricalBalancesStatus("requested")
+	HistoricalBalancesComplete  = HistoricalBalancesStatus("complete")
+)
+
+// HistoricalBalances is a snapshot request of the balances and positions
+// of the given accounts at EndTime. Results are filled in once Status is
+// complete.
+type HistoricalBalances struct {
+	ID       int64                      `json:"id"`
+	Accounts []string                   `json:"accounts"`
+	Time     time.Time                  `json:"time"`
+	EndTime  FTXTime                    `json:"endTime"`
+	Status   HistoricalBalancesStatus   `json:"status"`
+	Error    bool                       `json:"error"`
+	Results  []*HistoricalBalanceResult `json:"results"`
+}
+
+type HistoricalBalanceResult struct {
+	Account    string          `json:"account"`
+	Ticker     string          `json:"ticker"`
+	Size       decimal.Decimal `json:"size"`
+	Price      decimal.Decimal `json:"price"`
+	Subaccount string          `json:"subaccount"`
+}
+
+// BalanceSnapshot holds the balance of each coin or position of each future
+// across the requested accounts at Time.
+type BalanceSnapshot struct {
+	Time     time.Time
+	Balances map[string]decimal.Decimal
+}
@@ -2,8 +2,10 @@ package models
 
 import (
 	"encoding/json"
+	"time"
 
 	"github.com/pkg/errors"
+	"github.com/shopspring/decimal"
 )
 
 type BaseResponse struct {
@@ -31,6 +33,35 @@ type OrderBookResponse struct {
 	BaseResponse
 }
 
+// PriceLevel is one level of an order book message. Remove is set when the
+// size is zero, meaning the level is no longer in the book.
+type PriceLevel struct {
+	Price  decimal.Decimal
+	Size   decimal.Decimal
+	Remove bool
+}
+
+// Deltas returns the bid and ask levels of the message in the order FTX sent
+// them. For a partial they make up the whole book.
+func (r *OrderBookResponse) Deltas() (bids, asks []PriceLevel) {
+	return priceLevels(r.Bids), priceLevels(r.Asks)
+}
+
+func priceLevels(levels [][]decimal.Decimal) []PriceLevel {
+	result := make([]PriceLevel, 0, len(levels))
+	for _, level := range levels {
+		if len(level) < 2 {
+			continue
+		}
+		result = append(result, PriceLevel{
+			Price:  level[0],
+			Size:   level[1],
+			Remove: level[1].IsZero(),
+		})
+	}
+	return result
+}
+
 type FillResponse struct {
 	Fill
 	BaseResponse
@@ -41,10 +72,36 @@ type OrdersResponse struct {
 	BaseResponse
 }
 
+// StreamStats is a snapshot of the connections of a Stream.
+type StreamStats struct {
+	// Subscriptions is the number of channel and market subscriptions.
+	Subscriptions int
+	Connections   int
+	// Reconnects is the number of successful reconnections since the Stream
+	// was created.
+	Reconnects int
+	// Dropped is the number of events dropped for being older than the
+	// maximum event age or for a full event queue since the Stream was
+	// created.
+	Dropped int
+	Subs    []SubStats
+}
+
+// SubStats describes one connection of a Stream. LastMessage is zero until
+// a frame has been read.
+type SubStats struct {
+	Subscriptions int
+	LastMessage   time.Time
+	Dropped       int
+}
+
 type WSRequest struct {
 	ChannelType ChannelType `json:"channel"`
-	Market      string      `json:"market"`
+	Market      string      `json:"market,omitempty"`
 	Op          Operation   `json:"op"`
+	// Grouping is the price interval levels are grouped by on the
+	// orderbookGrouped channel.
+	Grouping float64 `json:"grouping,omitempty"`
 }
 
 type WSRequestAuthorize struct {
@@ -61,6 +118,12 @@ type WsResponse struct {
 	Data         json.RawMessage `json:"data"`
 }
 
+// MapTo unmarshals the data of the message into v, which allows decoding
+// channels the client has no mapper for.
+func (wr *WsResponse) MapTo(v interface{}) error {
+	return errors.WithStack(json.Unmarshal(wr.Data, v))
+}
+
 func (wr *WsResponse) MapToTradesResponse() (*TradesResponse, error) {
 	var trades []Trade
 	err := json.Unmarshal(wr.Data, &trades)
@@ -119,6 +182,7 @@ func (wr *WsResponse) MapToFillResponse() (*FillResponse, error) {
 		Fill: fill,
 		BaseResponse: BaseResponse{
 			ResponseType: wr.ResponseType,
+			Symbol:       fill.Market,
 		},
 	}, nil
 }
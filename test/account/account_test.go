@@ -1,6 +1,7 @@
 package testacct
 
 import (
+	"context"
 	"os"
 	"testing"
 
@@ -78,3 +79,44 @@ func TestAccount_ChangeAccountLeverage(t *testing.T) {
 		t.Fatalf("Account leverage not equal to desired leverage: %v, %v", account.Leverage, l)
 	}
 }
+
+func TestAccount_PortfolioSummary(t *testing.T) {
+
+	ftx := api.New(
+		api.WithAuth(os.Getenv("FTX_PROD_MAIN_KEY"), os.Getenv("FTX_PROD_MAIN_SECRET")),
+	)
+	err := ftx.SetServerTimeDiff()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	summary, err := ftx.Account.PortfolioSummary(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t.Logf("Summary: %+v\n", *summary)
+}
+
+func TestAccount_GetAccountFeesAnd30DayVolume(t *testing.T) {
+
+	ftx := api.New(
+		api.WithAuth(os.Getenv("FTX_PROD_MAIN_KEY"), os.Getenv("FTX_PROD_MAIN_SECRET")),
+	)
+	err := ftx.SetServerTimeDiff()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	fees, err := ftx.Account.GetAccountFees(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Logf("Fees: %+v\n", *fees)
+
+	volume, err := ftx.Account.Get30DayVolume(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Logf("Volume: %v\n", volume)
+}
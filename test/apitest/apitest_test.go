@@ -0,0 +1,100 @@
+package testapitest
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/shopspring/decimal"
+
+	"github.com/uscott/go-ftx/api/apitest"
+	"github.com/uscott/go-ftx/models"
+)
+
+func TestMockStream(t *testing.T) {
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	mock := apitest.NewMockStream()
+	defer mock.Close()
+
+	tickersC, err := mock.Stream().SubscribeToTickers(ctx, "BTC-PERP")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = mock.Send(models.WsResponse{
+		ChannelType:  models.TickerChannel,
+		Market:       "BTC-PERP",
+		ResponseType: models.Update,
+		Data:         json.RawMessage(`{"bid": 100.5, "ask": 101, "last": 100.75, "time": 1}`),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	select {
+	case ticker := <-tickersC:
+		if ticker.Symbol != "BTC-PERP" {
+			t.Fatalf("Should be equal: %v, %v", ticker.Symbol, "BTC-PERP")
+		}
+		if !ticker.Bid.Equal(decimal.NewFromFloat(100.5)) {
+			t.Fatalf("Should be equal: %v, %v", ticker.Bid, 100.5)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("No ticker received")
+	}
+
+	requests := mock.Requests()
+	if len(requests) != 1 || requests[0].ChannelType != models.TickerChannel {
+		t.Fatalf("Unexpected requests: %+v", requests)
+	}
+}
+
+func TestMockStream_ReadN(t *testing.T) {
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	mock := apitest.NewMockStream()
+	defer mock.Close()
+
+	go func() {
+		for i := 0; i < 3; i++ {
+			err := mock.Send(models.WsResponse{
+				ChannelType:  models.TickerChannel,
+				Market:       "BTC-PERP",
+				ResponseType: models.Update,
+				Data:         json.RawMessage(`{"bid": 100, "ask": 101, "time": 1}`),
+			})
+			if err != nil {
+				return
+			}
+		}
+	}()
+
+	events, err := mock.Stream().ReadN(ctx, models.TickerChannel, 2, "BTC-PERP")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(events) != 2 {
+		t.Fatalf("Should be equal: %d, %d", len(events), 2)
+	}
+	if _, ok := events[0].(*models.TickerResponse); !ok {
+		t.Fatalf("Unexpected event: %T", events[0])
+	}
+
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		requests := mock.Requests()
+		if n := len(requests); n == 2 && requests[1].Op == models.UnSubscribe {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("No unsubscribe received: %+v", requests)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
@@ -1 +1,37 @@
 package testconvert
+
+import (
+	"os"
+	"testing"
+
+	"github.com/pkg/errors"
+	"github.com/shopspring/decimal"
+	"github.com/uscott/go-ftx/api"
+)
+
+func client(t *testing.T) *api.Client {
+
+	ftx := api.New(
+		api.WithAuth(os.Getenv("FTX_PROD_MAIN_KEY"), os.Getenv("FTX_PROD_MAIN_SECRET")),
+	)
+	if err := ftx.SetServerTimeDiff(); err != nil {
+		t.Fatal(errors.WithStack(err))
+	}
+	return ftx
+}
+
+func TestConvert_RequestQuote(t *testing.T) {
+
+	ftx := client(t)
+
+	id, err := ftx.Convert.RequestQuote("USD", "USDT", decimal.NewFromInt(1))
+	if err != nil {
+		t.Fatal(errors.WithStack(err))
+	}
+
+	status, err := ftx.Convert.GetQuoteStatus(id)
+	if err != nil {
+		t.Fatal(errors.WithStack(err))
+	}
+	t.Logf("Quote status: %+v\n", *status)
+}
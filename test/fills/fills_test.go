@@ -23,8 +23,8 @@ func TestFills_GetFills(t *testing.T) {
 		for _, side := range []models.Side{models.Buy, models.Sell} {
 			_, err := ftx.Orders.PlaceOrder(&models.OrderParams{
 				Market: api.PtrString("BTC-PERP"),
-				Side:   api.PtrString(string(side)),
-				Type:   api.PtrString(string(models.MarketOrder)),
+				Side:   api.PtrOrderSide(side),
+				Type:   api.PtrOrderType(models.MarketOrder),
 				Size:   api.PtrDecimal(0.001),
 			})
 			if err != nil {
@@ -76,18 +76,38 @@ func TestFutures_GetFundingRates(t *testing.T) {
 
 }
 
+func TestFutures_GetCurrentFundingRate(t *testing.T) {
+
+	ftx := api.New()
+
+	rate, err := ftx.Futures.GetCurrentFundingRate("BTC-PERP")
+	if err != nil {
+		t.Fatal(errors.WithStack(err))
+	}
+	if rate.Time.IsZero() || rate.NextFundingTime.IsZero() {
+		t.Fatalf("Missing funding times: %+v", *rate)
+	}
+	t.Logf("Funding Rate: %+v\n", *rate)
+}
+
 func TestFutures_GetIndexWeights(t *testing.T) {
 
-	/*
-		ftx := api.New()
-		index := "BTC-PERP"
+	ftx := api.New()
+	index := "SHIT"
 
-		weights, err := ftx.Futures.GetIndexWeights(index)
-		if err != nil {
-			t.Fatal(errors.WithStack(err))
-		}
-		t.Logf("Weights: %+v\n", weights)
-	*/
+	weights, err := ftx.Futures.GetIndexWeights(index)
+	if err != nil {
+		t.Fatal(errors.WithStack(err))
+	}
+	if len(weights) == 0 {
+		t.Fatal("Should have weights")
+	}
+	t.Logf("Weights: %+v\n", weights)
+
+	_, err = ftx.Futures.GetIndexWeights("BTC-PERP")
+	if !errors.Is(err, api.ErrIndexNotFound) {
+		t.Fatalf("Should be equal: %v, %v", err, api.ErrIndexNotFound)
+	}
 }
 
 func TestFutures_GetExpiredFutures(t *testing.T) {
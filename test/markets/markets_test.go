@@ -171,3 +171,25 @@ func TestMarkets_GetHistoricalPrices(t *testing.T) {
 		}
 	}
 }
+
+func TestMarkets_GetMarketStats(t *testing.T) {
+
+	ftx := api.New()
+
+	stats, err := ftx.Markets.GetMarketStats("BTC-PERP")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stats.High24h.LessThan(stats.Low24h) {
+		t.Fatalf("High should not be less than low: %v, %v", stats.High24h, stats.Low24h)
+	}
+	t.Logf("Stats: %+v", *stats)
+
+	all, err := ftx.Markets.GetAllMarketStats("BTC-PERP", "BTC/USD")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(all) != 2 {
+		t.Fatalf("Length should be 2: %d", len(all))
+	}
+}
@@ -0,0 +1,328 @@
+package testmodels
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/pkg/errors"
+	"github.com/shopspring/decimal"
+	"github.com/uscott/go-ftx/models"
+)
+
+// Payloads as returned by FTX, numbers being sent as strings in some of them
+var payloads = []struct {
+	name    string
+	payload string
+	target  func() interface{}
+}{
+	{
+		"borrow rate",
+		`{"coin": "BTC", "estimate": 1.45e-06, "previous": 1.44e-06}`,
+		func() interface{} { return &models.BorrowRate{} },
+	},
+	{
+		"spot margin market info",
+		`{"coin": "BTC", "borrowed": 0.0, "free": 3.87278021, ` +
+			`"estimatedRate": 1e-06, "previousRate": 1e-06}`,
+		func() interface{} { return &models.SpotMarginMarketInfo{} },
+	},
+	{
+		"lending info",
+		`{"coin": "USD", "lendable": 10026.5, "locked": 100.0, "minRate": 1e-06, "offered": 100.0}`,
+		func() interface{} { return &models.LendingInfo{} },
+	},
+	{
+		"stake balance",
+		`{"coin": "SRM", "lifetimeRewards": 0.00058, "scheduledToUnstake": 0.0, "staked": 1.0}`,
+		func() interface{} { return &models.StakeBalance{} },
+	},
+	{
+		"withdrawal",
+		`{"coin": "TUSD", "address": "0x83a127952d266A6eA306c40Ac62A4a70668FE3BE", "tag": null, ` +
+			`"fee": 0, "id": 1, "size": "20.0", "status": "complete", ` +
+			`"time": "2019-03-05T09:56:55.728933+00:00", "txid": "0x8078356ae4b06a036d64747546c274af19581f1c78c510b60505798a7ffcaf1"}`,
+		func() interface{} { return &models.Withdrawal{} },
+	},
+	{
+		"deposit",
+		`{"coin": "TUSD", "confirmations": 64, "confirmedTime": "2019-03-05T09:56:55.728933+00:00", ` +
+			`"fee": 0, "id": 1, "sentTime": "2019-03-05T09:56:55.735929+00:00", "size": 99.0, ` +
+			`"status": "confirmed", "time": "2019-03-05T09:56:55.728933+00:00", "txid": "0x8078356ae4b06a"}`,
+		func() interface{} { return &models.Deposit{} },
+	},
+	{
+		"fill",
+		`{"fee": 20.1374935, "feeCurrency": "USD", "feeRate": 0.0005, "future": "EOS-0329", ` +
+			`"id": 11215, "liquidity": "taker", "market": "EOS-0329", "baseCurrency": null, ` +
+			`"quoteCurrency": null, "orderId": 8436981, "tradeId": 1013912, "price": 4.201, ` +
+			`"side": "buy", "size": 9587, "time": "2019-03-27T19:15:10.204619+00:00", "type": "order"}`,
+		func() interface{} { return &models.Fill{} },
+	},
+	{
+		"order",
+		`{"createdAt": "2019-03-05T09:56:55.728933+00:00", "filledSize": 0, "future": "XRP-PERP", ` +
+			`"id": 9596912, "market": "XRP-PERP", "price": null, "avgFillPrice": null, ` +
+			`"remainingSize": 31431, "side": "sell", "size": 31431, "status": "open", ` +
+			`"type": "market", "reduceOnly": false, "ioc": true, "postOnly": false, "clientId": null}`,
+		func() interface{} { return &models.Order{} },
+	},
+	{
+		"future stats",
+		`{"volume": 1000.23, "nextFundingRate": 0.00025, "nextFundingTime": "2019-03-29T03:00:00+00:00", ` +
+			`"expirationPrice": 3992.1, "predictedExpirationPrice": 3993.6, "strikePrice": 8182.35, ` +
+			`"openInterest": 21124.583}`,
+		func() interface{} { return &models.FutureStats{} },
+	},
+	{
+		"order book",
+		`{"asks": [[4114.25, 6.263]], "bids": [[4112.25, 49.29]], "checksum": 1234, ` +
+			`"time": 1557950914.1305, "action": "partial"}`,
+		func() interface{} { return &models.OrderBook{} },
+	},
+	{
+		"account",
+		`{"backstopProvider": true, "collateral": 3568181.02691129, "freeCollateral": 1786071.456884368, ` +
+			`"initialMarginRequirement": 0.12222384240257728, "leverage": 10, "liquidating": false, ` +
+			`"maintenanceMarginRequirement": 0.07177992558058484, "makerFee": 0.0002, ` +
+			`"marginFraction": 0.5588433331419503, "openMarginFraction": 0.2447194090423075, ` +
+			`"positionLimit": null, "positionLimitUsed": 2, "spotLendingEnabled": true, "spotMarginEnabled": true, ` +
+			`"takerFee": 0.0005, "totalAccountValue": 3568180.98341129, "totalPositionSize": 6384939.6992, ` +
+			`"username": "user@domain.com", "positions": [{"cost": -31.7906, "entryPrice": 138.22, ` +
+			`"future": "ETH-PERP", "initialMarginRequirement": 0.1, "longOrderSize": 1744.55, ` +
+			`"maintenanceMarginRequirement": 0.04, "netSize": -0.23, "openSize": 1744.32, ` +
+			`"realizedPnl": 3.39441714, "shortOrderSize": 1732.09, "side": "sell", "size": 0.23, ` +
+			`"unrealizedPnl": 0}]}`,
+		func() interface{} { return &models.AccountInformation{} },
+	},
+}
+
+func TestModels_RoundTrip(t *testing.T) {
+
+	for _, p := range payloads {
+
+		first := p.target()
+		if err := json.Unmarshal([]byte(p.payload), first); err != nil {
+			t.Fatalf("%s: %+v", p.name, errors.WithStack(err))
+		}
+
+		encoded, err := json.Marshal(first)
+		if err != nil {
+			t.Fatalf("%s: %+v", p.name, errors.WithStack(err))
+		}
+
+		second := p.target()
+		if err = json.Unmarshal(encoded, second); err != nil {
+			t.Fatalf("%s: %+v", p.name, errors.WithStack(err))
+		}
+
+		reencoded, err := json.Marshal(second)
+		if err != nil {
+			t.Fatalf("%s: %+v", p.name, errors.WithStack(err))
+		}
+
+		if string(encoded) != string(reencoded) {
+			t.Fatalf("%s: Should be equal: %s, %s", p.name, encoded, reencoded)
+		}
+	}
+}
+
+func TestModels_NumericStrings(t *testing.T) {
+
+	rate := models.BorrowRate{}
+	if err := json.Unmarshal([]byte(payloads[0].payload), &rate); err != nil {
+		t.Fatal(errors.WithStack(err))
+	}
+	if rate.Estimate != 1.45e-06 || rate.Previous != 1.44e-06 {
+		t.Fatalf("Unexpected rates: %+v", rate)
+	}
+
+	withdrawal := models.Withdrawal{}
+	if err := json.Unmarshal([]byte(payloads[4].payload), &withdrawal); err != nil {
+		t.Fatal(errors.WithStack(err))
+	}
+	if !withdrawal.Size.Equal(decimal.NewFromInt(20)) {
+		t.Fatalf("Should be equal: %v, %v", withdrawal.Size, 20)
+	}
+
+	for payload, expected := range map[string]models.JSONFloat{
+		`1.5`:     1.5,
+		`"1.5"`:   1.5,
+		`" 2e-3"`: 0.002,
+		`""`:      0,
+		`null`:    0,
+	} {
+		var f models.JSONFloat
+		if err := json.Unmarshal([]byte(payload), &f); err != nil {
+			t.Fatalf("%s: %v", payload, err)
+		}
+		if f != expected {
+			t.Fatalf("Should be equal: %v, %v", f, expected)
+		}
+	}
+
+	for _, payload := range []string{`"abc"`, `true`, `{}`} {
+		var f models.JSONFloat
+		if err := json.Unmarshal([]byte(payload), &f); err == nil {
+			t.Fatalf("%s: Should fail", payload)
+		}
+	}
+
+	if b, _ := json.Marshal(models.JSONFloat(0.25)); string(b) != `0.25` {
+		t.Fatalf("Should be equal: %s, %s", b, `0.25`)
+	}
+}
+
+func TestModels_MarketType(t *testing.T) {
+
+	tests := []struct {
+		market    models.Market
+		spot      bool
+		future    bool
+		perpetual bool
+		move      bool
+		base      string
+	}{
+		{models.Market{Name: "BTC/USD", Type: models.SpotMarket, BaseCurrency: "BTC"}, true, false, false, false, "BTC"},
+		{models.Market{Name: "BTC-PERP", Type: models.FutureMarket, Underlying: "BTC"}, false, true, true, false, "BTC"},
+		{models.Market{Name: "BTC-0326", Type: models.FutureMarket, Underlying: "BTC"}, false, true, false, false, "BTC"},
+		{models.Market{Name: "BTC-MOVE-1231", Type: models.FutureMarket, Underlying: "BTC"}, false, true, false, true, "BTC"},
+		{models.Market{Name: "BTC-MOVE-WK-0101", Type: models.FutureMarket, Underlying: "BTC"}, false, true, false, true, "BTC"},
+		{models.Market{Name: "DEFI-PERP", Type: models.FutureMarket, Underlying: "DEFI"}, false, true, true, false, "DEFI"},
+		{models.Market{Name: "BULL/USD", Type: models.SpotMarket, BaseCurrency: "BULL"}, true, false, false, false, "BULL"},
+		// Markets known only by name
+		{models.Market{Name: "ETH/BTC"}, true, false, false, false, "ETH"},
+		{models.Market{Name: "ETH-PERP"}, false, true, true, false, "ETH"},
+		{models.Market{Name: "ETH-MOVE-2021Q1"}, false, true, false, true, "ETH"},
+		{models.Market{Name: "SOL-1231"}, false, true, false, false, "SOL"},
+		{models.Market{}, false, false, false, false, ""},
+	}
+
+	for _, tt := range tests {
+		m := tt.market
+		if m.IsSpot() != tt.spot || m.IsFuture() != tt.future ||
+			m.IsPerpetual() != tt.perpetual || m.IsMove() != tt.move {
+			t.Fatalf("%q: Unexpected type: spot %v, future %v, perpetual %v, move %v",
+				m.Name, m.IsSpot(), m.IsFuture(), m.IsPerpetual(), m.IsMove())
+		}
+		if base := m.BaseAsset(); base != tt.base {
+			t.Fatalf("%q: Should be equal: %s, %s", m.Name, base, tt.base)
+		}
+	}
+}
+
+func TestModels_MarketRounding(t *testing.T) {
+
+	market := &models.Market{
+		PriceIncrement: decimal.RequireFromString("0.0001"),
+		SizeIncrement:  decimal.RequireFromString("0.0001"),
+	}
+
+	// 0.1 + 0.2 is 0.30000000000000004 as a float
+	a, b := 0.1, 0.2
+	sum := decimal.NewFromFloat(a + b)
+
+	tests := []struct {
+		name     string
+		round    func(decimal.Decimal) decimal.Decimal
+		value    string
+		expected string
+	}{
+		{"round price down", market.RoundPrice, "1.23454", "1.2345"},
+		{"round price half", market.RoundPrice, "1.23455", "1.2346"},
+		{"round negative price", market.RoundPrice, "-1.23455", "-1.2346"},
+		{"floor price", market.FloorPrice, "1.23459", "1.2345"},
+		{"ceil price", market.CeilPrice, "1.23451", "1.2346"},
+		{"ceil price on increment", market.CeilPrice, "1.2345", "1.2345"},
+		{"round size", market.RoundSize, "0.00015", "0.0002"},
+		{"floor size", market.FloorSize, "0.00019999", "0.0001"},
+		{"floor size below increment", market.FloorSize, "0.00009", "0"},
+		{"ceil size", market.CeilSize, "0.00000001", "0.0001"},
+		{"round float sum", market.RoundSize, sum.String(), "0.3"},
+	}
+
+	for _, tt := range tests {
+		actual := tt.round(decimal.RequireFromString(tt.value))
+		if expected := decimal.RequireFromString(tt.expected); !actual.Equal(expected) {
+			t.Fatalf("%s: Should be equal: %v, %v", tt.name, actual, expected)
+		}
+	}
+
+	// Markets without an increment leave values as they are
+	free := &models.Market{}
+	if p := decimal.RequireFromString("1.23456789"); !free.RoundPrice(p).Equal(p) {
+		t.Fatalf("Should be equal: %v, %v", free.RoundPrice(p), p)
+	}
+}
+
+func TestModels_ComputeOrderBookChecksum(t *testing.T) {
+
+	levels := func(pairs ...float64) []models.PriceLevel {
+		result := make([]models.PriceLevel, 0, len(pairs)/2)
+		for i := 0; i+1 < len(pairs); i += 2 {
+			result = append(result, models.PriceLevel{
+				Price: decimal.NewFromFloat(pairs[i]),
+				Size:  decimal.NewFromFloat(pairs[i+1]),
+			})
+		}
+		return result
+	}
+
+	var deepBids, deepAsks []models.PriceLevel
+	for i := 0; i < 120; i++ {
+		deepBids = append(deepBids, levels(1000-float64(i)*0.5, float64(i+1))...)
+	}
+	for i := 0; i < 110; i++ {
+		deepAsks = append(deepAsks, levels(1001+float64(i)*0.25, 0.5)...)
+	}
+
+	// Checksums computed with the Python reference of the FTX documentation
+	tests := []struct {
+		name     string
+		bids     []models.PriceLevel
+		asks     []models.PriceLevel
+		expected uint32
+	}{
+		{"documentation", levels(5000.5, 10, 4995.0, 5), levels(5001.0, 6, 5002.0, 7), 2933775928},
+		{"exponents", levels(0.00012, 1234567, 0.00009, 0.00001), levels(0.00013, 2e16), 3089980113},
+		{"deeper than 100 levels", deepBids, deepAsks, 1306762330},
+		{"empty", nil, nil, 0},
+	}
+
+	for _, tt := range tests {
+		if actual := models.ComputeOrderBookChecksum(tt.bids, tt.asks); actual != tt.expected {
+			t.Fatalf("%s: Should be equal: %d, %d", tt.name, actual, tt.expected)
+		}
+	}
+}
+
+func TestModels_AccountInformation(t *testing.T) {
+
+	account := models.AccountInformation{}
+	if err := json.Unmarshal([]byte(payloads[10].payload), &account); err != nil {
+		t.Fatal(errors.WithStack(err))
+	}
+
+	if !account.BackstopProvider || account.Liquidating {
+		t.Fatalf("Unexpected flags: %v, %v", account.BackstopProvider, account.Liquidating)
+	}
+	if !account.SpotLendingEnabled || !account.SpotMarginEnabled {
+		t.Fatalf("Unexpected flags: %v, %v", account.SpotLendingEnabled, account.SpotMarginEnabled)
+	}
+	if account.PositionLimit != nil {
+		t.Fatalf("Should be nil: %v", account.PositionLimit)
+	}
+	if account.PositionLimitUsed == nil || !account.PositionLimitUsed.Equal(decimal.NewFromInt(2)) {
+		t.Fatalf("Should be equal: %v, %v", account.PositionLimitUsed, 2)
+	}
+
+	limited := models.AccountInformation{}
+	if err := json.Unmarshal([]byte(`{"positionLimit": 5000.5, "liquidating": true}`), &limited); err != nil {
+		t.Fatal(errors.WithStack(err))
+	}
+	if limited.PositionLimit == nil || !limited.PositionLimit.Equal(decimal.NewFromFloat(5000.5)) {
+		t.Fatalf("Should be equal: %v, %v", limited.PositionLimit, 5000.5)
+	}
+	if !limited.Liquidating {
+		t.Fatal("Should be liquidating")
+	}
+}
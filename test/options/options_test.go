@@ -1,6 +1,7 @@
 package testoptions
 
 import (
+	"encoding/json"
 	"os"
 	"testing"
 	"time"
@@ -56,6 +57,39 @@ func TestOptions_ListUserQuoteRequests(t *testing.T) {
 func TestOptions_CreateQuoteRequest(t *testing.T) {
 }
 
+func TestOptions_CreateQuoteRequestDecode(t *testing.T) {
+
+	payload := []byte(`{
+		"id": 3,
+		"option": {
+			"underlying": "BTC",
+			"type": "call",
+			"strike": 7800,
+			"expiry": "2020-04-10T03:00:00+00:00"
+		},
+		"side": "buy",
+		"size": 1,
+		"time": "2020-04-08T02:00:00+00:00",
+		"requestExpiry": "2020-04-08T02:05:00+00:00",
+		"status": "open",
+		"limitPrice": 100
+	}`)
+
+	result := models.CreateQuoteRequest{}
+	if err := json.Unmarshal(payload, &result); err != nil {
+		t.Fatal(errors.WithStack(err))
+	}
+
+	option := result.Option
+	if option.Underlying != "BTC" || option.Type != models.Call ||
+		option.Strike.String() != "7800" || option.Expiry.Day() != 10 {
+		t.Fatalf("Unexpected option: %+v", option)
+	}
+	if result.ID != 3 || result.Side != models.Buy || result.Status != models.Open {
+		t.Fatalf("Unexpected request: %+v", result)
+	}
+}
+
 func TestOptions_CancelQuoteRequest(t *testing.T) {}
 
 func TestOptions_GetQuotesForUserQuoteRequest(t *testing.T) {}
@@ -195,3 +229,21 @@ func TestOptions_GetHistoricalOpenInterest(t *testing.T) {
 		t.Logf("Open Interest: %+v\n", *oi)
 	}
 }
+
+func TestOptions_GetOptionsHistoricalVolumesRange(t *testing.T) {
+
+	ftx := prepForTest(t)
+	end := time.Now().UTC()
+	start := end.Add(-30 * 24 * time.Hour)
+
+	volumes, err := ftx.Options.GetOptionsHistoricalVolumesRange(start, end)
+	if err != nil {
+		t.Fatal(errors.WithStack(err))
+	}
+	for i := 1; i < len(volumes); i++ {
+		if volumes[i].StartTime.Before(volumes[i-1].StartTime) {
+			t.Fatalf("Not sorted at %d", i)
+		}
+	}
+	t.Logf("Volumes: %d", len(volumes))
+}
@@ -0,0 +1,304 @@
+package testorderbook
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/pkg/errors"
+	"github.com/shopspring/decimal"
+	"github.com/uscott/go-ftx/api"
+	"github.com/uscott/go-ftx/models"
+)
+
+const market = "BTC-PERP"
+
+var frames = []string{
+	`{"channel": "orderbook", "market": "BTC-PERP", "type": "partial", "data": {` +
+		`"bids": [[100.5, 2], [100.0, 1]], "asks": [[101.0, 3], [101.5, 4]], ` +
+		`"checksum": 3513243081, "time": 1, "action": "partial"}}`,
+	`{"channel": "orderbook", "market": "BTC-PERP", "type": "update", "data": {` +
+		`"bids": [[100.5, 0], [100.25, 5]], "asks": [], ` +
+		`"checksum": 3168301514, "time": 2, "action": "update"}}`,
+	`{"channel": "orderbook", "market": "BTC-PERP", "type": "update", "data": {` +
+		`"bids": [[100.75, 1]], "asks": [], "checksum": 1, "time": 3, "action": "update"}}`,
+}
+
+func TestOrderBookCache(t *testing.T) {
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	next, resubC := make(chan struct{}), make(chan string, 2)
+	upgrader := websocket.Upgrader{}
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+
+		go func() {
+			resyncing := false
+			for {
+				req := map[string]interface{}{}
+				if err := conn.ReadJSON(&req); err != nil {
+					return
+				}
+				op, _ := req["op"].(string)
+				if resyncing = resyncing || op == "unsubscribe"; resyncing {
+					resubC <- op
+				}
+			}
+		}()
+
+		for _, f := range frames {
+			if err = conn.WriteMessage(websocket.TextMessage, []byte(f)); err != nil {
+				return
+			}
+			select {
+			case <-next:
+			case <-ctx.Done():
+				return
+			}
+		}
+		<-ctx.Done()
+	}))
+	defer server.Close()
+
+	client := api.New()
+	client.Stream.SetURL("ws" + strings.TrimPrefix(server.URL, "http"))
+
+	cache, err := api.NewOrderBookCache(ctx, &client.Stream, market)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	waitFor := func(price string) {
+		for i := 0; i < 100; i++ {
+			if bid, _, ok := cache.BestBid(market); ok && bid.String() == price {
+				return
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+		t.Fatalf("Best bid never reached %s", price)
+	}
+
+	waitFor("100.5")
+	if spread, ok := cache.Spread(market); !ok || !spread.Equal(decimal.RequireFromString("0.5")) {
+		t.Fatalf("Unexpected spread: %v, %v", spread, ok)
+	}
+
+	next <- struct{}{}
+	waitFor("100.25")
+	if ask, size, ok := cache.BestAsk(market); !ok || ask.String() != "101" || size.String() != "3" {
+		t.Fatalf("Unexpected best ask: %v, %v, %v", ask, size, ok)
+	}
+
+	next <- struct{}{}
+	select {
+	case err := <-cache.Errors():
+		if errors.Cause(err) != api.ErrChecksumMismatch {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Checksum mismatch not reported")
+	}
+	if _, _, ok := cache.BestBid(market); ok {
+		t.Fatal("Book should be invalid until resynced")
+	}
+
+	for _, expected := range []string{"unsubscribe", "subscribe"} {
+		select {
+		case op := <-resubC:
+			if op != expected {
+				t.Fatalf("Should be equal: %s, %s", op, expected)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("No %s sent", expected)
+		}
+	}
+}
+
+func TestOrderBook_Decode(t *testing.T) {
+
+	expected := []struct {
+		action   models.ResponseType
+		checksum uint32
+		bids     int
+		asks     int
+	}{
+		{models.Partial, 3513243081, 2, 2},
+		{models.Update, 3168301514, 2, 0},
+	}
+
+	for i, e := range expected {
+
+		msg := models.WsResponse{}
+		if err := json.Unmarshal([]byte(frames[i]), &msg); err != nil {
+			t.Fatal(errors.WithStack(err))
+		}
+
+		book, err := msg.MapToOrderBookResponse()
+		if err != nil {
+			t.Fatal(errors.WithStack(err))
+		}
+
+		if book.Action != e.action || book.ResponseType != e.action {
+			t.Fatalf("Should be equal: %v, %v, %v", book.Action, book.ResponseType, e.action)
+		}
+		if book.Checksum != e.checksum {
+			t.Fatalf("Should be equal: %d, %d", book.Checksum, e.checksum)
+		}
+		if len(book.Bids) != e.bids || len(book.Asks) != e.asks {
+			t.Fatalf("Unexpected levels: %+v", book.OrderBook)
+		}
+		if book.Time.Time.Unix() != int64(i+1) {
+			t.Fatalf("Should be equal: %d, %d", book.Time.Time.Unix(), i+1)
+		}
+	}
+
+	msg := models.WsResponse{}
+	if err := json.Unmarshal([]byte(frames[1]), &msg); err != nil {
+		t.Fatal(errors.WithStack(err))
+	}
+	book, _ := msg.MapToOrderBookResponse()
+	if !book.Bids[0][0].Equal(decimal.NewFromFloat(100.5)) || !book.Bids[0][1].IsZero() {
+		t.Fatalf("Removed level should decode with size 0: %v", book.Bids[0])
+	}
+}
+
+func TestOrderBook_Deltas(t *testing.T) {
+
+	msg := models.WsResponse{}
+	if err := json.Unmarshal([]byte(frames[1]), &msg); err != nil {
+		t.Fatal(errors.WithStack(err))
+	}
+	book, err := msg.MapToOrderBookResponse()
+	if err != nil {
+		t.Fatal(errors.WithStack(err))
+	}
+
+	bids, asks := book.Deltas()
+	if len(bids) != 2 || len(asks) != 0 {
+		t.Fatalf("Unexpected levels: %+v, %+v", bids, asks)
+	}
+	if !bids[0].Price.Equal(decimal.NewFromFloat(100.5)) || !bids[0].Remove {
+		t.Fatalf("Level should be removed: %+v", bids[0])
+	}
+	if !bids[1].Price.Equal(decimal.NewFromFloat(100.25)) ||
+		!bids[1].Size.Equal(decimal.NewFromInt(5)) || bids[1].Remove {
+		t.Fatalf("Unexpected level: %+v", bids[1])
+	}
+}
+
+func TestOrderBookCache_SetDepth(t *testing.T) {
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	const depth = 2
+
+	// Five levels a side, then the best bid and ask are removed so that
+	// levels the trimmed book does not hold move into it, the checksums
+	// covering all the levels received.
+	bids := [][]float64{{100.5, 2}, {100.25, 1}, {100, 3}, {99.75, 4}, {99.5, 5}}
+	asks := [][]float64{{101, 3}, {101.25, 1}, {101.5, 2}, {101.75, 4}, {102, 5}}
+	levels := func(levels [][]float64) []models.PriceLevel {
+		result := make([]models.PriceLevel, len(levels))
+		for i, l := range levels {
+			result[i] = models.PriceLevel{Price: decimal.NewFromFloat(l[0]), Size: decimal.NewFromFloat(l[1])}
+		}
+		return result
+	}
+	// frame sends the levels with the checksum of the book they result in
+	frame := func(action string, sentBids, sentAsks, bids, asks [][]float64, time int) string {
+		b, _ := json.Marshal(sentBids)
+		a, _ := json.Marshal(sentAsks)
+		return fmt.Sprintf(`{"channel": "orderbook", "market": "BTC-PERP", "type": "%s", "data": {`+
+			`"bids": %s, "asks": %s, "checksum": %d, "time": %d, "action": "%s"}}`,
+			action, b, a, models.ComputeOrderBookChecksum(levels(bids), levels(asks)), time, action)
+	}
+	frames := []string{
+		frame("partial", bids, asks, bids, asks, 1),
+		frame("update", [][]float64{{100.5, 0}}, [][]float64{{101, 0}}, bids[1:], asks[1:], 2),
+	}
+
+	upgrader := websocket.Upgrader{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+
+		go func() {
+			for {
+				if _, _, err := conn.ReadMessage(); err != nil {
+					return
+				}
+			}
+		}()
+
+		for _, f := range frames {
+			if err = conn.WriteMessage(websocket.TextMessage, []byte(f)); err != nil {
+				return
+			}
+		}
+		<-ctx.Done()
+	}))
+	defer server.Close()
+
+	client := api.New()
+	client.Stream.SetURL("ws" + strings.TrimPrefix(server.URL, "http"))
+
+	cache, err := api.NewOrderBookCache(ctx, &client.Stream, market)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cache.SetDepth(depth)
+
+	var b *models.OrderBook
+	for i := 0; i < 100; i++ {
+		var ok bool
+		if b, ok = cache.Book(market); ok && b.Time.Time.Unix() == 2 {
+			break
+		}
+		if ok && (len(b.Bids) > depth || len(b.Asks) > depth) {
+			t.Fatalf("Unexpected levels: %+v", b)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if b == nil || b.Time.Time.Unix() != 2 {
+		t.Fatal("Update never applied")
+	}
+
+	select {
+	case err := <-cache.Errors():
+		t.Fatalf("Unexpected error: %v", err)
+	default:
+	}
+
+	if len(b.Bids) != depth || len(b.Asks) != depth {
+		t.Fatalf("Unexpected levels: %+v", b)
+	}
+	for i, e := range []string{"100.25", "100"} {
+		if b.Bids[i][0].String() != e {
+			t.Fatalf("Should be equal: %v, %v", b.Bids[i][0], e)
+		}
+	}
+	for i, e := range []string{"101.25", "101.5"} {
+		if b.Asks[i][0].String() != e {
+			t.Fatalf("Should be equal: %v, %v", b.Asks[i][0], e)
+		}
+	}
+}
@@ -1,6 +1,8 @@
 package testorders
 
 import (
+	"context"
+	"encoding/json"
 	"os"
 	"testing"
 	"time"
@@ -151,6 +153,24 @@ func TestOrders_GetTriggerOrdersHistory(t *testing.T) {
 	}
 }
 
+func TestOrders_GetTriggerOrdersHistoryRange(t *testing.T) {
+
+	end := time.Now()
+	hist, err := client(t).GetTriggerOrdersHistoryRange(
+		api.PtrString(swap), end.Add(-30*24*time.Hour), end)
+	if err != nil {
+		t.Fatal(errors.WithStack(err))
+	}
+	for i, h := range hist {
+		if i > 0 && h.CreatedAt.Before(hist[i-1].CreatedAt) {
+			t.Fatalf("Not sorted: %v, %v", hist[i-1].CreatedAt, h.CreatedAt)
+		}
+		if i < N {
+			t.Logf("Trigger Order: %+v\n", *h)
+		}
+	}
+}
+
 func TestOrders_PlaceOrderModifyAndCancel(t *testing.T) {
 
 	ftx := api.New(
@@ -174,9 +194,9 @@ func TestOrders_PlaceOrderModifyAndCancel(t *testing.T) {
 
 	err = ftx.Orders.PlaceOrder(&models.OrderParams{
 		Market:   api.PtrString(swap),
-		Side:     api.PtrString(string(models.Buy)),
+		Side:     api.PtrOrderSide(models.Buy),
 		Price:    &price,
-		Type:     api.PtrString(string(orderType)),
+		Type:     api.PtrOrderType(orderType),
 		Size:     &size,
 		PostOnly: api.PtrBool(true),
 	}, &order)
@@ -228,9 +248,9 @@ func TestOrders_PlaceTriggerOrderModifyAndCancel(t *testing.T) {
 	orderPrice := triggerPrice.Sub(decimal.NewFromFloat(1e3))
 	err = ftx.Orders.PlaceTriggerOrder(&models.TriggerOrderParams{
 		Market:       api.PtrString(swap),
-		Side:         api.PtrString(string(models.Sell)),
+		Side:         api.PtrOrderSide(models.Sell),
 		Size:         &size,
-		Type:         api.PtrString(string(models.Stop)),
+		Type:         api.PtrTriggerOrderType(models.Stop),
 		TriggerPrice: &triggerPrice,
 		OrderPrice:   &orderPrice,
 	}, &order)
@@ -284,9 +304,9 @@ func TestOrders_CancelAll(t *testing.T) {
 
 		err = ftx.Orders.PlaceOrder(&models.OrderParams{
 			Market:   api.PtrString(c),
-			Side:     api.PtrString(string(models.Buy)),
+			Side:     api.PtrOrderSide(models.Buy),
 			Price:    &price,
-			Type:     api.PtrString(string(models.LimitOrder)),
+			Type:     api.PtrOrderType(models.LimitOrder),
 			Size:     api.PtrDecimal(decimal.NewFromFloat(0.01)),
 			PostOnly: api.PtrBool(true),
 		}, o)
@@ -336,3 +356,181 @@ func TestOrders_CancelAll(t *testing.T) {
 
 	t.Logf("\n%s Order Status: %+v\n", contract, order2)
 }
+
+func TestOrders_WaitForOrder(t *testing.T) {
+
+	ftx := client(t)
+
+	future := models.Future{}
+	if err := ftx.Futures.GetFutureByName(swap, &future); err != nil {
+		t.Fatal(errors.WithStack(err))
+	}
+
+	order := models.Order{}
+	err := ftx.Orders.PlaceOrder(&models.OrderParams{
+		Market:   api.PtrString(swap),
+		Side:     api.PtrOrderSide(models.Buy),
+		Price:    api.PtrDecimal(future.Bid.Div(decimal.NewFromInt(2)).Round(0)),
+		Type:     api.PtrOrderType(models.LimitOrder),
+		Size:     api.PtrDecimal(decimal.NewFromFloat(0.0001)),
+		PostOnly: api.PtrBool(true),
+	}, &order)
+	if err != nil {
+		t.Fatal(errors.WithStack(err))
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	go func() {
+		time.Sleep(2 * time.Second)
+		if _, err := ftx.Orders.CancelOrder(order.ID); err != nil {
+			t.Log(err)
+		}
+	}()
+
+	final, err := ftx.Orders.WaitForOrder(ctx, order.ID, time.Second)
+	if err != nil {
+		t.Fatal(errors.WithStack(err))
+	}
+	assert.Equal(t, models.Closed, final.Status)
+}
+
+func TestOrders_ReplaceOrders(t *testing.T) {
+
+	ftx := client(t)
+
+	future := models.Future{}
+	if err := ftx.Futures.GetFutureByName(swap, &future); err != nil {
+		t.Fatal(errors.WithStack(err))
+	}
+
+	quotes := func(offset int64) []*models.OrderParams {
+		params := make([]*models.OrderParams, 2)
+		for i := range params {
+			price := future.Bid.Div(decimal.NewFromInt(2)).Round(0).
+				Sub(decimal.NewFromInt(offset + int64(i)))
+			params[i] = &models.OrderParams{
+				Market:   api.PtrString(swap),
+				Side:     api.PtrOrderSide(models.Buy),
+				Price:    &price,
+				Type:     api.PtrOrderType(models.LimitOrder),
+				Size:     api.PtrDecimal(decimal.NewFromFloat(0.0001)),
+				PostOnly: api.PtrBool(true),
+			}
+		}
+		return params
+	}
+
+	ctx := context.Background()
+
+	orders, err := ftx.Orders.ReplaceOrders(ctx, nil, quotes(0))
+	if err != nil {
+		t.Fatal(errors.WithStack(err))
+	}
+	assert.Len(t, orders, 2)
+
+	ids := make([]int64, len(orders))
+	for i, o := range orders {
+		ids[i] = o.ID
+	}
+
+	orders, err = ftx.Orders.ReplaceOrders(ctx, ids, quotes(10))
+	if err != nil {
+		t.Fatal(errors.WithStack(err))
+	}
+	assert.Len(t, orders, 2)
+
+	for _, o := range orders {
+		if _, err := ftx.Orders.CancelOrder(o.ID); err != nil {
+			t.Fatal(errors.WithStack(err))
+		}
+	}
+}
+
+func TestOrders_OrderParamsSideAndType(t *testing.T) {
+
+	params := &models.OrderParams{
+		Market: api.PtrString(swap),
+		Side:   api.PtrOrderSide(models.Buy),
+		Type:   api.PtrOrderType(models.LimitOrder),
+	}
+	if _, err := json.Marshal(params); err != nil {
+		t.Fatal(errors.WithStack(err))
+	}
+
+	params.Side = api.PtrOrderSide("bid")
+	if _, err := json.Marshal(params); err == nil {
+		t.Fatal("Invalid side should fail to marshal")
+	}
+
+	params.Side = api.PtrOrderSide(models.Sell)
+	params.Type = api.PtrOrderType("limt")
+	if _, err := json.Marshal(params); err == nil {
+		t.Fatal("Invalid type should fail to marshal")
+	}
+
+	order := models.Order{}
+	if err := json.Unmarshal([]byte(`{"side": "sell", "type": "market"}`), &order); err != nil {
+		t.Fatal(errors.WithStack(err))
+	}
+	assert.Equal(t, models.Sell, order.Side)
+	assert.Equal(t, models.MarketOrder, order.Type)
+
+	// Responses are decoded as sent
+	if err := json.Unmarshal([]byte(`{"side": "short", "type": "stop"}`), &order); err != nil {
+		t.Fatal(errors.WithStack(err))
+	}
+	assert.Equal(t, models.OrderSide("short"), order.Side)
+	if _, err := json.Marshal(order); err != nil {
+		t.Fatal(errors.WithStack(err))
+	}
+
+	trigger := &models.TriggerOrderParams{Side: api.PtrOrderSide("bid")}
+	if _, err := json.Marshal(trigger); err == nil {
+		t.Fatal("Invalid side should fail to marshal")
+	}
+}
+
+func TestOrders_OrderParamsOptionalFields(t *testing.T) {
+
+	params := &models.OrderParams{
+		Market: api.PtrString(swap),
+		Side:   api.PtrOrderSide(models.Buy),
+		Type:   api.PtrOrderType(models.MarketOrder),
+		Size:   api.PtrDecimal(decimal.NewFromInt(1)),
+	}
+
+	b, err := json.Marshal(params)
+	if err != nil {
+		t.Fatal(errors.WithStack(err))
+	}
+	expected := `{"market":"` + swap + `","side":"buy","price":null,"type":"market","size":"1"}`
+	assert.Equal(t, expected, string(b))
+
+	params.ReduceOnly = api.PtrBool(false)
+	params.IOC = api.PtrBool(true)
+	params.PostOnly = api.PtrBool(false)
+	params.ClientID = api.PtrString("abc")
+	params.RejectOnPriceBand = api.PtrBool(true)
+	params.ExternalReferralProgram = api.PtrString("ref")
+
+	if b, err = json.Marshal(params); err != nil {
+		t.Fatal(errors.WithStack(err))
+	}
+
+	fields := map[string]interface{}{}
+	if err = json.Unmarshal(b, &fields); err != nil {
+		t.Fatal(errors.WithStack(err))
+	}
+	for k, v := range map[string]interface{}{
+		"reduceOnly":              false,
+		"ioc":                     true,
+		"postOnly":                false,
+		"clientId":                "abc",
+		"rejectOnPriceBand":       true,
+		"externalReferralProgram": "ref",
+	} {
+		assert.Equal(t, v, fields[k], k)
+	}
+}
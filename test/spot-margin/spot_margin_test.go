@@ -3,6 +3,7 @@ package testspotmargin
 import (
 	"os"
 	"testing"
+	"time"
 
 	"github.com/pkg/errors"
 	"github.com/uscott/go-ftx/api"
@@ -94,6 +95,45 @@ func TestSpotMargin_GetBorrowHistory(t *testing.T) {
 	}
 }
 
+func TestSpotMargin_GetMyBorrowHistory(t *testing.T) {
+
+	ftx := prepForTest(t)
+
+	end := time.Now()
+	hist, err := ftx.SpotMargin.GetMyBorrowHistory(end.Add(-7*24*time.Hour), end)
+	if err != nil {
+		t.Fatal(errors.WithStack(err))
+	}
+	for i, h := range hist {
+		if i > 0 && h.Time.Before(hist[i-1].Time) {
+			t.Fatalf("History out of order at %d", i)
+		}
+	}
+	t.Logf("Entries: %d", len(hist))
+}
+
+func TestSpotMargin_GetSpotMarginHistory(t *testing.T) {
+
+	ftx := prepForTest(t)
+
+	coin := "USD"
+	end := time.Now().Unix()
+	start := end - 24*3600
+	hist, err := ftx.SpotMargin.GetSpotMarginHistory(&coin, &start, &end)
+	if err != nil {
+		t.Fatal(errors.WithStack(err))
+	}
+	for i, h := range hist {
+		if i > 9 {
+			return
+		}
+		if h.Coin != coin {
+			t.Fatalf("Should be equal: %s, %s", h.Coin, coin)
+		}
+		t.Logf("Hist: %+v\n", *h)
+	}
+}
+
 func TestSpotMargin_GetLendingHistory(t *testing.T) {
 
 	ftx := prepForTest(t)
@@ -1,11 +1,13 @@
 package teststaking
 
 import (
+	"encoding/json"
 	"os"
 	"testing"
 
 	"github.com/pkg/errors"
 	"github.com/uscott/go-ftx/api"
+	"github.com/uscott/go-ftx/models"
 )
 
 func client(t *testing.T) *api.Client {
@@ -82,3 +84,22 @@ func TestStaking_GetStakingRewards(t *testing.T) {
 		t.Logf("Balance: %+v\n", *r)
 	}
 }
+
+func TestStaking_StakingRewardDecode(t *testing.T) {
+
+	payload := []byte(`{
+		"coin": "SRM",
+		"id": 43558,
+		"size": 0.01,
+		"status": "complete",
+		"time": "2020-05-08T03:27:14.000000+00:00"
+	}`)
+
+	reward := models.StakingReward{}
+	if err := json.Unmarshal(payload, &reward); err != nil {
+		t.Fatal(errors.WithStack(err))
+	}
+	if reward.Coin != "SRM" || reward.ID != 43558 || reward.Size.String() != "0.01" {
+		t.Fatalf("Unexpected reward: %+v", reward)
+	}
+}
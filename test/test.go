@@ -57,9 +57,9 @@ func PlaceSampleOrders(
 
 	e := ftx.Orders.PlaceOrder(&models.OrderParams{
 		Market:   api.PtrString(USDTSWAP),
-		Side:     api.PtrString(string(models.Buy)),
+		Side:     api.PtrOrderSide(models.Buy),
 		Price:    api.PtrDecimal(bid.Sub(incr)),
-		Type:     api.PtrString(string(models.LimitOrder)),
+		Type:     api.PtrOrderType(models.LimitOrder),
 		Size:     &size,
 		PostOnly: api.PtrBool(false),
 	}, o)
@@ -70,9 +70,9 @@ func PlaceSampleOrders(
 
 	e = ftx.Orders.PlaceOrder(&models.OrderParams{
 		Market:   api.PtrString(USDTSWAP),
-		Side:     api.PtrString(string(models.Sell)),
+		Side:     api.PtrOrderSide(models.Sell),
 		Price:    api.PtrDecimal(ask.Add(incr)),
-		Type:     api.PtrString(string(models.LimitOrder)),
+		Type:     api.PtrOrderType(models.LimitOrder),
 		Size:     &size,
 		PostOnly: api.PtrBool(false),
 	}, o)
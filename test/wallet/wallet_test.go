@@ -1,6 +1,7 @@
 package testwallet
 
 import (
+	"context"
 	"os"
 	"strings"
 	"testing"
@@ -144,3 +145,23 @@ func TestWallet_GetSavedAddresses(t *testing.T) {
 		t.Logf("Address: %+v\n", *a)
 	}
 }
+
+func TestWallet_GetBalanceHistory(t *testing.T) {
+
+	ftx := prepForTest(t)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Minute)
+	defer cancel()
+
+	end := time.Now().UTC().Truncate(24 * time.Hour)
+	snapshots, err := ftx.Wallet.GetBalanceHistory(ctx, end.Add(-48*time.Hour), end)
+	if err != nil {
+		t.Fatal(errors.WithStack(err))
+	}
+	if len(snapshots) != 3 {
+		t.Fatalf("Should be equal: %d, %d", len(snapshots), 3)
+	}
+	for _, s := range snapshots {
+		t.Logf("Snapshot: %+v\n", *s)
+	}
+}
@@ -2,10 +2,28 @@ package testws
 
 import (
 	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"runtime"
+	"strconv"
+	"strings"
+	"sync/atomic"
 	"testing"
 	"time"
 
+	"github.com/gorilla/websocket"
+	"github.com/pkg/errors"
+	"github.com/shopspring/decimal"
+	"github.com/uscott/go-clog"
 	"github.com/uscott/go-ftx/api"
+	"github.com/uscott/go-ftx/api/apitest"
+	"github.com/uscott/go-ftx/models"
 	"github.com/uscott/go-ftx/test"
 )
 
@@ -17,7 +35,7 @@ func Test_WS(t *testing.T) {
 	defer cancel()
 
 	client := api.New()
-	client.Stream.SetDebugMode(true)
+	client.Logger.SetLevel(clog.DebugLevel)
 
 	symbols := []string{"BTC-PERP", "BTC/USD", "FTT-PERP", "FTT/USD"}
 
@@ -69,3 +87,2538 @@ func Test_WS(t *testing.T) {
 		}
 	}
 }
+
+func Test_WS_SlowConsumer(t *testing.T) {
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	pongC := make(chan struct{}, 1)
+	upgrader := websocket.Upgrader{}
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+
+		conn.SetPongHandler(func(string) error {
+			pongC <- struct{}{}
+			return nil
+		})
+		go func() {
+			for {
+				if _, _, err := conn.ReadMessage(); err != nil {
+					return
+				}
+			}
+		}()
+
+		for i := 0; i < 100; i++ {
+			frame := fmt.Sprintf(
+				`{"channel": "ticker", "market": "BTC-PERP", "type": "update", `+
+					`"data": {"bid": %d, "ask": %d, "time": 1}}`, i, i+1)
+			if err = conn.WriteMessage(websocket.TextMessage, []byte(frame)); err != nil {
+				return
+			}
+		}
+
+		err = conn.WriteControl(websocket.PingMessage, nil, time.Now().Add(time.Second))
+		if err != nil {
+			return
+		}
+		<-ctx.Done()
+	}))
+	defer server.Close()
+
+	client := api.New()
+	client.Stream.SetURL("ws" + strings.TrimPrefix(server.URL, "http"))
+
+	// The returned channel is never read: the consumer is stalled
+	if _, err := client.Stream.SubscribeToTickers(ctx, "BTC-PERP"); err != nil {
+		t.Fatal(err)
+	}
+
+	select {
+	case <-pongC:
+	case <-time.After(5 * time.Second):
+		t.Fatal("No pong received while the consumer is stalled")
+	}
+}
+
+func Test_WS_AuthError(t *testing.T) {
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	upgrader := websocket.Upgrader{}
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+
+		for {
+			req := map[string]interface{}{}
+			if err = conn.ReadJSON(&req); err != nil {
+				return
+			}
+			if req["op"] == "login" {
+				err = conn.WriteMessage(websocket.TextMessage,
+					[]byte(`{"type": "error", "code": 400, "msg": "Invalid login credentials"}`))
+				if err != nil {
+					return
+				}
+			}
+		}
+	}))
+	defer server.Close()
+
+	client := api.New(api.WithAuth("key", "secret"))
+	client.Stream.SetURL("ws" + strings.TrimPrefix(server.URL, "http"))
+
+	if _, err := client.Stream.SubscribeToFills(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	select {
+	case err := <-client.Stream.Errors():
+		t.Logf("Error: %v", err)
+	case <-time.After(5 * time.Second):
+		t.Fatal("Auth error not delivered")
+	}
+
+	if client.Stream.Subs[0].Authenticated() {
+		t.Fatal("Should not be authenticated")
+	}
+}
+
+func Test_WS_ReadDeadline(t *testing.T) {
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	connC := make(chan struct{}, 8)
+	upgrader := websocket.Upgrader{}
+
+	// The server never reads, so pings go unanswered, and never writes
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		connC <- struct{}{}
+		<-ctx.Done()
+	}))
+	defer server.Close()
+
+	client := api.New()
+	client.Stream.SetURL("ws" + strings.TrimPrefix(server.URL, "http"))
+	client.Stream.SetTimeout(500 * time.Millisecond)
+
+	if _, err := client.Stream.SubscribeToTickers(ctx, "BTC-PERP"); err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 0; i < 2; i++ {
+		select {
+		case <-connC:
+		case <-time.After(5 * time.Second):
+			t.Fatalf("Connection #%d not made", i+1)
+		}
+	}
+}
+
+func Test_WS_CancelNoLeak(t *testing.T) {
+
+	upgrader := websocket.Upgrader{}
+
+	// The server only reads, so client reads stay blocked until the
+	// connection is closed
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		for {
+			if _, _, err := conn.ReadMessage(); err != nil {
+				return
+			}
+		}
+	}))
+	defer server.Close()
+
+	client := api.New()
+	client.Stream.SetURL("ws" + strings.TrimPrefix(server.URL, "http"))
+
+	before := runtime.NumGoroutine()
+
+	for i := 0; i < 5; i++ {
+		ctx, cancel := context.WithCancel(context.Background())
+		if _, err := client.Stream.SubscribeToTickers(ctx, "BTC-PERP"); err != nil {
+			t.Fatal(err)
+		}
+		cancel()
+	}
+
+	deadline := time.Now().Add(5 * time.Second)
+	for runtime.NumGoroutine() > before {
+		if time.Now().After(deadline) {
+			t.Fatalf("Goroutines leaked: %d, %d", runtime.NumGoroutine(), before)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// newAckServer returns a server confirming subscriptions to any market but
+// BAD-MARKET, for which it sends an error, and SILENT-MARKET, which it ignores.
+func newAckServer() *httptest.Server {
+
+	upgrader := websocket.Upgrader{}
+
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+
+		for {
+			req := models.WSRequest{}
+			if err = conn.ReadJSON(&req); err != nil {
+				return
+			}
+			resp := models.WsResponse{
+				ChannelType:  req.ChannelType,
+				Market:       req.Market,
+				ResponseType: models.Subscribed,
+			}
+			switch req.Market {
+			case "BAD-MARKET":
+				resp = models.WsResponse{ResponseType: models.Error, Code: 400, Message: "Invalid market"}
+			case "SILENT-MARKET":
+				continue
+			}
+			if err = conn.WriteJSON(resp); err != nil {
+				return
+			}
+		}
+	}))
+}
+
+func Test_WS_WaitForAck(t *testing.T) {
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	server := newAckServer()
+	defer server.Close()
+
+	client := api.New()
+	client.Stream.SetURL("ws" + strings.TrimPrefix(server.URL, "http"))
+
+	c, err := client.Stream.SubscribeToTickersWithOptions(ctx, api.SubscribeOptions{
+		Markets:    []string{"BTC-PERP", "ETH-PERP"},
+		Buffer:     16,
+		WaitForAck: true,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cap(c) != 16 {
+		t.Fatalf("Should be equal: %d, %d", cap(c), 16)
+	}
+
+	_, err = client.Stream.SubscribeToTickersWithOptions(ctx, api.SubscribeOptions{
+		Markets:    []string{"BAD-MARKET"},
+		WaitForAck: true,
+	})
+	if err == nil {
+		t.Fatal("Subscribing to an invalid market should fail")
+	}
+	t.Logf("Error: %v", err)
+}
+
+func Test_WS_Compression(t *testing.T) {
+
+	for _, enabled := range []bool{true, false} {
+
+		ctx, cancel := context.WithCancel(context.Background())
+
+		extC := make(chan string, 1)
+		upgrader := websocket.Upgrader{EnableCompression: true}
+
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			conn, err := upgrader.Upgrade(w, r, nil)
+			if err != nil {
+				return
+			}
+			defer conn.Close()
+			extC <- r.Header.Get("Sec-Websocket-Extensions")
+			for {
+				if _, _, err := conn.ReadMessage(); err != nil {
+					return
+				}
+			}
+		}))
+
+		client := api.New()
+		client.Stream.SetURL("ws" + strings.TrimPrefix(server.URL, "http"))
+		client.Stream.SetCompression(enabled)
+
+		if _, err := client.Stream.SubscribeToTickers(ctx, "BTC-PERP"); err != nil {
+			t.Fatal(err)
+		}
+
+		ext := <-extC
+		if offered := strings.Contains(ext, "permessage-deflate"); offered != enabled {
+			t.Fatalf("Should be equal: %v, %v (%q)", offered, enabled, ext)
+		}
+
+		cancel()
+		server.Close()
+	}
+}
+
+func Test_WS_Resubscribe(t *testing.T) {
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	opsC := make(chan string, 16)
+	upgrader := websocket.Upgrader{}
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		for {
+			req := map[string]interface{}{}
+			if err = conn.ReadJSON(&req); err != nil {
+				return
+			}
+			op, _ := req["op"].(string)
+			ch, _ := req["channel"].(string)
+			opsC <- strings.TrimSuffix(op+" "+ch, " ")
+		}
+	}))
+	defer server.Close()
+
+	client := api.New(api.WithAuth("key", "secret"))
+	client.Stream.SetURL("ws" + strings.TrimPrefix(server.URL, "http"))
+
+	if _, err := client.Stream.SubscribeToFills(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	expectOps := func(expected ...string) {
+		for _, e := range expected {
+			select {
+			case op := <-opsC:
+				if op != e {
+					t.Fatalf("Should be equal: %s, %s", op, e)
+				}
+			case <-time.After(5 * time.Second):
+				t.Fatalf("No %s received", e)
+			}
+		}
+	}
+
+	expectOps("login", "subscribe fills")
+
+	ws := client.Stream.Subs[0]
+	if err := client.Stream.Dial(ws); err != nil {
+		t.Fatal(err)
+	}
+	if err := ws.Resubscribe(&client.Stream); err != nil {
+		t.Fatal(err)
+	}
+
+	expectOps("login", "subscribe fills")
+}
+
+func Test_WS_PingRTT(t *testing.T) {
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	upgrader := websocket.Upgrader{}
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		for {
+			_, data, err := conn.ReadMessage()
+			if err != nil {
+				return
+			}
+			if strings.Contains(string(data), `"ping"`) {
+				if err = conn.WriteMessage(websocket.TextMessage, []byte(`{"type": "pong"}`)); err != nil {
+					return
+				}
+			}
+		}
+	}))
+	defer server.Close()
+
+	client := api.New()
+	client.Stream.SetURL("ws" + strings.TrimPrefix(server.URL, "http"))
+	client.Stream.SetPingInterval(100 * time.Millisecond)
+
+	if _, err := client.Stream.SubscribeToTickers(ctx, "BTC-PERP"); err != nil {
+		t.Fatal(err)
+	}
+
+	ws := client.Stream.Subs[0]
+	if ws.LastPingRTT() != 0 || ws.AvgPingRTT() != 0 {
+		t.Fatal("No ping should have been answered yet")
+	}
+
+	deadline := time.Now().Add(5 * time.Second)
+	for ws.LastPingRTT() == 0 {
+		if time.Now().After(deadline) {
+			t.Fatal("No ping answered")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	if ws.AvgPingRTT() <= 0 {
+		t.Fatalf("Average should be positive: %v", ws.AvgPingRTT())
+	}
+}
+
+func Test_WS_AlwaysAuthenticate(t *testing.T) {
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	opsC := make(chan string, 8)
+	upgrader := websocket.Upgrader{}
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		for {
+			req := map[string]interface{}{}
+			if err = conn.ReadJSON(&req); err != nil {
+				return
+			}
+			op, _ := req["op"].(string)
+			ch, _ := req["channel"].(string)
+			opsC <- strings.TrimSuffix(op+" "+ch, " ")
+		}
+	}))
+	defer server.Close()
+
+	client := api.New()
+	client.Stream.SetURL("ws" + strings.TrimPrefix(server.URL, "http"))
+	client.Stream.SetAlwaysAuthenticate(true)
+
+	if _, err := client.Stream.SubscribeToTickers(ctx, "BTC-PERP"); !errors.Is(err, api.ErrNoCredentials) {
+		t.Fatalf("Should be equal: %v, %v", err, api.ErrNoCredentials)
+	}
+
+	client = api.New(api.WithAuth("key", "secret"))
+	client.Stream.SetURL("ws" + strings.TrimPrefix(server.URL, "http"))
+	client.Stream.SetAlwaysAuthenticate(true)
+
+	if _, err := client.Stream.SubscribeToTickers(ctx, "BTC-PERP"); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, e := range []string{"login", "subscribe ticker"} {
+		select {
+		case op := <-opsC:
+			if op != e {
+				t.Fatalf("Should be equal: %s, %s", op, e)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("No %s received", e)
+		}
+	}
+}
+
+func Test_WS_ServeWaitsForAck(t *testing.T) {
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	server := newAckServer()
+	defer server.Close()
+
+	client := api.New()
+	client.Stream.SetURL("ws" + strings.TrimPrefix(server.URL, "http"))
+	client.Stream.SetWaitForAck(true)
+	client.Stream.SetAckTimeout(500 * time.Millisecond)
+
+	if _, err := client.Stream.SubscribeToTickers(ctx, "BTC-PERP"); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, market := range []string{"BAD-MARKET", "SILENT-MARKET"} {
+		_, err := client.Stream.SubscribeToTickers(ctx, market)
+		if err == nil {
+			t.Fatalf("Subscribing to %s should fail", market)
+		}
+		t.Logf("Error: %v", err)
+	}
+
+	client.Stream.SetWaitForAck(false)
+
+	if _, err := client.Stream.SubscribeToTickers(ctx, "BAD-MARKET"); err != nil {
+		t.Fatalf("Subscribing without waiting should not fail: %v", err)
+	}
+}
+
+func Test_WS_UnknownChannel(t *testing.T) {
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	mock := apitest.NewMockStream()
+	defer mock.Close()
+
+	const channel = models.ChannelType("lending")
+
+	ws := api.NewWsSub()
+	ws.AppendRequests(channel, "USD")
+	if err := mock.Stream().ServeSub(ctx, ws); err != nil {
+		t.Fatal(err)
+	}
+
+	err := mock.Send(models.WsResponse{
+		ChannelType:  channel,
+		Market:       "USD",
+		ResponseType: models.Update,
+		Data:         json.RawMessage(`{"coin": "USD", "rate": 0.0001}`),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	select {
+	case e := <-ws.EventC:
+		data, ok := e.(json.RawMessage)
+		if !ok {
+			t.Fatalf("Unexpected event: %T", e)
+		}
+		msg := models.WsResponse{Data: data}
+		v := struct {
+			Coin string  `json:"coin"`
+			Rate float64 `json:"rate"`
+		}{}
+		if err = msg.MapTo(&v); err != nil {
+			t.Fatal(err)
+		}
+		if v.Coin != "USD" || v.Rate != 0.0001 {
+			t.Fatalf("Unexpected data: %+v", v)
+		}
+	case <-ctx.Done():
+		t.Fatal("No event delivered")
+	}
+}
+
+func Test_WS_MaxSubscriptions(t *testing.T) {
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	server := newAckServer()
+	defer server.Close()
+
+	client := api.New()
+	client.Stream.SetURL("ws" + strings.TrimPrefix(server.URL, "http"))
+	client.Stream.SetMaxSubscriptions(3)
+
+	if _, err := client.Stream.SubscribeToTickers(ctx, "BTC-PERP", "ETH-PERP"); err != nil {
+		t.Fatal(err)
+	}
+	if n := client.Stream.Subscriptions(); n != 2 {
+		t.Fatalf("Should be equal: %d, %d", n, 2)
+	}
+
+	_, err := client.Stream.SubscribeToTrades(ctx, "BTC-PERP", "ETH-PERP")
+	if !errors.Is(err, api.ErrTooManySubscriptions) {
+		t.Fatalf("Should be equal: %v, %v", err, api.ErrTooManySubscriptions)
+	}
+
+	tradesCtx, tradesCancel := context.WithCancel(ctx)
+	if _, err = client.Stream.SubscribeToTrades(tradesCtx, "BTC-PERP"); err != nil {
+		t.Fatal(err)
+	}
+	if n := client.Stream.Subscriptions(); n != 3 {
+		t.Fatalf("Should be equal: %d, %d", n, 3)
+	}
+
+	tradesCancel()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for client.Stream.Subscriptions() != 2 {
+		if time.Now().After(deadline) {
+			t.Fatalf("Should be equal: %d, %d", client.Stream.Subscriptions(), 2)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func Test_WS_MarketDataFeed(t *testing.T) {
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	mock := apitest.NewMockStream()
+	defer mock.Close()
+
+	feed, err := mock.Stream().MarketDataFeed(ctx, "BTC-PERP")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	frames := []string{
+		`{"channel": "orderbook", "market": "BTC-PERP", "type": "partial", "data": {` +
+			`"bids": [[100.5, 2], [100.0, 1]], "asks": [[101.0, 3], [101.5, 4]], ` +
+			`"checksum": 3513243081, "time": 1, "action": "partial"}}`,
+		`{"channel": "ticker", "market": "BTC-PERP", "type": "update", "data": {` +
+			`"bid": 100.5, "ask": 101, "bidSize": 2, "askSize": 3, "last": 101, "time": 2}}`,
+		`{"channel": "trades", "market": "BTC-PERP", "type": "update", "data": [{` +
+			`"id": 1, "price": 101, "size": 0.5, "side": "buy", "liquidation": false, ` +
+			`"time": "2021-06-01T12:00:03+00:00"}]}`,
+	}
+	for _, f := range frames {
+		if err = mock.SendRaw([]byte(f)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for _, kind := range []models.MarketUpdateKind{
+		models.BookUpdate, models.TickerUpdate, models.TradeUpdate,
+	} {
+		select {
+		case u := <-feed:
+			if u.Kind != kind || u.Market != "BTC-PERP" {
+				t.Fatalf("Unexpected update: %+v", u)
+			}
+			if !u.BestBid.Equal(decimal.RequireFromString("100.5")) ||
+				!u.BestAsk.Equal(decimal.NewFromInt(101)) {
+				t.Fatalf("Unexpected top of book: %v, %v", u.BestBid, u.BestAsk)
+			}
+			if (kind == models.TickerUpdate) != (u.Ticker != nil) ||
+				(kind == models.TradeUpdate) != (u.Trade != nil) {
+				t.Fatalf("Unexpected update: %+v", u)
+			}
+		case <-ctx.Done():
+			t.Fatalf("No %s update", kind)
+		}
+	}
+}
+
+func Test_WS_Close(t *testing.T) {
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	server := newAckServer()
+	defer server.Close()
+
+	client := api.New()
+	client.Stream.SetURL("ws" + strings.TrimPrefix(server.URL, "http"))
+
+	subs := make([]*api.WsSub, 2)
+	for i, market := range []string{"BTC-PERP", "ETH-PERP"} {
+		subs[i] = api.NewWsSub()
+		subs[i].AppendRequests(models.TickerChannel, market)
+		if err := client.Stream.ServeSub(ctx, subs[i]); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for i := 0; i < 2; i++ {
+		if err := client.Stream.Close(subs[0]); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	select {
+	case _, ok := <-subs[0].EventC:
+		if ok {
+			t.Fatal("EventC should be closed")
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("EventC not closed")
+	}
+
+	if len(client.Stream.Subs) != 1 || client.Stream.Subs[0] != subs[1] {
+		t.Fatalf("Unexpected subscriptions: %v", client.Stream.Subs)
+	}
+	if n := client.Stream.Subscriptions(); n != 1 {
+		t.Fatalf("Should be equal: %d, %d", n, 1)
+	}
+
+	if err := client.Stream.Close(api.NewWsSub()); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func Test_WS_OnRawMessage(t *testing.T) {
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	mock := apitest.NewMockStream()
+	defer mock.Close()
+
+	framesC := make(chan string, 8)
+	mock.Stream().SetOnRawMessage(func(messageType int, data []byte) {
+		if messageType == websocket.TextMessage {
+			framesC <- string(data)
+		}
+	})
+
+	tickersC, err := mock.Stream().SubscribeToTickers(ctx, "BTC-PERP")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	frame := `{"channel": "ticker", "market": "BTC-PERP", "type": "update", "data": ` +
+		`{"bid": 100, "ask": 101, "time": 1}}`
+	if err = mock.SendRaw([]byte(frame)); err != nil {
+		t.Fatal(err)
+	}
+
+	select {
+	case <-tickersC:
+	case <-ctx.Done():
+		t.Fatal("No ticker delivered")
+	}
+
+	// The subscribe ack comes first
+	for _, expected := range []string{"subscribed", frame} {
+		select {
+		case f := <-framesC:
+			if !strings.Contains(f, expected) {
+				t.Fatalf("Unexpected frame: %s", f)
+			}
+		case <-ctx.Done():
+			t.Fatal("Frame not captured")
+		}
+	}
+}
+
+func Test_WS_On(t *testing.T) {
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	mock := apitest.NewMockStream()
+	defer mock.Close()
+
+	tickersC := make(chan *models.TickerResponse, 8)
+
+	ws := api.NewWsSub()
+	ws.AppendRequests(models.TradesChannel, "BTC-PERP")
+	ws.AppendRequests(models.TickerChannel, "BTC-PERP")
+	ws.On(models.TickerChannel, func(e interface{}) {
+		tickersC <- e.(*models.TickerResponse)
+	})
+
+	if err := mock.Stream().ServeSub(ctx, ws); err != nil {
+		t.Fatal(err)
+	}
+
+	frames := []string{
+		`{"channel": "trades", "market": "BTC-PERP", "type": "update", "data": [{` +
+			`"id": 1, "price": 101, "size": 0.5, "side": "buy", "time": "2021-06-01T12:00:03+00:00"}]}`,
+		`{"channel": "ticker", "market": "BTC-PERP", "type": "update", "data": ` +
+			`{"bid": 100, "ask": 101, "time": 1}}`,
+	}
+	for _, f := range frames {
+		if err := mock.SendRaw([]byte(f)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	select {
+	case ticker := <-tickersC:
+		if ticker.Symbol != "BTC-PERP" || !ticker.Bid.Equal(decimal.NewFromInt(100)) {
+			t.Fatalf("Unexpected ticker: %+v", ticker)
+		}
+	case <-ctx.Done():
+		t.Fatal("Ticker handler not called")
+	}
+
+	select {
+	case e := <-ws.EventC:
+		t.Fatalf("Unexpected event: %+v", e)
+	default:
+	}
+}
+
+func Test_WS_ReadFailureNoLeak(t *testing.T) {
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	upgrader := websocket.Upgrader{}
+
+	// The server sends a ticker which fails to decode
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		if _, _, err = conn.ReadMessage(); err != nil {
+			return
+		}
+		err = conn.WriteMessage(websocket.TextMessage, []byte(
+			`{"channel": "ticker", "market": "BTC-PERP", "type": "update", "data": "oops"}`))
+		if err != nil {
+			return
+		}
+		for {
+			if _, _, err = conn.ReadMessage(); err != nil {
+				return
+			}
+		}
+	}))
+	defer server.Close()
+
+	client := api.New()
+	client.Stream.SetURL("ws" + strings.TrimPrefix(server.URL, "http"))
+	client.Stream.SetTimeout(100 * time.Millisecond)
+
+	before := runtime.NumGoroutine()
+
+	ws := api.NewWsSub()
+	ws.AppendRequests(models.TickerChannel, "BTC-PERP")
+	if err := client.Stream.ServeSub(ctx, ws); err != nil {
+		t.Fatal(err)
+	}
+
+	select {
+	case _, ok := <-ws.EventC:
+		if ok {
+			t.Fatal("EventC should be closed")
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("EventC not closed")
+	}
+
+	select {
+	case err := <-client.Stream.Errors():
+		t.Logf("Error: %v", err)
+	default:
+		t.Fatal("Read failure not reported")
+	}
+
+	deadline := time.Now().Add(5 * time.Second)
+	for runtime.NumGoroutine() > before {
+		if time.Now().After(deadline) {
+			t.Fatalf("Goroutines leaked: %d, %d", runtime.NumGoroutine(), before)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	if n := client.Stream.Subscriptions(); n != 0 {
+		t.Fatalf("Should be equal: %d, %d", n, 0)
+	}
+}
+
+func Test_WS_SetDialer(t *testing.T) {
+
+	server := newAckServer()
+	defer server.Close()
+
+	client := api.New()
+	client.Stream.SetURL("ws" + strings.TrimPrefix(server.URL, "http"))
+
+	var dials int32
+	dialer := *websocket.DefaultDialer
+	dialer.NetDialContext = func(ctx context.Context, network, addr string) (net.Conn, error) {
+		atomic.AddInt32(&dials, 1)
+		return (&net.Dialer{}).DialContext(ctx, network, addr)
+	}
+	client.Stream.SetDialer(&dialer)
+
+	ws := api.NewWsSub()
+	if err := client.Stream.Dial(ws); err != nil {
+		t.Fatal(err)
+	}
+	ws.Conn().Close()
+
+	if n := atomic.LoadInt32(&dials); n != 1 {
+		t.Fatalf("Should be equal: %d, %d", n, 1)
+	}
+}
+
+func Test_WS_SetTLSConfig(t *testing.T) {
+
+	upgrader := websocket.Upgrader{}
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if conn, err := upgrader.Upgrade(w, r, nil); err == nil {
+			conn.Close()
+		}
+	}))
+	defer server.Close()
+
+	client := api.New()
+	client.Stream.SetURL("wss" + strings.TrimPrefix(server.URL, "https"))
+
+	// The test certificate is self signed
+	if err := client.Stream.Dial(api.NewWsSub()); err == nil {
+		t.Fatal("Should fail to verify the certificate")
+	}
+
+	roots := x509.NewCertPool()
+	roots.AddCert(server.Certificate())
+	client.Stream.SetTLSConfig(&tls.Config{RootCAs: roots})
+
+	ws := api.NewWsSub()
+	if err := client.Stream.Dial(ws); err != nil {
+		t.Fatal(err)
+	}
+	ws.Conn().Close()
+}
+
+func Test_WS_SetProxy(t *testing.T) {
+
+	proxy, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer proxy.Close()
+
+	acceptedC := make(chan struct{}, 1)
+	go func() {
+		conn, err := proxy.Accept()
+		if err != nil {
+			return
+		}
+		acceptedC <- struct{}{}
+		conn.Close()
+	}()
+
+	client := api.New()
+	client.Stream.SetURL("ws://ftx.invalid/ws/")
+	client.Stream.SetProxy(&url.URL{Scheme: "http", Host: proxy.Addr().String()})
+
+	// The proxy hangs up so only the connection to it matters
+	_ = client.Stream.Dial(api.NewWsSub())
+
+	select {
+	case <-acceptedC:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Proxy not used")
+	}
+}
+
+func Test_WS_Stats(t *testing.T) {
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	server := newAckServer()
+	defer server.Close()
+
+	client := api.New()
+	client.Stream.SetURL("ws" + strings.TrimPrefix(server.URL, "http"))
+	client.Stream.SetWaitForAck(true)
+	client.Stream.SetReconnectionInterval(10 * time.Millisecond)
+
+	if stats := client.Stream.Stats(); stats.Connections != 0 || len(stats.Subs) != 0 {
+		t.Fatalf("Unexpected stats: %+v", stats)
+	}
+
+	before := time.Now()
+	subs := make([]*api.WsSub, 2)
+	for i, markets := range [][]string{{"BTC-PERP", "ETH-PERP"}, {"SOL-PERP"}} {
+		subs[i] = api.NewWsSub()
+		subs[i].AppendRequests(models.TickerChannel, markets...)
+		if err := client.Stream.ServeSub(ctx, subs[i]); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	stats := client.Stream.Stats()
+	if stats.Subscriptions != 3 || stats.Connections != 2 || stats.Reconnects != 0 {
+		t.Fatalf("Unexpected stats: %+v", stats)
+	}
+	for i, expected := range []int{2, 1} {
+		sub := stats.Subs[i]
+		if sub.Subscriptions != expected {
+			t.Fatalf("Should be equal: %d, %d", sub.Subscriptions, expected)
+		}
+		// ServeSub returned after the ack was read
+		if sub.LastMessage.Before(before) {
+			t.Fatalf("Unexpected last message time: %v", sub.LastMessage)
+		}
+	}
+
+	// The failed read makes the sub reconnect
+	subs[1].Conn().Close()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for client.Stream.Stats().Reconnects != 1 {
+		if time.Now().After(deadline) {
+			t.Fatalf("Unexpected stats: %+v", client.Stream.Stats())
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func Test_WS_SetAutoReconnect(t *testing.T) {
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	upgrader := websocket.Upgrader{}
+
+	// The server drops the connection right after the subscription
+	var dials int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&dials, 1)
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		_, _, _ = conn.ReadMessage()
+	}))
+	defer server.Close()
+
+	client := api.New()
+	client.Stream.SetURL("ws" + strings.TrimPrefix(server.URL, "http"))
+	client.Stream.SetReconnectionInterval(10 * time.Millisecond)
+	client.Stream.SetAutoReconnect(false)
+
+	ws := api.NewWsSub()
+	ws.AppendRequests(models.TickerChannel, "BTC-PERP")
+	if err := client.Stream.ServeSub(ctx, ws); err != nil {
+		t.Fatal(err)
+	}
+
+	select {
+	case _, ok := <-ws.EventC:
+		if ok {
+			t.Fatal("EventC should be closed")
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("EventC not closed")
+	}
+
+	select {
+	case err := <-client.Stream.Errors():
+		t.Logf("Error: %v", err)
+	default:
+		t.Fatal("Disconnect not reported")
+	}
+
+	if n := atomic.LoadInt32(&dials); n != 1 {
+		t.Fatalf("Should be equal: %d, %d", n, 1)
+	}
+}
+
+func Test_WS_Tap(t *testing.T) {
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	mock := apitest.NewMockStream()
+	defer mock.Close()
+
+	ws := api.NewWsSub()
+	ws.AppendRequests(models.TickerChannel, "BTC-PERP")
+	fast, slow := ws.Tap(8), ws.Tap(1)
+
+	if err := mock.Stream().ServeSub(ctx, ws); err != nil {
+		t.Fatal(err)
+	}
+
+	const n = 3
+	for i := 1; i <= n; i++ {
+		frame := fmt.Sprintf(`{"channel": "ticker", "market": "BTC-PERP", "type": "update", "data": `+
+			`{"bid": %d, "ask": 101, "time": 1}}`, 100-i)
+		if err := mock.SendRaw([]byte(frame)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// The slow tap is never read and must not hold up the others
+	for _, c := range []<-chan interface{}{ws.EventC, fast} {
+		for i := 1; i <= n; i++ {
+			select {
+			case e := <-c:
+				bid := e.(*models.TickerResponse).Bid
+				if expected := decimal.NewFromInt(int64(100 - i)); !bid.Equal(expected) {
+					t.Fatalf("Should be equal: %v, %v", bid, expected)
+				}
+			case <-ctx.Done():
+				t.Fatal("Event not delivered")
+			}
+		}
+	}
+
+	if err := mock.Stream().Close(ws); err != nil {
+		t.Fatal(err)
+	}
+
+	var received int
+	for range slow {
+		received++
+	}
+	if received != 1 {
+		t.Fatalf("Should be equal: %d, %d", received, 1)
+	}
+	if _, ok := <-fast; ok {
+		t.Fatal("Tap should be closed")
+	}
+	if _, ok := <-ws.Tap(1); ok {
+		t.Fatal("Tap of a closed subscription should be closed")
+	}
+}
+
+func Test_WS_SubscribeToFillsByMarket(t *testing.T) {
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	upgrader := websocket.Upgrader{}
+	requestsC := make(chan models.WSRequest, 8)
+
+	// Fills of every market are sent whatever the subscription
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		for {
+			req := models.WSRequest{}
+			if err = conn.ReadJSON(&req); err != nil {
+				return
+			}
+			if req.Op != models.Subscribe {
+				continue
+			}
+			requestsC <- req
+			for _, market := range []string{"ETH-PERP", "BTC-PERP"} {
+				frame := fmt.Sprintf(`{"channel": "fills", "type": "update", "data": `+
+					`{"id": 1, "market": "%s", "price": 100, "size": 1, "side": "buy"}}`, market)
+				if err = conn.WriteMessage(websocket.TextMessage, []byte(frame)); err != nil {
+					return
+				}
+			}
+		}
+	}))
+	defer server.Close()
+
+	client := api.New(api.WithAuth("key", "secret"))
+	client.Stream.SetURL("ws" + strings.TrimPrefix(server.URL, "http"))
+
+	fillsC, err := client.Stream.SubscribeToFills(ctx, "BTC-PERP")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	select {
+	case req := <-requestsC:
+		if req.ChannelType != models.FillsChannel || req.Market != "BTC-PERP" {
+			t.Fatalf("Unexpected request: %+v", req)
+		}
+	case <-ctx.Done():
+		t.Fatal("No subscription received")
+	}
+
+	select {
+	case fill := <-fillsC:
+		if fill.Symbol != "BTC-PERP" {
+			t.Fatalf("Should be equal: %s, %s", fill.Symbol, "BTC-PERP")
+		}
+	case <-ctx.Done():
+		t.Fatal("No fill delivered")
+	}
+
+	select {
+	case fill := <-fillsC:
+		t.Fatalf("Unexpected fill: %+v", fill)
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	// Without markets the subscription covers the whole account
+	b, _ := json.Marshal(api.MakeRequests(models.FillsChannel, nil)[0])
+	if strings.Contains(string(b), "market") {
+		t.Fatalf("Unexpected request: %s", b)
+	}
+}
+
+func Test_WS_RequestOptions(t *testing.T) {
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	mock := apitest.NewMockStream()
+	defer mock.Close()
+	mock.Stream().SetWaitForAck(true)
+
+	ws := api.NewWsSub()
+	ws.AppendRequestsWithOptions(
+		models.OrderBookGroupedChannel, api.RequestOptions{Grouping: 500}, "BTC-PERP")
+
+	if err := mock.Stream().ServeSub(ctx, ws); err != nil {
+		t.Fatal(err)
+	}
+
+	requests := mock.Requests()
+	if len(requests) != 1 {
+		t.Fatalf("Unexpected requests: %+v", requests)
+	}
+	if r := requests[0]; r.ChannelType != models.OrderBookGroupedChannel || r.Grouping != 500 {
+		t.Fatalf("Unexpected request: %+v", r)
+	}
+
+	// Fields left unset are not sent
+	b, _ := json.Marshal(api.MakeRequests(models.TickerChannel, api.TrivialMap{"BTC-PERP": {}})[0])
+	if expected := `{"channel":"ticker","market":"BTC-PERP","op":"subscribe"}`; string(b) != expected {
+		t.Fatalf("Should be equal: %s, %s", b, expected)
+	}
+}
+
+func Test_WS_RequestOptionsResubscribe(t *testing.T) {
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	mock := apitest.NewMockStream()
+	defer mock.Close()
+	mock.Stream().SetWaitForAck(true)
+	mock.Stream().SetReconnectionInterval(10 * time.Millisecond)
+
+	ws := api.NewWsSub()
+	ws.AppendRequestsWithOptions(
+		models.OrderBookGroupedChannel, api.RequestOptions{Grouping: 500}, "BTC-PERP")
+
+	if err := mock.Stream().ServeSub(ctx, ws); err != nil {
+		t.Fatal(err)
+	}
+
+	waitForRequests := func(n int) []models.WSRequest {
+		for {
+			if requests := mock.Requests(); len(requests) >= n {
+				return requests
+			}
+			if ctx.Err() != nil {
+				t.Fatalf("Unexpected requests: %+v", mock.Requests())
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+	}
+
+	// The grouping is sent again after a reconnect...
+	ws.Conn().Close()
+	waitForRequests(2)
+
+	// ...and after a resubscribe
+	if err := ws.Resubscribe(mock.Stream()); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, r := range waitForRequests(3) {
+		if r.ChannelType != models.OrderBookGroupedChannel || r.Grouping != 500 {
+			t.Fatalf("Unexpected request: %+v", r)
+		}
+	}
+}
+
+func Test_WS_ShareConnections(t *testing.T) {
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	mock := apitest.NewMockStream()
+	defer mock.Close()
+	mock.Stream().SetShareConnections(true)
+	mock.Stream().SetWaitForAck(true)
+
+	tickersCtx, cancelTickers := context.WithCancel(ctx)
+	tickers, err := mock.Stream().SubscribeToTickers(tickersCtx, "BTC-PERP")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tradesCtx, cancelTrades := context.WithCancel(ctx)
+	defer cancelTrades()
+	trades, err := mock.Stream().SubscribeToTrades(tradesCtx, "BTC-PERP", "ETH-PERP")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if stats := mock.Stream().Stats(); stats.Connections != 1 || stats.Subscriptions != 3 {
+		t.Fatalf("Unexpected stats: %+v", stats)
+	}
+
+	frames := []string{
+		`{"channel": "trades", "market": "ETH-PERP", "type": "update", "data": ` +
+			`[{"id": 1, "price": 2000, "size": 1, "side": "buy", "time": "2021-01-01T00:00:00+00:00"}]}`,
+		`{"channel": "ticker", "market": "BTC-PERP", "type": "update", "data": ` +
+			`{"bid": 99, "ask": 101, "time": 1}}`,
+	}
+	for _, frame := range frames {
+		if err := mock.SendRaw([]byte(frame)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	select {
+	case trade := <-trades:
+		if trade.ID != 1 {
+			t.Fatalf("Should be equal: %d, %d", trade.ID, 1)
+		}
+	case <-ctx.Done():
+		t.Fatal("Trade not delivered")
+	}
+	select {
+	case ticker := <-tickers:
+		if expected := decimal.NewFromInt(99); !ticker.Bid.Equal(expected) {
+			t.Fatalf("Should be equal: %v, %v", ticker.Bid, expected)
+		}
+	case <-ctx.Done():
+		t.Fatal("Ticker not delivered")
+	}
+
+	// Only the requests of the closed subscription are unsubscribed
+	cancelTickers()
+
+	var unsubscribed []models.WSRequest
+	for len(unsubscribed) == 0 {
+		if ctx.Err() != nil {
+			t.Fatal("No unsubscribe request")
+		}
+		time.Sleep(10 * time.Millisecond)
+		for _, r := range mock.Requests() {
+			if r.Op == models.UnSubscribe {
+				unsubscribed = append(unsubscribed, r)
+			}
+		}
+	}
+	if len(unsubscribed) != 1 || unsubscribed[0].ChannelType != models.TickerChannel {
+		t.Fatalf("Unexpected requests: %+v", unsubscribed)
+	}
+	if stats := mock.Stream().Stats(); stats.Connections != 1 || stats.Subscriptions != 2 {
+		t.Fatalf("Unexpected stats: %+v", stats)
+	}
+
+	// The connection goes with its last subscription
+	cancelTrades()
+
+	for mock.Stream().Stats().Connections != 0 {
+		if ctx.Err() != nil {
+			t.Fatalf("Unexpected stats: %+v", mock.Stream().Stats())
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func Test_WS_Replay(t *testing.T) {
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	frames := strings.Join([]string{
+		`{"channel": "ticker", "market": "BTC-PERP", "type": "update", "data": {"bid": 99, "ask": 101, "time": 1609459200}}`,
+		`{"channel": "ticker", "market": "BTC-PERP", "type": "subscribed"}`,
+		`not a frame`,
+		`{"channel": "trades", "market": "BTC-PERP", "type": "update", "data": [` +
+			`{"id": 1, "price": 100, "size": 1, "side": "buy", "time": "2021-01-01T00:00:01+00:00"},` +
+			`{"id": 2, "price": 100, "size": 2, "side": "sell", "time": "2021-01-01T00:00:01+00:00"}]}`,
+	}, "\n")
+
+	client := api.New()
+	client.Stream.SetReplaySpeed(10)
+
+	start := time.Now()
+	events, err := client.Stream.Replay(ctx, strings.NewReader(frames))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var received []interface{}
+	for e := range events {
+		received = append(received, e)
+	}
+
+	if len(received) != 3 {
+		t.Fatalf("Unexpected events: %+v", received)
+	}
+	if ticker, ok := received[0].(*models.TickerResponse); !ok || !ticker.Bid.Equal(decimal.NewFromInt(99)) {
+		t.Fatalf("Unexpected event: %+v", received[0])
+	}
+	for i, id := range []int64{1, 2} {
+		if trade, ok := received[i+1].(*models.TradeResponse); !ok || trade.ID != id {
+			t.Fatalf("Unexpected event: %+v", received[i+1])
+		}
+	}
+
+	// The trades were recorded a second after the ticker
+	if elapsed := time.Since(start); elapsed < 100*time.Millisecond || elapsed > time.Second {
+		t.Fatalf("Unexpected replay time: %v", elapsed)
+	}
+
+	select {
+	case err := <-client.Stream.Errors():
+		if !strings.Contains(err.Error(), "line 3") {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+	default:
+		t.Fatal("Unreadable line not reported")
+	}
+}
+
+func Test_WS_RecoverPanic(t *testing.T) {
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	malformed := `{"channel": "ticker", "market": "BTC-PERP", "type": "update", "data": {"bid": null}}`
+
+	tests := []struct {
+		name  string
+		setup func(s *api.Stream, ws *api.WsSub)
+	}{
+		{
+			"read",
+			func(s *api.Stream, ws *api.WsSub) {
+				s.SetOnRawMessage(func(_ int, data []byte) {
+					if string(data) == malformed {
+						var m map[string]int
+						m["bid"]++
+					}
+				})
+			},
+		},
+		{
+			"deliver",
+			func(s *api.Stream, ws *api.WsSub) {
+				ws.On(models.TickerChannel, func(e interface{}) {
+					var ticker *models.TickerResponse
+					_ = ticker.Bid
+				})
+			},
+		},
+	}
+
+	for _, tt := range tests {
+
+		mock := apitest.NewMockStream()
+
+		ws := api.NewWsSub()
+		ws.AppendRequests(models.TickerChannel, "BTC-PERP")
+		tt.setup(mock.Stream(), ws)
+
+		if err := mock.Stream().ServeSub(ctx, ws); err != nil {
+			t.Fatal(err)
+		}
+		if err := mock.SendRaw([]byte(malformed)); err != nil {
+			t.Fatal(err)
+		}
+
+		select {
+		case err := <-mock.Stream().Errors():
+			if !strings.Contains(err.Error(), "Panic") {
+				t.Fatalf("%s: Unexpected error: %v", tt.name, err)
+			}
+		case <-ctx.Done():
+			t.Fatalf("%s: Panic not reported", tt.name)
+		}
+
+		// The subscription is closed as on any other failure
+	drain:
+		for {
+			select {
+			case _, ok := <-ws.EventC:
+				if !ok {
+					break drain
+				}
+			case <-ctx.Done():
+				t.Fatalf("%s: Subscription not closed", tt.name)
+			}
+		}
+
+		mock.Close()
+	}
+}
+
+func Test_WS_AnswerPings(t *testing.T) {
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	upgrader := websocket.Upgrader{}
+	controlPong, dataPong := make(chan string, 1), make(chan string, 1)
+
+	// The server pings both with a control frame and with a data frame
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		conn.SetPongHandler(func(msg string) error {
+			controlPong <- msg
+			return nil
+		})
+		if _, _, err = conn.ReadMessage(); err != nil {
+			return
+		}
+		if err = conn.WriteControl(websocket.PingMessage, []byte("hello"), time.Now().Add(time.Second)); err != nil {
+			return
+		}
+		if err = conn.WriteMessage(websocket.TextMessage, []byte(`{"type": "ping"}`)); err != nil {
+			return
+		}
+		for {
+			_, data, err := conn.ReadMessage()
+			if err != nil {
+				return
+			}
+			dataPong <- strings.TrimSpace(string(data))
+		}
+	}))
+	defer server.Close()
+
+	client := api.New()
+	client.Stream.SetURL("ws" + strings.TrimPrefix(server.URL, "http"))
+
+	ws := api.NewWsSub()
+	ws.AppendRequests(models.TickerChannel, "BTC-PERP")
+	if err := client.Stream.ServeSub(ctx, ws); err != nil {
+		t.Fatal(err)
+	}
+
+	select {
+	case msg := <-controlPong:
+		if msg != "hello" {
+			t.Fatalf("Should be equal: %s, %s", msg, "hello")
+		}
+	case <-ctx.Done():
+		t.Fatal("Control ping not answered")
+	}
+
+	select {
+	case msg := <-dataPong:
+		if expected := `{"op":"pong"}`; msg != expected {
+			t.Fatalf("Should be equal: %s, %s", msg, expected)
+		}
+	case <-ctx.Done():
+		t.Fatal("Data ping not answered")
+	}
+}
+
+func Test_WS_LoginBeforePrivateSubscribe(t *testing.T) {
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	opsC := make(chan string, 8)
+	upgrader := websocket.Upgrader{}
+
+	// The first connection is dropped once subscribed to check the order
+	// after a reconnection as well
+	var dials int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		first := atomic.AddInt32(&dials, 1) == 1
+		for {
+			req := map[string]interface{}{}
+			if err = conn.ReadJSON(&req); err != nil {
+				return
+			}
+			op, _ := req["op"].(string)
+			ch, _ := req["channel"].(string)
+			opsC <- strings.TrimSuffix(op+" "+ch, " ")
+			if first && op == "subscribe" {
+				return
+			}
+		}
+	}))
+	defer server.Close()
+
+	client := api.New(api.WithAuth("key", "secret"))
+	client.Stream.SetURL("ws" + strings.TrimPrefix(server.URL, "http"))
+	client.Stream.SetReconnectionInterval(10 * time.Millisecond)
+
+	if _, err := client.Stream.SubscribeToFills(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, e := range []string{"login", "subscribe fills", "login", "subscribe fills"} {
+		select {
+		case op := <-opsC:
+			if op != e {
+				t.Fatalf("Should be equal: %s, %s", op, e)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("No %s received", e)
+		}
+	}
+}
+
+// hostTransport sends every request to the test server instead of FTX.
+type hostTransport string
+
+func (h hostTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	req.URL.Scheme, req.URL.Host = "http", string(h)
+	return http.DefaultTransport.RoundTrip(req)
+}
+
+func Test_WS_SubscribeToTradesWithBackfill(t *testing.T) {
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	upgrader := websocket.Upgrader{}
+	since := time.Date(2021, 6, 1, 12, 0, 0, 0, time.UTC)
+
+	var query url.Values
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+
+		// Trades are returned latest first as FTX does
+		if strings.HasPrefix(r.URL.Path, "/api/markets/BTC-PERP/trades") {
+			query = r.URL.Query()
+			_, _ = w.Write([]byte(`{"success": true, "result": [` +
+				`{"id": 2, "price": 101, "size": 1, "side": "buy", "time": "2021-06-01T12:00:02+00:00"},` +
+				`{"id": 1, "price": 100, "size": 1, "side": "sell", "time": "2021-06-01T12:00:01+00:00"}]}`))
+			return
+		}
+
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		if _, _, err = conn.ReadMessage(); err != nil {
+			return
+		}
+		// The live feed overlaps the backfill
+		err = conn.WriteMessage(websocket.TextMessage, []byte(
+			`{"channel": "trades", "market": "BTC-PERP", "type": "update", "data": [`+
+				`{"id": 2, "price": 101, "size": 1, "side": "buy", "time": "2021-06-01T12:00:02+00:00"},`+
+				`{"id": 3, "price": 102, "size": 1, "side": "buy", "time": "2021-06-01T12:00:03+00:00"}]}`))
+		if err != nil {
+			return
+		}
+		for {
+			if _, _, err = conn.ReadMessage(); err != nil {
+				return
+			}
+		}
+	}))
+	defer server.Close()
+
+	client := api.New(api.WithHTTPClient(&http.Client{
+		Transport: hostTransport(strings.TrimPrefix(server.URL, "http://")),
+	}))
+	client.Stream.SetURL("ws" + strings.TrimPrefix(server.URL, "http"))
+
+	trades, err := client.Stream.SubscribeToTradesWithBackfill(ctx, "BTC-PERP", since)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if start := query.Get("start_time"); start != strconv.FormatInt(since.Unix(), 10) {
+		t.Fatalf("Should be equal: %s, %d", start, since.Unix())
+	}
+
+	for _, id := range []int64{1, 2, 3} {
+		select {
+		case trade := <-trades:
+			if trade.ID != id || trade.Symbol != "BTC-PERP" {
+				t.Fatalf("Unexpected trade: %+v", trade)
+			}
+		case <-ctx.Done():
+			t.Fatalf("Trade %d not received", id)
+		}
+	}
+
+	select {
+	case trade := <-trades:
+		t.Fatalf("Unexpected trade: %+v", trade)
+	case <-time.After(50 * time.Millisecond):
+	}
+}
+
+func Test_WS_SetUserAgent(t *testing.T) {
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	agents := make(chan string, 1)
+	upgrader := websocket.Upgrader{}
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		agents <- r.Header.Get("User-Agent")
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		for {
+			if _, _, err = conn.ReadMessage(); err != nil {
+				return
+			}
+		}
+	}))
+	defer server.Close()
+
+	client := api.New()
+	client.SetUserAgent("my-app/1.2")
+	client.Stream.SetURL("ws" + strings.TrimPrefix(server.URL, "http"))
+
+	ws := api.NewWsSub()
+	ws.AppendRequests(models.TickerChannel, "BTC-PERP")
+	if err := client.Stream.ServeSub(ctx, ws); err != nil {
+		t.Fatal(err)
+	}
+
+	if ua := <-agents; ua != "my-app/1.2" {
+		t.Fatalf("Should be equal: %s, %s", ua, "my-app/1.2")
+	}
+}
+
+func Test_WS_SetMaxEventAge(t *testing.T) {
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	mock := apitest.NewMockStream()
+	defer mock.Close()
+	mock.Stream().SetMaxEventAge(100 * time.Millisecond)
+
+	ws := api.NewWsSub()
+	ws.AppendRequests(models.TickerChannel, "BTC-PERP")
+	if err := mock.Stream().ServeSub(ctx, ws); err != nil {
+		t.Fatal(err)
+	}
+
+	send := func(bid int) {
+		frame := fmt.Sprintf(`{"channel": "ticker", "market": "BTC-PERP", "type": "update", "data": `+
+			`{"bid": %d, "ask": 101, "time": 1}}`, bid)
+		if err := mock.SendRaw([]byte(frame)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// The first ticker is held by the delivery of EventC, the others queue
+	// up behind it until they are stale
+	for bid := 1; bid <= 3; bid++ {
+		send(bid)
+	}
+	time.Sleep(300 * time.Millisecond)
+	send(4)
+
+	for _, expected := range []int64{1, 4} {
+		select {
+		case e := <-ws.EventC:
+			if bid := e.(*models.TickerResponse).Bid; !bid.Equal(decimal.NewFromInt(expected)) {
+				t.Fatalf("Should be equal: %v, %d", bid, expected)
+			}
+		case <-ctx.Done():
+			t.Fatal("Event not delivered")
+		}
+	}
+
+	stats := mock.Stream().Stats()
+	if stats.Dropped != 2 || len(stats.Subs) != 1 || stats.Subs[0].Dropped != 2 {
+		t.Fatalf("Unexpected stats: %+v", stats)
+	}
+}
+
+func Test_WS_SetMaxQueuedEvents(t *testing.T) {
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	mock := apitest.NewMockStream()
+	defer mock.Close()
+	mock.Stream().SetMaxQueuedEvents(2)
+
+	ws := api.NewWsSub()
+	ws.AppendRequests(models.TickerChannel, "BTC-PERP")
+	if err := mock.Stream().ServeSub(ctx, ws); err != nil {
+		t.Fatal(err)
+	}
+
+	// Tickers queue up behind the one held by the delivery of EventC, the
+	// oldest being dropped once two are queued
+	for bid := 1; bid <= 5; bid++ {
+		frame := fmt.Sprintf(`{"channel": "ticker", "market": "BTC-PERP", "type": "update", "data": `+
+			`{"bid": %d, "ask": 101, "time": 1}}`, bid)
+		if err := mock.SendRaw([]byte(frame)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	time.Sleep(200 * time.Millisecond)
+
+	select {
+	case err := <-mock.Stream().Errors():
+		if !errors.Is(err, api.ErrQueueFull) {
+			t.Fatalf("Should be equal: %v, %v", err, api.ErrQueueFull)
+		}
+	default:
+		t.Fatal("Full queue not reported")
+	}
+
+	received := 0
+	for last := false; !last; {
+		select {
+		case e := <-ws.EventC:
+			received++
+			last = e.(*models.TickerResponse).Bid.Equal(decimal.NewFromInt(5))
+		case <-ctx.Done():
+			t.Fatal("Event not delivered")
+		}
+	}
+
+	stats := mock.Stream().Stats()
+	if stats.Dropped < 2 || received+stats.Dropped != 5 {
+		t.Fatalf("Unexpected stats: %+v, %d received", stats, received)
+	}
+	select {
+	case err := <-mock.Stream().Errors():
+		t.Fatalf("Reported again: %v", err)
+	default:
+	}
+}
+
+func Test_WS_Heartbeat(t *testing.T) {
+
+	// heartbeatServer answers pings with pongs if answer is set, counting
+	// connections and the pings sent as text frames
+	heartbeatServer := func(answer bool) (*httptest.Server, chan struct{}, chan string) {
+
+		connC := make(chan struct{}, 8)
+		pingC := make(chan string, 64)
+		upgrader := websocket.Upgrader{}
+
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			conn, err := upgrader.Upgrade(w, r, nil)
+			if err != nil {
+				return
+			}
+			defer conn.Close()
+			connC <- struct{}{}
+			for {
+				messageType, data, err := conn.ReadMessage()
+				if err != nil {
+					return
+				}
+				if messageType != websocket.TextMessage || !strings.Contains(string(data), `"ping"`) {
+					continue
+				}
+				select {
+				case pingC <- strings.TrimSpace(string(data)):
+				default:
+				}
+				if answer {
+					if err = conn.WriteMessage(websocket.TextMessage, []byte(`{"type": "pong"}`)); err != nil {
+						return
+					}
+				}
+			}
+		}))
+
+		return server, connC, pingC
+	}
+
+	t.Run("Alive", func(t *testing.T) {
+
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+
+		server, connC, pingC := heartbeatServer(true)
+		defer server.Close()
+
+		client := api.New()
+		client.Stream.SetURL("ws" + strings.TrimPrefix(server.URL, "http"))
+		client.Stream.SetPingInterval(50 * time.Millisecond)
+
+		if _, err := client.Stream.SubscribeToTickers(ctx, "BTC-PERP"); err != nil {
+			t.Fatal(err)
+		}
+		<-connC
+
+		for i := 0; i < 6; i++ {
+			select {
+			case ping := <-pingC:
+				if expected := `{"op":"ping"}`; ping != expected {
+					t.Fatalf("Should be equal: %s, %s", ping, expected)
+				}
+			case <-time.After(5 * time.Second):
+				t.Fatalf("Ping #%d not sent", i+1)
+			}
+		}
+
+		select {
+		case <-connC:
+			t.Fatal("Live connection should not be reconnected")
+		default:
+		}
+
+		if rtt := client.Stream.Subs[0].LastPingRTT(); rtt <= 0 {
+			t.Fatalf("Should be positive: %v", rtt)
+		}
+	})
+
+	t.Run("Dead", func(t *testing.T) {
+
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+
+		server, connC, _ := heartbeatServer(false)
+		defer server.Close()
+
+		client := api.New()
+		client.Stream.SetURL("ws" + strings.TrimPrefix(server.URL, "http"))
+		client.Stream.SetPingInterval(50 * time.Millisecond)
+
+		if _, err := client.Stream.SubscribeToTickers(ctx, "BTC-PERP"); err != nil {
+			t.Fatal(err)
+		}
+
+		for i := 0; i < 2; i++ {
+			select {
+			case <-connC:
+			case <-time.After(5 * time.Second):
+				t.Fatalf("Connection #%d not made", i+1)
+			}
+		}
+	})
+}
+
+func Test_WS_SubscribeForever(t *testing.T) {
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	upgrader := websocket.Upgrader{}
+
+	// Every connection sends one ticker and is dropped, the second dial
+	// being refused
+	var dials int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n := atomic.AddInt32(&dials, 1)
+		if n == 2 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		if _, _, err = conn.ReadMessage(); err != nil {
+			return
+		}
+		_ = conn.WriteMessage(websocket.TextMessage, []byte(fmt.Sprintf(
+			`{"channel": "ticker", "market": "BTC-PERP", "type": "update", `+
+				`"data": {"bid": %d, "ask": %d, "time": 1}}`, n, n+1)))
+		// Leaves time for the ticker to be delivered before the drop
+		time.Sleep(50 * time.Millisecond)
+	}))
+	defer server.Close()
+
+	client := api.New()
+	client.Stream.SetURL("ws" + strings.TrimPrefix(server.URL, "http"))
+	client.Stream.SetReconnectionInterval(10 * time.Millisecond)
+	client.Stream.SetAutoReconnect(false)
+
+	c := client.Stream.SubscribeForever(ctx, models.TickerChannel, "BTC-PERP")
+
+	for _, expected := range []float64{1, 3, 4} {
+		select {
+		case e, ok := <-c:
+			if !ok {
+				t.Fatal("Channel should be open")
+			}
+			ticker, ok := e.(*models.TickerResponse)
+			if !ok {
+				t.Fatalf("Unexpected event: %T", e)
+			}
+			if bid, _ := ticker.Ticker.Bid.Float64(); bid != expected {
+				t.Fatalf("Should be equal: %v, %v", bid, expected)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("Ticker %v not received", expected)
+		}
+	}
+
+	cancel()
+
+	deadline := time.After(5 * time.Second)
+	for {
+		select {
+		case _, ok := <-c:
+			if !ok {
+				return
+			}
+		case <-deadline:
+			t.Fatal("Channel should be closed")
+		}
+	}
+}
+
+func Test_WS_SetFirstDataTimeout(t *testing.T) {
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	upgrader := websocket.Upgrader{}
+
+	// Every subscription is confirmed but only BTC-PERP gets data
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		for {
+			req := models.WSRequest{}
+			if err = conn.ReadJSON(&req); err != nil {
+				return
+			}
+			err = conn.WriteJSON(models.WsResponse{
+				ChannelType: req.ChannelType, Market: req.Market, ResponseType: models.Subscribed})
+			if err != nil {
+				return
+			}
+			if req.Market == "BTC-PERP" {
+				err = conn.WriteMessage(websocket.TextMessage, []byte(
+					`{"channel": "ticker", "market": "BTC-PERP", "type": "update", `+
+						`"data": {"bid": 1, "ask": 2, "time": 1}}`))
+				if err != nil {
+					return
+				}
+			}
+		}
+	}))
+	defer server.Close()
+
+	client := api.New()
+	client.Stream.SetURL("ws" + strings.TrimPrefix(server.URL, "http"))
+	client.Stream.SetFirstDataTimeout(100 * time.Millisecond)
+
+	tickers, err := client.Stream.SubscribeToTickers(ctx, "BTC-PERP", "ILLIQUID-PERP")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	select {
+	case <-tickers:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Ticker not received")
+	}
+
+	select {
+	case err := <-client.Stream.Errors():
+		if !errors.Is(err, api.ErrNoData) || !strings.Contains(err.Error(), "ILLIQUID-PERP") {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("ErrNoData not sent")
+	}
+
+	// Only the silent market is reported and the connection stays open
+	time.Sleep(200 * time.Millisecond)
+	select {
+	case err := <-client.Stream.Errors():
+		t.Fatalf("Unexpected error: %v", err)
+	default:
+	}
+	if stats := client.Stream.Stats(); stats.Connections != 1 || stats.Reconnects != 0 {
+		t.Fatalf("Unexpected stats: %+v", stats)
+	}
+}
+
+func Test_WS_WsSubClose(t *testing.T) {
+
+	upgrader := websocket.Upgrader{}
+	closeC := make(chan int, 1)
+
+	// The server streams tickers until the client closes the connection
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		go func() {
+			for i := 0; ; i++ {
+				err := conn.WriteMessage(websocket.TextMessage, []byte(fmt.Sprintf(
+					`{"channel": "ticker", "market": "BTC-PERP", "type": "update", `+
+						`"data": {"bid": %d, "ask": %d, "time": 1}}`, i, i+1)))
+				if err != nil {
+					return
+				}
+				time.Sleep(time.Millisecond)
+			}
+		}()
+		for {
+			if _, _, err = conn.ReadMessage(); err != nil {
+				if ce, ok := err.(*websocket.CloseError); ok {
+					closeC <- ce.Code
+				}
+				return
+			}
+		}
+	}))
+	defer server.Close()
+
+	client := api.New()
+	client.Stream.SetURL("ws" + strings.TrimPrefix(server.URL, "http"))
+
+	ws := api.NewWsSub()
+	ws.AppendRequests(models.TickerChannel, "BTC-PERP")
+	if err := client.Stream.ServeSub(context.Background(), ws); err != nil {
+		t.Fatal(err)
+	}
+
+	done := make(chan int)
+	go func() {
+		n := 0
+		for range ws.EventC {
+			n++
+		}
+		done <- n
+	}()
+
+	time.Sleep(20 * time.Millisecond)
+
+	if err := ws.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	select {
+	case code := <-closeC:
+		if code != websocket.CloseNormalClosure {
+			t.Fatalf("Should be equal: %d, %d", code, websocket.CloseNormalClosure)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Close frame not received")
+	}
+
+	select {
+	case n := <-done:
+		if n == 0 {
+			t.Fatal("No event received")
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("EventC not closed")
+	}
+
+	if err := ws.Close(); err != nil {
+		t.Fatalf("Closing again should do nothing: %v", err)
+	}
+	if err := client.Stream.Close(ws); err != nil {
+		t.Fatalf("Closing again should do nothing: %v", err)
+	}
+	if n := len(client.Stream.Subs); n != 0 {
+		t.Fatalf("Should be equal: %d, %d", n, 0)
+	}
+
+	// A subscription never served only has its EventC closed
+	unserved := api.NewWsSub()
+	if err := unserved.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := <-unserved.EventC; ok {
+		t.Fatal("EventC should be closed")
+	}
+}
+
+func Test_WS_Maintenance(t *testing.T) {
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	const backoff = 200 * time.Millisecond
+
+	upgrader := websocket.Upgrader{}
+
+	// The first connection sends a ticker and is closed for maintenance
+	var dials int32
+	closedAt, redialedAt := make(chan time.Time, 1), make(chan time.Time, 1)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n := atomic.AddInt32(&dials, 1)
+		if n == 2 {
+			redialedAt <- time.Now()
+		}
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		if _, _, err = conn.ReadMessage(); err != nil {
+			return
+		}
+		_ = conn.WriteMessage(websocket.TextMessage, []byte(fmt.Sprintf(
+			`{"channel": "ticker", "market": "BTC-PERP", "type": "update", `+
+				`"data": {"bid": %d, "ask": %d, "time": 1}}`, n, n+1)))
+		if n > 1 {
+			<-ctx.Done()
+			return
+		}
+		time.Sleep(50 * time.Millisecond)
+		closedAt <- time.Now()
+		_ = conn.WriteMessage(websocket.CloseMessage,
+			websocket.FormatCloseMessage(websocket.CloseServiceRestart, "Scheduled maintenance"))
+		time.Sleep(50 * time.Millisecond)
+	}))
+	defer server.Close()
+
+	client := api.New()
+	client.Stream.SetURL("ws" + strings.TrimPrefix(server.URL, "http"))
+	client.Stream.SetReconnectionInterval(10 * time.Millisecond)
+	client.Stream.SetMaintenanceBackoff(backoff)
+
+	maintenance := make(chan bool, 2)
+	client.Stream.SetOnMaintenance(func(m bool) { maintenance <- m })
+
+	c, err := client.Stream.SubscribeToTickers(ctx, "BTC-PERP")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, expected := range []float64{1, 2} {
+		select {
+		case ticker := <-c:
+			if bid, _ := ticker.Ticker.Bid.Float64(); bid != expected {
+				t.Fatalf("Should be equal: %v, %v", bid, expected)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("Ticker %v not received", expected)
+		}
+	}
+
+	if wait := (<-redialedAt).Sub(<-closedAt); wait < backoff {
+		t.Fatalf("Reconnected after %v, before the maintenance backoff", wait)
+	}
+
+	select {
+	case err := <-client.Stream.Errors():
+		if !errors.Is(err, api.ErrMaintenance) {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+	default:
+		t.Fatal("Maintenance not reported")
+	}
+
+	for _, expected := range []bool{true, false} {
+		if m := <-maintenance; m != expected {
+			t.Fatalf("Should be equal: %v, %v", m, expected)
+		}
+	}
+}
+
+func Test_WS_Shutdown(t *testing.T) {
+
+	server := newAckServer()
+	defer server.Close()
+
+	client := api.New()
+	client.Stream.SetURL("ws" + strings.TrimPrefix(server.URL, "http"))
+
+	before := runtime.NumGoroutine()
+
+	client.Stream.WithContext(context.Background())
+
+	// Subscriptions made with contexts which are never cancelled
+	if _, err := client.Stream.SubscribeToTickers(context.Background(), "BTC-PERP"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := client.Stream.SubscribeToTrades(context.Background(), "ETH-PERP"); err != nil {
+		t.Fatal(err)
+	}
+	ws := api.NewWsSub()
+	ws.AppendRequests(models.OrderBookChannel, "SOL-PERP")
+	if err := client.Stream.ServeSub(context.Background(), ws); err != nil {
+		t.Fatal(err)
+	}
+	forever := client.Stream.SubscribeForever(context.Background(), models.TickerChannel, "ETH-PERP")
+
+	if n := client.Stream.Stats().Connections; n < 3 {
+		t.Fatalf("Unexpected connections: %d", n)
+	}
+
+	if err := client.Stream.Shutdown(); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, ok := <-ws.EventC; ok {
+		t.Fatal("EventC should be closed")
+	}
+	select {
+	case _, ok := <-forever:
+		if ok {
+			t.Fatal("Channel should be closed")
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Channel not closed")
+	}
+
+	if _, err := client.Stream.SubscribeToTickers(context.Background(), "BTC-PERP"); err == nil {
+		t.Fatal("Should fail after shutdown")
+	}
+
+	deadline := time.Now().Add(5 * time.Second)
+	for runtime.NumGoroutine() > before {
+		if time.Now().After(deadline) {
+			t.Fatalf("Goroutines leaked: %d, %d", runtime.NumGoroutine(), before)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// failingConn fails every write from the given one on.
+type failingConn struct {
+	net.Conn
+	writes int32
+	failAt int32
+}
+
+func (c *failingConn) Write(b []byte) (int, error) {
+	if atomic.AddInt32(&c.writes, 1) >= c.failAt {
+		return 0, errors.New("Write failed")
+	}
+	return c.Conn.Write(b)
+}
+
+func Test_WS_SubscribeErrors(t *testing.T) {
+
+	upgrader := websocket.Upgrader{}
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		for {
+			if _, _, err := conn.ReadMessage(); err != nil {
+				return
+			}
+		}
+	}))
+	defer server.Close()
+
+	client := api.New()
+	client.Stream.SetURL("ws" + strings.TrimPrefix(server.URL, "http"))
+	// The handshake and the first request are written, the second fails
+	client.Stream.SetDialer(&websocket.Dialer{
+		NetDial: func(network, addr string) (net.Conn, error) {
+			conn, err := net.Dial(network, addr)
+			return &failingConn{Conn: conn, failAt: 3}, err
+		},
+	})
+
+	ws := api.NewWsSub()
+	ws.AppendRequests(models.TickerChannel, "BTC-PERP")
+	ws.AppendRequests(models.TickerChannel, "ETH-PERP")
+	ws.AppendRequests(models.MarketsChannel)
+
+	if err := client.Stream.Dial(ws); err != nil {
+		t.Fatal(err)
+	}
+	defer ws.Conn().Close()
+
+	err := ws.Subscribe()
+	var partial *api.PartialError
+	if !errors.As(err, &partial) {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if len(partial.Errors) != 2 || partial.Parts != 3 {
+		t.Fatalf("Unexpected errors: %v", partial)
+	}
+
+	failed, unsent := partial.Errors[0], partial.Errors[1]
+	if !strings.HasPrefix(failed.Error(), "Subscribe to ticker ETH-PERP:") || errors.Is(failed, api.ErrNotSent) {
+		t.Fatalf("Unexpected error: %v", failed)
+	}
+	if !strings.HasPrefix(unsent.Error(), "Subscribe to markets:") || !errors.Is(unsent, api.ErrNotSent) {
+		t.Fatalf("Unexpected error: %v", unsent)
+	}
+}
+
+func Test_WS_SubscribeClosesChannel(t *testing.T) {
+
+	upgrader := websocket.Upgrader{}
+
+	// Tickers are sent and the connection dropped, trades never end
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		req := models.WSRequest{}
+		if err = conn.ReadJSON(&req); err != nil {
+			return
+		}
+		if req.ChannelType != models.TickerChannel {
+			for {
+				if _, _, err := conn.ReadMessage(); err != nil {
+					return
+				}
+			}
+		}
+		for i := 1; i <= 3; i++ {
+			_ = conn.WriteMessage(websocket.TextMessage, []byte(fmt.Sprintf(
+				`{"channel": "ticker", "market": "BTC-PERP", "type": "update", `+
+					`"data": {"bid": %d, "ask": %d, "time": 1}}`, i, i+1)))
+		}
+		time.Sleep(50 * time.Millisecond)
+	}))
+	defer server.Close()
+
+	client := api.New()
+	client.Stream.SetURL("ws" + strings.TrimPrefix(server.URL, "http"))
+	client.Stream.SetAutoReconnect(false)
+
+	tickers, err := client.Stream.SubscribeToTickers(context.Background(), "BTC-PERP")
+	if err != nil {
+		t.Fatal(err)
+	}
+	trades, err := client.Stream.SubscribeToTrades(context.Background(), "BTC-PERP")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	drain := func(name string, n int, count func() int) {
+		done := make(chan int, 1)
+		go func() { done <- count() }()
+		select {
+		case got := <-done:
+			if got != n {
+				t.Fatalf("Should be equal: %d, %d", got, n)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("%s channel not closed", name)
+		}
+	}
+
+	drain("Ticker", 3, func() (n int) {
+		for range tickers {
+			n++
+		}
+		return
+	})
+
+	if err = client.Stream.Shutdown(); err != nil {
+		t.Fatal(err)
+	}
+	drain("Trade", 0, func() (n int) {
+		for range trades {
+			n++
+		}
+		return
+	})
+}
+
+func Test_WS_DeprecatedServe(t *testing.T) {
+
+	ctx, cancel := context.WithCancel(context.Background())
+
+	server := newAckServer()
+	defer server.Close()
+
+	client := api.New()
+	client.Stream.SetURL("ws" + strings.TrimPrefix(server.URL, "http"))
+
+	ws := client.Stream.WsSub
+	ws.AppendRequests(models.TickerChannel, "BTC-PERP")
+
+	if err := client.Stream.Serve(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if conn := client.Stream.WSConn(); conn == nil || conn != ws.Conn() {
+		t.Fatalf("Unexpected connection: %v", conn)
+	}
+	if client.Stream.IsLoggedIn() {
+		t.Fatal("Should not be logged in")
+	}
+
+	cancel()
+
+	select {
+	case _, ok := <-ws.EventC:
+		if ok {
+			t.Fatal("EventC should be closed")
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("EventC not closed")
+	}
+}
+
+func Test_WS_DeprecatedServeTwice(t *testing.T) {
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	mock := apitest.NewMockStream()
+	defer mock.Close()
+	mock.Stream().SetWaitForAck(true)
+
+	ws := mock.Stream().WsSub
+	ws.AppendRequests(models.TickerChannel, "BTC-PERP")
+	if err := mock.Stream().Serve(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	// Serving again sends the requests appended since on the connection
+	ws.AppendRequests(models.TradesChannel, "BTC-PERP")
+	if err := mock.Stream().Serve(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	for len(mock.Requests()) < 2 {
+		if ctx.Err() != nil {
+			t.Fatalf("Unexpected requests: %+v", mock.Requests())
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if requests := mock.Requests(); len(requests) != 2 || requests[1].ChannelType != models.TradesChannel {
+		t.Fatalf("Unexpected requests: %+v", requests)
+	}
+
+	if err := mock.Stream().ServeSub(ctx, ws); !errors.Is(err, api.ErrAlreadyServed) {
+		t.Fatalf("Should be equal: %v, %v", err, api.ErrAlreadyServed)
+	}
+
+	cancel()
+
+	select {
+	case _, ok := <-ws.EventC:
+		if ok {
+			t.Fatal("EventC should be closed")
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("EventC not closed")
+	}
+}
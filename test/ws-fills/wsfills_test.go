@@ -1,6 +1,7 @@
 package testwsfills
 
 import (
+	"encoding/json"
 	"testing"
 	"time"
 
@@ -38,3 +39,70 @@ func Test_Fills(t *testing.T) {
 		}
 	}
 }
+
+func TestFill_Decode(t *testing.T) {
+
+	frame := `{"channel": "fills", "type": "update", "data": {` +
+		`"fee": 0.0097, "feeCurrency": "USD", "feeRate": "0.0007", "future": null, ` +
+		`"id": 7828307, "liquidity": "taker", "market": "BTC/USD", ` +
+		`"baseCurrency": "BTC", "quoteCurrency": "USD", "orderId": 38065410, ` +
+		`"tradeId": 19129310, "price": 13860.5, "side": "buy", "size": "0.001", ` +
+		`"time": "2020-11-06T03:02:11.553347+00:00", "type": "order"}}`
+
+	msg := models.WsResponse{}
+	if err := json.Unmarshal([]byte(frame), &msg); err != nil {
+		t.Fatal(err)
+	}
+
+	fill, err := msg.MapToFillResponse()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	decimals := []struct {
+		name     string
+		value    decimal.Decimal
+		expected string
+	}{
+		{"fee", fill.Fee, "0.0097"},
+		{"feeRate", fill.FeeRate, "0.0007"},
+		{"price", fill.Price, "13860.5"},
+		{"size", fill.Size, "0.001"},
+	}
+	for _, d := range decimals {
+		if !d.value.Equal(decimal.RequireFromString(d.expected)) {
+			t.Fatalf("Should be equal: %v, %v - %s", d.value, d.expected, d.name)
+		}
+	}
+
+	strs := []struct {
+		name     string
+		value    string
+		expected string
+	}{
+		{"feeCurrency", fill.FeeCurrency, "USD"},
+		{"future", fill.Future, ""},
+		{"liquidity", fill.Liquidity, "taker"},
+		{"market", fill.Market, "BTC/USD"},
+		{"baseCurrency", fill.BaseCurrency, "BTC"},
+		{"quoteCurrency", fill.QuoteCurrency, "USD"},
+		{"side", fill.Side, "buy"},
+		{"type", fill.Type, "order"},
+		{"symbol", fill.Symbol, "BTC/USD"},
+	}
+	for _, s := range strs {
+		if s.value != s.expected {
+			t.Fatalf("Should be equal: %s, %s - %s", s.value, s.expected, s.name)
+		}
+	}
+
+	if fill.ID != 7828307 || fill.OrderID != 38065410 || fill.TradeID != 19129310 {
+		t.Fatalf("Unexpected IDs: %d, %d, %d", fill.ID, fill.OrderID, fill.TradeID)
+	}
+	if expected := time.Date(2020, 11, 6, 3, 2, 11, 553347000, time.UTC); !fill.Time.Equal(expected) {
+		t.Fatalf("Should be equal: %v, %v", fill.Time, expected)
+	}
+	if fill.ResponseType != models.Update {
+		t.Fatalf("Should be equal: %v, %v", fill.ResponseType, models.Update)
+	}
+}